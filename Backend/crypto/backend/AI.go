@@ -1,84 +1,17 @@
+//go:build !ingest
+
 package main
 
 import (
-    "bytes"
     "encoding/json"
     "fmt"
     "io"
-    "io/ioutil"
     "log"
     "net/http"
-    "os"
     "strings"
     "time"
 )
 
-type OpenAIResponse struct {
-    Choices []struct {
-        Message struct {
-            Content string `json:"content"`
-        } `json:"message"` // OpenAI returns lowercase "message"
-    } `json:"choices"`
-}
-
-func callOpenAI(prompt string) (string, error) {
-    apiKey := os.Getenv("OPENAI_API_KEY")
-    if apiKey == "" {
-        return "", fmt.Errorf("OPENAI_API_KEY not set in environment")
-    }
-
-    reqBody := fmt.Sprintf(`{
-        "model": "gpt-4o-mini",
-        "messages": [{"role":"user", "content": %q}]
-    }`, prompt)
-
-    req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer([]byte(reqBody)))
-    if err != nil {
-        return "", err
-    }
-    req.Header.Set("Content-Type", "application/json")
-    req.Header.Set("Authorization", "Bearer "+apiKey)
-
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        return "", err
-    }
-    defer resp.Body.Close()
-
-    body, err := ioutil.ReadAll(resp.Body)
-    if err != nil {
-        return "", err
-    }
-
-    if resp.StatusCode != http.StatusOK {
-        var errResp struct {
-            Error struct {
-                Message string `json:"message"`
-            } `json:"error"`
-        }
-        _ = json.Unmarshal(body, &errResp)
-        msg := errResp.Error.Message
-        if msg == "" {
-            msg = string(body)
-        }
-        return "", fmt.Errorf("OpenAI API error %d: %s", resp.StatusCode, msg)
-    }
-
-    var openaiResp OpenAIResponse
-    err = json.Unmarshal(body, &openaiResp)
-    if err != nil {
-        return "", fmt.Errorf("failed to parse OpenAI response: %w", err)
-    }
-
-    if len(openaiResp.Choices) == 0 {
-        return "", fmt.Errorf("no choices in OpenAI response")
-    }
-
-    cqlQuery := openaiResp.Choices[0].Message.Content
-    return cqlQuery, nil
-}
-
 // stripCQLFromMarkdown removes markdown code fences so we get raw CQL (e.g. ```cql ... ``` or ``` ... ```).
 func stripCQLFromMarkdown(s string) string {
     s = strings.TrimSpace(s)
@@ -115,7 +48,91 @@ func handleAsk(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    now := time.Now().UTC()
+    ask := askRequest{question: question, now: time.Now().UTC()}
+    ask.answer = r.URL.Query().Get("answer") == "true"
+    if id := r.URL.Query().Get("conversation_id"); id != "" {
+        conv, ok := conversations.get(id, askOwner(r), ask.now)
+        if !ok {
+            writeAskError(w, http.StatusNotFound, "Conversation not found or expired")
+            return
+        }
+        // Follow-ups are always answered, since that is what the
+        // conversation is made of.
+        ask.answer = true
+        ask.conversationID = conv.ID
+        ask.history = conv.Turns
+    }
+
+    switch mode := r.URL.Query().Get("mode"); mode {
+    case "", "cql":
+        askCQLMode(w, r, ask)
+    case "intent":
+        askIntentMode(w, r, ask)
+    default:
+        writeAskError(w, http.StatusBadRequest, "mode must be cql or intent")
+    }
+}
+
+// askRequest is a question to /ask and how it should be answered.
+type askRequest struct {
+    question       string
+    now            time.Time
+    answer         bool
+    conversationID string
+    history        []askTurn
+}
+
+// askIntentMode has the model extract an askIntent and answers it with the
+// analytics endpoints.
+func askIntentMode(w http.ResponseWriter, r *http.Request, ask askRequest) {
+    reply, err := complete(r.Context(), FeatureAskIntent, "", intentPrompt(ask.question, ask.now, ask.history))
+    if err != nil {
+        log.Printf("AI ask: LLM failed: %v", err)
+        writeAskError(w, http.StatusInternalServerError, "AI intent extraction failed: "+err.Error())
+        return
+    }
+
+    intent, err := parseIntent(reply)
+    if err != nil {
+        log.Printf("AI ask: bad intent %q: %v", reply, err)
+        writeAskError(w, http.StatusUnprocessableEntity, err.Error())
+        return
+    }
+    start, end, err := resolveIntent(&intent, ask.now)
+    if err != nil {
+        log.Printf("AI ask: rejected intent %+v: %v", intent, err)
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusUnprocessableEntity)
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "error":  err.Error(),
+            "intent": intent,
+        })
+        return
+    }
+
+    results := runIntent(r, intent, start, end)
+    if ask.answer {
+        used, _ := json.Marshal(intent)
+        turn := askTurn{Question: ask.question, Intent: &intent}
+        writeAnswer(w, r, ask, turn, string(used), results, map[string]interface{}{
+            "intent":  intent,
+            "results": results,
+        })
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    if err := json.NewEncoder(w).Encode(map[string]interface{}{
+        "intent":  intent,
+        "results": results,
+    }); err != nil {
+        log.Printf("AI ask: encode error: %v", err)
+    }
+}
+
+// askCQLMode has the model write CQL and runs it through the sandbox.
+func askCQLMode(w http.ResponseWriter, r *http.Request, ask askRequest) {
+    now := ask.now
     since15 := now.Add(-15 * time.Minute)
     timeContext := fmt.Sprintf(
         "Current UTC time for reference: %s. '15 minutes ago' in UTC: %s. Use these exact timestamp formats in CQL (e.g. for 'last 15 minutes' use timestamp >= '%s').",
@@ -135,18 +152,21 @@ Rules:
 - SELECT must use exactly this column order: coin_id, timestamp, price_usd.
 - For time ranges (e.g. "last 15 minutes", "last hour") use WHERE coin_id = '...' AND timestamp >= 'YYYY-MM-DD HH:MM:SS' with the timestamp literal.
 - Keyspace is iot_data. Table is crypto_price_by_coin. Always use: FROM iot_data.crypto_price_by_coin
-- Add ALLOW FILTERING when using timestamp range or LIMIT. Syntax: ... LIMIT N ALLOW FILTERING; or ... ALLOW FILTERING;
-- Coin IDs in the table are lowercase: bitcoin, ethereum, cardano, solana, polkadot, chainlink, etc.
+- Always restrict coin_id, with = or IN ('a', 'b'). Only coin_id and timestamp may appear in WHERE.
+- Time ranges may span at most 31 days. LIMIT may be at most 1000.
+- ORDER BY timestamp ASC or DESC is allowed only with a single coin_id. No aggregates, no other columns.
+- Coin IDs in the table are lowercase: ` + strings.Join(askCoinIDs(), ", ") + `.
 
 ` + timeContext + `
 
-User question: ` + question
+` + conversationContext(ask.history) + `
+User question: ` + ask.question
 
 
 
-    cqlQuery, err := callOpenAI(aiPrompt)
+    cqlQuery, err := complete(r.Context(), FeatureAskCQL, "", aiPrompt)
     if err != nil {
-        log.Printf("AI ask: OpenAI failed: %v", err)
+        log.Printf("AI ask: LLM failed: %v", err)
         writeAskError(w, http.StatusInternalServerError, "AI query generation failed: "+err.Error())
         return
     }
@@ -157,46 +177,51 @@ User question: ` + question
         writeAskError(w, http.StatusBadRequest, "No query generated")
         return
     }
-    if !strings.HasSuffix(cqlQuery, ";") {
-        cqlQuery += ";"
+
+    // The generated statement only runs after it passes the sandbox, and
+    // then as a statement rebuilt from what was parsed.
+    query, err := parseAskQuery(cqlQuery, now)
+    if err != nil {
+        log.Printf("AI ask: rejected %q: %v", cqlQuery, err)
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusUnprocessableEntity)
+        json.NewEncoder(w).Encode(map[string]string{
+            "error": err.Error(),
+            "query": cqlQuery,
+        })
+        return
     }
-    if !strings.Contains(strings.ToUpper(cqlQuery), "ALLOW FILTERING") {
-        cqlQuery = strings.TrimSuffix(cqlQuery, ";")
-        cqlQuery += " ALLOW FILTERING;"
+
+    rows, err := query.run(store)
+    if err != nil {
+        log.Printf("AI ask: Cassandra query failed: %v", err)
+        writeAskError(w, http.StatusBadRequest, "Query execution failed: "+err.Error())
+        return
     }
 
-    iter := session.Query(cqlQuery).Iter()
-    if iter == nil {
-        writeAskError(w, http.StatusInternalServerError, "Failed to execute query")
+    if ask.answer {
+        summary := summarizeRows(rows)
+        turn := askTurn{Question: ask.question, Query: query.String()}
+        writeAnswer(w, r, ask, turn, query.String(), summary, map[string]interface{}{
+            "query":   query.String(),
+            "summary": summary,
+            "rows":    rows,
+        })
         return
     }
 
     var results []map[string]interface{}
 
-    for {
-        var coinID string
-        var ts time.Time
-        var price float64
-
-        if !iter.Scan(&coinID, &ts, &price) {
-            break
-        }
-
+    for _, row := range rows {
         rowData := map[string]interface{}{
-            "coin_id":   coinID,
-            "timestamp": ts,
-            "price_usd": price,
+            "coin_id":   row.CoinID,
+            "timestamp": row.Timestamp,
+            "price_usd": row.PriceUSD,
         }
 
         results = append(results, rowData)
     }
 
-    if err := iter.Close(); err != nil {
-        log.Printf("AI ask: Cassandra query failed: %v", err)
-        writeAskError(w, http.StatusBadRequest, "Query execution failed: "+err.Error())
-        return
-    }
-
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusOK)
     if err := json.NewEncoder(w).Encode(results); err != nil {
@@ -204,6 +229,15 @@ User question: ` + question
     }
 }
 
+// askCoinIDs lists the registry's enabled coin ids for the prompt.
+func askCoinIDs() []string {
+    var ids []string
+    for _, c := range registry.Enabled() {
+        ids = append(ids, c.ID)
+    }
+    return ids
+}
+
 func writeAskError(w http.ResponseWriter, code int, message string) {
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(code)
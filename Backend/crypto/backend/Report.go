@@ -16,20 +16,20 @@ import (
 	"time"
 
 	"context"
-	"encoding/base64"
-	"github.com/gocql/gocql"
 	"github.com/jung-kurt/gofpdf"
-	openai "github.com/sashabaranov/go-openai"
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/vg"
 	"strings"
 )
 
-// PricePoint is a single timestamped price.
+// PricePoint is a single timestamped price with the 24h market cap and
+// volume reported alongside it (0 when unknown).
 type PricePoint struct {
 	Timestamp time.Time
 	Price     float64
+	MarketCap float64
+	Volume24h float64
 }
 
 // MarketData maps coin_id -> timeseries of PricePoint (sorted by timestamp ascending).
@@ -37,71 +37,82 @@ type MarketData map[string][]PricePoint
 
 // Insight holds computed statistics for a single coin.
 type Insight struct {
-	CoinID        string
-	FirstPrice    float64
-	LastPrice     float64
-	PercentChange float64
-	AvgPrice      float64
-	StdDev        float64
-	Volatility    float64
-	MinPrice      float64
-	MaxPrice      float64
-	MedianPrice   float64
-	RangePct      float64
-	DataPoints    int
+	CoinID        string  `json:"coin_id"`
+	FirstPrice    float64 `json:"first_price"`
+	LastPrice     float64 `json:"last_price"`
+	PercentChange float64 `json:"percent_change"`
+	AvgPrice      float64 `json:"avg_price"`
+	StdDev        float64 `json:"stddev"`
+	Volatility    float64 `json:"volatility"`
+	MinPrice      float64 `json:"min_price"`
+	MaxPrice      float64 `json:"max_price"`
+	MedianPrice   float64 `json:"median_price"`
+	RangePct      float64 `json:"range_pct"`
+	DataPoints    int     `json:"data_points"`
+	MarketCap     float64 `json:"market_cap,omitempty"` // last reported market cap of the period
+	Volume24h     float64 `json:"volume_24h,omitempty"` // last reported 24h volume of the period
+
+	// Filled by periodMetrics; the day fields and Returns only for
+	// periods longer than a day.
+	MaxDrawdownPct float64        `json:"max_drawdown_pct"`
+	BestDay        string         `json:"best_day,omitempty"`
+	BestDayPct     float64        `json:"best_day_pct,omitempty"`
+	WorstDay       string         `json:"worst_day,omitempty"`
+	WorstDayPct    float64        `json:"worst_day_pct,omitempty"`
+	Returns        []PeriodReturn `json:"returns,omitempty"`
 }
 
-// ReportInsights holds the whole day's insights.
+// ReportInsights holds the whole period's insights. MarketCapIndexPct is
+// the period's change of a market-cap-weighted index over the coins that
+// report a market cap; VolumeLeaders are the coins with the largest 24h
+// volume.
 type ReportInsights struct {
-	Date        time.Time
-	CoinMetrics []Insight
-	TopGainers  []Insight
-	TopLosers   []Insight
+	Date              time.Time    `json:"date"`
+	Period            ReportPeriod `json:"period"`
+	CoinMetrics       []Insight `json:"coin_metrics"`
+	TopGainers        []Insight `json:"top_gainers"`
+	TopLosers         []Insight `json:"top_losers"`
+	MarketCapIndexPct float64   `json:"market_cap_index_pct"`
+	VolumeLeaders     []Insight `json:"volume_leaders"`
 }
 
-// fetchYesterdayData queries Cassandra for the previous day's prices and
-// returns a MarketData map of coin -> []PricePoint (sorted ascending).
-func fetchYesterdayData(session *gocql.Session) (MarketData, error) {
+// fetchPeriodData queries the store for the prices in period and returns a
+// MarketData map of coin -> []PricePoint (sorted ascending).
+func fetchPeriodData(store PriceStore, period ReportPeriod) (MarketData, error) {
 	data := make(MarketData)
 
 	// Get all distinct coin_ids first
-	var coinIDs []string
-	iter := session.Query(`SELECT DISTINCT coin_id FROM crypto_price_by_coin`).Iter()
-	var cid string
-	for iter.Scan(&cid) {
-		coinIDs = append(coinIDs, cid)
-	}
-	if err := iter.Close(); err != nil {
+	coinIDs, err := store.Coins()
+	if err != nil {
 		return nil, err
 	}
 
-	start := time.Now().UTC().AddDate(0, 0, -1).Truncate(24 * time.Hour)
-	end := start.Add(24 * time.Hour)
+	start, end := period.Start, period.End
 
 	// Query each coin individually with range filter on timestamp
+	// Cassandra timestamps have millisecond precision, so this keeps the end exclusive.
 	for _, coin := range coinIDs {
-		var ts time.Time
-		var price float64
-		it := session.Query(`
-			SELECT timestamp, price_usd
-			FROM crypto_price_by_coin
-			WHERE coin_id = ? AND timestamp >= ? AND timestamp < ?
-			ORDER BY timestamp ASC
-		`, coin, start, end).Iter()
-
-		for it.Scan(&ts, &price) {
-			data[coin] = append(data[coin], PricePoint{Timestamp: ts, Price: price})
+		rows, err := store.Range(coin, start, end.Add(-time.Millisecond))
+		if err != nil {
+			log.Printf("fetch %s: %v", coin, err)
+			continue
+		}
+		for _, row := range rows {
+			data[coin] = append(data[coin], PricePoint{
+				Timestamp: row.Timestamp,
+				Price:     row.PriceUSD,
+				MarketCap: row.MarketCapUSD,
+				Volume24h: row.Volume24hUSD,
+			})
 		}
-		it.Close()
 	}
 
 	return data, nil
 }
 
 // analyzeMarket computes insights (percent change, average, stddev, volatility) per coin.
-func analyzeMarket(data MarketData) ReportInsights {
+func analyzeMarket(data MarketData, period ReportPeriod) ReportInsights {
 	insights := []Insight{}
-	reportDate := time.Now().UTC().AddDate(0, 0, -1)
 
 	for coin, series := range data {
 		if len(series) == 0 {
@@ -179,7 +190,18 @@ func analyzeMarket(data MarketData) ReportInsights {
 			rangePct = (maxP - minP) / minP * 100
 		}
 
-		insights = append(insights, Insight{
+		// Latest reported market data
+		var marketCap, volume float64
+		for i := len(series) - 1; i >= 0 && (marketCap == 0 || volume == 0); i-- {
+			if marketCap == 0 {
+				marketCap = series[i].MarketCap
+			}
+			if volume == 0 {
+				volume = series[i].Volume24h
+			}
+		}
+
+		insight := Insight{
 			CoinID:        coin,
 			FirstPrice:    first,
 			LastPrice:     last,
@@ -192,7 +214,21 @@ func analyzeMarket(data MarketData) ReportInsights {
 			MedianPrice:   median,
 			RangePct:      rangePct,
 			DataPoints:    len(series),
-		})
+			MarketCap:     marketCap,
+			Volume24h:     volume,
+		}
+		periodMetrics(&insight, series, period)
+		insights = append(insights, insight)
+	}
+
+	return summarizeInsights(insights, period)
+}
+
+// summarizeInsights ranks the per-coin insights and derives the movers,
+// volume leaders and index from them.
+func summarizeInsights(insights []Insight, period ReportPeriod) ReportInsights {
+	if insights == nil {
+		insights = []Insight{}
 	}
 
 	// Sort by gainers
@@ -205,11 +241,44 @@ func analyzeMarket(data MarketData) ReportInsights {
 	topLosers := append([]Insight{}, reverseSlice(insights)[0:top]...)
 
 	return ReportInsights{
-		Date:        reportDate,
-		CoinMetrics: insights,
-		TopGainers:  topGainers,
-		TopLosers:   topLosers,
+		Date:              period.Start,
+		Period:            period,
+		CoinMetrics:       insights,
+		TopGainers:        topGainers,
+		TopLosers:         topLosers,
+		MarketCapIndexPct: marketCapIndex(insights),
+		VolumeLeaders:     volumeLeaders(insights, 5),
+	}
+}
+
+// marketCapIndex returns the percent change of an index weighting each
+// coin's change by its market cap. Coins without a market cap are skipped.
+func marketCapIndex(insights []Insight) float64 {
+	var weighted, total float64
+	for _, in := range insights {
+		if in.MarketCap > 0 {
+			weighted += in.MarketCap * in.PercentChange
+			total += in.MarketCap
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return weighted / total
+}
+
+// volumeLeaders returns up to n coins with the largest 24h volume.
+func volumeLeaders(insights []Insight, n int) []Insight {
+	var withVolume []Insight
+	for _, in := range insights {
+		if in.Volume24h > 0 {
+			withVolume = append(withVolume, in)
+		}
 	}
+	sort.Slice(withVolume, func(i, j int) bool {
+		return withVolume[i].Volume24h > withVolume[j].Volume24h
+	})
+	return withVolume[:min(n, len(withVolume))]
 }
 
 func min(a, b int) int {
@@ -229,11 +298,8 @@ func reverseSlice(s []Insight) []Insight {
 	return out
 }
 
-func createCharts(data MarketData, maxCharts int, outDir string) ([]string, error) {
-	if err := os.MkdirAll(outDir, 0755); err != nil {
-		return nil, err
-	}
-
+// chartCoins picks the coins with the most data points to chart.
+func chartCoins(data MarketData, maxCharts int) []string {
 	type coinScore struct {
 		coin  string
 		score int
@@ -250,12 +316,21 @@ func createCharts(data MarketData, maxCharts int, outDir string) ([]string, erro
 	for i := 0; i < len(scores) && len(selected) < maxCharts; i++ {
 		selected = append(selected, scores[i].coin)
 	}
+	return selected
+}
+
+// createCharts plots coins into outDir. tickFormat is the time format of
+// the x axis labels, which are shown in loc.
+func createCharts(data MarketData, coins []string, outDir, tickFormat string, loc *time.Location) ([]string, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
 
 	paths := []string{}
-	for _, coin := range selected {
+	for _, coin := range coins {
 		series := data[coin]
 		p := plot.New()
-		p.Title.Text = coin + " Price (UTC)"
+		p.Title.Text = coin + " Price (" + loc.String() + ")"
 		p.X.Label.Text = "Time"
 		p.Y.Label.Text = "Price USD"
 
@@ -274,7 +349,7 @@ func createCharts(data MarketData, maxCharts int, outDir string) ([]string, erro
 		scatter.Radius = vg.Points(0.5)
 		p.Add(scatter)
 
-		p.X.Tick.Marker = plot.TimeTicks{Format: "15:04"}
+		p.X.Tick.Marker = plot.TimeTicks{Format: tickFormat, Time: plot.UnixTimeIn(loc)}
 
 		fileName := fmt.Sprintf("%s_chart.png", coin)
 		fullPath := filepath.Join(outDir, fileName)
@@ -286,12 +361,15 @@ func createCharts(data MarketData, maxCharts int, outDir string) ([]string, erro
 	return paths, nil
 }
 
-func buildPDF(insights ReportInsights, chartPaths []string, data MarketData) ([]byte, error) {
-
-	openaiClient := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+// buildPDF renders the report. opts picks the sections and the language of
+// the fixed text; the charts are already drawn in opts' zone.
+func buildPDF(insights ReportInsights, chartPaths []string, data MarketData, narr reportNarrator, opts reportOptions) ([]byte, error) {
 
 	pdf := gofpdf.New("P", "mm", "A4", "")
-	pdf.SetTitle("Daily Crypto Market Report", false)
+	// The core fonts are cp1252; tr converts translated and AI text to it.
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	t := func(english string) string { return tr(opts.text(english)) }
+	pdf.SetTitle(opts.text(insights.Period.Title()), true)
 	pdf.SetAuthor("Crypto Analytics Platform", false)
 
 	// === Footer with page numbers ===
@@ -299,18 +377,18 @@ func buildPDF(insights ReportInsights, chartPaths []string, data MarketData) ([]
 	pdf.SetFooterFunc(func() {
 		pdf.SetY(-15)
 		pdf.SetFont("Helvetica", "I", 8)
-		pdf.CellFormat(0, 10, fmt.Sprintf("Page %d/{nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+		pdf.CellFormat(0, 10, fmt.Sprintf(t("Page %d/{nb}"), pdf.PageNo()), "", 0, "C", false, 0, "")
 	})
 
 	// === COVER PAGE ===
 	pdf.AddPage()
 	addBackground(pdf, "Image/background.jpg")
 	pdf.SetFont("Helvetica", "B", 24)
-	pdf.CellFormat(0, 15, "Daily Crypto Market Report", "", 1, "C", false, 0, "")
+	pdf.CellFormat(0, 15, t(insights.Period.Title()), "", 1, "C", false, 0, "")
 	pdf.Ln(10)
 
 	pdf.SetFont("Helvetica", "I", 12)
-	pdf.CellFormat(0, 10, fmt.Sprintf("Generated on %s (UTC)", insights.Date.Format("2006-01-02")), "", 1, "C", false, 0, "")
+	pdf.CellFormat(0, 10, tr(opts.covering(insights.Period)), "", 1, "C", false, 0, "")
 	pdf.Ln(15)
 
 	coverImg := "Image/image.png"
@@ -328,142 +406,250 @@ func buildPDF(insights ReportInsights, chartPaths []string, data MarketData) ([]
 		pdf.Ln(3)
 	}
 
-	// === DAILY RANGE METRICS ===
-	pdf.AddPage()
-	addBackground(pdf, "Image/background.jpg")
-	sectionHeader("Daily Range Metrics")
+	var rows [][]string
+	var analysis string
+	fill := false
 
-	pdf.SetFont("Helvetica", "B", 9)
-	pdf.SetFillColor(230, 230, 230)
-	pdf.CellFormat(30, 8, "Coin", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(30, 8, "Min", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(30, 8, "Max", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(30, 8, "Median", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(30, 8, "Range %", "1", 1, "C", true, 0, "")
+	// === DAILY RANGE METRICS ===
+	if opts.includes(SectionRanges) {
+		pdf.AddPage()
+		addBackground(pdf, "Image/background.jpg")
+		rangeTitle := "Daily Range Metrics"
+		if insights.Period.Days() > 1 {
+			rangeTitle = "Range Metrics"
+		}
+		sectionHeader(t(rangeTitle))
+
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetFillColor(230, 230, 230)
+		pdf.CellFormat(30, 8, t("Coin"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(30, 8, t("Min"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(30, 8, t("Max"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(30, 8, t("Median"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(30, 8, t("Range %"), "1", 1, "C", true, 0, "")
+
+		pdf.SetFont("Helvetica", "", 9)
+
+		for _, c := range insights.CoinMetrics {
+			if fill {
+				pdf.SetFillColor(245, 245, 245)
+			} else {
+				pdf.SetFillColor(255, 255, 255)
+			}
+			fill = !fill
 
-	pdf.SetFont("Helvetica", "", 9)
+			pdf.CellFormat(30, 6, c.CoinID, "1", 0, "L", true, 0, "")
+			pdf.CellFormat(30, 6, fmt.Sprintf("%.4f", c.MinPrice), "1", 0, "R", true, 0, "")
+			pdf.CellFormat(30, 6, fmt.Sprintf("%.4f", c.MaxPrice), "1", 0, "R", true, 0, "")
+			pdf.CellFormat(30, 6, fmt.Sprintf("%.4f", c.MedianPrice), "1", 0, "R", true, 0, "")
+			pdf.CellFormat(30, 6, fmt.Sprintf("%.2f%%", c.RangePct), "1", 1, "R", true, 0, "")
+		}
 
-	fill := false
-	for _, c := range insights.CoinMetrics {
-		if fill {
-			pdf.SetFillColor(245, 245, 245)
-		} else {
-			pdf.SetFillColor(255, 255, 255)
+		rows = [][]string{}
+		for _, c := range insights.CoinMetrics {
+			rows = append(rows, []string{
+				c.CoinID,
+				fmt.Sprintf("%.4f", c.MinPrice),
+				fmt.Sprintf("%.4f", c.MaxPrice),
+				fmt.Sprintf("%.4f", c.MedianPrice),
+				fmt.Sprintf("%.2f%%", c.RangePct),
+			})
 		}
-		fill = !fill
+		analysis = narr.section(context.Background(), rangeTitle, rows, narrateRangeMetrics(insights.CoinMetrics))
 
-		pdf.CellFormat(30, 6, c.CoinID, "1", 0, "L", true, 0, "")
-		pdf.CellFormat(30, 6, fmt.Sprintf("%.4f", c.MinPrice), "1", 0, "R", true, 0, "")
-		pdf.CellFormat(30, 6, fmt.Sprintf("%.4f", c.MaxPrice), "1", 0, "R", true, 0, "")
-		pdf.CellFormat(30, 6, fmt.Sprintf("%.4f", c.MedianPrice), "1", 0, "R", true, 0, "")
-		pdf.CellFormat(30, 6, fmt.Sprintf("%.2f%%", c.RangePct), "1", 1, "R", true, 0, "")
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "I", 9)
+		pdf.MultiCell(0, 6, tr(analysis), "", "L", false)
 	}
 
-	rows := [][]string{}
-	for _, c := range insights.CoinMetrics {
-		rows = append(rows, []string{
-			c.CoinID,
-			fmt.Sprintf("%.4f", c.MinPrice),
-			fmt.Sprintf("%.4f", c.MaxPrice),
-			fmt.Sprintf("%.4f", c.MedianPrice),
-			fmt.Sprintf("%.2f%%", c.RangePct),
-		})
-	}
-	analysis, _ := generateAnalysisFromOpenAI(context.Background(), openaiClient, "Daily Range Metrics", rows)
+	// === MARKET OVERVIEW PAGE ===
+	if opts.includes(SectionMarket) && len(insights.VolumeLeaders) > 0 {
+		pdf.AddPage()
+		addBackground(pdf, "Image/background.jpg")
+		sectionHeader(t("Market Overview"))
 
-	pdf.Ln(4)
-	pdf.SetFont("Helvetica", "I", 9)
-	pdf.MultiCell(0, 6, analysis, "", "L", false)
+		pdf.SetFont("Helvetica", "", 11)
+		pdf.CellFormat(0, 8, fmt.Sprintf(t("Market-cap-weighted index change: %.2f%%"), insights.MarketCapIndexPct), "", 1, "L", false, 0, "")
+		pdf.Ln(3)
 
-	// === TOP GAINERS PAGE ===
-	pdf.AddPage()
-	addBackground(pdf, "Image/background.jpg")
-	sectionHeader("Top Gainers")
-
-	// Table headers
-	pdf.SetFont("Helvetica", "B", 11)
-	pdf.SetFillColor(230, 230, 230)
-	pdf.CellFormat(50, 8, "Coin", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(40, 8, "Percent Change", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(40, 8, "Avg Price", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(40, 8, "Volatility", "1", 1, "C", true, 0, "")
-
-	// Table rows (alternating background)
-	pdf.SetFont("Helvetica", "", 10)
-	fill = false
-	for _, g := range insights.TopGainers {
-		if fill {
-			pdf.SetFillColor(245, 245, 245)
-		} else {
-			pdf.SetFillColor(255, 255, 255)
+		pdf.SetFont("Helvetica", "B", 11)
+		pdf.SetFillColor(230, 230, 230)
+		pdf.CellFormat(50, 8, t("Coin"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(45, 8, t("24h Volume (USD)"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(45, 8, t("Market Cap (USD)"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(30, 8, t("Change %"), "1", 1, "C", true, 0, "")
+
+		pdf.SetFont("Helvetica", "", 10)
+		fill = false
+		for _, v := range insights.VolumeLeaders {
+			if fill {
+				pdf.SetFillColor(245, 245, 245)
+			} else {
+				pdf.SetFillColor(255, 255, 255)
+			}
+			fill = !fill
+
+			pdf.CellFormat(50, 7, v.CoinID, "1", 0, "L", true, 0, "")
+			pdf.CellFormat(45, 7, fmt.Sprintf("%.0f", v.Volume24h), "1", 0, "R", true, 0, "")
+			pdf.CellFormat(45, 7, fmt.Sprintf("%.0f", v.MarketCap), "1", 0, "R", true, 0, "")
+			pdf.CellFormat(30, 7, fmt.Sprintf("%.2f%%", v.PercentChange), "1", 1, "R", true, 0, "")
 		}
-		fill = !fill
 
-		pdf.CellFormat(50, 7, g.CoinID, "1", 0, "L", true, 0, "")
-		pdf.CellFormat(40, 7, fmt.Sprintf("%.2f%%", g.PercentChange), "1", 0, "R", true, 0, "")
-		pdf.CellFormat(40, 7, fmt.Sprintf("%.4f", g.AvgPrice), "1", 0, "R", true, 0, "")
-		pdf.CellFormat(40, 7, fmt.Sprintf("%.6f", g.Volatility), "1", 1, "R", true, 0, "")
-	}
+		rows = [][]string{{"Market-cap-weighted index", fmt.Sprintf("%.2f%%", insights.MarketCapIndexPct)}}
+		for _, v := range insights.VolumeLeaders {
+			rows = append(rows, []string{
+				v.CoinID,
+				fmt.Sprintf("%.0f", v.Volume24h),
+				fmt.Sprintf("%.0f", v.MarketCap),
+				fmt.Sprintf("%.2f%%", v.PercentChange),
+			})
+		}
+		analysis = narr.section(context.Background(), "Market Overview (Volume Leaders)", rows, narrateMarketOverview(insights))
 
-	// After drawing Top Gainers table
-	rows = [][]string{}
-	for _, g := range insights.TopGainers {
-		rows = append(rows, []string{
-			g.CoinID,
-			fmt.Sprintf("%.2f%%", g.PercentChange),
-			fmt.Sprintf("%.4f", g.AvgPrice),
-			fmt.Sprintf("%.6f", g.Volatility),
-		})
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "I", 9)
+		pdf.MultiCell(0, 6, tr(analysis), "", "L", false)
 	}
 
-	analysis, _ = generateAnalysisFromOpenAI(context.Background(), openaiClient, "Top Gainers", rows)
+	// === TOP GAINERS PAGE ===
+	if opts.includes(SectionGainers) {
+		pdf.AddPage()
+		addBackground(pdf, "Image/background.jpg")
+		sectionHeader(t("Top Gainers"))
+
+		// Table headers
+		pdf.SetFont("Helvetica", "B", 11)
+		pdf.SetFillColor(230, 230, 230)
+		pdf.CellFormat(50, 8, t("Coin"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(40, 8, t("Percent Change"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(40, 8, t("Avg Price"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(40, 8, t("Volatility"), "1", 1, "C", true, 0, "")
+
+		// Table rows (alternating background)
+		pdf.SetFont("Helvetica", "", 10)
+		fill = false
+		for _, g := range insights.TopGainers {
+			if fill {
+				pdf.SetFillColor(245, 245, 245)
+			} else {
+				pdf.SetFillColor(255, 255, 255)
+			}
+			fill = !fill
 
-	pdf.Ln(4)
-	pdf.SetFont("Helvetica", "I", 9)
-	pdf.MultiCell(0, 6, analysis, "", "L", false)
+			pdf.CellFormat(50, 7, g.CoinID, "1", 0, "L", true, 0, "")
+			pdf.CellFormat(40, 7, fmt.Sprintf("%.2f%%", g.PercentChange), "1", 0, "R", true, 0, "")
+			pdf.CellFormat(40, 7, fmt.Sprintf("%.4f", g.AvgPrice), "1", 0, "R", true, 0, "")
+			pdf.CellFormat(40, 7, fmt.Sprintf("%.6f", g.Volatility), "1", 1, "R", true, 0, "")
+		}
 
-	// === TOP LOSERS PAGE ===
-	pdf.AddPage()
-	addBackground(pdf, "Image/background.jpg")
-	sectionHeader("Top Losers")
-
-	pdf.SetFont("Helvetica", "B", 11)
-	pdf.SetFillColor(230, 230, 230)
-	pdf.CellFormat(50, 8, "Coin", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(40, 8, "Percent Change", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(40, 8, "Avg Price", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(40, 8, "Volatility", "1", 1, "C", true, 0, "")
-
-	pdf.SetFont("Helvetica", "", 10)
-	fill = false
-	for _, g := range insights.TopLosers {
-		if fill {
-			pdf.SetFillColor(245, 245, 245)
-		} else {
-			pdf.SetFillColor(255, 255, 255)
+		// After drawing Top Gainers table
+		rows = [][]string{}
+		for _, g := range insights.TopGainers {
+			rows = append(rows, []string{
+				g.CoinID,
+				fmt.Sprintf("%.2f%%", g.PercentChange),
+				fmt.Sprintf("%.4f", g.AvgPrice),
+				fmt.Sprintf("%.6f", g.Volatility),
+			})
 		}
-		fill = !fill
 
-		pdf.CellFormat(50, 7, g.CoinID, "1", 0, "L", true, 0, "")
-		pdf.CellFormat(40, 7, fmt.Sprintf("%.2f%%", g.PercentChange), "1", 0, "R", true, 0, "")
-		pdf.CellFormat(40, 7, fmt.Sprintf("%.4f", g.AvgPrice), "1", 0, "R", true, 0, "")
-		pdf.CellFormat(40, 7, fmt.Sprintf("%.6f", g.Volatility), "1", 1, "R", true, 0, "")
+		analysis = narr.section(context.Background(), "Top Gainers", rows, narrateMovers(insights.TopGainers, true))
+
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "I", 9)
+		pdf.MultiCell(0, 6, tr(analysis), "", "L", false)
 	}
 
-	rows = [][]string{}
-	for _, g := range insights.TopLosers {
-		rows = append(rows, []string{
-			g.CoinID,
-			fmt.Sprintf("%.2f%%", g.PercentChange),
-			fmt.Sprintf("%.4f", g.AvgPrice),
-			fmt.Sprintf("%.6f", g.Volatility),
-		})
+	// === TOP LOSERS PAGE ===
+	if opts.includes(SectionLosers) {
+		pdf.AddPage()
+		addBackground(pdf, "Image/background.jpg")
+		sectionHeader(t("Top Losers"))
+
+		pdf.SetFont("Helvetica", "B", 11)
+		pdf.SetFillColor(230, 230, 230)
+		pdf.CellFormat(50, 8, t("Coin"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(40, 8, t("Percent Change"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(40, 8, t("Avg Price"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(40, 8, t("Volatility"), "1", 1, "C", true, 0, "")
+
+		pdf.SetFont("Helvetica", "", 10)
+		fill = false
+		for _, g := range insights.TopLosers {
+			if fill {
+				pdf.SetFillColor(245, 245, 245)
+			} else {
+				pdf.SetFillColor(255, 255, 255)
+			}
+			fill = !fill
+
+			pdf.CellFormat(50, 7, g.CoinID, "1", 0, "L", true, 0, "")
+			pdf.CellFormat(40, 7, fmt.Sprintf("%.2f%%", g.PercentChange), "1", 0, "R", true, 0, "")
+			pdf.CellFormat(40, 7, fmt.Sprintf("%.4f", g.AvgPrice), "1", 0, "R", true, 0, "")
+			pdf.CellFormat(40, 7, fmt.Sprintf("%.6f", g.Volatility), "1", 1, "R", true, 0, "")
+		}
+
+		rows = [][]string{}
+		for _, g := range insights.TopLosers {
+			rows = append(rows, []string{
+				g.CoinID,
+				fmt.Sprintf("%.2f%%", g.PercentChange),
+				fmt.Sprintf("%.4f", g.AvgPrice),
+				fmt.Sprintf("%.6f", g.Volatility),
+			})
+		}
+		analysis = narr.section(context.Background(), "Top Losers", rows, narrateMovers(insights.TopLosers, false))
+
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "I", 9)
+		pdf.MultiCell(0, 6, tr(analysis), "", "L", false)
 	}
-	analysis, _ = generateAnalysisFromOpenAI(context.Background(), openaiClient, "Top Losers", rows)
 
-	pdf.Ln(4)
-	pdf.SetFont("Helvetica", "I", 9)
-	pdf.MultiCell(0, 6, analysis, "", "L", false)
+	// === PERIOD PERFORMANCE PAGE ===
+	if opts.includes(SectionPerformance) && insights.Period.Days() > 1 && len(insights.CoinMetrics) > 0 {
+		pdf.AddPage()
+		addBackground(pdf, "Image/background.jpg")
+		sectionHeader(t("Period Performance"))
+
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetFillColor(230, 230, 230)
+		pdf.CellFormat(30, 8, t("Coin"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(25, 8, t("Change %"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(30, 8, t("Max Drawdown"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(50, 8, t("Best Day"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(50, 8, t("Worst Day"), "1", 1, "C", true, 0, "")
+
+		pdf.SetFont("Helvetica", "", 9)
+		fill = false
+		rows = [][]string{}
+		for _, c := range insights.CoinMetrics {
+			if fill {
+				pdf.SetFillColor(245, 245, 245)
+			} else {
+				pdf.SetFillColor(255, 255, 255)
+			}
+			fill = !fill
+
+			row := []string{
+				c.CoinID,
+				fmt.Sprintf("%.2f%%", c.PercentChange),
+				fmt.Sprintf("%.2f%%", c.MaxDrawdownPct),
+				fmt.Sprintf("%s (%.2f%%)", c.BestDay, c.BestDayPct),
+				fmt.Sprintf("%s (%.2f%%)", c.WorstDay, c.WorstDayPct),
+			}
+			pdf.CellFormat(30, 6, row[0], "1", 0, "L", true, 0, "")
+			pdf.CellFormat(25, 6, row[1], "1", 0, "R", true, 0, "")
+			pdf.CellFormat(30, 6, row[2], "1", 0, "R", true, 0, "")
+			pdf.CellFormat(50, 6, row[3], "1", 0, "R", true, 0, "")
+			pdf.CellFormat(50, 6, row[4], "1", 1, "R", true, 0, "")
+			rows = append(rows, row)
+		}
+		analysis = narr.section(context.Background(), "Period Performance", rows, narratePerformance(insights))
+
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "I", 9)
+		pdf.MultiCell(0, 6, tr(analysis), "", "L", false)
+	}
 
 	// === CHART PAGES ===
 	for _, cp := range chartPaths {
@@ -476,59 +662,61 @@ func buildPDF(insights ReportInsights, chartPaths []string, data MarketData) ([]
 		pdf.ImageOptions(cp, 15, 40, 180, 0, false, imgOpt, 0, "")
 		pdf.Ln(100)
 		pdf.SetFont("Helvetica", "I", 10)
-		pdf.CellFormat(0, 8, fmt.Sprintf("Price chart: %s", filepath.Base(cp)), "", 1, "C", false, 0, "")
+		pdf.CellFormat(0, 8, fmt.Sprintf(t("Price chart: %s"), filepath.Base(cp)), "", 1, "C", false, 0, "")
 
 		coinName := strings.TrimSuffix(filepath.Base(cp), "_chart.png")
-		analysis, _ := generateChartAnalysis(context.Background(), openaiClient, coinName, data[coinName])
+		analysis := narr.chart(context.Background(), coinName, data[coinName])
 		pdf.Ln(64)
-		pdf.MultiCell(0, 6, analysis, "", "L", false)
+		pdf.MultiCell(0, 6, tr(analysis), "", "L", false)
 	}
 
 	// === SNAPSHOT METRICS ===
-	pdf.AddPage()
-	addBackground(pdf, "background.jpg")
-	sectionHeader("Snapshot Metrics (Top Coins)")
-
-	pdf.SetFont("Helvetica", "B", 9)
-	pdf.SetFillColor(230, 230, 230)
-	pdf.CellFormat(40, 8, "Coin", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(35, 8, "Change %", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(35, 8, "Avg", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(35, 8, "StdDev", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(35, 8, "Volatility", "1", 1, "C", true, 0, "")
-
-	pdf.SetFont("Helvetica", "", 9)
-	fill = false
-	for _, c := range insights.CoinMetrics {
-		if fill {
-			pdf.SetFillColor(245, 245, 245)
-		} else {
-			pdf.SetFillColor(255, 255, 255)
+	if opts.includes(SectionSnapshot) {
+		pdf.AddPage()
+		addBackground(pdf, "background.jpg")
+		sectionHeader(t("Snapshot Metrics (Top Coins)"))
+
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetFillColor(230, 230, 230)
+		pdf.CellFormat(40, 8, t("Coin"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(35, 8, t("Change %"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(35, 8, t("Avg"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(35, 8, t("StdDev"), "1", 0, "C", true, 0, "")
+		pdf.CellFormat(35, 8, t("Volatility"), "1", 1, "C", true, 0, "")
+
+		pdf.SetFont("Helvetica", "", 9)
+		fill = false
+		for _, c := range insights.CoinMetrics {
+			if fill {
+				pdf.SetFillColor(245, 245, 245)
+			} else {
+				pdf.SetFillColor(255, 255, 255)
+			}
+			fill = !fill
+
+			pdf.CellFormat(40, 6, c.CoinID, "1", 0, "L", true, 0, "")
+			pdf.CellFormat(35, 6, fmt.Sprintf("%.2f%%", c.PercentChange), "1", 0, "R", true, 0, "")
+			pdf.CellFormat(35, 6, fmt.Sprintf("%.4f", c.AvgPrice), "1", 0, "R", true, 0, "")
+			pdf.CellFormat(35, 6, fmt.Sprintf("%.6f", c.StdDev), "1", 0, "R", true, 0, "")
+			pdf.CellFormat(35, 6, fmt.Sprintf("%.6f", c.Volatility), "1", 1, "R", true, 0, "")
 		}
-		fill = !fill
 
-		pdf.CellFormat(40, 6, c.CoinID, "1", 0, "L", true, 0, "")
-		pdf.CellFormat(35, 6, fmt.Sprintf("%.2f%%", c.PercentChange), "1", 0, "R", true, 0, "")
-		pdf.CellFormat(35, 6, fmt.Sprintf("%.4f", c.AvgPrice), "1", 0, "R", true, 0, "")
-		pdf.CellFormat(35, 6, fmt.Sprintf("%.6f", c.StdDev), "1", 0, "R", true, 0, "")
-		pdf.CellFormat(35, 6, fmt.Sprintf("%.6f", c.Volatility), "1", 1, "R", true, 0, "")
-	}
+		rows = [][]string{}
+		for _, c := range insights.CoinMetrics {
+			rows = append(rows, []string{
+				c.CoinID,
+				fmt.Sprintf("%.2f%%", c.PercentChange),
+				fmt.Sprintf("%.4f", c.AvgPrice),
+				fmt.Sprintf("%.6f", c.StdDev),
+				fmt.Sprintf("%.6f", c.Volatility),
+			})
+		}
+		analysis = narr.section(context.Background(), "Snapshot Metrics", rows, narrateSnapshot(insights.CoinMetrics))
 
-	rows = [][]string{}
-	for _, c := range insights.CoinMetrics {
-		rows = append(rows, []string{
-			c.CoinID,
-			fmt.Sprintf("%.2f%%", c.PercentChange),
-			fmt.Sprintf("%.4f", c.AvgPrice),
-			fmt.Sprintf("%.6f", c.StdDev),
-			fmt.Sprintf("%.6f", c.Volatility),
-		})
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "I", 9)
+		pdf.MultiCell(0, 6, tr(analysis), "", "L", false)
 	}
-	analysis, _ = generateAnalysisFromOpenAI(context.Background(), openaiClient, "Snapshot Metrics", rows)
-
-	pdf.Ln(4)
-	pdf.SetFont("Helvetica", "I", 9)
-	pdf.MultiCell(0, 6, analysis, "", "L", false)
 
 	// === OUTPUT ===
 	var buf bytes.Buffer
@@ -538,37 +726,131 @@ func buildPDF(insights ReportInsights, chartPaths []string, data MarketData) ([]
 	return buf.Bytes(), nil
 }
 
-// generateDailyReportPDF is the main entrypoint for report generation.
-func generateDailyReportPDF(tmpDir string) ([]byte, error) {
-	data, err := fetchYesterdayData(session)
+// reportRun is the report pipeline for one period. The prices, the
+// per-coin insights and the rendered charts are computed once and shared
+// by every personalized build of the report.
+type reportRun struct {
+	period    ReportPeriod
+	narrative string
+	dir       string
+	data      MarketData
+	insights  ReportInsights
+	charts    map[string]string // zone + "|" + coin -> PNG path
+}
+
+// newReportRun fetches and analyzes period. narrative is NarrativeAI or
+// NarrativeTemplate. Charts are drawn under a new directory in tmpDir,
+// removed by close.
+func newReportRun(period ReportPeriod, tmpDir, narrative string) (*reportRun, error) {
+	data, err := fetchPeriodData(store, period)
 	if err != nil {
 		return nil, fmt.Errorf("fetch data: %w", err)
 	}
-
-	insights := analyzeMarket(data)
+	dir, err := os.MkdirTemp(tmpDir, "report-")
 	if err != nil {
-		return nil, fmt.Errorf("analyze: %w", err)
+		return nil, fmt.Errorf("create charts: %w", err)
 	}
+	return &reportRun{
+		period:    period,
+		narrative: narrative,
+		dir:       dir,
+		data:      data,
+		insights:  analyzeMarket(data, period),
+		charts:    make(map[string]string),
+	}, nil
+}
 
-	chartPaths, err := createCharts(data, 3, tmpDir)
-	if err != nil {
-		return nil, fmt.Errorf("create charts: %w", err)
+// close removes the run's charts.
+func (run *reportRun) close() {
+	_ = os.RemoveAll(run.dir)
+}
+
+// reportBuild is one rendering of a run: the insights and charts it shows
+// and, once built, the PDF.
+type reportBuild struct {
+	Insights ReportInsights
+	Charts   []string
+	PDF      []byte
+	data     MarketData
+}
+
+// prepare narrows the run to opts and draws its charts, without rendering
+// the PDF.
+func (run *reportRun) prepare(opts reportOptions) (reportBuild, error) {
+	b := reportBuild{Insights: run.insights, data: run.data}
+	if len(opts.Coins) > 0 {
+		b.data = restrictData(run.data, opts.Coins)
+		b.Insights = restrictInsights(run.insights, opts.Coins)
 	}
+	if opts.includes(SectionCharts) {
+		var err error
+		if b.Charts, err = run.chartsFor(b.data, opts.Location); err != nil {
+			return b, fmt.Errorf("create charts: %w", err)
+		}
+	}
+	return b, nil
+}
 
-	pdfBytes, err := buildPDF(insights, chartPaths, data)
+// build renders the report personalized by opts.
+func (run *reportRun) build(opts reportOptions) (reportBuild, error) {
+	b, err := run.prepare(opts)
 	if err != nil {
-		return nil, fmt.Errorf("build pdf: %w", err)
+		return b, err
+	}
+	narr := reportNarrator{mode: run.narrative, client: llm, language: opts.Language, loc: opts.Location}
+	if b.PDF, err = buildPDF(b.Insights, b.Charts, b.data, narr, opts); err != nil {
+		return b, fmt.Errorf("build pdf: %w", err)
+	}
+	return b, nil
+}
+
+// chartsFor returns the charts of the three coins in data with the most
+// readings, drawn in loc. Charts already drawn by the run are reused.
+func (run *reportRun) chartsFor(data MarketData, loc *time.Location) ([]string, error) {
+	tickFormat := "15:04"
+	if run.period.Days() > 1 {
+		tickFormat = "Jan 02"
 	}
+	coins := chartCoins(data, 3)
 
-	for _, p := range chartPaths {
-		_ = os.Remove(p)
+	var missing []string
+	for _, coin := range coins {
+		if _, ok := run.charts[loc.String()+"|"+coin]; !ok {
+			missing = append(missing, coin)
+		}
+	}
+	if len(missing) > 0 {
+		paths, err := createCharts(data, missing, filepath.Join(run.dir, loc.String()), tickFormat, loc)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			run.charts[loc.String()+"|"+strings.TrimSuffix(filepath.Base(p), "_chart.png")] = p
+		}
 	}
 
-	return pdfBytes, nil
+	var out []string
+	for _, coin := range coins {
+		if p, ok := run.charts[loc.String()+"|"+coin]; ok {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// archive builds the full report, every coin and section in English and
+// UTC, and stores it.
+func (run *reportRun) archive() (ArchivedReport, error) {
+	b, err := run.build(defaultReportOptions())
+	if err != nil {
+		return ArchivedReport{}, err
+	}
+	return archiveReport(run.insights, b.PDF, run.narrative), nil
 }
 
-// sendEmailWithAttachment is a stub for email sending (implement as needed).
-func sendEmailWithAttachment(to, subject, body string, attachment []byte, filename string) error {
+// deliverMail sends a complete message (headers and body) to one recipient
+// through the SMTP account in SMTP_EMAIL/SMTP_PASS, upgrading to TLS first.
+func deliverMail(to string, msg []byte) error {
 	from := os.Getenv("SMTP_EMAIL")
 	password := os.Getenv("SMTP_PASS")
 	smtpHost := "smtp.gmail.com"
@@ -576,33 +858,6 @@ func sendEmailWithAttachment(to, subject, body string, attachment []byte, filena
 
 	auth := smtp.PlainAuth("", from, password, smtpHost)
 
-	var msg bytes.Buffer
-	boundary := "boundary123"
-
-	// Headers
-	msg.WriteString(fmt.Sprintf("From: %s\r\n", from))
-	msg.WriteString(fmt.Sprintf("To: %s\r\n", to))
-	msg.WriteString(fmt.Sprintf("Subject: %s\r\n", subject))
-	msg.WriteString("MIME-Version: 1.0\r\n")
-	msg.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=%s\r\n\r\n", boundary))
-
-	// Body
-	msg.WriteString(fmt.Sprintf("--%s\r\n", boundary))
-	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
-	msg.WriteString(body + "\r\n")
-
-	// Attachment
-	msg.WriteString(fmt.Sprintf("--%s\r\n", boundary))
-	msg.WriteString(fmt.Sprintf("Content-Type: application/pdf; name=%q\r\n", filename))
-	msg.WriteString("Content-Transfer-Encoding: base64\r\n")
-	msg.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=%q\r\n\r\n", filename))
-
-	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(attachment)))
-	base64.StdEncoding.Encode(encoded, attachment)
-	msg.Write(encoded)
-	msg.WriteString("\r\n")
-	msg.WriteString(fmt.Sprintf("--%s--", boundary))
-
 	addr := smtpHost + ":" + smtpPort
 	conn, err := smtp.Dial(addr)
 	if err != nil {
@@ -630,55 +885,189 @@ func sendEmailWithAttachment(to, subject, body string, attachment []byte, filena
 	if err != nil {
 		return err
 	}
-	if _, err = w.Write(msg.Bytes()); err != nil {
+	if _, err = w.Write(msg); err != nil {
 		return err
 	}
 	return w.Close()
 }
 
-// sendDailyReports fetches emails and sends the daily report.
+// sendDailyReports builds and archives yesterday's report and emails it
+// to the subscribers of the daily digest.
 func sendDailyReports() {
-	pdfData, err := generateDailyReportPDF(os.TempDir())
+	sendPeriodReports(PeriodDaily)
+}
+
+// sendPeriodReports builds and archives the last complete daily, weekly or
+// monthly report and emails it to the subscribers of that digest.
+func sendPeriodReports(kind string) {
+	period, err := lastCompletePeriod(kind, time.Now())
+	if err != nil {
+		log.Println("Error choosing report period:", err)
+		return
+	}
+	run, err := newReportRun(period, os.TempDir(), configuredNarrative())
 	if err != nil {
 		log.Println("Error generating report:", err)
 		return
 	}
+	defer run.close()
+	report, err := run.archive()
+	if err != nil {
+		log.Println("Error generating report:", err)
+		return
+	}
+	emailReport(report, run)
+}
 
-	iter := session.Query(`SELECT email FROM email_subscribers`).Iter()
-	var email string
-	for iter.Scan(&email) {
-		if err := sendEmailWithAttachment(email, "Daily Crypto Report",
-			"Dear Subscriber,\n\nAttached is your daily market analysis prepared by the Crypto Dashboard team.\n\nBest regards,\nCrypto Dashboard Team", pdfData, "report.pdf"); err != nil {
-			log.Printf("Failed to send email to %s: %v", email, err)
+// reportSubjects are the email subjects by period kind.
+var reportSubjects = map[string]string{
+	PeriodDaily:   "Daily Crypto Report",
+	PeriodWeekly:  "Weekly Crypto Report",
+	PeriodMonthly: "Monthly Crypto Report",
+}
+
+// emailReport sends report to every subscriber of its period's digest as
+// an HTML email of its highlights with the PDF attached. Subscribers with
+// the default preferences get the archived PDF; the others get a
+// personalized build, made once for each distinct set of preferences.
+// Builds and charts come from run, or from a run started here when run is
+// nil. If no run is available the email shows the archived insights
+// without charts.
+func emailReport(report ArchivedReport, run *reportRun) {
+	period, err := parsePeriodLabel(report.Date)
+	if err != nil {
+		log.Printf("report %s: %v", report.Date, err)
+		return
+	}
+	subscribers, err := digestSubscribers(period.Kind)
+	if err != nil {
+		log.Println("Error fetching subscribers:", err)
+		return
+	}
+
+	var runErr error
+	if run == nil {
+		// A run started here is this call's to clean up.
+		defer func() {
+			if run != nil {
+				run.close()
+			}
+		}()
+	}
+	build := func(opts reportOptions) (reportBuild, error) {
+		if run == nil {
+			if runErr != nil {
+				return reportBuild{}, runErr
+			}
+			if run, runErr = newReportRun(period, os.TempDir(), report.Narrative); runErr != nil {
+				return reportBuild{}, runErr
+			}
+		}
+		if opts.isDefault() {
+			b, err := run.prepare(opts)
+			b.PDF = report.PDF
+			return b, err
+		}
+		return run.build(opts)
+	}
+
+	built := make(map[string]reportBuild)
+	for _, p := range subscribers {
+		opts := p.reportOptions()
+		b, ok := built[opts.key()]
+		if !ok {
+			if b, err = build(opts); err != nil {
+				// Fall back to the archived report rather than send nothing.
+				log.Printf("report %s: building for %s: %v", report.Date, p.Email, err)
+				b = archivedBuild(report)
+			}
+			built[opts.key()] = b
+		}
+
+		subject := fmt.Sprintf(opts.text("Crypto Report %s"), report.Date)
+		if s, ok := reportSubjects[period.Kind]; ok {
+			subject = opts.text(s)
+		}
+		greeting := fmt.Sprintf(opts.text("Dear Subscriber,\n\nAttached is your %s for %s, prepared by the Crypto Dashboard team.\n\nBest regards,\nCrypto Dashboard Team"),
+			opts.text(period.Title()), report.Date)
+		email, err := renderReportEmail(b, opts, greeting)
+		if err != nil {
+			log.Printf("report %s: rendering email: %v", report.Date, err)
+			continue
+		}
+		if err := sendReportEmail(p.Email, subject, email, b.PDF, "crypto_report_"+report.Date+".pdf"); err != nil {
+			log.Printf("Failed to send email to %s: %v", p.Email, err)
 		} else {
-			log.Printf("Sent report to %s", email)
+			log.Printf("Sent %s report to %s", report.Date, p.Email)
 		}
 	}
-	iter.Close()
 }
 
-
-// generateReportHandler HTTP handler serves the PDF report.
+// generateReportHandler HTTP handler serves a PDF report from the archive,
+// building it first when it is missing or ?rebuild=true. The period comes
+// from ?period=daily|weekly|monthly with any ?date=YYYY-MM-DD inside it
+// (default the last complete period), or ?period=custom&start=&end=; a
+// period that has not finished is refused. ?narrative=template builds it without the LLM, and ?email=true also sends
+// it to the period's digest subscribers.
 func generateReportHandler(w http.ResponseWriter, r *http.Request) {
-	sendDailyReports()
-	tmpDir := os.TempDir()
-
-	pdfBytes, err := generateDailyReportPDF(tmpDir)
+	narrative, err := parseNarrative(r.URL.Query().Get("narrative"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	period, err := periodFromRequest(r, now)
 	if err != nil {
-		http.Error(w, "Failed to generate report: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A partial report would be archived under the period's final label
+	// and served until the worker replaced it.
+	if !period.Start.Before(now) {
+		http.Error(w, "The period has not started yet", http.StatusBadRequest)
+		return
+	}
+	if period.End.After(now) {
+		http.Error(w, "The period has not finished yet", http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/pdf")
-	w.Header().Set("Content-Disposition", `attachment; filename="daily_crypto_report.pdf"`)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(pdfBytes)))
+	var run *reportRun
+	report, err := store.Report(period.Label())
+	if err == ErrNotFound || r.URL.Query().Get("rebuild") == "true" {
+		run, err = newReportRun(period, os.TempDir(), narrative)
+		if err == nil {
+			defer run.close()
+			report, err = run.archive()
+		}
+		if err != nil {
+			http.Error(w, "Failed to generate report: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else if err != nil {
+		log.Printf("report %s: %v", period.Label(), err)
+		http.Error(w, "Failed to load report", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("email") == "true" {
+		emailReport(report, run)
+	}
+	writeReportPDF(w, report)
+}
+
+// reportAnalystRole is the system message for the report's commentary.
+const reportAnalystRole = "You are a financial analyst that writes concise, professional summaries."
 
-	if _, err := w.Write(pdfBytes); err != nil {
-		log.Printf("Error writing PDF response: %v", err)
+// languageInstruction asks for the commentary in language, "" for English.
+func languageInstruction(language string) string {
+	if l, ok := reportLanguages[language]; ok && language != LanguageEnglish {
+		return "\nWrite it in " + l.Name + "."
 	}
+	return ""
 }
 
-func generateAnalysisFromOpenAI(ctx context.Context, client *openai.Client, tableName string, rows [][]string) (string, error) {
+func generateAnalysis(ctx context.Context, client LLMClient, tableName string, rows [][]string, language string) (string, error) {
 	// Convert rows into a readable text block
 	var b strings.Builder
 	b.WriteString(fmt.Sprintf("Table: %s\n", tableName))
@@ -688,26 +1077,27 @@ func generateAnalysisFromOpenAI(ctx context.Context, client *openai.Client, tabl
 	}
 
 	prompt := fmt.Sprintf(`You are a financial analyst. 
-Analyze the following table and provide a concise professional summary (2-3 sentences) suitable for a crypto market PDF report:
+Analyze the following table and provide a concise professional summary (2-3 sentences) suitable for a crypto market PDF report.%s
 
-%s`, b.String())
+%s`, languageInstruction(language), b.String())
 
-	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: "gpt-4o-mini", // fast & cheap; could use gpt-4o if you want more detail
-		Messages: []openai.ChatCompletionMessage{
-			{Role: "system", Content: "You are a financial analyst that writes concise, professional summaries."},
-			{Role: "user", Content: prompt},
-		},
+	if client == nil {
+		return "", fmt.Errorf("no language model configured")
+	}
+	resp, err := client.Complete(ctx, LLMRequest{
+		Feature:   FeatureReportSection,
+		System:    reportAnalystRole,
+		Prompt:    prompt,
 		MaxTokens: 200,
 	})
 	if err != nil {
 		return "", err
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return resp.Text, nil
 }
 
-func generateChartAnalysis(ctx context.Context, client *openai.Client, coin string, series []PricePoint) (string, error) {
+func generateChartAnalysis(ctx context.Context, client LLMClient, coin string, series []PricePoint, loc *time.Location, language string) (string, error) {
 	if len(series) == 0 {
 		return fmt.Sprintf("No price data available for %s.", coin), nil
 	}
@@ -715,33 +1105,33 @@ func generateChartAnalysis(ctx context.Context, client *openai.Client, coin stri
 	var b strings.Builder
 	b.WriteString(fmt.Sprintf("Coin: %s\n", coin))
 	b.WriteString("Timestamps and Prices:\n")
-	for i, p := range series {
-		if i >= 20 { // limit rows to avoid giant prompt
-			b.WriteString("... (truncated)\n")
-			break
-		}
-		b.WriteString(fmt.Sprintf("%s | %.4f\n", p.Timestamp.Format("15:04"), p.Price))
+	// Sample at most 20 evenly spaced rows to avoid a giant prompt.
+	step := (len(series) + 19) / 20
+	for i := 0; i < len(series); i += step {
+		p := series[i]
+		b.WriteString(fmt.Sprintf("%s | %.4f\n", p.Timestamp.In(loc).Format("Jan 02 15:04 MST"), p.Price))
 	}
 
 	prompt := fmt.Sprintf(`You are a financial analyst. 
-Given the following intraday price series for %s, summarize the overall trend, volatility, and any key patterns. 
-Keep it to 2–3 professional sentences for a financial PDF report.
+Given the following price series for %s, summarize the overall trend, volatility, and any key patterns. 
+Keep it to 2–3 professional sentences for a financial PDF report.%s
 
-%s`, coin, b.String())
+%s`, coin, languageInstruction(language), b.String())
 
-	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: "gpt-4o-mini",
-		Messages: []openai.ChatCompletionMessage{
-			{Role: "system", Content: "You are a financial analyst that writes concise, professional summaries."},
-			{Role: "user", Content: prompt},
-		},
+	if client == nil {
+		return "", fmt.Errorf("no language model configured")
+	}
+	resp, err := client.Complete(ctx, LLMRequest{
+		Feature:   FeatureReportChart,
+		System:    reportAnalystRole,
+		Prompt:    prompt,
 		MaxTokens: 200,
 	})
 	if err != nil {
 		return "", err
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return resp.Text, nil
 }
 
 func addBackground(pdf *gofpdf.Fpdf, patternPath string) {
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Alert kinds.
+const (
+	// AlertThreshold fires when the price crosses Threshold in Direction
+	// ("above" or "below") between two consecutive ticks.
+	AlertThreshold = "threshold"
+	// AlertPctChange fires when the price has moved more than Threshold
+	// percent over the last WindowMinutes, "up", "down" or "any".
+	AlertPctChange = "pct_change"
+	// AlertVolatility fires when the standard deviation of the price over
+	// the last WindowMinutes exceeds Threshold percent of its mean.
+	AlertVolatility = "volatility"
+)
+
+// AlertRule is an account's or a subscriber's alert. UserID is the owning
+// account; rules created with the legacy X-Alert-Key have none and belong to
+// Email. Email is where email alerts go. WebhookSecret signs webhook
+// deliveries; it is returned once, when the rule is created, and never
+// listed again.
+type AlertRule struct {
+	ID              string    `json:"id"`
+	UserID          string    `json:"user_id,omitempty"`
+	Email           string    `json:"email"`
+	CoinID          string    `json:"coin_id"`
+	Kind            string    `json:"kind"`
+	Direction       string    `json:"direction,omitempty"`
+	Threshold       float64   `json:"threshold"`
+	WindowMinutes   int       `json:"window_minutes,omitempty"`
+	Channel         string    `json:"channel"`
+	WebhookURL      string    `json:"webhook_url,omitempty"`
+	WebhookSecret   string    `json:"webhook_secret,omitempty"`
+	CooldownMinutes int       `json:"cooldown_minutes"`
+	Enabled         bool      `json:"enabled"`
+	CreatedAt       time.Time `json:"created_at"`
+	LastFiredAt     time.Time `json:"last_fired_at,omitempty"`
+}
+
+// AlertStore persists alert rules and the keys subscribers use to manage
+// them. Keys are stored as SHA-256 hashes.
+type AlertStore interface {
+	// Alerts returns every rule.
+	Alerts() ([]AlertRule, error)
+	// Alert returns one rule, or ErrNotFound.
+	Alert(id string) (AlertRule, error)
+	// PutAlert adds or replaces a rule by ID.
+	PutAlert(a AlertRule) error
+	// DeleteAlert removes a rule.
+	DeleteAlert(id string) error
+	// PutAlertKey records that keyHash belongs to email.
+	PutAlertKey(keyHash, email string, createdAt time.Time) error
+	// AlertKeyEmail returns the email owning keyHash, or ErrNotFound.
+	AlertKeyEmail(keyHash string) (string, error)
+}
+
+// AlertEvent is what a fired rule delivers, as the webhook body and the
+// content of the email.
+type AlertEvent struct {
+	AlertID     string    `json:"alert_id"`
+	CoinID      string    `json:"coin_id"`
+	Kind        string    `json:"kind"`
+	Message     string    `json:"message"`
+	PriceUSD    float64   `json:"price_usd"`
+	Value       float64   `json:"value"`
+	Threshold   float64   `json:"threshold"`
+	TriggeredAt time.Time `json:"triggered_at"`
+}
+
+// validate checks a rule from the API and fills in defaults.
+func (a *AlertRule) validate() error {
+	c, ok := registry.Resolve(strings.TrimSpace(a.CoinID))
+	if !ok {
+		return fmt.Errorf("unknown coin %q", a.CoinID)
+	}
+	a.CoinID = c.ID
+	if a.Threshold <= 0 {
+		return fmt.Errorf("threshold must be positive")
+	}
+
+	switch a.Kind {
+	case AlertThreshold:
+		if a.Direction != "above" && a.Direction != "below" {
+			return fmt.Errorf("threshold alerts need direction \"above\" or \"below\"")
+		}
+		a.WindowMinutes = 0
+	case AlertPctChange:
+		if a.Direction == "" {
+			a.Direction = "any"
+		}
+		if a.Direction != "up" && a.Direction != "down" && a.Direction != "any" {
+			return fmt.Errorf("pct_change alerts need direction \"up\", \"down\" or \"any\"")
+		}
+		if a.WindowMinutes < 10 || a.WindowMinutes > 10080 {
+			return fmt.Errorf("window_minutes must be between 10 and 10080")
+		}
+	case AlertVolatility:
+		a.Direction = ""
+		if a.WindowMinutes < 30 || a.WindowMinutes > 10080 {
+			return fmt.Errorf("window_minutes must be between 30 and 10080")
+		}
+	default:
+		return fmt.Errorf("kind must be threshold, pct_change or volatility")
+	}
+
+	switch a.Channel {
+	case "", "email":
+		a.Channel = "email"
+		a.WebhookURL = ""
+	case "webhook":
+		if err := checkWebhookURL(a.WebhookURL); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("channel must be email or webhook")
+	}
+
+	if a.CooldownMinutes == 0 {
+		a.CooldownMinutes = 60
+	}
+	if a.CooldownMinutes < 1 || a.CooldownMinutes > 10080 {
+		return fmt.Errorf("cooldown_minutes must be between 1 and 10080")
+	}
+	return nil
+}
+
+// evaluate checks a against the row just stored for its coin and returns
+// the event to deliver, if any. It does not apply the cool-down.
+func (a AlertRule) evaluate(p PriceData) (*AlertEvent, error) {
+	ev := &AlertEvent{AlertID: a.ID, CoinID: a.CoinID, Kind: a.Kind, PriceUSD: p.PriceUSD, Threshold: a.Threshold, TriggeredAt: p.Timestamp}
+
+	switch a.Kind {
+	case AlertThreshold:
+		prev, err := store.At(a.CoinID, p.Timestamp.Add(-time.Millisecond))
+		if err == ErrNotFound {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		crossed := (a.Direction == "above" && prev.PriceUSD <= a.Threshold && p.PriceUSD > a.Threshold) ||
+			(a.Direction == "below" && prev.PriceUSD >= a.Threshold && p.PriceUSD < a.Threshold)
+		if !crossed {
+			return nil, nil
+		}
+		ev.Value = p.PriceUSD
+		ev.Message = fmt.Sprintf("%s crossed %s $%s: now $%s (was $%s)",
+			a.CoinID, a.Direction, formatAlertNumber(a.Threshold), formatAlertNumber(p.PriceUSD), formatAlertNumber(prev.PriceUSD))
+
+	case AlertPctChange:
+		then, err := store.At(a.CoinID, p.Timestamp.Add(-time.Duration(a.WindowMinutes)*time.Minute))
+		if err == ErrNotFound || (err == nil && then.PriceUSD <= 0) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		change := (p.PriceUSD - then.PriceUSD) / then.PriceUSD * 100
+		hit := (a.Direction == "up" && change >= a.Threshold) ||
+			(a.Direction == "down" && change <= -a.Threshold) ||
+			(a.Direction == "any" && math.Abs(change) >= a.Threshold)
+		if !hit {
+			return nil, nil
+		}
+		ev.Value = change
+		ev.Message = fmt.Sprintf("%s moved %+.2f%% in %d minutes to $%s", a.CoinID, change, a.WindowMinutes, formatAlertNumber(p.PriceUSD))
+
+	case AlertVolatility:
+		rows, err := store.Range(a.CoinID, p.Timestamp.Add(-time.Duration(a.WindowMinutes)*time.Minute), p.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) < 3 {
+			return nil, nil
+		}
+		var sum, variance float64
+		for _, row := range rows {
+			sum += row.PriceUSD
+		}
+		mean := sum / float64(len(rows))
+		for _, row := range rows {
+			variance += (row.PriceUSD - mean) * (row.PriceUSD - mean)
+		}
+		pct := math.Sqrt(variance/float64(len(rows))) / mean * 100
+		if pct < a.Threshold {
+			return nil, nil
+		}
+		ev.Value = pct
+		ev.Message = fmt.Sprintf("%s volatility over %d minutes is %.2f%% of its mean price (limit %.2f%%)", a.CoinID, a.WindowMinutes, pct, a.Threshold)
+
+	default:
+		return nil, nil
+	}
+	return ev, nil
+}
+
+func formatAlertNumber(v float64) string {
+	if v >= 1 {
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	}
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+// evaluateAlerts runs every enabled rule for the coins in rows, the tick the
+// ingestion job just stored. A rule fires at most once per cool-down, and
+// only while its owner is still an enabled account or, for legacy rules, a
+// verified subscriber. Email rules also need Email to be a verified
+// subscriber, so an account's alerts stop when its address unsubscribes.
+func evaluateAlerts(ctx context.Context, rows []PriceData) {
+	if len(rows) == 0 {
+		return
+	}
+	rules, err := store.Alerts()
+	if err != nil {
+		log.Printf("Error loading alerts: %v", err)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+	emails, err := store.Subscribers()
+	if err != nil {
+		log.Printf("Error loading subscribers for alerts: %v", err)
+		return
+	}
+	verified := make(map[string]bool, len(emails))
+	for _, e := range emails {
+		verified[e] = true
+	}
+	users, err := store.Users()
+	if err != nil {
+		log.Printf("Error loading accounts for alerts: %v", err)
+		return
+	}
+	enabled := make(map[string]bool, len(users))
+	for _, u := range users {
+		enabled[u.ID] = !u.Disabled
+	}
+	latest := make(map[string]PriceData, len(rows))
+	for _, p := range rows {
+		latest[p.CoinID] = p
+	}
+
+	for _, a := range rules {
+		p, ok := latest[a.CoinID]
+		if !ok || !a.Enabled {
+			continue
+		}
+		if (a.UserID != "" && !enabled[a.UserID]) || ((a.UserID == "" || a.Channel == "email") && !verified[a.Email]) {
+			continue
+		}
+		if !a.LastFiredAt.IsZero() && p.Timestamp.Sub(a.LastFiredAt) < time.Duration(a.CooldownMinutes)*time.Minute {
+			continue
+		}
+
+		ev, err := a.evaluate(p)
+		if err != nil {
+			log.Printf("Error evaluating alert %s: %v", a.ID, err)
+			continue
+		}
+		if ev == nil {
+			continue
+		}
+
+		if err := deliverAlert(ctx, a, *ev); err != nil {
+			// Leave LastFiredAt alone so the next tick retries.
+			log.Printf("Error delivering alert %s to %s: %v", a.ID, a.Channel, err)
+			continue
+		}
+		log.Printf("Alert %s fired: %s", a.ID, ev.Message)
+
+		a.LastFiredAt = p.Timestamp
+		if err := store.PutAlert(a); err != nil {
+			log.Printf("Error recording alert %s: %v", a.ID, err)
+		}
+	}
+}
+
+// alertWebhookClient delivers webhook alerts. It only connects to public
+// addresses and does not follow redirects, so a rule can't make the worker
+// call itself or the internal network.
+var alertWebhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: dialPublicOnly}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, 100.64.0.0/10.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicAddr reports whether webhooks may be sent to ip: anything but
+// loopback, private, shared, link-local, unspecified and multicast
+// addresses.
+func publicAddr(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) ||
+		ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast())
+}
+
+// checkWebhookURL accepts http(s) URLs whose host resolves only to public
+// addresses. Deliveries check the address again when they connect, since
+// DNS can change after the rule is saved.
+func checkWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return fmt.Errorf("webhook alerts need an http(s) webhook_url")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("webhook_url host %q does not resolve", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if !publicAddr(addr.IP) {
+			return fmt.Errorf("webhook_url must resolve to a public address")
+		}
+	}
+	return nil
+}
+
+// dialPublicOnly is the webhook dialer's Control hook; it refuses
+// connections to non-public addresses.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicAddr(ip) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}
+
+func deliverAlert(ctx context.Context, a AlertRule, ev AlertEvent) error {
+	if a.Channel == "webhook" {
+		return deliverAlertWebhook(ctx, a, ev)
+	}
+
+	from := os.Getenv("SMTP_EMAIL")
+	manage := "the key sent to this address"
+	if a.UserID != "" {
+		manage = "your API key"
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: Crypto alert: %s\r\n\r\n%s\r\n\r\nTriggered at %s.\r\nAlert id: %s. Manage your alerts with %s.\r\n",
+		from, a.Email, ev.Message, ev.Message, ev.TriggeredAt.UTC().Format(time.RFC1123), a.ID, manage)
+	return deliverMail(a.Email, []byte(msg))
+}
+
+// deliverAlertWebhook POSTs ev as JSON. X-Alert-Signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)), with the
+// Unix timestamp sent in X-Alert-Timestamp so receivers can reject replays.
+func deliverAlertWebhook(ctx context.Context, a AlertRule, ev AlertEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Alert-Id", a.ID)
+	req.Header.Set("X-Alert-Timestamp", ts)
+	req.Header.Set("X-Alert-Signature", "sha256="+signAlert(a.WebhookSecret, ts, body))
+
+	resp, err := alertWebhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func signAlert(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// randomHex returns n random bytes, hex encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// hashKey is how access keys are stored: hex SHA-256.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// alertKeyOwner returns the subscriber email for the X-Alert-Key header.
+func alertKeyOwner(r *http.Request) (string, bool) {
+	key := r.Header.Get("X-Alert-Key")
+	if key == "" {
+		return "", false
+	}
+	email, err := store.AlertKeyEmail(hashKey(key))
+	if err != nil {
+		if err != ErrNotFound {
+			log.Printf("Alert key lookup error: %v", err)
+		}
+		return "", false
+	}
+	return email, true
+}
+
+// requestAlertKey handles POST /alerts/key with {"email": ...}. A verified
+// subscriber is emailed a new key for the other /alerts routes; the response
+// is the same either way so it cannot be used to probe for subscribers.
+func requestAlertKey(w http.ResponseWriter, r *http.Request) {
+	mailSubscriberKey(w, r, "alert key", "X-Alert-Key", "create and manage price alerts", store.PutAlertKey)
+}
+
+// mailSubscriberKey reads {"email": ...} and, when it is a verified
+// subscriber, stores the hash of a new key with put and emails the key.
+// name is what the key is called, header where it goes and use what it is
+// for.
+func mailSubscriberKey(w http.ResponseWriter, r *http.Request, name, header, use string, put func(keyHash, email string, createdAt time.Time) error) {
+	var sub struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil || sub.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	emails, err := store.Subscribers()
+	if err != nil {
+		log.Printf("Error fetching subscribers: %v", err)
+		http.Error(w, "Failed to issue key", http.StatusInternalServerError)
+		return
+	}
+	for _, e := range emails {
+		if e != sub.Email {
+			continue
+		}
+		key := randomHex(24)
+		if err := put(hashKey(key), e, time.Now()); err != nil {
+			log.Printf("Error storing %s: %v", name, err)
+			http.Error(w, "Failed to issue key", http.StatusInternalServerError)
+			return
+		}
+		msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: Your Crypto Dashboard %s\r\n\r\nUse this key in the %s header to %s:\r\n\r\n%s\r\n\r\nIf you did not request it, you can ignore this email.\r\n",
+			os.Getenv("SMTP_EMAIL"), e, name, header, use, key)
+		if err := deliverMail(e, []byte(msg)); err != nil {
+			log.Printf("Error sending %s: %v", name, err)
+			http.Error(w, "Failed to send "+name, http.StatusInternalServerError)
+			return
+		}
+		break
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If this email is a verified subscriber, a new " + name + " has been sent to it.",
+	})
+}
+
+// alertOwner is who an /alerts request acts for: the caller's account, or
+// with the legacy X-Alert-Key a verified subscriber.
+type alertOwner struct {
+	UserID string
+	Email  string
+}
+
+// owns reports whether a belongs to o. Account rules and key rules are kept
+// apart even when the emails match, since account emails are not verified.
+func (o alertOwner) owns(a AlertRule) bool {
+	if o.UserID != "" {
+		return a.UserID == o.UserID
+	}
+	return a.UserID == "" && a.Email == o.Email
+}
+
+// withAlertOwner resolves the owner of the /alerts routes: the account of
+// the caller's API key or, failing that, the X-Alert-Key's subscriber.
+func withAlertOwner(next func(w http.ResponseWriter, r *http.Request, o alertOwner)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if u, ok := callerAccount(r); ok {
+			next(w, r, alertOwner{UserID: u.ID, Email: u.Email})
+			return
+		}
+		email, ok := alertKeyOwner(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="crypto-dashboard"`)
+			http.Error(w, "An API key or a valid X-Alert-Key is required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r, alertOwner{Email: email})
+	}
+}
+
+// verifiedSubscriber reports whether email is a verified subscriber.
+func verifiedSubscriber(email string) (bool, error) {
+	emails, err := store.Subscribers()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range emails {
+		if e == email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// allowAlertChannel refuses an account's email rule until the account's
+// email is a verified subscriber. Account emails are typed in by an admin
+// and never confirmed, so without this an alert could mail anyone. X-Alert-Key
+// owners are verified subscribers already.
+func allowAlertChannel(w http.ResponseWriter, a AlertRule, o alertOwner) bool {
+	if a.Channel != "email" || o.UserID == "" {
+		return true
+	}
+	ok, err := verifiedSubscriber(o.Email)
+	if err != nil {
+		log.Printf("Error fetching subscribers: %v", err)
+		http.Error(w, "Failed to save alert", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, "Email alerts need a verified address; subscribe "+o.Email+" with POST /subscribe and follow the link first", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ownedAlert loads the {id} rule and checks it belongs to o.
+func ownedAlert(w http.ResponseWriter, r *http.Request, o alertOwner) (AlertRule, bool) {
+	a, err := store.Alert(mux.Vars(r)["id"])
+	if err == ErrNotFound || (err == nil && !o.owns(a)) {
+		http.Error(w, "Alert not found", http.StatusNotFound)
+		return a, false
+	} else if err != nil {
+		log.Printf("Alert query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return a, false
+	}
+	return a, true
+}
+
+func writeAlert(w http.ResponseWriter, status int, a AlertRule, withSecret bool) {
+	if !withSecret {
+		a.WebhookSecret = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(a)
+}
+
+// listAlerts handles GET /alerts.
+func listAlerts(w http.ResponseWriter, r *http.Request, o alertOwner) {
+	rules, err := store.Alerts()
+	if err != nil {
+		log.Printf("Alert query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	out := []AlertRule{}
+	for _, a := range rules {
+		if o.owns(a) {
+			a.WebhookSecret = ""
+			out = append(out, a)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// createAlert handles POST /alerts. The response includes webhook_secret for
+// webhook rules; it is not shown again.
+func createAlert(w http.ResponseWriter, r *http.Request, o alertOwner) {
+	var a AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := a.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowAlertChannel(w, a, o) {
+		return
+	}
+	a.ID = uuid.New().String()
+	a.UserID = o.UserID
+	a.Email = o.Email
+	a.Enabled = true
+	a.CreatedAt = time.Now()
+	a.LastFiredAt = time.Time{}
+	a.WebhookSecret = ""
+	if a.Channel == "webhook" {
+		a.WebhookSecret = randomHex(32)
+	}
+
+	if err := store.PutAlert(a); err != nil {
+		log.Printf("Error saving alert: %v", err)
+		http.Error(w, "Failed to save alert", http.StatusInternalServerError)
+		return
+	}
+	writeAlert(w, http.StatusCreated, a, true)
+}
+
+// getAlert handles GET /alerts/{id}.
+func getAlert(w http.ResponseWriter, r *http.Request, o alertOwner) {
+	if a, ok := ownedAlert(w, r, o); ok {
+		writeAlert(w, http.StatusOK, a, false)
+	}
+}
+
+// updateAlert handles PUT /alerts/{id}. Fields left out of the body keep
+// their current values, so {"enabled": false} pauses a rule. The webhook
+// secret is kept, or issued when a rule switches to webhook.
+func updateAlert(w http.ResponseWriter, r *http.Request, o alertOwner) {
+	old, ok := ownedAlert(w, r, o)
+	if !ok {
+		return
+	}
+	a := old
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := a.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowAlertChannel(w, a, o) {
+		return
+	}
+	a.ID, a.UserID, a.Email, a.CreatedAt, a.LastFiredAt = old.ID, old.UserID, old.Email, old.CreatedAt, old.LastFiredAt
+	a.WebhookSecret = old.WebhookSecret
+	issued := false
+	if a.Channel == "webhook" && a.WebhookSecret == "" {
+		a.WebhookSecret = randomHex(32)
+		issued = true
+	}
+
+	if err := store.PutAlert(a); err != nil {
+		log.Printf("Error saving alert: %v", err)
+		http.Error(w, "Failed to save alert", http.StatusInternalServerError)
+		return
+	}
+	writeAlert(w, http.StatusOK, a, issued)
+}
+
+// deleteAlert handles DELETE /alerts/{id}.
+func deleteAlert(w http.ResponseWriter, r *http.Request, o alertOwner) {
+	a, ok := ownedAlert(w, r, o)
+	if !ok {
+		return
+	}
+	if err := store.DeleteAlert(a.ID); err != nil {
+		log.Printf("Error deleting alert: %v", err)
+		http.Error(w, "Failed to delete alert", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAccountEmailAlertsNeedVerifiedAddress(t *testing.T) {
+	s := useMemoryStore(t)
+	u := User{ID: "u1", Email: "a@example.com", Role: RoleUser}
+	if err := s.PutUser(u); err != nil {
+		t.Fatal(err)
+	}
+	_, key, err := issueAPIKey(u.ID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/alerts", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		authenticate(withAlertOwner(createAlert)).ServeHTTP(w, r)
+		return w
+	}
+	const email = `{"coin_id": "btc", "kind": "threshold", "direction": "above", "threshold": 100000}`
+	const webhook = `{"coin_id": "btc", "kind": "threshold", "direction": "above", "threshold": 100000, "channel": "webhook", "webhook_url": "https://93.184.216.34/hook"}`
+
+	if w := create(email); w.Code != http.StatusForbidden {
+		t.Errorf("unverified email rule: %d %s", w.Code, w.Body)
+	}
+	if w := create(webhook); w.Code != http.StatusCreated {
+		t.Errorf("webhook rule: %d %s", w.Code, w.Body)
+	}
+
+	// Nor can a webhook rule switch to email.
+	rules, _ := s.Alerts()
+	if len(rules) != 1 {
+		t.Fatalf("%d rules stored, want the webhook rule", len(rules))
+	}
+	r := httptest.NewRequest(http.MethodPut, "/alerts/"+rules[0].ID, strings.NewReader(`{"channel": "email"}`))
+	r.Header.Set("Authorization", "Bearer "+key)
+	r = mux.SetURLVars(r, map[string]string{"id": rules[0].ID})
+	w := httptest.NewRecorder()
+	authenticate(withAlertOwner(updateAlert)).ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("switching to email: %d %s", w.Code, w.Body)
+	}
+
+	if err := s.AddSubscriber(u.Email, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if w := create(email); w.Code != http.StatusCreated {
+		t.Errorf("verified email rule: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestWebhookAddresses(t *testing.T) {
+	for _, tt := range []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"100.128.0.1", true},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+	} {
+		if got := publicAddr(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("publicAddr(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+
+	for _, raw := range []string{
+		"http://127.0.0.1:8080/hook",
+		"https://[::1]/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://100.100.100.200/",
+		"ftp://93.184.216.34/hook",
+		"file:///etc/passwd",
+		"https:///hook",
+		"not a url",
+	} {
+		if err := checkWebhookURL(raw); err == nil {
+			t.Errorf("checkWebhookURL(%q) accepted", raw)
+		}
+	}
+	if err := checkWebhookURL("https://93.184.216.34/hook"); err != nil {
+		t.Errorf("public address refused: %v", err)
+	}
+}
+
+func TestDeliverAlertWebhook(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	a := AlertRule{ID: "a1", Channel: "webhook", WebhookURL: srv.URL, WebhookSecret: "s3cret"}
+	ev := AlertEvent{AlertID: "a1", CoinID: "bitcoin", Kind: "threshold", PriceUSD: 100000}
+
+	// The test server listens on loopback, which the real client refuses
+	// even though the URL was never checked.
+	if err := deliverAlertWebhook(context.Background(), a, ev); err == nil || got != nil {
+		t.Fatalf("delivered to %s: %v", srv.URL, err)
+	}
+
+	old := alertWebhookClient
+	alertWebhookClient = srv.Client()
+	t.Cleanup(func() { alertWebhookClient = old })
+	if err := deliverAlertWebhook(context.Background(), a, ev); err != nil {
+		t.Fatal(err)
+	}
+	var sent AlertEvent
+	if err := json.Unmarshal(body, &sent); err != nil || sent.CoinID != "bitcoin" {
+		t.Errorf("body %s: %v", body, err)
+	}
+	ts := got.Header.Get("X-Alert-Timestamp")
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(ts + "." + string(body)))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.Header.Get("X-Alert-Signature") != want {
+		t.Errorf("signature %q, want %q", got.Header.Get("X-Alert-Signature"), want)
+	}
+	if got.Header.Get("X-Alert-Id") != "a1" || ts == "" {
+		t.Errorf("headers %v", got.Header)
+	}
+	// A different secret or timestamp gives a different signature.
+	if signAlert("other", ts, body) == signAlert("s3cret", ts, body) || signAlert("s3cret", ts+"0", body) == signAlert("s3cret", ts, body) {
+		t.Error("the signature does not cover the secret and timestamp")
+	}
+}
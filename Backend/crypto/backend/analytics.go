@@ -8,11 +8,12 @@ import (
     "strconv"
     "time"
 
-    "github.com/gocql/gocql"
     "github.com/gorilla/mux"
 )
 
-// Volatility Endpoint
+// Volatility Endpoint. With ?interval=1h (or 1m, 5m, 1d) it works from candle
+// closes instead of every raw row, and ?vs=eur (or any quote currency) prices
+// the series in that currency.
 func getVolatility(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     coinID := vars["coin_id"]
@@ -31,23 +32,30 @@ func getVolatility(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    iter := session.Query(`
-        SELECT price_usd
-        FROM crypto_price_by_coin
-        WHERE coin_id = ? AND timestamp >= ? AND timestamp <= ? ALLOW FILTERING`,
-        coinID, start, end).Consistency(gocql.One).Iter()
+    interval := r.URL.Query().Get("interval")
+    if _, ok := candleIntervals[interval]; interval != "" && !ok {
+        http.Error(w, "Invalid interval", http.StatusBadRequest)
+        return
+    }
 
-    var prices []float64
-    var price float64
-    for iter.Scan(&price) {
-        prices = append(prices, price)
+    vs, err := parseVs(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
     }
 
-    if err := iter.Close(); err != nil {
+    timestamps, prices, err := rangeSeries(coinID, interval, start, end)
+    if err != nil {
         http.Error(w, "Query error", http.StatusInternalServerError)
         return
     }
 
+    prices, err = convertSeries(coinID, vs, timestamps, prices)
+    if err != nil {
+        writeConversionError(w, err)
+        return
+    }
+
     if len(prices) < 2 {
         http.Error(w, "Not enough data points", http.StatusBadRequest)
         return
@@ -70,13 +78,14 @@ func getVolatility(w http.ResponseWriter, r *http.Request) {
         "stddev_price": stddev,
         "mean_price":   mean,
         "data_points":  len(prices),
+        "vs_currency":  vsLabel(vs),
     }
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(response)
 }
 
-// Trend Endpoint
+// Trend Endpoint. Accepts the same optional interval and vs as getVolatility.
 func getTrend(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     coinID := vars["coin_id"]
@@ -95,27 +104,37 @@ func getTrend(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    iter := session.Query(`
-        SELECT timestamp, price_usd
-        FROM crypto_price_by_coin
-        WHERE coin_id = ? AND timestamp >= ? AND timestamp <= ? ALLOW FILTERING`,
-        coinID, start, end).Consistency(gocql.One).Iter()
-
-    var timestamp time.Time
-    var price float64
-    var xValues, yValues []float64
+    interval := r.URL.Query().Get("interval")
+    if _, ok := candleIntervals[interval]; interval != "" && !ok {
+        http.Error(w, "Invalid interval", http.StatusBadRequest)
+        return
+    }
 
-    for iter.Scan(&timestamp, &price) {
-        x := float64(timestamp.Unix())
-        xValues = append(xValues, x)
-        yValues = append(yValues, price)
+    vs, err := parseVs(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
     }
 
-    if err := iter.Close(); err != nil {
+    timestamps, prices, err := rangeSeries(coinID, interval, start, end)
+    if err != nil {
         http.Error(w, "Query error", http.StatusInternalServerError)
         return
     }
 
+    prices, err = convertSeries(coinID, vs, timestamps, prices)
+    if err != nil {
+        writeConversionError(w, err)
+        return
+    }
+
+    var xValues, yValues []float64
+    for i, ts := range timestamps {
+        x := float64(ts.Unix())
+        xValues = append(xValues, x)
+        yValues = append(yValues, prices[i])
+    }
+
     n := len(xValues)
     if n < 2 {
         http.Error(w, "Not enough data points", http.StatusBadRequest)
@@ -139,6 +158,7 @@ func getTrend(w http.ResponseWriter, r *http.Request) {
         "data_points":   n,
         "start":         start,
         "end":           end,
+        "vs_currency":   vsLabel(vs),
     }
 
     w.Header().Set("Content-Type", "application/json")
@@ -156,6 +176,10 @@ func trendDescription(slope float64) string {
     }
 }
 
+// getTopMovers handles GET /top-movers?minutes=1440&sort=change&min_volume=0.
+// sort is "change" (largest absolute move, the default), "volume" or
+// "market_cap"; min_volume drops coins with less 24h USD volume. start and
+// end (RFC3339) measure a past window instead of the last minutes.
 func getTopMovers(w http.ResponseWriter, r *http.Request) {
     minutes := 1440
     if v := r.URL.Query().Get("minutes"); v != "" {
@@ -164,24 +188,59 @@ func getTopMovers(w http.ResponseWriter, r *http.Request) {
         }
     }
 
+    sortBy := r.URL.Query().Get("sort")
+    if sortBy == "" {
+        sortBy = "change"
+    }
+    if sortBy != "change" && sortBy != "volume" && sortBy != "market_cap" {
+        http.Error(w, "Invalid sort (use change, volume or market_cap)", http.StatusBadRequest)
+        return
+    }
+
+    var minVolume float64
+    if v := r.URL.Query().Get("min_volume"); v != "" {
+        parsed, err := strconv.ParseFloat(v, 64)
+        if err != nil || parsed < 0 {
+            http.Error(w, "Invalid min_volume", http.StatusBadRequest)
+            return
+        }
+        minVolume = parsed
+    }
+
     since := time.Now().UTC().Add(-time.Duration(minutes) * time.Minute)
+    var until time.Time
+    if startStr, endStr := r.URL.Query().Get("start"), r.URL.Query().Get("end"); startStr != "" || endStr != "" {
+        var err error
+        if since, err = time.Parse(time.RFC3339, startStr); err != nil {
+            http.Error(w, "Invalid start time", http.StatusBadRequest)
+            return
+        }
+        if until, err = time.Parse(time.RFC3339, endStr); err != nil {
+            http.Error(w, "Invalid end time", http.StatusBadRequest)
+            return
+        }
+    }
 
-    iter := session.Query(`SELECT DISTINCT coin_id FROM crypto_price_by_coin`).Iter()
+    // Coins the registry disabled are left out, like in /coins.
     var coins []string
-    var coinID string
-    for iter.Scan(&coinID) {
-        coins = append(coins, coinID)
+    for _, c := range registry.Enabled() {
+        coins = append(coins, c.ID)
     }
-    if err := iter.Close(); err != nil {
-        http.Error(w, "failed to fetch coin list", http.StatusInternalServerError)
-        return
+    if len(coins) == 0 {
+        var err error
+        if coins, err = store.Coins(); err != nil {
+            http.Error(w, "failed to fetch coin list", http.StatusInternalServerError)
+            return
+        }
     }
 
     type CoinChange struct {
-        CoinID string  `json:"coin_id"`
-        Start  float64 `json:"start_price"`
-        End    float64 `json:"end_price"`
-        Change float64 `json:"percent_change"`
+        CoinID    string  `json:"coin_id"`
+        Start     float64 `json:"start_price"`
+        End       float64 `json:"end_price"`
+        Change    float64 `json:"percent_change"`
+        MarketCap float64 `json:"market_cap_usd,omitempty"`
+        Volume24h float64 `json:"volume_24h_usd,omitempty"`
     }
 
     var movers []CoinChange
@@ -189,49 +248,48 @@ func getTopMovers(w http.ResponseWriter, r *http.Request) {
     for _, coin := range coins {
         var startPrice, endPrice float64
         var foundStart, foundEnd bool
+        var latest PriceData
 
         // Price at or before boundary
-        err := session.Query(`
-            SELECT price_usd
-            FROM crypto_price_by_coin
-            WHERE coin_id = ? AND timestamp <= ?
-            ORDER BY timestamp DESC
-            LIMIT 1
-        `, coin, since).
-            Consistency(gocql.One).
-            Scan(&startPrice)
-        if err == nil {
+        if p, err := store.At(coin, since); err == nil {
+            startPrice = p.PriceUSD
             foundStart = true
         }
 
-        // Latest price
-        err = session.Query(`
-            SELECT price_usd
-            FROM crypto_price_by_coin
-            WHERE coin_id = ?
-            ORDER BY timestamp DESC
-            LIMIT 1
-        `, coin).
-            Consistency(gocql.One).
-            Scan(&endPrice)
+        // Latest price, or the last one in the window
+        p, err := store.Latest(coin)
+        if !until.IsZero() {
+            p, err = store.At(coin, until)
+        }
         if err == nil {
+            endPrice = p.PriceUSD
+            latest = p
             foundEnd = true
         }
 
-        if foundStart && foundEnd && startPrice > 0 {
+        if foundStart && foundEnd && startPrice > 0 && latest.Volume24hUSD >= minVolume {
             percentChange := ((endPrice - startPrice) / startPrice) * 100
             movers = append(movers, CoinChange{
-                CoinID: coin,
-                Start:  startPrice,
-                End:    endPrice,
-                Change: percentChange,
+                CoinID:    coin,
+                Start:     startPrice,
+                End:       endPrice,
+                Change:    percentChange,
+                MarketCap: latest.MarketCapUSD,
+                Volume24h: latest.Volume24hUSD,
             })
         }
     }
 
-    // Sort by largest absolute change
     sort.Slice(movers, func(i, j int) bool {
-        return math.Abs(movers[i].Change) > math.Abs(movers[j].Change)
+        switch sortBy {
+        case "volume":
+            return movers[i].Volume24h > movers[j].Volume24h
+        case "market_cap":
+            return movers[i].MarketCap > movers[j].MarketCap
+        default:
+            // Largest absolute change
+            return math.Abs(movers[i].Change) > math.Abs(movers[j].Change)
+        }
     })
 
     w.Header().Set("Content-Type", "application/json")
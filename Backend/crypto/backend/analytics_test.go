@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTopMoversSkipsDisabledCoins(t *testing.T) {
+	s := useMemoryStore(t)
+	end := time.Now().UTC().Truncate(time.Minute)
+	for coin, prices := range map[string][2]float64{"bitcoin": {100, 110}, "ethereum": {100, 95}, "terra": {1, 5}} {
+		s.Insert(PriceData{CoinID: coin, Timestamp: end.Add(-2 * time.Hour), PriceUSD: prices[0]})
+		s.Insert(PriceData{CoinID: coin, Timestamp: end, PriceUSD: prices[1]})
+	}
+
+	w := httptest.NewRecorder()
+	getTopMovers(w, httptest.NewRequest(http.MethodGet, "/top-movers?minutes=60", nil))
+	var movers []struct {
+		CoinID string  `json:"coin_id"`
+		Change float64 `json:"percent_change"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&movers); err != nil {
+		t.Fatalf("%d: %v", w.Code, err)
+	}
+	var got []string
+	for _, m := range movers {
+		got = append(got, m.CoinID)
+	}
+	if len(got) != 2 || got[0] != "bitcoin" || got[1] != "ethereum" {
+		t.Errorf("movers = %v, want bitcoin and ethereum without the disabled terra", got)
+	}
+}
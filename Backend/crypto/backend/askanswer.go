@@ -0,0 +1,220 @@
+//go:build !ingest
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// askConversationTTL is how long a conversation stays open after its
+	// last question.
+	askConversationTTL = 30 * time.Minute
+	// askConversationTurns is how many earlier turns are kept as context.
+	askConversationTurns = 5
+	// askAnswerDataLimit caps the JSON handed to the model for an answer.
+	askAnswerDataLimit = 12000
+)
+
+// askTurn is one answered question, kept so a follow-up can refer to it.
+type askTurn struct {
+	Question string     `json:"question"`
+	Intent   *askIntent `json:"intent,omitempty"`
+	Query    string     `json:"query,omitempty"`
+	Answer   string     `json:"answer"`
+}
+
+// askConversation is the context follow-up questions are asked against.
+type askConversation struct {
+	ID      string
+	Owner   string
+	Turns   []askTurn
+	Updated time.Time
+}
+
+// askConversations holds open conversations in the API process.
+type askConversations struct {
+	mu        sync.Mutex
+	byID      map[string]*askConversation
+	lastSweep time.Time
+}
+
+var conversations = &askConversations{byID: make(map[string]*askConversation)}
+
+// get returns a copy of the conversation id if it is still open and was
+// started by owner.
+func (c *askConversations) get(id, owner string, now time.Time) (askConversation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep(now)
+	conv, ok := c.byID[id]
+	if !ok || conv.Owner != owner {
+		return askConversation{}, false
+	}
+	out := *conv
+	out.Turns = append([]askTurn(nil), conv.Turns...)
+	return out, true
+}
+
+// add records turn in conversation id, starting a new conversation when
+// id is empty, and returns the conversation's id.
+func (c *askConversations) add(id, owner string, turn askTurn, now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conv, ok := c.byID[id]
+	if !ok || conv.Owner != owner {
+		conv = &askConversation{ID: randomHex(16), Owner: owner}
+		c.byID[conv.ID] = conv
+	}
+	conv.Turns = append(conv.Turns, turn)
+	if len(conv.Turns) > askConversationTurns {
+		conv.Turns = conv.Turns[len(conv.Turns)-askConversationTurns:]
+	}
+	conv.Updated = now
+	return conv.ID
+}
+
+// sweep drops expired conversations, at most once a minute. c.mu must be
+// held.
+func (c *askConversations) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	c.lastSweep = now
+	for id, conv := range c.byID {
+		if now.Sub(conv.Updated) > askConversationTTL {
+			delete(c.byID, id)
+		}
+	}
+}
+
+// askOwner identifies who may continue a conversation: the caller's user,
+// or their address when anonymous.
+func askOwner(r *http.Request) string {
+	if p, ok := currentPrincipal(r); ok {
+		return "user:" + p.User.ID
+	}
+	return "ip:" + clientIP(r)
+}
+
+// conversationContext renders earlier turns for a prompt.
+func conversationContext(turns []askTurn) string {
+	if len(turns) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("This is a follow-up. The conversation so far, oldest first:\n")
+	for _, t := range turns {
+		fmt.Fprintf(&b, "- Question: %s\n", t.Question)
+		if t.Intent != nil {
+			intent, _ := json.Marshal(t.Intent)
+			fmt.Fprintf(&b, "  Intent: %s\n", intent)
+		}
+		if t.Query != "" {
+			fmt.Fprintf(&b, "  Query: %s\n", t.Query)
+		}
+		fmt.Fprintf(&b, "  Answer: %s\n", t.Answer)
+	}
+	b.WriteString("Keep anything from the latest turn that the new question does not change, e.g. \"and what about solana?\" asks the same thing about solana.\n")
+	return b.String()
+}
+
+// askRowSummary condenses one coin's rows from a CQL answer into the
+// numbers an answer can quote.
+type askRowSummary struct {
+	CoinID        string    `json:"coin_id"`
+	Rows          int       `json:"rows"`
+	First         time.Time `json:"first_timestamp"`
+	Last          time.Time `json:"last_timestamp"`
+	FirstPrice    float64   `json:"first_price_usd"`
+	LastPrice     float64   `json:"last_price_usd"`
+	Min           float64   `json:"min_price_usd"`
+	Max           float64   `json:"max_price_usd"`
+	Average       float64   `json:"average_price_usd"`
+	PercentChange float64   `json:"percent_change"`
+}
+
+func summarizeRows(rows []PriceData) []askRowSummary {
+	byCoin := make(map[string][]PriceData)
+	for _, r := range rows {
+		byCoin[r.CoinID] = append(byCoin[r.CoinID], r)
+	}
+	out := make([]askRowSummary, 0, len(byCoin))
+	for coin, series := range byCoin {
+		sort.Slice(series, func(i, j int) bool { return series[i].Timestamp.Before(series[j].Timestamp) })
+		s := askRowSummary{
+			CoinID:     coin,
+			Rows:       len(series),
+			First:      series[0].Timestamp,
+			Last:       series[len(series)-1].Timestamp,
+			FirstPrice: series[0].PriceUSD,
+			LastPrice:  series[len(series)-1].PriceUSD,
+			Min:        math.Inf(1),
+			Max:        math.Inf(-1),
+		}
+		var sum float64
+		for _, p := range series {
+			sum += p.PriceUSD
+			s.Min = math.Min(s.Min, p.PriceUSD)
+			s.Max = math.Max(s.Max, p.PriceUSD)
+		}
+		s.Average = sum / float64(len(series))
+		if s.FirstPrice != 0 {
+			s.PercentChange = (s.LastPrice - s.FirstPrice) / s.FirstPrice * 100
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CoinID < out[j].CoinID })
+	return out
+}
+
+// answerPrompt asks the model to answer question from data alone.
+func answerPrompt(question, used string, data interface{}, history []askTurn) string {
+	payload, _ := json.Marshal(data)
+	if len(payload) > askAnswerDataLimit {
+		payload = append(payload[:askAnswerDataLimit], "…(truncated)"...)
+	}
+	return `You answer questions about cryptocurrency prices for a dashboard.
+Answer in at most three sentences of plain text, no markdown. Quote the numbers that support the answer
+(prices in USD unless the data says otherwise, rounded sensibly) and the time window they cover.
+Use only the data below. If it cannot answer the question, say so.
+
+` + conversationContext(history) + `
+Question: ` + question + `
+Query used: ` + used + `
+Data: ` + string(payload)
+}
+
+// writeAnswer summarizes data into a natural-language answer, records the
+// turn in the caller's conversation and writes the response. fields are the
+// supporting query and numbers returned alongside the answer.
+func writeAnswer(w http.ResponseWriter, r *http.Request, ask askRequest, turn askTurn, used string, data interface{}, fields map[string]interface{}) {
+	answer, err := complete(r.Context(), FeatureAskAnswer, "", answerPrompt(turn.Question, used, data, ask.history))
+	if err != nil {
+		log.Printf("AI ask: answer synthesis failed: %v", err)
+		writeAskError(w, http.StatusInternalServerError, "AI answer failed: "+err.Error())
+		return
+	}
+	turn.Answer = strings.TrimSpace(answer)
+
+	resp := map[string]interface{}{
+		"answer":          turn.Answer,
+		"question":        turn.Question,
+		"conversation_id": conversations.add(ask.conversationID, askOwner(r), turn, time.Now()),
+	}
+	for k, v := range fields {
+		resp[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("AI ask: encode error: %v", err)
+	}
+}
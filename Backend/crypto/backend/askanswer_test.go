@@ -0,0 +1,84 @@
+//go:build !ingest
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAskConversations(t *testing.T) {
+	c := &askConversations{byID: make(map[string]*askConversation)}
+	now := askNow
+
+	id := c.add("", "user:u1", askTurn{Question: "q0"}, now)
+	// An unknown id or someone else's conversation starts a new one.
+	if other := c.add(id, "ip:192.0.2.1", askTurn{Question: "theirs"}, now); other == id {
+		t.Fatal("another caller joined the conversation")
+	}
+	if again := c.add("gone", "user:u1", askTurn{Question: "new"}, now); again == id {
+		t.Fatal("an unknown id continued the conversation")
+	}
+	for i := 1; i <= askConversationTurns; i++ {
+		if got := c.add(id, "user:u1", askTurn{Question: fmt.Sprintf("q%d", i)}, now); got != id {
+			t.Fatalf("turn %d started conversation %s", i, got)
+		}
+	}
+
+	conv, ok := c.get(id, "user:u1", now)
+	if !ok || len(conv.Turns) != askConversationTurns || conv.Turns[0].Question != "q1" {
+		t.Fatalf("conversation %+v, %v: want the last %d turns", conv, ok, askConversationTurns)
+	}
+	// The copy does not share turns with the stored conversation.
+	conv.Turns[0].Question = "edited"
+	if again, _ := c.get(id, "user:u1", now); again.Turns[0].Question != "q1" {
+		t.Error("get returned the stored turns")
+	}
+	if _, ok := c.get(id, "ip:192.0.2.1", now); ok {
+		t.Error("another caller read the conversation")
+	}
+
+	if _, ok := c.get(id, "user:u1", now.Add(askConversationTTL-time.Second)); !ok {
+		t.Error("expired before its TTL")
+	}
+	if _, ok := c.get(id, "user:u1", now.Add(askConversationTTL+2*time.Minute)); ok {
+		t.Error("still open after its TTL")
+	}
+}
+
+func TestConversationContext(t *testing.T) {
+	if conversationContext(nil) != "" {
+		t.Error("context without turns")
+	}
+	got := conversationContext([]askTurn{
+		{Question: "average btc last week?", Intent: &askIntent{Metric: "average", Coins: []string{"bitcoin"}}, Answer: "About 95,000 USD."},
+		{Question: "and eth?", Query: "SELECT ...", Answer: "About 3,300 USD."},
+	})
+	for _, want := range []string{"follow-up", "average btc last week?", `"metric":"average"`, "Query: SELECT ...", "About 3,300 USD."} {
+		if !strings.Contains(got, want) {
+			t.Errorf("context lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "btc last week") > strings.Index(got, "and eth?") {
+		t.Error("turns are not oldest first")
+	}
+}
+
+func TestSummarizeRows(t *testing.T) {
+	at := func(minute int) time.Time { return askNow.Add(time.Duration(minute) * time.Minute) }
+	got := summarizeRows([]PriceData{
+		{CoinID: "ethereum", Timestamp: at(0), PriceUSD: 10},
+		{CoinID: "bitcoin", Timestamp: at(20), PriceUSD: 110},
+		{CoinID: "bitcoin", Timestamp: at(0), PriceUSD: 100},
+		{CoinID: "bitcoin", Timestamp: at(10), PriceUSD: 90},
+	})
+	if len(got) != 2 || got[0].CoinID != "bitcoin" || got[1].CoinID != "ethereum" {
+		t.Fatalf("summaries %+v", got)
+	}
+	want := askRowSummary{CoinID: "bitcoin", Rows: 3, First: at(0), Last: at(20), FirstPrice: 100, LastPrice: 110, Min: 90, Max: 110, Average: 100, PercentChange: 10}
+	if b := got[0]; b != want {
+		t.Errorf("bitcoin = %+v\nwant %+v", b, want)
+	}
+}
@@ -0,0 +1,230 @@
+//go:build !ingest
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// askIntent is what the model extracts from a question. Times are RFC3339;
+// a missing window means the last 24 hours.
+type askIntent struct {
+	Metric         string   `json:"metric"`
+	Coins          []string `json:"coins"`
+	Start          string   `json:"start,omitempty"`
+	End            string   `json:"end,omitempty"`
+	At             string   `json:"at,omitempty"`
+	Interval       string   `json:"interval,omitempty"`
+	HorizonMinutes int      `json:"horizon_minutes,omitempty"`
+	VsCurrency     string   `json:"vs_currency,omitempty"`
+}
+
+// askMetric describes how an intent metric maps onto an existing endpoint.
+type askMetric struct {
+	handler http.HandlerFunc
+	// perCoin metrics are answered once for each coin in the intent.
+	perCoin bool
+	// params turns the resolved intent into the endpoint's query string.
+	params func(in askIntent, start, end time.Time) url.Values
+}
+
+func windowParams(in askIntent, start, end time.Time) url.Values {
+	v := url.Values{"start": {start.Format(time.RFC3339)}, "end": {end.Format(time.RFC3339)}}
+	if in.Interval != "" {
+		v.Set("interval", in.Interval)
+	}
+	return v
+}
+
+// askMetrics are the metrics /ask can answer, each by one of the REST
+// handlers, so an answer carries exactly the numbers the endpoint would.
+var askMetrics = map[string]askMetric{
+	"latest":     {getLatestPrice, true, func(askIntent, time.Time, time.Time) url.Values { return url.Values{} }},
+	"history":    {getHistory, true, windowParams},
+	"average":    {getAveragePrice, true, windowParams},
+	"range":      {getPriceRange, true, windowParams},
+	"volatility": {getVolatility, true, windowParams},
+	"trend":      {getTrend, true, windowParams},
+	"price_at": {getPriceAtTime, true, func(in askIntent, start, end time.Time) url.Values {
+		return url.Values{"timestamp": {in.At}}
+	}},
+	"prediction": {getPredict, true, func(in askIntent, start, end time.Time) url.Values {
+		// The handler's lookback runs from 30 minutes to 30 days.
+		lookback := int(end.Sub(start).Minutes())
+		if lookback < 30 {
+			lookback = 30
+		} else if lookback > 43200 {
+			lookback = 43200
+		}
+		v := url.Values{"lookback_minutes": {strconv.Itoa(lookback)}}
+		if in.HorizonMinutes > 0 {
+			v.Set("horizon_minutes", strconv.Itoa(in.HorizonMinutes))
+		}
+		return v
+	}},
+	"top_movers": {getTopMovers, false, windowParams},
+}
+
+// intentPrompt asks the model for an askIntent as JSON.
+// Earlier turns of a conversation are included so follow-ups resolve.
+func intentPrompt(question string, now time.Time, history []askTurn) string {
+	return `You turn questions about cryptocurrency prices into a JSON intent for an analytics API.
+Return ONLY a JSON object, no markdown, with these fields:
+- "metric": one of "latest" (current price), "history" (raw price rows), "average", "range" (min and max),
+  "volatility" (standard deviation), "trend" (direction and slope), "price_at" (price at one moment),
+  "prediction" (forecast), "top_movers" (largest moves across all coins).
+- "coins": coin ids from this list: ` + strings.Join(askCoinIDs(), ", ") + `. Empty for top_movers.
+- "start", "end": the time window as RFC3339 UTC timestamps. Omit for "latest".
+- "at": RFC3339 UTC timestamp, only for "price_at".
+- "interval": optional candle size for volatility and trend over long windows: "1h" or "1d".
+- "horizon_minutes": optional, only for "prediction".
+- "vs_currency": optional quote currency such as "eur" or "btc"; omit for USD.
+
+The current UTC time is ` + now.Format(time.RFC3339) + `. "Yesterday" is the previous UTC calendar day; "last week" is the 7 days before now.
+
+` + conversationContext(history) + `
+Question: ` + question
+}
+
+// parseIntent reads the model's reply, tolerating code fences or text
+// around the JSON object.
+func parseIntent(reply string) (askIntent, error) {
+	var in askIntent
+	i, j := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if i < 0 || j < i {
+		return in, fmt.Errorf("the model did not return a JSON intent")
+	}
+	if err := json.Unmarshal([]byte(reply[i:j+1]), &in); err != nil {
+		return in, fmt.Errorf("the model returned an invalid intent: %v", err)
+	}
+	in.Metric = strings.ToLower(strings.TrimSpace(in.Metric))
+	return in, nil
+}
+
+// resolveIntent checks in against the registry, replacing aliases with
+// canonical coin ids, and returns the window to query.
+func resolveIntent(in *askIntent, now time.Time) (time.Time, time.Time, error) {
+	m, ok := askMetrics[in.Metric]
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("unsupported metric %q", in.Metric)
+	}
+	if m.perCoin && len(in.Coins) == 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("the question does not name a coin")
+	}
+	if len(in.Coins) > askMaxCoins {
+		return time.Time{}, time.Time{}, fmt.Errorf("at most %d coins may be asked about at once", askMaxCoins)
+	}
+	for i, id := range in.Coins {
+		c, ok := registry.Resolve(strings.ToLower(strings.TrimSpace(id)))
+		if !ok {
+			return time.Time{}, time.Time{}, fmt.Errorf("unknown coin %q", id)
+		}
+		in.Coins[i] = c.ID
+	}
+
+	end := now
+	if in.End != "" {
+		t, err := time.Parse(time.RFC3339, in.End)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end %q", in.End)
+		}
+		end = t
+	}
+	start := end.Add(-24 * time.Hour)
+	if in.Start != "" {
+		t, err := time.Parse(time.RFC3339, in.Start)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid start %q", in.Start)
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("the time window is empty")
+	}
+	if end.Sub(start) > 366*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("the time window is longer than a year")
+	}
+	// history returns raw rows, so it gets the same bound as a CQL query.
+	if in.Metric == "history" && end.Sub(start) > askMaxRange {
+		return time.Time{}, time.Time{}, fmt.Errorf("a history window may be at most 31 days")
+	}
+	if in.Metric == "price_at" {
+		if _, err := time.Parse(time.RFC3339, in.At); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("price_at needs an RFC3339 \"at\"")
+		}
+	}
+	in.Start, in.End = start.Format(time.RFC3339), end.Format(time.RFC3339)
+	return start, end, nil
+}
+
+// askResult is one endpoint call made for an intent.
+type askResult struct {
+	CoinID string          `json:"coin_id,omitempty"`
+	Metric string          `json:"metric"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// captureWriter records a handler's response in memory.
+type captureWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (c *captureWriter) Header() http.Header { return c.header }
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	return c.body.Write(b)
+}
+
+func (c *captureWriter) WriteHeader(status int) { c.status = status }
+
+// runIntent calls the metric's handler for each coin, as a request to the
+// matching REST route would.
+func runIntent(r *http.Request, in askIntent, start, end time.Time) []askResult {
+	m := askMetrics[in.Metric]
+	params := m.params(in, start, end)
+	if in.VsCurrency != "" && in.Metric != "top_movers" && in.Metric != "price_at" {
+		params.Set("vs", strings.ToLower(in.VsCurrency))
+	}
+
+	coins := in.Coins
+	if !m.perCoin {
+		coins = []string{""}
+	}
+	results := make([]askResult, 0, len(coins))
+	for _, coin := range coins {
+		req := r.Clone(r.Context())
+		req.Method = http.MethodGet
+		req.Body = http.NoBody
+		req.URL = &url.URL{Path: "/" + in.Metric, RawQuery: params.Encode()}
+		if coin != "" {
+			req = mux.SetURLVars(req, map[string]string{"coin_id": coin})
+		}
+
+		cw := &captureWriter{header: make(http.Header)}
+		m.handler(cw, req)
+
+		res := askResult{CoinID: coin, Metric: in.Metric}
+		if cw.status == 0 || cw.status == http.StatusOK {
+			res.Data = json.RawMessage(bytes.TrimSpace(cw.body.Bytes()))
+		} else {
+			res.Error = strings.TrimSpace(cw.body.String())
+		}
+		results = append(results, res)
+	}
+	return results
+}
@@ -0,0 +1,91 @@
+//go:build !ingest
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseIntent(t *testing.T) {
+	in, err := parseIntent("Here you go:\n```json\n{\"metric\": \" Average \", \"coins\": [\"btc\"]}\n```")
+	if err != nil || in.Metric != "average" || len(in.Coins) != 1 || in.Coins[0] != "btc" {
+		t.Errorf("fenced reply: %+v, %v", in, err)
+	}
+	for _, reply := range []string{"I can't answer that.", "} {", `{"metric": 1}`} {
+		if _, err := parseIntent(reply); err == nil {
+			t.Errorf("parseIntent(%q) accepted", reply)
+		}
+	}
+}
+
+func TestResolveIntent(t *testing.T) {
+	useMemoryStore(t)
+	tests := []struct {
+		name       string
+		in         askIntent
+		start, end time.Time
+		err        string
+	}{
+		{"defaults to the last day", askIntent{Metric: "average", Coins: []string{"BTC"}}, askNow.Add(-24 * time.Hour), askNow, ""},
+		{"window", askIntent{Metric: "trend", Coins: []string{"eth"}, Start: "2025-01-01T00:00:00Z", End: "2025-01-08T00:00:00Z"},
+			time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC), ""},
+		{"top movers need no coin", askIntent{Metric: "top_movers"}, askNow.Add(-24 * time.Hour), askNow, ""},
+		{"unknown metric", askIntent{Metric: "sentiment", Coins: []string{"btc"}}, time.Time{}, time.Time{}, "unsupported metric"},
+		{"no coin", askIntent{Metric: "latest"}, time.Time{}, time.Time{}, "does not name a coin"},
+		{"unknown coin", askIntent{Metric: "latest", Coins: []string{"shibacoin"}}, time.Time{}, time.Time{}, "unknown coin"},
+		{"too many coins", askIntent{Metric: "latest", Coins: strings.Split(strings.Repeat("btc,", askMaxCoins+1), ",")[:askMaxCoins+1]}, time.Time{}, time.Time{}, "at most"},
+		{"reversed window", askIntent{Metric: "average", Coins: []string{"btc"}, Start: "2025-01-08T00:00:00Z", End: "2025-01-01T00:00:00Z"}, time.Time{}, time.Time{}, "empty"},
+		{"over a year", askIntent{Metric: "average", Coins: []string{"btc"}, Start: "2023-01-01T00:00:00Z"}, time.Time{}, time.Time{}, "longer than a year"},
+		{"history over 31 days", askIntent{Metric: "history", Coins: []string{"btc"}, Start: "2024-12-01T00:00:00Z"}, time.Time{}, time.Time{}, "at most 31 days"},
+		{"price_at without at", askIntent{Metric: "price_at", Coins: []string{"btc"}}, time.Time{}, time.Time{}, "RFC3339"},
+		{"bad start", askIntent{Metric: "average", Coins: []string{"btc"}, Start: "yesterday"}, time.Time{}, time.Time{}, "invalid start"},
+	}
+	for _, tt := range tests {
+		in := tt.in
+		start, end, err := resolveIntent(&in, askNow)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("%s: %s..%s, %v", tt.name, start, end, err)
+		}
+		if in.Start != tt.start.Format(time.RFC3339) || in.End != tt.end.Format(time.RFC3339) {
+			t.Errorf("%s: intent window %s..%s", tt.name, in.Start, in.End)
+		}
+		for _, c := range in.Coins {
+			if c != "bitcoin" && c != "ethereum" {
+				t.Errorf("%s: coin %q was not resolved", tt.name, c)
+			}
+		}
+	}
+}
+
+func TestRunIntent(t *testing.T) {
+	s := useMemoryStore(t)
+	s.Insert(PriceData{CoinID: "bitcoin", Timestamp: askNow.Add(-time.Minute), PriceUSD: 100000})
+
+	in := askIntent{Metric: "latest", Coins: []string{"btc", "eth"}}
+	start, end, err := resolveIntent(&in, askNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := runIntent(httptest.NewRequest("POST", "/ask", strings.NewReader("{}")), in, start, end)
+	if len(results) != 2 {
+		t.Fatalf("results %+v", results)
+	}
+	var latest PriceData
+	if err := json.Unmarshal(results[0].Data, &latest); err != nil || results[0].CoinID != "bitcoin" || latest.PriceUSD != 100000 {
+		t.Errorf("bitcoin: %+v, %v", results[0], err)
+	}
+	// A coin without data reports the endpoint's error instead.
+	if results[1].CoinID != "ethereum" || results[1].Data != nil || results[1].Error != "Price data not found" {
+		t.Errorf("ethereum: %+v", results[1])
+	}
+}
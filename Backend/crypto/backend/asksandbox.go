@@ -0,0 +1,513 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Bounds on what a generated /ask query may read.
+const (
+	askMaxLimit = 1000
+	askMaxRange = 31 * 24 * time.Hour
+	askMaxCoins = 10
+)
+
+// askQuery is a validated /ask statement. Only the pieces the sandbox
+// understands survive parsing, and the statement that runs is rebuilt from
+// them with bound values, never the model's text.
+type askQuery struct {
+	Coins []string
+	// From and To bound the timestamp. From is always set; a zero To
+	// means up to now.
+	From, To     time.Time
+	FromOp, ToOp string
+	// Ordered is set when the statement had an ORDER BY; without one rows
+	// come back in the table's clustering order, newest first.
+	Ordered   bool
+	Ascending bool
+	Limit     int
+}
+
+// queryRejection explains why a generated statement was refused.
+type queryRejection struct {
+	Reason string
+}
+
+func (e *queryRejection) Error() string { return "query rejected: " + e.Reason }
+
+func reject(format string, args ...interface{}) error {
+	return &queryRejection{Reason: fmt.Sprintf(format, args...)}
+}
+
+type cqlToken struct {
+	kind string // "ident", "string", "number" or "symbol"
+	text string
+}
+
+// tokenizeCQL splits a statement into tokens. Comments are refused rather
+// than skipped, since they are a common way to smuggle in a second
+// statement or hide the end of one.
+func tokenizeCQL(s string) ([]cqlToken, error) {
+	var toks []cqlToken
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '-' && i+1 < len(rs) && rs[i+1] == '-',
+			c == '/' && i+1 < len(rs) && (rs[i+1] == '/' || rs[i+1] == '*'):
+			return nil, reject("comments are not allowed")
+		case c == '\'':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(rs) {
+					return nil, reject("unterminated string literal")
+				}
+				if rs[i] == '\'' {
+					if i+1 < len(rs) && rs[i+1] == '\'' {
+						b.WriteRune('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteRune(rs[i])
+				i++
+			}
+			toks = append(toks, cqlToken{"string", b.String()})
+		case c == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				j++
+			}
+			if j >= len(rs) {
+				return nil, reject("unterminated quoted identifier")
+			}
+			toks = append(toks, cqlToken{"ident", string(rs[i+1 : j])})
+			i = j + 1
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			toks = append(toks, cqlToken{"ident", string(rs[i:j])})
+			i = j
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(rs) && unicode.IsDigit(rs[j]) {
+				j++
+			}
+			toks = append(toks, cqlToken{"number", string(rs[i:j])})
+			i = j
+		case c == '>' || c == '<':
+			if i+1 < len(rs) && rs[i+1] == '=' {
+				toks = append(toks, cqlToken{"symbol", string(rs[i : i+2])})
+				i += 2
+			} else {
+				toks = append(toks, cqlToken{"symbol", string(c)})
+				i++
+			}
+		case strings.ContainsRune("=(),;.*", c):
+			toks = append(toks, cqlToken{"symbol", string(c)})
+			i++
+		default:
+			return nil, reject("unexpected character %q", c)
+		}
+	}
+	return toks, nil
+}
+
+type cqlParser struct {
+	toks []cqlToken
+	pos  int
+}
+
+func (p *cqlParser) peek() cqlToken {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return cqlToken{}
+}
+
+func (p *cqlParser) next() cqlToken {
+	t := p.peek()
+	if p.pos < len(p.toks) {
+		p.pos++
+	}
+	return t
+}
+
+// isKeyword reports whether the next token is the keyword kw.
+func (p *cqlParser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == "ident" && strings.EqualFold(t.text, kw)
+}
+
+func (p *cqlParser) keyword(kw string) error {
+	if !p.isKeyword(kw) {
+		return reject("expected %s, found %s", kw, p.describe())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *cqlParser) symbol(sym string) error {
+	if t := p.peek(); t.kind != "symbol" || t.text != sym {
+		return reject("expected %q, found %s", sym, p.describe())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *cqlParser) describe() string {
+	t := p.peek()
+	if t.kind == "" {
+		return "end of statement"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// parseAskQuery validates a generated statement against the allow-list:
+//
+//	SELECT coin_id, timestamp, price_usd FROM [iot_data.]crypto_price_by_coin
+//	WHERE coin_id = '...' | coin_id IN ('...', ...)
+//	[AND timestamp >|>=|<|<= '...']...
+//	[ORDER BY timestamp ASC|DESC] [LIMIT n] [ALLOW FILTERING] [;]
+//
+// Every coin must be in the registry, a lower time bound may be at most
+// askMaxRange before the upper one (now when omitted), and LIMIT may not
+// exceed askMaxLimit; it defaults to askMaxLimit. Without a lower bound the
+// read starts askMaxRange before the upper one. The coin restriction and the
+// time range are what keep the read on known partitions and a bounded slice
+// of each instead of the whole table.
+func parseAskQuery(cql string, now time.Time) (askQuery, error) {
+	q := askQuery{Limit: askMaxLimit}
+	toks, err := tokenizeCQL(cql)
+	if err != nil {
+		return q, err
+	}
+	p := &cqlParser{toks: toks}
+
+	if !p.isKeyword("select") {
+		return q, reject("only SELECT statements are allowed, found %s", p.describe())
+	}
+	p.next()
+	for i, col := range []string{"coin_id", "timestamp", "price_usd"} {
+		if i > 0 {
+			if err := p.symbol(","); err != nil {
+				return q, reject("select exactly coin_id, timestamp, price_usd")
+			}
+		}
+		if !p.isKeyword(col) {
+			return q, reject("select exactly coin_id, timestamp, price_usd")
+		}
+		p.next()
+	}
+
+	if err := p.keyword("from"); err != nil {
+		return q, err
+	}
+	table := p.next()
+	if p.peek().text == "." {
+		if !strings.EqualFold(table.text, "iot_data") {
+			return q, reject("keyspace %q is not allowed", table.text)
+		}
+		p.next()
+		table = p.next()
+	}
+	if table.kind != "ident" || !strings.EqualFold(table.text, "crypto_price_by_coin") {
+		return q, reject("only the crypto_price_by_coin table may be queried, not %q", table.text)
+	}
+
+	if err := p.keyword("where"); err != nil {
+		return q, reject("a WHERE clause restricting coin_id is required")
+	}
+	for {
+		if err := p.condition(&q); err != nil {
+			return q, err
+		}
+		if !p.isKeyword("and") {
+			break
+		}
+		p.next()
+	}
+	if len(q.Coins) == 0 {
+		return q, reject("the WHERE clause must restrict coin_id")
+	}
+
+	if p.isKeyword("order") {
+		p.next()
+		if err := p.keyword("by"); err != nil {
+			return q, err
+		}
+		if !p.isKeyword("timestamp") {
+			return q, reject("results can only be ordered by timestamp")
+		}
+		p.next()
+		switch {
+		case p.isKeyword("asc"):
+			q.Ascending = true
+			p.next()
+		case p.isKeyword("desc"):
+			p.next()
+		}
+		q.Ordered = true
+	}
+	if p.isKeyword("limit") {
+		p.next()
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != "number" || err != nil || n < 1 {
+			return q, reject("LIMIT must be a positive integer")
+		}
+		if n > askMaxLimit {
+			return q, reject("LIMIT %d exceeds the maximum of %d", n, askMaxLimit)
+		}
+		q.Limit = n
+	}
+	if p.isKeyword("allow") {
+		p.next()
+		if err := p.keyword("filtering"); err != nil {
+			return q, err
+		}
+	}
+	if p.peek().text == ";" {
+		p.next()
+	}
+	if p.pos != len(p.toks) {
+		return q, reject("unexpected %s after the statement; only one statement is allowed", p.describe())
+	}
+
+	if q.Ordered && len(q.Coins) > 1 {
+		return q, reject("ORDER BY is only supported for a single coin_id")
+	}
+	to := q.To
+	if to.IsZero() {
+		to = now
+	}
+	if q.From.IsZero() {
+		q.From, q.FromOp = to.Add(-askMaxRange), ">="
+	}
+	if !q.From.Before(to) {
+		return q, reject("the time range is empty")
+	}
+	if to.Sub(q.From) > askMaxRange {
+		return q, reject("the time range exceeds %d days", int(askMaxRange.Hours()/24))
+	}
+	return q, nil
+}
+
+// condition parses one WHERE restriction into q.
+func (p *cqlParser) condition(q *askQuery) error {
+	col := p.next()
+	switch {
+	case col.kind == "ident" && strings.EqualFold(col.text, "coin_id"):
+		if len(q.Coins) > 0 {
+			return reject("coin_id is restricted twice")
+		}
+		var ids []string
+		if p.isKeyword("in") {
+			p.next()
+			if err := p.symbol("("); err != nil {
+				return err
+			}
+			for {
+				t := p.next()
+				if t.kind != "string" {
+					return reject("coin_id values must be quoted strings")
+				}
+				ids = append(ids, t.text)
+				if p.peek().text != "," {
+					break
+				}
+				p.next()
+			}
+			if err := p.symbol(")"); err != nil {
+				return err
+			}
+		} else {
+			if err := p.symbol("="); err != nil {
+				return reject("coin_id must be compared with = or IN")
+			}
+			t := p.next()
+			if t.kind != "string" {
+				return reject("coin_id values must be quoted strings")
+			}
+			ids = append(ids, t.text)
+		}
+		if len(ids) > askMaxCoins {
+			return reject("at most %d coins may be queried at once", askMaxCoins)
+		}
+		for _, id := range ids {
+			c, ok := registry.Resolve(id)
+			if !ok {
+				return reject("unknown coin_id %q", id)
+			}
+			q.Coins = append(q.Coins, c.ID)
+		}
+		return nil
+
+	case col.kind == "ident" && strings.EqualFold(col.text, "timestamp"):
+		op := p.next()
+		switch op.text {
+		case ">", ">=", "<", "<=":
+		default:
+			return reject("timestamp must be compared with >, >=, < or <=")
+		}
+		t, err := parseCQLTimestamp(p.next())
+		if err != nil {
+			return err
+		}
+		if op.text[0] == '>' {
+			if !q.From.IsZero() {
+				return reject("the lower time bound is given twice")
+			}
+			q.From, q.FromOp = t, op.text
+		} else {
+			if !q.To.IsZero() {
+				return reject("the upper time bound is given twice")
+			}
+			q.To, q.ToOp = t, op.text
+		}
+		return nil
+
+	default:
+		return reject("only coin_id and timestamp may appear in WHERE, not %q", col.text)
+	}
+}
+
+var cqlTimestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999-0700",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseCQLTimestamp reads a timestamp literal, either a quoted date as
+// Cassandra accepts it (UTC when no zone is given) or epoch milliseconds.
+func parseCQLTimestamp(t cqlToken) (time.Time, error) {
+	switch t.kind {
+	case "string":
+		for _, layout := range cqlTimestampLayouts {
+			if ts, err := time.Parse(layout, t.text); err == nil {
+				return ts.UTC(), nil
+			}
+		}
+	case "number":
+		if ms, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return time.UnixMilli(ms).UTC(), nil
+		}
+	}
+	return time.Time{}, reject("invalid timestamp %q", t.text)
+}
+
+// Statement renders q as CQL with bind markers for every value.
+func (q askQuery) Statement() (string, []interface{}) {
+	var b strings.Builder
+	var args []interface{}
+	b.WriteString("SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id ")
+	if len(q.Coins) == 1 {
+		b.WriteString("= ?")
+		args = append(args, q.Coins[0])
+	} else {
+		b.WriteString("IN ?")
+		args = append(args, q.Coins)
+	}
+	if !q.From.IsZero() {
+		b.WriteString(" AND timestamp " + q.FromOp + " ?")
+		args = append(args, q.From)
+	}
+	if !q.To.IsZero() {
+		b.WriteString(" AND timestamp " + q.ToOp + " ?")
+		args = append(args, q.To)
+	}
+	if q.Ordered {
+		if q.Ascending {
+			b.WriteString(" ORDER BY timestamp ASC")
+		} else {
+			b.WriteString(" ORDER BY timestamp DESC")
+		}
+	}
+	b.WriteString(" LIMIT ?")
+	args = append(args, q.Limit)
+	return b.String(), args
+}
+
+// String is the statement with its values inlined, for showing to users.
+func (q askQuery) String() string {
+	stmt, args := q.Statement()
+	for _, a := range args {
+		var lit string
+		switch v := a.(type) {
+		case string:
+			lit = "'" + v + "'"
+		case []string:
+			lit = "('" + strings.Join(v, "', '") + "')"
+		case time.Time:
+			lit = "'" + v.Format("2006-01-02 15:04:05") + "'"
+		default:
+			lit = fmt.Sprint(v)
+		}
+		stmt = strings.Replace(stmt, "?", lit, 1)
+	}
+	return stmt
+}
+
+// cqlQuerier is implemented by stores that can run a CQL statement
+// returning (coin_id, timestamp, price_usd) rows.
+type cqlQuerier interface {
+	QueryCQL(cql string, args ...interface{}) ([]PriceData, error)
+}
+
+// run executes q. Stores that speak CQL get the rebuilt statement; others
+// answer it from Range, newest first unless ascending was asked for.
+func (q askQuery) run(s Store) ([]PriceData, error) {
+	if querier, ok := s.(cqlQuerier); ok {
+		stmt, args := q.Statement()
+		return querier.QueryCQL(stmt, args...)
+	}
+
+	var out []PriceData
+	for _, coin := range q.Coins {
+		rows, err := s.Range(coin, q.From, rangeEnd(q.To))
+		if err != nil {
+			return nil, err
+		}
+		kept := rows[:0:0]
+		for _, r := range rows {
+			if (q.FromOp == ">" && !r.Timestamp.After(q.From)) || (q.ToOp == "<" && !r.Timestamp.Before(q.To)) {
+				continue
+			}
+			kept = append(kept, r)
+		}
+		if !q.Ascending {
+			sort.SliceStable(kept, func(i, j int) bool { return kept[i].Timestamp.After(kept[j].Timestamp) })
+		}
+		out = append(out, kept...)
+		if len(out) >= q.Limit {
+			break
+		}
+	}
+	if len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+
+// rangeEnd is the end to read up to when no upper bound was given.
+func rangeEnd(to time.Time) time.Time {
+	if to.IsZero() {
+		return time.Now().UTC().Add(time.Minute)
+	}
+	return to
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var askNow = time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+
+func TestParseAskQueryRejects(t *testing.T) {
+	useMemoryStore(t)
+
+	tests := []struct {
+		name, cql, want string
+	}{
+		// Smuggling a second statement or hiding the end of one.
+		{"second statement", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin'; DROP TABLE crypto_price_by_coin", "only one statement"},
+		{"two selects", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin'; SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'ethereum'", "only one statement"},
+		{"double semicolon", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin';;", "only one statement"},
+		{"dash comment", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' -- LIMIT 5", "comments are not allowed"},
+		{"slash comment", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' // x", "comments are not allowed"},
+		{"block comment", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin /* x */ WHERE coin_id = 'bitcoin'", "comments are not allowed"},
+		{"unterminated string", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin", "unterminated string"},
+		{"quote escape", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin''; DROP TABLE x; --'", "unknown coin_id"},
+		{"unexpected character", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND price_usd > $1", "unexpected character"},
+
+		// Other statements, tables and keyspaces.
+		{"insert", "INSERT INTO crypto_price_by_coin (coin_id, timestamp, price_usd) VALUES ('bitcoin', 0, 1)", "only SELECT"},
+		{"delete", "DELETE FROM crypto_price_by_coin WHERE coin_id = 'bitcoin'", "only SELECT"},
+		{"truncate", "TRUNCATE crypto_price_by_coin", "only SELECT"},
+		{"empty", "", "only SELECT"},
+		{"star", "SELECT * FROM crypto_price_by_coin WHERE coin_id = 'bitcoin'", "select exactly"},
+		{"extra column", "SELECT coin_id, timestamp, price_usd, market_cap_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin'", "expected from"},
+		{"other table", "SELECT coin_id, timestamp, price_usd FROM api_keys WHERE coin_id = 'bitcoin'", "only the crypto_price_by_coin table"},
+		{"other keyspace", "SELECT coin_id, timestamp, price_usd FROM system_auth.crypto_price_by_coin WHERE coin_id = 'bitcoin'", "keyspace"},
+		{"system table", "SELECT coin_id, timestamp, price_usd FROM system.local WHERE coin_id = 'bitcoin'", "keyspace"},
+
+		// Limits and coins.
+		{"oversize limit", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' LIMIT 1001", "exceeds the maximum"},
+		{"zero limit", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' LIMIT 0", "positive integer"},
+		{"bound limit", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' LIMIT ?", "unexpected character"},
+		{"unknown coin", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'notacoin'", "unknown coin_id"},
+		{"unknown coin in list", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id IN ('bitcoin', 'notacoin')", "unknown coin_id"},
+		{"too many coins", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id IN ('bitcoin', 'ethereum', 'ripple', 'litecoin', 'cardano', 'dogecoin', 'polkadot', 'bitcoin-cash', 'binancecoin', 'chainlink', 'tron')", "at most 10 coins"},
+		{"unquoted coin", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = bitcoin", "quoted strings"},
+		{"coin twice", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND coin_id = 'ethereum'", "restricted twice"},
+		{"no where", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin", "restricting coin_id"},
+		{"no coin restriction", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE timestamp > '2025-01-20'", "must restrict coin_id"},
+		{"other column", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND price_usd > 10", "only coin_id and timestamp"},
+		{"or", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' OR coin_id = 'ethereum'", "only one statement"},
+		{"order by price", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' ORDER BY price_usd", "ordered by timestamp"},
+		{"order several coins", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id IN ('bitcoin', 'ethereum') ORDER BY timestamp ASC", "single coin_id"},
+
+		// Time ranges.
+		{"range over 31 days", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND timestamp >= '2024-12-01' AND timestamp < '2025-01-02'", "exceeds 31 days"},
+		{"lower bound too old", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND timestamp > '2024-06-01'", "exceeds 31 days"},
+		{"epoch lower bound", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND timestamp > 0", "exceeds 31 days"},
+		{"empty range", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND timestamp >= '2025-01-20' AND timestamp < '2025-01-20'", "range is empty"},
+		{"inverted range", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND timestamp > '2025-01-25' AND timestamp < '2025-01-20'", "range is empty"},
+		{"future lower bound", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND timestamp > '2025-02-10'", "range is empty"},
+		{"lower bound twice", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND timestamp > '2025-01-20' AND timestamp >= '2025-01-21'", "given twice"},
+		{"timestamp equals", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND timestamp = '2025-01-20'", "compared with"},
+		{"bad timestamp", "SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND timestamp > 'yesterday'", "invalid timestamp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseAskQuery(tt.cql, askNow)
+			var rej *queryRejection
+			if !errors.As(err, &rej) {
+				t.Fatalf("got %v, want a rejection", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %q, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAskQueryAccepts(t *testing.T) {
+	useMemoryStore(t)
+	day := func(s string) time.Time {
+		ts, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	tests := []struct {
+		name, cql string
+		want      askQuery
+	}{
+		{
+			"no time bound reads the last 31 days",
+			"select coin_id, timestamp, price_usd from crypto_price_by_coin where coin_id = 'btc' limit 1",
+			askQuery{Coins: []string{"bitcoin"}, From: askNow.Add(-askMaxRange), FromOp: ">=", Limit: 1},
+		},
+		{
+			"upper bound only",
+			"SELECT coin_id, timestamp, price_usd FROM iot_data.crypto_price_by_coin WHERE coin_id IN ('eth', 'solana') AND timestamp <= '2025-01-10'",
+			askQuery{Coins: []string{"ethereum", "solana"}, From: day("2025-01-10").Add(-askMaxRange), FromOp: ">=", To: day("2025-01-10"), ToOp: "<=", Limit: askMaxLimit},
+		},
+		{
+			"full range",
+			"SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND timestamp > '2025-01-01T00:00:00Z' AND timestamp < '2025-02-01' ORDER BY timestamp ASC LIMIT 1000 ALLOW FILTERING;",
+			askQuery{Coins: []string{"bitcoin"}, From: day("2025-01-01"), FromOp: ">", To: day("2025-02-01"), ToOp: "<", Ordered: true, Ascending: true, Limit: 1000},
+		},
+		{
+			"epoch milliseconds",
+			"SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND timestamp >= 1737331200000 ORDER BY timestamp DESC",
+			askQuery{Coins: []string{"bitcoin"}, From: day("2025-01-20"), FromOp: ">=", Ordered: true, Limit: askMaxLimit},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := parseAskQuery(tt.cql, askNow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(q, tt.want) {
+				t.Errorf("got %+v\nwant %+v", q, tt.want)
+			}
+		})
+	}
+}
+
+func TestAskQueryStatementBindsValues(t *testing.T) {
+	useMemoryStore(t)
+
+	tests := []struct {
+		cql, want string
+		args      int
+	}{
+		{
+			"SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'BTC' LIMIT 5",
+			"SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = ? AND timestamp >= ? LIMIT ?",
+			3,
+		},
+		{
+			"SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id IN ('bitcoin', 'eth') AND timestamp > '2025-01-20' AND timestamp <= '2025-01-25'",
+			"SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id IN ? AND timestamp > ? AND timestamp <= ? LIMIT ?",
+			4,
+		},
+		{
+			"SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = 'bitcoin' AND timestamp > '2025-01-20' ORDER BY timestamp ASC",
+			"SELECT coin_id, timestamp, price_usd FROM crypto_price_by_coin WHERE coin_id = ? AND timestamp > ? ORDER BY timestamp ASC LIMIT ?",
+			3,
+		},
+	}
+	for _, tt := range tests {
+		q, err := parseAskQuery(tt.cql, askNow)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.cql, err)
+		}
+		stmt, args := q.Statement()
+		if stmt != tt.want {
+			t.Errorf("Statement() = %q\nwant %q", stmt, tt.want)
+		}
+		if len(args) != tt.args || strings.Count(stmt, "?") != len(args) {
+			t.Errorf("%q has %d bind markers for %d args, want %d", stmt, strings.Count(stmt, "?"), len(args), tt.args)
+		}
+		if strings.ContainsAny(stmt, "'0123456789") {
+			t.Errorf("%q carries a literal value", stmt)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Roles. Public read routes need no account; RoleAdmin is required for
+// report generation, coin management and account administration.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// User is an account that authenticates with API keys.
+type User struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKey is stored by the SHA-256 of the key; the key itself is shown once
+// when it is created. ID is a prefix of the hash, safe to list and revoke by.
+type APIKey struct {
+	ID         string    `json:"id"`
+	KeyHash    string    `json:"-"`
+	UserID     string    `json:"user_id"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// UserStore persists accounts and their API keys.
+type UserStore interface {
+	// Users returns every account.
+	Users() ([]User, error)
+	// User returns ErrNotFound when there is no such account.
+	User(id string) (User, error)
+	// PutUser adds or replaces an account by ID.
+	PutUser(u User) error
+	// DeleteUser removes an account and its keys.
+	DeleteUser(id string) error
+	// APIKeys returns the keys of one account.
+	APIKeys(userID string) ([]APIKey, error)
+	// APIKey returns the key with the given hash, or ErrNotFound.
+	APIKey(keyHash string) (APIKey, error)
+	// PutAPIKey adds or replaces a key by hash.
+	PutAPIKey(k APIKey) error
+	// DeleteAPIKey removes one key.
+	DeleteAPIKey(keyHash string) error
+}
+
+// apiKeyPrefix marks keys issued by this API so they are easy to spot in
+// logs and secret scanners.
+const apiKeyPrefix = "cdk_"
+
+// keyTouchInterval limits how often LastUsedAt is written back.
+const keyTouchInterval = time.Hour
+
+// principal is who a request is authenticated as.
+type principal struct {
+	User  User
+	KeyID string
+}
+
+type principalKey struct{}
+
+// adminTokenKeyID is the KeyID of requests authenticated with ADMIN_TOKEN.
+const adminTokenKeyID = "admin-token"
+
+// currentPrincipal returns the authenticated caller, if any.
+func currentPrincipal(r *http.Request) (principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// callerAccount returns the account the request is authenticated as. It is
+// false for anonymous requests and for the ADMIN_TOKEN, which is not an
+// account.
+func callerAccount(r *http.Request) (User, bool) {
+	p, ok := currentPrincipal(r)
+	if !ok || p.KeyID == adminTokenKeyID {
+		return User{}, false
+	}
+	return p.User, true
+}
+
+// requestAPIKey reads the key from "Authorization: Bearer <key>" or X-API-Key.
+func requestAPIKey(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// authenticate is the router middleware that resolves the caller. Requests
+// without credentials continue anonymously; requests with a key that is
+// unknown or belongs to a disabled account are rejected with 401, so a
+// mistyped key is not silently treated as anonymous. The ADMIN_TOKEN in
+// X-Admin-Token still authenticates as an admin, which is how the first
+// account is created.
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := os.Getenv("ADMIN_TOKEN"); token != "" && r.Header.Get("X-Admin-Token") != "" {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(token)) != 1 {
+				http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+				return
+			}
+			p := principal{User: User{ID: adminTokenKeyID, Role: RoleAdmin}, KeyID: adminTokenKeyID}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+			return
+		}
+
+		key := requestAPIKey(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := lookupAPIKey(key)
+		if err == ErrNotFound {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			log.Printf("API key lookup error: %v", err)
+			http.Error(w, "Query error", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// lookupAPIKey resolves a presented key to its account. Disabled accounts
+// are reported as ErrNotFound.
+func lookupAPIKey(key string) (principal, error) {
+	k, err := store.APIKey(hashKey(key))
+	if err != nil {
+		return principal{}, err
+	}
+	u, err := store.User(k.UserID)
+	if err != nil {
+		return principal{}, err
+	}
+	if u.Disabled {
+		return principal{}, ErrNotFound
+	}
+	if now := time.Now().UTC(); now.Sub(k.LastUsedAt) > keyTouchInterval {
+		k.LastUsedAt = now
+		if err := store.PutAPIKey(k); err != nil {
+			log.Printf("Error updating API key: %v", err)
+		}
+	}
+	return principal{User: u, KeyID: k.ID}, nil
+}
+
+// requireUser rejects anonymous requests.
+func requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := currentPrincipal(r); !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="crypto-dashboard"`)
+			http.Error(w, "An API key is required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireAdmin rejects requests not authenticated as an admin account or
+// with the ADMIN_TOKEN.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireUser(func(w http.ResponseWriter, r *http.Request) {
+		if p, _ := currentPrincipal(r); p.User.Role != RoleAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// issueAPIKey creates and stores a new key for userID and returns it in
+// clear text, the only time it is available.
+func issueAPIKey(userID, name string) (APIKey, string, error) {
+	key := apiKeyPrefix + randomHex(24)
+	hash := hashKey(key)
+	k := APIKey{ID: hash[:12], KeyHash: hash, UserID: userID, Name: name, CreatedAt: time.Now().UTC()}
+	return k, key, store.PutAPIKey(k)
+}
+
+// writeNewKey answers a key creation with the key shown once.
+func writeNewKey(w http.ResponseWriter, body map[string]interface{}, k APIKey, key string) {
+	body["api_key"] = k
+	body["key"] = key
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(body)
+}
+
+// createUser handles POST /admin/users with {"email": ..., "role": "user"}.
+// The response carries the account's first API key.
+func createUser(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(body.Email))
+	if !strings.Contains(email, "@") {
+		http.Error(w, "A valid email is required", http.StatusBadRequest)
+		return
+	}
+	if body.Role == "" {
+		body.Role = RoleUser
+	}
+	if body.Role != RoleUser && body.Role != RoleAdmin {
+		http.Error(w, "role must be user or admin", http.StatusBadRequest)
+		return
+	}
+
+	users, err := store.Users()
+	if err != nil {
+		log.Printf("User query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	for _, u := range users {
+		if u.Email == email {
+			http.Error(w, "An account with that email already exists", http.StatusConflict)
+			return
+		}
+	}
+
+	u := User{ID: uuid.New().String(), Email: email, Role: body.Role, CreatedAt: time.Now().UTC()}
+	if err := store.PutUser(u); err != nil {
+		log.Printf("Error saving user: %v", err)
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
+	k, key, err := issueAPIKey(u.ID, "default")
+	if err != nil {
+		log.Printf("Error saving API key: %v", err)
+		http.Error(w, "Failed to issue key", http.StatusInternalServerError)
+		return
+	}
+	writeNewKey(w, map[string]interface{}{"user": u}, k, key)
+}
+
+// listUsers handles GET /admin/users.
+func listUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := store.Users()
+	if err != nil {
+		log.Printf("User query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	if users == nil {
+		users = []User{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// loadUser fetches {user_id}, answering 404 when it does not exist.
+func loadUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	u, err := store.User(mux.Vars(r)["user_id"])
+	if err == ErrNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return u, false
+	} else if err != nil {
+		log.Printf("User query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return u, false
+	}
+	return u, true
+}
+
+// updateUser handles PUT /admin/users/{user_id} with any of {"role": ...,
+// "disabled": true}. Disabling an account rejects all of its keys.
+func updateUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := loadUser(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Role     *string `json:"role"`
+		Disabled *bool   `json:"disabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if body.Role != nil {
+		if *body.Role != RoleUser && *body.Role != RoleAdmin {
+			http.Error(w, "role must be user or admin", http.StatusBadRequest)
+			return
+		}
+		u.Role = *body.Role
+	}
+	if body.Disabled != nil {
+		u.Disabled = *body.Disabled
+	}
+	if err := store.PutUser(u); err != nil {
+		log.Printf("Error saving user: %v", err)
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// deleteUser handles DELETE /admin/users/{user_id}.
+func deleteUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := loadUser(w, r)
+	if !ok {
+		return
+	}
+	if err := store.DeleteUser(u.ID); err != nil {
+		log.Printf("Error deleting user: %v", err)
+		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createUserKey handles POST /admin/users/{user_id}/keys with {"name": ...}.
+func createUserKey(w http.ResponseWriter, r *http.Request) {
+	u, ok := loadUser(w, r)
+	if !ok {
+		return
+	}
+	newKeyFor(w, r, u)
+}
+
+// newKeyFor reads an optional {"name": ...} and issues a key for u.
+func newKeyFor(w http.ResponseWriter, r *http.Request, u User) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+	}
+	k, key, err := issueAPIKey(u.ID, strings.TrimSpace(body.Name))
+	if err != nil {
+		log.Printf("Error saving API key: %v", err)
+		http.Error(w, "Failed to issue key", http.StatusInternalServerError)
+		return
+	}
+	writeNewKey(w, map[string]interface{}{}, k, key)
+}
+
+// accountUser returns the caller's stored account. The ADMIN_TOKEN is not an
+// account, so it has none.
+func accountUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	p, _ := currentPrincipal(r)
+	u, err := store.User(p.User.ID)
+	if err == ErrNotFound {
+		http.Error(w, "The admin token has no account; use an API key", http.StatusBadRequest)
+		return u, false
+	} else if err != nil {
+		log.Printf("User query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return u, false
+	}
+	return u, true
+}
+
+// getAccount handles GET /account, the caller's account and keys.
+func getAccount(w http.ResponseWriter, r *http.Request) {
+	u, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+	keys, err := store.APIKeys(u.ID)
+	if err != nil {
+		log.Printf("API key query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []APIKey{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user": u,
+		"keys": keys,
+	})
+}
+
+// createAccountKey handles POST /account/keys, letting a caller rotate keys.
+func createAccountKey(w http.ResponseWriter, r *http.Request) {
+	u, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+	newKeyFor(w, r, u)
+}
+
+// deleteAccountKey handles DELETE /account/keys/{key_id}.
+func deleteAccountKey(w http.ResponseWriter, r *http.Request) {
+	u, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+	keys, err := store.APIKeys(u.ID)
+	if err != nil {
+		log.Printf("API key query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	for _, k := range keys {
+		if k.ID == mux.Vars(r)["key_id"] {
+			if err := store.DeleteAPIKey(k.KeyHash); err != nil {
+				log.Printf("Error deleting API key: %v", err)
+				http.Error(w, "Failed to delete key", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	http.Error(w, "Key not found", http.StatusNotFound)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAuthenticateRoles(t *testing.T) {
+	s := useMemoryStore(t)
+	t.Setenv("ADMIN_TOKEN", "adm")
+	s.PutUser(User{ID: "u1", Email: "a@example.com", Role: RoleUser})
+	s.PutUser(User{ID: "u2", Email: "b@example.com", Role: RoleAdmin})
+	s.PutUser(User{ID: "u3", Email: "c@example.com", Role: RoleAdmin, Disabled: true})
+	keys := map[string]string{}
+	for _, id := range []string{"u1", "u2", "u3"} {
+		_, key, err := issueAPIKey(id, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[id] = key
+	}
+
+	// Only the hash is kept.
+	stored, _ := s.APIKeys("u1")
+	if len(stored) != 1 || stored[0].KeyHash != hashKey(keys["u1"]) {
+		t.Errorf("stored key %+v", stored)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		public  int
+		user    int
+		admin   int
+		account bool
+	}{
+		{"anonymous", "", "", 200, 401, 401, false},
+		{"user", "Authorization", "Bearer " + keys["u1"], 200, 200, 403, true},
+		{"user in X-API-Key", "X-API-Key", keys["u1"], 200, 200, 403, true},
+		{"admin", "Authorization", "bearer " + keys["u2"], 200, 200, 200, true},
+		{"disabled admin", "Authorization", "Bearer " + keys["u3"], 401, 401, 401, false},
+		// A mistyped key is not treated as anonymous.
+		{"unknown key", "Authorization", "Bearer " + keys["u1"] + "x", 401, 401, 401, false},
+		{"the hash as a key", "Authorization", "Bearer " + hashKey(keys["u1"]), 401, 401, 401, false},
+		{"admin token", "X-Admin-Token", "adm", 200, 200, 200, false},
+		{"wrong admin token", "X-Admin-Token", "adm2", 401, 401, 401, false},
+	}
+	for _, tt := range tests {
+		var account bool
+		public := func(w http.ResponseWriter, r *http.Request) { _, account = callerAccount(r) }
+		for _, h := range []struct {
+			handler http.HandlerFunc
+			want    int
+		}{
+			{public, tt.public},
+			{requireUser(ok), tt.user},
+			{requireAdmin(ok), tt.admin},
+		} {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			authenticate(h.handler).ServeHTTP(w, r)
+			if w.Code != h.want {
+				t.Errorf("%s: %d, want %d", tt.name, w.Code, h.want)
+			}
+		}
+		if account != tt.account {
+			t.Errorf("%s: callerAccount = %v", tt.name, account)
+		}
+	}
+}
+
+func TestDeleteAccountKey(t *testing.T) {
+	s := useMemoryStore(t)
+	s.PutUser(User{ID: "u1", Email: "a@example.com", Role: RoleUser})
+	s.PutUser(User{ID: "u2", Email: "b@example.com", Role: RoleUser})
+	k1, key1, _ := issueAPIKey("u1", "laptop")
+	_, key2, _ := issueAPIKey("u2", "laptop")
+
+	del := func(key, keyID string) int {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/account/keys/"+keyID, nil), map[string]string{"key_id": keyID})
+		r.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		authenticate(requireUser(deleteAccountKey)).ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := del(key2, k1.ID); code != http.StatusNotFound {
+		t.Errorf("another account's key: %d", code)
+	}
+	if code := del(key1, k1.ID); code != http.StatusNoContent {
+		t.Errorf("own key: %d", code)
+	}
+	if _, err := lookupAPIKey(key1); err != ErrNotFound {
+		t.Errorf("deleted key still resolves: %v", err)
+	}
+	if code := del(key1, k1.ID); code != http.StatusUnauthorized {
+		t.Errorf("with the deleted key: %d", code)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// historySource is implemented by price sources that can return past USD
+// prices for a coin.
+type historySource interface {
+	PriceSource
+	History(ctx context.Context, c Coin, start, end time.Time) ([]PriceData, error)
+}
+
+// backfillChunk is the widest range requested at once. CoinGecko returns
+// hourly points for ranges up to 90 days and only daily ones beyond that.
+const backfillChunk = 90 * 24 * time.Hour
+
+// backfillMinGap is how close a fetched point may be to a stored row before
+// it is treated as already present. It keeps reruns idempotent even when the
+// provider shifts its timestamps slightly, and stops hourly history from
+// being interleaved with the ingestion job's own ticks.
+const backfillMinGap = 5 * time.Minute
+
+// BackfillOptions describes one backfill run.
+type BackfillOptions struct {
+	Coins      []Coin
+	Start, End time.Time
+	// RequestsPerMinute paces calls to the provider (default 10, which stays
+	// inside CoinGecko's public limit).
+	RequestsPerMinute int
+	// Progress, when set, is called after every chunk.
+	Progress func(BackfillProgress)
+}
+
+// BackfillProgress reports a finished chunk. The counts are running totals
+// for the coin.
+type BackfillProgress struct {
+	CoinID     string
+	Chunk      int
+	Chunks     int
+	ChunkStart time.Time
+	ChunkEnd   time.Time
+	Fetched    int
+	Inserted   int
+	Skipped    int
+}
+
+// BackfillResult is the outcome for one coin. Error is set when the coin
+// stopped early; rows inserted before that are kept.
+type BackfillResult struct {
+	CoinID   string `json:"coin_id"`
+	Fetched  int    `json:"fetched"`
+	Inserted int    `json:"inserted"`
+	Skipped  int    `json:"skipped"`
+	Error    string `json:"error,omitempty"`
+}
+
+// configuredHistorySource returns the first of sources that serves history,
+// falling back to CoinGecko.
+func configuredHistorySource(sources []PriceSource) historySource {
+	for _, src := range sources {
+		if hs, ok := src.(historySource); ok {
+			return hs
+		}
+	}
+	return coinGeckoSource{}
+}
+
+// backfillRequestsPerMinute reads BACKFILL_REQUESTS_PER_MIN (default 10).
+func backfillRequestsPerMinute() int {
+	if v, err := strconv.Atoi(os.Getenv("BACKFILL_REQUESTS_PER_MIN")); err == nil && v > 0 {
+		return v
+	}
+	return 10
+}
+
+// runBackfill fetches opts.Start..opts.End for every coin from src and
+// inserts the points db does not already have, folding each into the
+// candles. A coin that fails is recorded in its result and the run moves on;
+// only a cancelled ctx stops it.
+func runBackfill(ctx context.Context, src historySource, db Store, opts BackfillOptions) ([]BackfillResult, error) {
+	if !opts.Start.Before(opts.End) {
+		return nil, fmt.Errorf("backfill start %s is not before end %s", opts.Start.Format(time.RFC3339), opts.End.Format(time.RFC3339))
+	}
+	perMin := opts.RequestsPerMinute
+	if perMin <= 0 {
+		perMin = 10
+	}
+	p := &pacer{every: time.Minute / time.Duration(perMin)}
+
+	var results []BackfillResult
+	for _, c := range opts.Coins {
+		res, err := backfillCoin(ctx, src, db, c, opts, p)
+		if err != nil {
+			if ctx.Err() != nil {
+				return append(results, res), ctx.Err()
+			}
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func backfillCoin(ctx context.Context, src historySource, db Store, c Coin, opts BackfillOptions, p *pacer) (BackfillResult, error) {
+	res := BackfillResult{CoinID: c.ID}
+	chunks := int((opts.End.Sub(opts.Start) + backfillChunk - 1) / backfillChunk)
+
+	for i := 0; i < chunks; i++ {
+		chunkStart := opts.Start.Add(time.Duration(i) * backfillChunk)
+		chunkEnd := chunkStart.Add(backfillChunk)
+		if chunkEnd.After(opts.End) {
+			chunkEnd = opts.End
+		}
+
+		points, err := fetchHistoryWithRetry(ctx, src, c, chunkStart, chunkEnd, p)
+		if err != nil {
+			return res, fmt.Errorf("%s..%s: %w", chunkStart.Format(time.RFC3339), chunkEnd.Format(time.RFC3339), err)
+		}
+
+		existing, err := db.Range(c.ID, chunkStart.Add(-backfillMinGap), chunkEnd.Add(backfillMinGap))
+		if err != nil {
+			return res, err
+		}
+		have := make([]time.Time, len(existing))
+		for j, row := range existing {
+			have[j] = row.Timestamp
+		}
+
+		for _, row := range points {
+			res.Fetched++
+			if row.Timestamp.Before(chunkStart) || row.Timestamp.After(chunkEnd) || hasNear(have, row.Timestamp, backfillMinGap) {
+				res.Skipped++
+				continue
+			}
+			row.CoinID = c.ID
+			if err := db.Insert(row); err != nil {
+				return res, err
+			}
+			rollupCandles(db, row)
+			have = insertSorted(have, row.Timestamp)
+			res.Inserted++
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(BackfillProgress{
+				CoinID: c.ID, Chunk: i + 1, Chunks: chunks,
+				ChunkStart: chunkStart, ChunkEnd: chunkEnd,
+				Fetched: res.Fetched, Inserted: res.Inserted, Skipped: res.Skipped,
+			})
+		}
+	}
+	return res, nil
+}
+
+// fetchHistoryWithRetry waits for the pacer before every attempt and retries
+// rate-limit and server errors up to four times, honouring Retry-After.
+func fetchHistoryWithRetry(ctx context.Context, src historySource, c Coin, start, end time.Time, p *pacer) ([]PriceData, error) {
+	backoff := 30 * time.Second
+	for attempt := 0; ; attempt++ {
+		if err := p.wait(ctx); err != nil {
+			return nil, err
+		}
+		points, err := src.History(ctx, c, start, end)
+		var statusErr *httpStatusError
+		if err == nil || attempt == 4 || !errors.As(err, &statusErr) ||
+			(statusErr.Code != http.StatusTooManyRequests && statusErr.Code < 500) {
+			return points, err
+		}
+
+		delay := backoff
+		if statusErr.RetryAfter > 0 {
+			delay = statusErr.RetryAfter
+		}
+		backoff *= 2
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// pacer spaces out calls to at most one per every.
+type pacer struct {
+	every time.Duration
+	next  time.Time
+}
+
+func (p *pacer) wait(ctx context.Context) error {
+	if d := time.Until(p.next); d > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+	p.next = time.Now().Add(p.every)
+	return nil
+}
+
+// hasNear reports whether sorted contains a time within gap of t.
+func hasNear(sorted []time.Time, t time.Time, gap time.Duration) bool {
+	i := sort.Search(len(sorted), func(i int) bool { return !sorted[i].Before(t.Add(-gap)) })
+	return i < len(sorted) && !sorted[i].After(t.Add(gap))
+}
+
+func insertSorted(sorted []time.Time, t time.Time) []time.Time {
+	i := sort.Search(len(sorted), func(i int) bool { return !sorted[i].Before(t) })
+	sorted = append(sorted, time.Time{})
+	copy(sorted[i+1:], sorted[i:])
+	sorted[i] = t
+	return sorted
+}
+
+// coinGeckoMarketChart is the body of CoinGecko's market_chart/range
+// endpoint: [unix ms, value] pairs.
+type coinGeckoMarketChart struct {
+	Prices       [][2]float64 `json:"prices"`
+	MarketCaps   [][2]float64 `json:"market_caps"`
+	TotalVolumes [][2]float64 `json:"total_volumes"`
+}
+
+func (coinGeckoSource) History(ctx context.Context, c Coin, start, end time.Time) ([]PriceData, error) {
+	u := fmt.Sprintf("https://api.coingecko.com/api/v3/coins/%s/market_chart/range?vs_currency=usd&from=%d&to=%d",
+		url.PathEscape(c.ProviderID("coingecko", c.ID)), start.Unix(), end.Unix())
+
+	var chart coinGeckoMarketChart
+	if err := getJSON(ctx, u, &chart); err != nil {
+		return nil, err
+	}
+
+	caps := make(map[int64]float64, len(chart.MarketCaps))
+	for _, pt := range chart.MarketCaps {
+		caps[int64(pt[0])] = pt[1]
+	}
+	volumes := make(map[int64]float64, len(chart.TotalVolumes))
+	for _, pt := range chart.TotalVolumes {
+		volumes[int64(pt[0])] = pt[1]
+	}
+
+	rows := make([]PriceData, 0, len(chart.Prices))
+	for _, pt := range chart.Prices {
+		ms := int64(pt[0])
+		if pt[1] <= 0 {
+			continue
+		}
+		rows = append(rows, PriceData{
+			CoinID:        c.ID,
+			Timestamp:     time.UnixMilli(ms).UTC(),
+			PriceUSD:      pt[1],
+			SourcesAgreed: 1,
+			MarketCapUSD:  caps[ms],
+			Volume24hUSD:  volumes[ms],
+		})
+	}
+	return rows, nil
+}
+
+// History serves the recorded rows for c between start and end, so
+// backfills can be exercised offline.
+func (s *replaySource) History(ctx context.Context, c Coin, start, end time.Time) ([]PriceData, error) {
+	var rows []PriceData
+	for _, tick := range s.ticks {
+		for _, row := range tick {
+			if row.CoinID == c.ID && !row.Timestamp.Before(start) && !row.Timestamp.After(end) {
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Candle is an OHLC bar for one coin and interval. Start is the beginning of
+// the bucket; Ticks counts the raw prices folded into it. FirstTick and
+// LastTick are the timestamps behind Open and Close, so ticks folded out of
+// order (e.g. by a backfill) still land in the right place.
+type Candle struct {
+	CoinID    string    `json:"coin_id"`
+	Interval  string    `json:"interval"`
+	Start     time.Time `json:"start"`
+	Open      float64   `json:"open"`
+	High      float64   `json:"high"`
+	Low       float64   `json:"low"`
+	Close     float64   `json:"close"`
+	Ticks     int       `json:"ticks"`
+	FirstTick time.Time `json:"-"`
+	LastTick  time.Time `json:"-"`
+}
+
+// candleIntervals are the bucket sizes maintained by the ingestion job.
+var candleIntervals = map[string]time.Duration{
+	"1m": time.Minute,
+	"5m": 5 * time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// CandleStore persists rolled-up candles.
+type CandleStore interface {
+	// Candle returns the candle starting at start, or ErrNotFound.
+	Candle(coinID, interval string, start time.Time) (Candle, error)
+	// PutCandle adds or replaces a candle.
+	PutCandle(c Candle) error
+	// Candles returns candles with start <= Start <= end, sorted ascending.
+	Candles(coinID, interval string, start, end time.Time) ([]Candle, error)
+}
+
+// bucketStart returns the UTC start of the interval bucket containing ts.
+func bucketStart(ts time.Time, d time.Duration) time.Time {
+	return ts.UTC().Truncate(d)
+}
+
+// foldTick merges a price into c, which must cover p's bucket. A zero c
+// starts a new candle.
+func foldTick(c Candle, p PriceData) Candle {
+	if c.Ticks == 0 {
+		c.Open, c.High, c.Low, c.Close = p.PriceUSD, p.PriceUSD, p.PriceUSD, p.PriceUSD
+		c.FirstTick, c.LastTick = p.Timestamp, p.Timestamp
+		c.Ticks = 1
+		return c
+	}
+	if p.PriceUSD > c.High {
+		c.High = p.PriceUSD
+	}
+	if p.PriceUSD < c.Low {
+		c.Low = p.PriceUSD
+	}
+	if p.Timestamp.Before(c.FirstTick) {
+		c.Open, c.FirstTick = p.PriceUSD, p.Timestamp
+	}
+	if !p.Timestamp.Before(c.LastTick) {
+		c.Close, c.LastTick = p.PriceUSD, p.Timestamp
+	}
+	c.Ticks++
+	return c
+}
+
+// rollupCandles folds a freshly stored price into every candle interval.
+func rollupCandles(cs CandleStore, p PriceData) {
+	for name, d := range candleIntervals {
+		start := bucketStart(p.Timestamp, d)
+		c, err := cs.Candle(p.CoinID, name, start)
+		if err != nil && err != ErrNotFound {
+			log.Printf("Error reading %s %s candle: %v", p.CoinID, name, err)
+			continue
+		}
+		if err == ErrNotFound {
+			c = Candle{CoinID: p.CoinID, Interval: name, Start: start}
+		}
+		if err := cs.PutCandle(foldTick(c, p)); err != nil {
+			log.Printf("Error writing %s %s candle: %v", p.CoinID, name, err)
+		}
+	}
+}
+
+// candlesFromPrices builds candles from raw prices sorted ascending.
+func candlesFromPrices(rows []PriceData, interval string) []Candle {
+	d := candleIntervals[interval]
+	var out []Candle
+	for _, p := range rows {
+		start := bucketStart(p.Timestamp, d)
+		if len(out) == 0 || !out[len(out)-1].Start.Equal(start) {
+			out = append(out, Candle{CoinID: p.CoinID, Interval: interval, Start: start})
+		}
+		out[len(out)-1] = foldTick(out[len(out)-1], p)
+	}
+	return out
+}
+
+// loadCandles reads stored candles. Buckets before the first stored candle,
+// from before candle ingestion started or a backfill, are built from raw
+// prices and merged in front.
+func loadCandles(coinID, interval string, start, end time.Time) ([]Candle, error) {
+	d := candleIntervals[interval]
+	from := bucketStart(start, d)
+	candles, err := store.Candles(coinID, interval, from, end)
+	if err != nil {
+		return nil, err
+	}
+	uncovered := end
+	if len(candles) > 0 {
+		if !candles[0].Start.After(from) {
+			return candles, nil
+		}
+		uncovered = candles[0].Start.Add(-time.Nanosecond)
+	}
+	rows, err := store.Range(coinID, from, uncovered)
+	if err != nil {
+		return nil, err
+	}
+	return append(candlesFromPrices(rows, interval), candles...), nil
+}
+
+// rangeSeries returns the prices for coinID between start and end: candle
+// closes when interval names a candle size, every raw row otherwise.
+func rangeSeries(coinID, interval string, start, end time.Time) (ts []time.Time, prices []float64, err error) {
+	if interval == "" {
+		rows, err := store.Range(coinID, start, end)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, row := range rows {
+			ts = append(ts, row.Timestamp)
+			prices = append(prices, row.PriceUSD)
+		}
+		return ts, prices, nil
+	}
+
+	candles, err := loadCandles(coinID, interval, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, c := range candles {
+		ts = append(ts, c.Start)
+		prices = append(prices, c.Close)
+	}
+	return ts, prices, nil
+}
+
+// getCandles handles GET /candles/{coin_id}?interval=1h&start=&end=.
+// start and end are RFC3339 and default to the 100 buckets before now.
+func getCandles(w http.ResponseWriter, r *http.Request) {
+	coinID := mux.Vars(r)["coin_id"]
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "1h"
+	}
+	d, ok := candleIntervals[interval]
+	if !ok {
+		http.Error(w, "Invalid interval (use 1m, 5m, 1h or 1d)", http.StatusBadRequest)
+		return
+	}
+
+	end := time.Now().UTC()
+	if v := r.URL.Query().Get("end"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid end time", http.StatusBadRequest)
+			return
+		}
+		end = parsed
+	}
+	start := end.Add(-100 * d)
+	if v := r.URL.Query().Get("start"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid start time", http.StatusBadRequest)
+			return
+		}
+		start = parsed
+	}
+
+	candles, err := loadCandles(coinID, interval, start, end)
+	if err != nil {
+		log.Printf("Candle query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(candles)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollupCandles(t *testing.T) {
+	s := useMemoryStore(t)
+	at := func(minute int) time.Time { return time.Date(2025, 1, 1, 12, minute, 0, 0, time.UTC) }
+	// The 12:20 tick arrives last, as from a backfill.
+	for _, p := range []PriceData{
+		{CoinID: "bitcoin", Timestamp: at(0), PriceUSD: 100},
+		{CoinID: "bitcoin", Timestamp: at(10), PriceUSD: 120},
+		{CoinID: "bitcoin", Timestamp: at(30), PriceUSD: 90},
+		{CoinID: "bitcoin", Timestamp: at(50), PriceUSD: 110},
+		{CoinID: "bitcoin", Timestamp: at(20), PriceUSD: 130},
+	} {
+		rollupCandles(s, p)
+	}
+
+	hour, err := s.Candle("bitcoin", "1h", at(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Candle{CoinID: "bitcoin", Interval: "1h", Start: at(0), Open: 100, High: 130, Low: 90, Close: 110, Ticks: 5, FirstTick: at(0), LastTick: at(50)}
+	if hour != want {
+		t.Errorf("1h candle = %+v\nwant %+v", hour, want)
+	}
+	fives, _ := s.Candles("bitcoin", "5m", at(0), at(55))
+	if len(fives) != 5 || fives[2].Start != at(20) || fives[2].Open != 130 || fives[2].Ticks != 1 {
+		t.Errorf("5m candles = %+v", fives)
+	}
+	if day, _ := s.Candle("bitcoin", "1d", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)); day.Ticks != 5 || day.Close != 110 {
+		t.Errorf("1d candle = %+v", day)
+	}
+}
+
+func TestLoadCandlesBeforeFirstStoredCandle(t *testing.T) {
+	s := useMemoryStore(t)
+	at := func(hour, minute int) time.Time { return time.Date(2025, 1, 1, hour, minute, 0, 0, time.UTC) }
+	// Prices since 10:00, candles only since 12:00.
+	for hour := 10; hour <= 12; hour++ {
+		for minute := 0; minute < 60; minute += 30 {
+			p := PriceData{CoinID: "bitcoin", Timestamp: at(hour, minute), PriceUSD: float64(hour*100 + minute)}
+			s.Insert(p)
+			if hour == 12 {
+				rollupCandles(s, p)
+			}
+		}
+	}
+
+	candles, err := loadCandles("bitcoin", "1h", at(10, 15), at(12, 59))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 3 {
+		t.Fatalf("%d candles, want 10:00, 11:00 and 12:00: %+v", len(candles), candles)
+	}
+	for i, c := range candles {
+		hour := 10 + i
+		if c.Start != at(hour, 0) || c.Open != float64(hour*100) || c.Close != float64(hour*100+30) || c.Ticks != 2 {
+			t.Errorf("candle %d = %+v", i, c)
+		}
+	}
+
+	// Without any stored candle the whole range comes from prices.
+	ts, closes, err := rangeSeries("ethereum", "1h", at(10, 0), at(12, 0))
+	if err != nil || ts != nil || closes != nil {
+		t.Errorf("no data: %v %v %v", ts, closes, err)
+	}
+	ts, closes, _ = rangeSeries("bitcoin", "5m", at(10, 0), at(10, 59))
+	if len(ts) != 2 || closes[0] != 1000 || closes[1] != 1030 {
+		t.Errorf("5m closes from prices = %v %v", ts, closes)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Coin is one entry in the coin registry. ID is the coin_id used in
+// crypto_price_by_coin; ProviderIDs overrides the identifier a price source
+// uses for the coin (e.g. "coingecko": "avalanche-2", "kraken": "XBTUSD").
+type Coin struct {
+	ID          string            `json:"id"`
+	Symbol      string            `json:"symbol"`
+	Name        string            `json:"name"`
+	Disabled    bool              `json:"disabled,omitempty"`
+	Aliases     []string          `json:"aliases,omitempty"`
+	ProviderIDs map[string]string `json:"provider_ids,omitempty"`
+}
+
+// ProviderID returns the identifier provider uses for c, or fallback when
+// the registry has no override.
+func (c Coin) ProviderID(provider, fallback string) string {
+	if id, ok := c.ProviderIDs[provider]; ok && id != "" {
+		return id
+	}
+	return fallback
+}
+
+// CoinStore persists registry changes made through the admin API so they
+// are shared by the API and the ingestion job.
+type CoinStore interface {
+	// RegistryCoins returns every coin saved through PutCoin.
+	RegistryCoins() ([]Coin, error)
+	// PutCoin adds or replaces a coin by ID.
+	PutCoin(c Coin) error
+}
+
+// CoinRegistry is the coin universe: the config file overlaid with the
+// coins saved in the store.
+type CoinRegistry struct {
+	mu      sync.RWMutex
+	path    string
+	coinsDB CoinStore
+	coins   map[string]Coin
+	aliasTo map[string]string
+}
+
+// registry is the process-wide coin registry.
+var registry *CoinRegistry
+
+// loadCoinRegistry reads the JSON file named by COIN_REGISTRY_FILE (default
+// coins.json) and overlays the coins saved in db. A missing file only
+// leaves the registry to the stored coins.
+func loadCoinRegistry(db CoinStore) (*CoinRegistry, error) {
+	path := os.Getenv("COIN_REGISTRY_FILE")
+	if path == "" {
+		path = "coins.json"
+	}
+	r := &CoinRegistry{path: path, coinsDB: db}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the config file and the store. The ingestion job calls it
+// every tick so admin changes apply without a restart.
+func (r *CoinRegistry) Reload() error {
+	coins := make(map[string]Coin)
+
+	f, err := os.Open(r.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		log.Printf("Coin registry file %s not found, using stored coins only", r.path)
+	case err != nil:
+		return err
+	default:
+		var fileCoins []Coin
+		err := json.NewDecoder(f).Decode(&fileCoins)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("decode %s: %w", r.path, err)
+		}
+		for _, c := range fileCoins {
+			coins[c.ID] = c
+		}
+	}
+
+	stored, err := r.coinsDB.RegistryCoins()
+	if err != nil {
+		return fmt.Errorf("load stored coins: %w", err)
+	}
+	for _, c := range stored {
+		coins[c.ID] = c
+	}
+
+	aliasTo := make(map[string]string)
+	for id, c := range coins {
+		for _, a := range c.Aliases {
+			aliasTo[strings.ToLower(a)] = id
+		}
+	}
+
+	r.mu.Lock()
+	r.coins = coins
+	r.aliasTo = aliasTo
+	r.mu.Unlock()
+	return nil
+}
+
+// Resolve maps a coin id or alias to its registry entry.
+func (r *CoinRegistry) Resolve(idOrAlias string) (Coin, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if c, ok := r.coins[idOrAlias]; ok {
+		return c, true
+	}
+	if id, ok := r.aliasTo[strings.ToLower(idOrAlias)]; ok {
+		return r.coins[id], true
+	}
+	return Coin{}, false
+}
+
+// All returns every coin, enabled or not, sorted by id.
+func (r *CoinRegistry) All() []Coin {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]Coin, 0, len(r.coins))
+	for _, c := range r.coins {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Enabled returns the coins that are ingested and listed by /coins.
+func (r *CoinRegistry) Enabled() []Coin {
+	var out []Coin
+	for _, c := range r.All() {
+		if !c.Disabled {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Put validates c, saves it to the store and updates the registry.
+func (r *CoinRegistry) Put(c Coin) error {
+	if c.ID == "" || c.Symbol == "" {
+		return fmt.Errorf("id and symbol are required")
+	}
+
+	r.mu.RLock()
+	for _, a := range append([]string{c.ID}, c.Aliases...) {
+		if owner, ok := r.aliasTo[strings.ToLower(a)]; ok && owner != c.ID {
+			r.mu.RUnlock()
+			return fmt.Errorf("%q is already an alias of %s", a, owner)
+		}
+	}
+	for _, a := range c.Aliases {
+		if _, ok := r.coins[a]; ok && a != c.ID {
+			r.mu.RUnlock()
+			return fmt.Errorf("alias %q is already a coin id", a)
+		}
+	}
+	r.mu.RUnlock()
+
+	if err := r.coinsDB.PutCoin(c); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.coins[c.ID]; ok {
+		for _, a := range old.Aliases {
+			delete(r.aliasTo, strings.ToLower(a))
+		}
+	}
+	r.coins[c.ID] = c
+	for _, a := range c.Aliases {
+		r.aliasTo[strings.ToLower(a)] = c.ID
+	}
+	return nil
+}
+
+// resolveCoinAlias rewrites the {coin_id} route variable to the canonical
+// registry id, so /latest/btc reads the bitcoin series.
+func resolveCoinAlias(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if id, ok := vars["coin_id"]; ok && registry != nil {
+			if c, found := registry.Resolve(id); found && c.ID != id {
+				vars["coin_id"] = c.ID
+				r = mux.SetURLVars(r, vars)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listRegistryCoins handles GET /admin/coins.
+func listRegistryCoins(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(registry.All())
+}
+
+// putRegistryCoin handles POST /admin/coins, adding or replacing a coin.
+func putRegistryCoin(w http.ResponseWriter, r *http.Request) {
+	var c Coin
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	c.ID = strings.ToLower(strings.TrimSpace(c.ID))
+	c.Symbol = strings.ToUpper(strings.TrimSpace(c.Symbol))
+
+	if err := registry.Put(c); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// setRegistryCoinEnabled returns a handler for POST /admin/coins/{coin_id}/enable
+// and /disable.
+func setRegistryCoinEnabled(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := registry.Resolve(mux.Vars(r)["coin_id"])
+		if !ok {
+			http.Error(w, "Unknown coin", http.StatusNotFound)
+			return
+		}
+		c.Disabled = !enabled
+		if err := registry.Put(c); err != nil {
+			log.Printf("Error updating coin %s: %v", c.ID, err)
+			http.Error(w, "Failed to update coin", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// addRegistryCoinAlias handles POST /admin/coins/{coin_id}/aliases with a
+// body of {"alias": "btc"}.
+func addRegistryCoinAlias(w http.ResponseWriter, r *http.Request) {
+	c, ok := registry.Resolve(mux.Vars(r)["coin_id"])
+	if !ok {
+		http.Error(w, "Unknown coin", http.StatusNotFound)
+		return
+	}
+
+	var body struct {
+		Alias string `json:"alias"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Alias) == "" {
+		http.Error(w, "Alias is required", http.StatusBadRequest)
+		return
+	}
+	alias := strings.ToLower(strings.TrimSpace(body.Alias))
+	for _, a := range c.Aliases {
+		if strings.EqualFold(a, alias) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(c)
+			return
+		}
+	}
+
+	c.Aliases = append(append([]string(nil), c.Aliases...), alias)
+	if err := registry.Put(c); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCoinRegistryResolve(t *testing.T) {
+	useMemoryStore(t)
+	tests := []struct {
+		in, want string
+	}{
+		{"bitcoin", "bitcoin"},
+		{"btc", "bitcoin"},
+		{"BTC", "bitcoin"},
+		{"eth", "ethereum"},
+		{"terra", "terra"},
+		{"Bitcoin", ""},
+		{"luna", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		c, ok := registry.Resolve(tt.in)
+		if ok != (tt.want != "") || c.ID != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", tt.in, c.ID, ok, tt.want)
+		}
+	}
+
+	btc, _ := registry.Resolve("btc")
+	if btc.ProviderID("kraken", "BTCUSD") != "XBTUSD" || btc.ProviderID("binance", "BTCUSDT") != "BTCUSDT" {
+		t.Errorf("provider ids of %+v", btc)
+	}
+	for _, c := range registry.Enabled() {
+		if c.ID == "terra" {
+			t.Error("the disabled terra is listed as enabled")
+		}
+	}
+}
+
+func TestCoinRegistryPut(t *testing.T) {
+	s := useMemoryStore(t)
+
+	errs := []struct {
+		name string
+		coin Coin
+	}{
+		{"no symbol", Coin{ID: "pepe"}},
+		{"alias taken", Coin{ID: "pepe", Symbol: "PEPE", Aliases: []string{"BTC"}}},
+		{"alias is a coin id", Coin{ID: "pepe", Symbol: "PEPE", Aliases: []string{"solana"}}},
+		{"id is an alias", Coin{ID: "eth", Symbol: "ETH"}},
+	}
+	for _, tt := range errs {
+		if err := registry.Put(tt.coin); err == nil {
+			t.Errorf("%s: accepted %+v", tt.name, tt.coin)
+		}
+	}
+
+	// Replacing a coin's aliases frees the old ones.
+	if err := registry.Put(Coin{ID: "bitcoin", Symbol: "BTC", Aliases: []string{"xbt"}}); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := registry.Resolve("xbt"); !ok || c.ID != "bitcoin" {
+		t.Errorf("new alias resolves to %q", c.ID)
+	}
+	if _, ok := registry.Resolve("btc"); ok {
+		t.Error("the replaced alias still resolves")
+	}
+
+	// Stored coins survive a reload and override the file.
+	if err := registry.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := registry.Resolve("xbt"); !ok {
+		t.Error("a stored alias was lost on reload")
+	}
+	if stored, _ := s.RegistryCoins(); len(stored) != 1 {
+		t.Errorf("%d coins stored, want only the edited one", len(stored))
+	}
+}
+
+func TestResolveCoinAlias(t *testing.T) {
+	useMemoryStore(t)
+	var got string
+	router := mux.NewRouter()
+	router.Use(resolveCoinAlias)
+	router.HandleFunc("/latest/{coin_id}", func(w http.ResponseWriter, r *http.Request) { got = mux.Vars(r)["coin_id"] })
+
+	for in, want := range map[string]string{"btc": "bitcoin", "ethereum": "ethereum", "unknown": "unknown"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/latest/"+in, nil))
+		if got != want {
+			t.Errorf("/latest/%s reached the handler as %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConsensusConfig controls how quotes from several sources become one price.
+type ConsensusConfig struct {
+	// Method is "median" or "vwap" (volume-weighted average price).
+	Method string
+	// MaxDeviationPct rejects quotes further than this from the median of all
+	// quotes for the coin, in percent.
+	MaxDeviationPct float64
+}
+
+// configuredConsensus reads PRICE_CONSENSUS (default "median") and
+// PRICE_MAX_DEVIATION_PCT (default 2).
+func configuredConsensus() (ConsensusConfig, error) {
+	cfg := ConsensusConfig{Method: "median", MaxDeviationPct: 2}
+	if m := os.Getenv("PRICE_CONSENSUS"); m != "" {
+		if m != "median" && m != "vwap" {
+			return cfg, fmt.Errorf("unknown PRICE_CONSENSUS %q", m)
+		}
+		cfg.Method = m
+	}
+	if v := os.Getenv("PRICE_MAX_DEVIATION_PCT"); v != "" {
+		pct, err := strconv.ParseFloat(v, 64)
+		if err != nil || pct <= 0 {
+			return cfg, fmt.Errorf("invalid PRICE_MAX_DEVIATION_PCT %q", v)
+		}
+		cfg.MaxDeviationPct = pct
+	}
+	return cfg, nil
+}
+
+// ConsensusPrice is the agreed price for one coin in one tick. Market data
+// comes from the agreeing quotes: the largest reported 24h volume (the
+// aggregators cover more venues than any one exchange) and the median of
+// the reported market caps and 24h changes.
+type ConsensusPrice struct {
+	CoinID       string
+	PriceUSD     float64
+	Volume       float64
+	MarketCap    float64
+	Change24hPct float64
+	Agreed       []string // sources whose quotes were used
+	Rejected     []string // sources whose quotes deviated too far
+}
+
+// PriceData returns the row stored for c at timestamp.
+func (c ConsensusPrice) PriceData(timestamp time.Time) PriceData {
+	return PriceData{
+		CoinID:          c.CoinID,
+		Timestamp:       timestamp,
+		PriceUSD:        c.PriceUSD,
+		SourcesAgreed:   len(c.Agreed),
+		RejectedSources: c.Rejected,
+		MarketCapUSD:    c.MarketCap,
+		Volume24hUSD:    c.Volume,
+		Change24hPct:    c.Change24hPct,
+	}
+}
+
+// fetchAllQuotes queries every source concurrently. A failing source is
+// logged and contributes no quotes.
+func fetchAllQuotes(ctx context.Context, sources []PriceSource, coins []Coin) []Quote {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		quotes []Quote
+	)
+	for _, src := range sources {
+		wg.Add(1)
+		go func(src PriceSource) {
+			defer wg.Done()
+			got, err := src.Fetch(ctx, coins)
+			if err != nil {
+				log.Printf("Price source %s failed: %v", src.Name(), err)
+				return
+			}
+			mu.Lock()
+			quotes = append(quotes, got...)
+			mu.Unlock()
+		}(src)
+	}
+	wg.Wait()
+	return quotes
+}
+
+// buildConsensus groups quotes by coin and reduces each group to one price.
+// A coin whose quotes all disagree is logged and left out of the tick.
+// Results are sorted by coin id.
+func buildConsensus(quotes []Quote, cfg ConsensusConfig) []ConsensusPrice {
+	byCoin := make(map[string][]Quote)
+	for _, q := range quotes {
+		if q.PriceUSD > 0 {
+			byCoin[q.CoinID] = append(byCoin[q.CoinID], q)
+		}
+	}
+
+	out := make([]ConsensusPrice, 0, len(byCoin))
+	for coin, group := range byCoin {
+		c, ok := consensusFor(coin, group, cfg)
+		if !ok {
+			quoted := make([]string, len(group))
+			for i, q := range group {
+				quoted[i] = fmt.Sprintf("%s $%g", q.Source, q.PriceUSD)
+			}
+			log.Printf("No consensus for %s: every quote deviates more than %g%% from the median (%s); skipping it this tick",
+				coin, cfg.MaxDeviationPct, strings.Join(quoted, ", "))
+			continue
+		}
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CoinID < out[j].CoinID })
+	return out
+}
+
+// consensusFor reduces the quotes for one coin to a price. It reports false
+// when every quote is rejected: with two wildly different quotes the median
+// sits between them, no source reported it and there is no majority to
+// trust. Under vwap a quote without volume carries no weight, and the median
+// is used when no accepted quote has any.
+func consensusFor(coin string, group []Quote, cfg ConsensusConfig) (ConsensusPrice, bool) {
+	prices := make([]float64, len(group))
+	for i, q := range group {
+		prices[i] = q.PriceUSD
+	}
+	mid := median(prices)
+
+	result := ConsensusPrice{CoinID: coin}
+	var accepted []Quote
+	for _, q := range group {
+		if math.Abs(q.PriceUSD-mid)/mid*100 > cfg.MaxDeviationPct {
+			result.Rejected = append(result.Rejected, q.Source)
+			continue
+		}
+		accepted = append(accepted, q)
+		result.Agreed = append(result.Agreed, q.Source)
+	}
+	sort.Strings(result.Agreed)
+	sort.Strings(result.Rejected)
+
+	if len(accepted) == 0 {
+		return result, false
+	}
+
+	var caps, changes []float64
+	for _, q := range accepted {
+		if q.Volume > result.Volume {
+			result.Volume = q.Volume
+		}
+		if q.MarketCap > 0 {
+			caps = append(caps, q.MarketCap)
+		}
+		if q.Change24hPct != 0 {
+			changes = append(changes, q.Change24hPct)
+		}
+	}
+	result.MarketCap = median(caps)
+	result.Change24hPct = median(changes)
+
+	if cfg.Method == "vwap" {
+		var weighted, volume float64
+		for _, q := range accepted {
+			weighted += q.PriceUSD * q.Volume
+			volume += q.Volume
+		}
+		if volume > 0 {
+			result.PriceUSD = weighted / volume
+			return result, true
+		}
+	}
+
+	accPrices := make([]float64, len(accepted))
+	for i, q := range accepted {
+		accPrices[i] = q.PriceUSD
+	}
+	result.PriceUSD = median(accPrices)
+	return result, true
+}
+
+// median returns the middle value of xs, averaging the two middle values
+// for an even count. xs is not modified.
+func median(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), xs...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestConsensusFor(t *testing.T) {
+	byMedian := ConsensusConfig{Method: "median", MaxDeviationPct: 2}
+	byVWAP := ConsensusConfig{Method: "vwap", MaxDeviationPct: 2}
+	q := func(source string, price, volume float64) Quote {
+		return Quote{CoinID: "bitcoin", Source: source, PriceUSD: price, Volume: volume}
+	}
+
+	tests := []struct {
+		name     string
+		cfg      ConsensusConfig
+		quotes   []Quote
+		price    float64
+		agreed   []string
+		rejected []string
+	}{
+		{"single source", byMedian, []Quote{q("a", 100, 0)}, 100, []string{"a"}, nil},
+		{"outlier rejected", byMedian, []Quote{q("a", 100, 0), q("b", 101, 0), q("c", 150, 0)}, 100.5, []string{"a", "b"}, []string{"c"}},
+		{"within the limit", byMedian, []Quote{q("a", 100, 0), q("b", 101, 0), q("c", 102, 0)}, 101, []string{"a", "b", "c"}, nil},
+		{"vwap", byVWAP, []Quote{q("a", 100, 30), q("b", 101, 10)}, 100.25, []string{"a", "b"}, nil},
+		{"vwap ignores the outlier's volume", byVWAP, []Quote{q("a", 100, 30), q("b", 101, 10), q("c", 150, 1000)}, 100.25, []string{"a", "b"}, []string{"c"}},
+		{"vwap with a zero-volume source", byVWAP, []Quote{q("a", 100, 30), q("b", 101, 0)}, 100, []string{"a", "b"}, nil},
+		{"vwap without any volume", byVWAP, []Quote{q("a", 100, 0), q("b", 101, 0)}, 100.5, []string{"a", "b"}, nil},
+	}
+	for _, tt := range tests {
+		c, ok := consensusFor("bitcoin", tt.quotes, tt.cfg)
+		if !ok {
+			t.Errorf("%s: no consensus", tt.name)
+			continue
+		}
+		if math.Abs(c.PriceUSD-tt.price) > 1e-9 || !reflect.DeepEqual(c.Agreed, tt.agreed) || !reflect.DeepEqual(c.Rejected, tt.rejected) {
+			t.Errorf("%s: price %v agreed %v rejected %v, want %v %v %v", tt.name, c.PriceUSD, c.Agreed, c.Rejected, tt.price, tt.agreed, tt.rejected)
+		}
+	}
+}
+
+func TestConsensusForMarketData(t *testing.T) {
+	c, _ := consensusFor("bitcoin", []Quote{
+		{Source: "a", PriceUSD: 100, Volume: 5e9, MarketCap: 2e12, Change24hPct: 1},
+		{Source: "b", PriceUSD: 100, Volume: 3e10, MarketCap: 2.2e12},
+		{Source: "c", PriceUSD: 100, MarketCap: 2.1e12, Change24hPct: 2},
+		{Source: "d", PriceUSD: 200, Volume: 9e10, MarketCap: 9e12, Change24hPct: 50},
+	}, ConsensusConfig{Method: "median", MaxDeviationPct: 2})
+	if c.Volume != 3e10 || c.MarketCap != 2.1e12 || c.Change24hPct != 1.5 {
+		t.Errorf("volume %v, market cap %v, change %v; want 3e10, 2.1e12, 1.5", c.Volume, c.MarketCap, c.Change24hPct)
+	}
+}
+
+func TestBuildConsensusSkipsDisagreement(t *testing.T) {
+	// Two sources 100% apart leave a median of 150 that neither reported.
+	quotes := []Quote{
+		{CoinID: "solana", Source: "a", PriceUSD: 100},
+		{CoinID: "solana", Source: "b", PriceUSD: 200},
+		{CoinID: "ethereum", Source: "a", PriceUSD: 2500},
+		{CoinID: "bitcoin", Source: "a", PriceUSD: 60000},
+		{CoinID: "bitcoin", Source: "b", PriceUSD: 0},
+	}
+	if _, ok := consensusFor("solana", quotes[:2], ConsensusConfig{Method: "median", MaxDeviationPct: 2}); ok {
+		t.Error("consensusFor agreed on two quotes 100% apart")
+	}
+	got := buildConsensus(quotes, ConsensusConfig{Method: "median", MaxDeviationPct: 2})
+	var ids []string
+	for _, c := range got {
+		ids = append(ids, c.CoinID)
+	}
+	if want := []string{"bitcoin", "ethereum"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("coins = %v, want %v", ids, want)
+	}
+	if got[0].PriceUSD != 60000 || len(got[0].Agreed) != 1 {
+		t.Errorf("a zero quote counted: %+v", got[0])
+	}
+}
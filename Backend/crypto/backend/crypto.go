@@ -1,77 +1,78 @@
+//go:build ingest
+
 package main
 
 import (
-    "encoding/json"
-    "fmt"
+    "context"
+    "flag"
     "log"
-    "net/http"
-    "time"
     "os"
+    "os/signal"
+    "strings"
+    "time"
 
     
     "github.com/robfig/cron/v3"
-    gocqlastra "github.com/datastax/gocql-astra"
-    "github.com/gocql/gocql"
    
 )
 
-var session *gocql.Session
-
-type CoinGeckoResponse map[string]struct {
-    USD float64 `json:"usd"`
-}
-
-func main() {
-    
-    var err error
-
-    var cluster *gocql.ClusterConfig
+// priceSources are all queried on every tick; see configuredPriceSources.
+var priceSources []PriceSource
 
-    cluster, err = gocqlastra.NewClusterFromURL("https://api.astra.datastax.com", os.Getenv("ASTRA_DB_ID"), os.Getenv("ASTRA_DB_APPLICATION_TOKEN"), 10*time.Second)
-    cluster.Keyspace = "iot_data"
+// consensus decides how quotes from priceSources are combined.
+var consensus ConsensusConfig
 
+// quoteCurrencies are stored alongside USD on every tick.
+var quoteCurrencies = configuredQuoteCurrencies()
 
+func main() {
+    backfill := flag.Bool("backfill", false, "backfill history instead of running the scheduler, then exit")
+    backfillCoins := flag.String("coins", "", "backfill: comma separated coin ids or aliases (default every enabled coin)")
+    backfillFrom := flag.String("from", "", "backfill: start date, YYYY-MM-DD or RFC3339")
+    backfillTo := flag.String("to", "", "backfill: end date, YYYY-MM-DD or RFC3339 (default now)")
+    flag.Parse()
 
+    var err error
+    store, err = openStore()
     if err != nil {
-        log.Fatalf("unable to load cluster %s from astra: %v", os.Getenv("ASTRA_DB_APPLICATION_TOKEN"), err)
+        log.Fatalf("unable to open store: %v", err)
     }
 
-    cluster.Timeout = 30 * time.Second
-    start := time.Now()
-    session, err = gocql.NewSession(*cluster)
-    elapsed := time.Now().Sub(start)
-
+    registry, err = loadCoinRegistry(store)
     if err != nil {
-        log.Fatalf("unable to connect session: %v", err)
+        log.Fatalf("unable to load coin registry: %v", err)
     }
 
-    fmt.Println("Making the query now")
-
-    iter := session.Query("SELECT release_version FROM system.local").Iter()
-
-    var version string
-
-    for iter.Scan(&version) {
-        fmt.Println(version)
+    priceSources, err = configuredPriceSources()
+    if err != nil {
+        log.Fatalf("unable to configure price sources: %v", err)
     }
 
-    if err = iter.Close(); err != nil {
-        log.Printf("error running query: %v", err)
+    consensus, err = configuredConsensus()
+    if err != nil {
+        log.Fatalf("unable to configure price consensus: %v", err)
     }
 
-    fmt.Printf("Connection process took %s", elapsed)
-
+    llm, err = configuredLLM()
     if err != nil {
-        log.Fatalf("unable to connect session: %v", err)
+        log.Fatalf("unable to configure LLM: %v", err)
     }
 
+    if *backfill {
+        runBackfillCommand(*backfillCoins, *backfillFrom, *backfillTo)
+        return
+    }
 
-fmt.Println("Connected to Cassandra")
-
-    
     c := cron.New()
     c.AddFunc("@every 10m", fetchAndStoreCryptoPrices)
-    c.AddFunc("@daily", SendDailyReports)
+    c.AddFunc("@daily", sendDailyReports)
+    // Weekly digests go out on Monday, monthly ones on the 1st, once the
+    // last day of the period has been reported.
+    c.AddFunc("0 1 * * 1", func() { sendPeriodReports(PeriodWeekly) })
+    c.AddFunc("0 2 1 * *", func() { sendPeriodReports(PeriodMonthly) })
+    if os.Getenv("DATA_QUALITY_AUTO_BACKFILL") == "true" {
+        c.AddFunc("@hourly", repairRecentGaps)
+    }
     c.Start()
 
     
@@ -79,38 +80,165 @@ fmt.Println("Connected to Cassandra")
 }
 
 func fetchAndStoreCryptoPrices() {
-    url := "https://api.coingecko.com/api/v3/simple/price?ids=bitcoin,ethereum,ripple,litecoin,cardano,dogecoin,polkadot,bitcoin-cash,binancecoin,chainlink,vechain,tron,monero,solana,avalanche,terra,uniswap,shiba-inu,algorand&vs_currencies=usd"
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+    defer cancel()
 
-    resp, err := http.Get(url)
-    if err != nil {
-        log.Printf("Error fetching data: %v", err)
+    if err := registry.Reload(); err != nil {
+        log.Printf("Error reloading coin registry, using previous coins: %v", err)
+    }
+
+    quotes := fetchAllQuotes(ctx, priceSources, registry.Enabled())
+    if len(quotes) == 0 {
+        log.Printf("No prices fetched from any source")
         return
     }
-    defer resp.Body.Close()
 
-    if resp.StatusCode != http.StatusOK {
-        log.Printf("Non-OK HTTP status: %s", resp.Status)
+    timestamp := time.Now()
+
+    var stored []PriceData
+    for _, c := range buildConsensus(quotes, consensus) {
+        row := c.PriceData(timestamp)
+        err := store.Insert(row)
+
+        if err != nil {
+            log.Printf("Error inserting %s data: %v", c.CoinID, err)
+            continue
+        }
+        log.Printf("Inserted %s price: $%.2f (agreed %v, rejected %v)", c.CoinID, c.PriceUSD, c.Agreed, c.Rejected)
+        rollupCandles(store, row)
+        stored = append(stored, row)
+    }
+    publishTicks(ctx, stored)
+    evaluateAlerts(ctx, stored)
+
+    storeCurrencyQuotes(ctx, timestamp)
+}
+
+// storeCurrencyQuotes records the tick's quotes in quoteCurrencies under the
+// same timestamp as the USD rows, which is what cross-rate lookups join on.
+func storeCurrencyQuotes(ctx context.Context, timestamp time.Time) {
+    if len(quoteCurrencies) == 0 {
         return
     }
 
-    var prices CoinGeckoResponse
-    if err := json.NewDecoder(resp.Body).Decode(&prices); err != nil {
-        log.Printf("Error decoding response: %v", err)
+    quotes, err := fetchCurrencyQuotes(ctx, priceSources, registry.Enabled(), quoteCurrencies)
+    if err != nil {
+        log.Printf("Error fetching %v quotes: %v", quoteCurrencies, err)
         return
     }
 
-    timestamp := time.Now()
+    for _, q := range quotes {
+        q.Timestamp = timestamp
+        if err := store.InsertQuote(q); err != nil {
+            log.Printf("Error inserting %s/%s quote: %v", q.CoinID, q.VsCurrency, err)
+        }
+    }
+    log.Printf("Inserted %d quotes in %v", len(quotes), quoteCurrencies)
+}
+
+// runBackfillCommand handles -backfill: it resolves the coins and dates,
+// runs the backfill with progress logging and exits non-zero when any coin
+// failed. Ctrl-C stops it after the current request; rows already inserted
+// are kept and a rerun skips them.
+func runBackfillCommand(coinList, from, to string) {
+    start, err := parseBackfillTime(from)
+    if err != nil || from == "" {
+        log.Fatalf("-from is required as YYYY-MM-DD or RFC3339 (got %q)", from)
+    }
+    end := time.Now().UTC()
+    if to != "" {
+        if end, err = parseBackfillTime(to); err != nil {
+            log.Fatalf("invalid -to %q: %v", to, err)
+        }
+    }
+
+    coins := registry.Enabled()
+    if coinList != "" {
+        coins = nil
+        for _, id := range strings.Split(coinList, ",") {
+            c, ok := registry.Resolve(strings.TrimSpace(id))
+            if !ok {
+                log.Fatalf("unknown coin %q", id)
+            }
+            coins = append(coins, c)
+        }
+    }
 
-    for coinID, data := range prices {
-        err := session.Query(`
-            INSERT INTO crypto_price_by_coin (coin_id, timestamp, price_usd)
-            VALUES (?, ?, ?)`,
-            coinID, timestamp, data.USD).Exec()
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+
+    src := configuredHistorySource(priceSources)
+    log.Printf("Backfilling %d coins from %s to %s using %s", len(coins), start.Format(time.RFC3339), end.Format(time.RFC3339), src.Name())
+
+    results, err := runBackfill(ctx, src, store, BackfillOptions{
+        Coins:             coins,
+        Start:             start,
+        End:               end,
+        RequestsPerMinute: backfillRequestsPerMinute(),
+        Progress: func(p BackfillProgress) {
+            log.Printf("Backfill %s: chunk %d/%d (%s..%s) fetched %d, inserted %d, skipped %d",
+                p.CoinID, p.Chunk, p.Chunks, p.ChunkStart.Format("2006-01-02"), p.ChunkEnd.Format("2006-01-02"),
+                p.Fetched, p.Inserted, p.Skipped)
+        },
+    })
+
+    failed := 0
+    for _, r := range results {
+        if r.Error != "" {
+            failed++
+            log.Printf("Backfill %s failed: %s (inserted %d before stopping)", r.CoinID, r.Error, r.Inserted)
+            continue
+        }
+        log.Printf("Backfill %s done: fetched %d, inserted %d, skipped %d", r.CoinID, r.Fetched, r.Inserted, r.Skipped)
+    }
+    if err != nil {
+        log.Fatalf("Backfill interrupted: %v", err)
+    }
+    if failed > 0 {
+        log.Fatalf("Backfill finished with %d failed coins", failed)
+    }
+}
+
+// parseBackfillTime accepts a date (midnight UTC) or an RFC3339 time.
+func parseBackfillTime(v string) (time.Time, error) {
+    if t, err := time.Parse("2006-01-02", v); err == nil {
+        return t, nil
+    }
+    return time.Parse(time.RFC3339, v)
+}
+
+// repairRecentGaps scans the last day of every enabled coin and backfills
+// any gaps, so a failed fetch or a stopped worker heals itself.
+func repairRecentGaps() {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+    defer cancel()
+
+    end := time.Now().UTC()
+    start := end.Add(-24 * time.Hour)
+    src := configuredHistorySource(priceSources)
+
+    for _, coin := range registry.Enabled() {
+        rep, err := checkDataQuality(coin.ID, start, end, defaultQualityConfig)
+        if err != nil {
+            log.Printf("Data quality check failed for %s: %v", coin.ID, err)
+            continue
+        }
+        if len(rep.Gaps) == 0 {
+            continue
+        }
+        log.Printf("Data quality: %s has %d gaps in the last 24h, backfilling", coin.ID, len(rep.Gaps))
 
+        after, err := backfillGaps(ctx, src, rep, defaultQualityConfig)
         if err != nil {
-            log.Printf("Error inserting %s data: %v", coinID, err)
-        } else {
-            log.Printf("Inserted %s price: $%.2f", coinID, data.USD)
+            log.Printf("Gap backfill failed for %s: %v", coin.ID, err)
+            continue
+        }
+        for _, r := range after.Backfill {
+            if r.Error != "" {
+                log.Printf("Gap backfill failed for %s: %s", r.CoinID, r.Error)
+            } else {
+                log.Printf("Gap backfill %s: inserted %d, %d gaps remain", r.CoinID, r.Inserted, len(after.Gaps))
+            }
         }
     }
 }
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CurrencyQuote is a coin's price in a quote currency other than USD.
+type CurrencyQuote struct {
+	CoinID     string    `json:"coin_id"`
+	VsCurrency string    `json:"vs_currency"`
+	Timestamp  time.Time `json:"timestamp"`
+	Price      float64   `json:"price"`
+}
+
+// QuoteStore persists non-USD quotes. USD prices stay in the PriceStore.
+type QuoteStore interface {
+	// InsertQuote stores a single quote.
+	InsertQuote(q CurrencyQuote) error
+	// QuoteRange returns quotes for coinID in vs with start <= timestamp <= end,
+	// sorted ascending.
+	QuoteRange(coinID, vs string, start, end time.Time) ([]CurrencyQuote, error)
+}
+
+// multiCurrencySource is implemented by price sources that can quote coins
+// directly in currencies other than USD.
+type multiCurrencySource interface {
+	PriceSource
+	FetchIn(ctx context.Context, coins []Coin, vs []string) ([]CurrencyQuote, error)
+}
+
+// configuredQuoteCurrencies reads QUOTE_CURRENCIES, a comma separated list
+// such as "eur,gbp,btc,eth". USD is always ingested and is left out.
+func configuredQuoteCurrencies() []string {
+	var out []string
+	for _, vs := range strings.Split(os.Getenv("QUOTE_CURRENCIES"), ",") {
+		vs = strings.ToLower(strings.TrimSpace(vs))
+		if vs != "" && vs != "usd" {
+			out = append(out, vs)
+		}
+	}
+	return out
+}
+
+// fetchCurrencyQuotes asks the first multi-currency source that succeeds for
+// quotes in every currency of vs.
+func fetchCurrencyQuotes(ctx context.Context, sources []PriceSource, coins []Coin, vs []string) ([]CurrencyQuote, error) {
+	if len(vs) == 0 {
+		return nil, nil
+	}
+	var lastErr error
+	for _, src := range sources {
+		mc, ok := src.(multiCurrencySource)
+		if !ok {
+			continue
+		}
+		quotes, err := mc.FetchIn(ctx, coins, vs)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", src.Name(), err)
+			continue
+		}
+		return quotes, nil
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, errors.New("no configured price source quotes other currencies")
+}
+
+func (coinGeckoSource) FetchIn(ctx context.Context, coins []Coin, vs []string) ([]CurrencyQuote, error) {
+	byGeckoID := make(map[string]string, len(coins))
+	ids := make([]string, 0, len(coins))
+	for _, c := range coins {
+		geckoID := c.ProviderID("coingecko", c.ID)
+		byGeckoID[geckoID] = c.ID
+		ids = append(ids, geckoID)
+	}
+	u := "https://api.coingecko.com/api/v3/simple/price?ids=" + url.QueryEscape(strings.Join(ids, ",")) +
+		"&vs_currencies=" + url.QueryEscape(strings.Join(vs, ","))
+
+	var prices map[string]map[string]float64
+	if err := getJSON(ctx, u, &prices); err != nil {
+		return nil, err
+	}
+
+	var quotes []CurrencyQuote
+	for geckoID, byVs := range prices {
+		coinID, ok := byGeckoID[geckoID]
+		if !ok {
+			continue
+		}
+		for currency, price := range byVs {
+			if price > 0 {
+				quotes = append(quotes, CurrencyQuote{CoinID: coinID, VsCurrency: currency, Price: price})
+			}
+		}
+	}
+	return quotes, nil
+}
+
+// ErrNoRate is returned when a price cannot be expressed in the requested
+// currency, either directly or through a cross rate.
+var ErrNoRate = errors.New("no exchange rate available")
+
+var vsPattern = regexp.MustCompile(`^[a-z]{3,5}$`)
+
+// parseVs reads the vs= query parameter. An empty result means USD.
+func parseVs(r *http.Request) (string, error) {
+	vs := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("vs")))
+	if vs == "" || vs == "usd" {
+		return "", nil
+	}
+	if !vsPattern.MatchString(vs) {
+		return "", fmt.Errorf("invalid vs currency %q", vs)
+	}
+	return vs, nil
+}
+
+// crossRateReference is the coin whose direct quotes provide the USD rate of
+// fiat currencies when a coin has no direct quote of its own.
+const crossRateReference = "bitcoin"
+
+// convertSeries expresses USD prices of coinID taken at ts in vs. Each point
+// uses the coin's direct quote when one was stored at the same timestamp,
+// and otherwise divides by the USD price of one unit of vs: the registry
+// coin itself for crypto currencies (btc, eth), or the reference coin's
+// USD/vs ratio for fiat.
+func convertSeries(coinID, vs string, ts []time.Time, usd []float64) ([]float64, error) {
+	if vs == "" || len(ts) == 0 {
+		return usd, nil
+	}
+	start, end := ts[0], ts[len(ts)-1]
+
+	direct, err := store.QuoteRange(coinID, vs, start, end)
+	if err != nil {
+		return nil, err
+	}
+	byTime := make(map[int64]float64, len(direct))
+	for _, q := range direct {
+		byTime[q.Timestamp.UnixMilli()] = q.Price
+	}
+
+	var rates []rateAt
+	ratesLoaded := false
+
+	out := make([]float64, len(ts))
+	for i, t := range ts {
+		if p, ok := byTime[t.UnixMilli()]; ok {
+			out[i] = p
+			continue
+		}
+		if !ratesLoaded {
+			// Reach back an hour so the first point has a preceding rate.
+			rates, err = usdPerUnit(vs, start.Add(-time.Hour), end)
+			if err != nil {
+				return nil, err
+			}
+			ratesLoaded = true
+		}
+		rate, ok := rateNear(rates, t)
+		if !ok {
+			return nil, fmt.Errorf("%w for %s in %s", ErrNoRate, coinID, vs)
+		}
+		out[i] = usd[i] / rate
+	}
+	return out, nil
+}
+
+type rateAt struct {
+	t   time.Time
+	usd float64 // USD price of one unit of the currency
+}
+
+// usdPerUnit returns the USD price of one unit of vs over [start, end].
+func usdPerUnit(vs string, start, end time.Time) ([]rateAt, error) {
+	var rates []rateAt
+
+	if c, ok := registry.Resolve(vs); ok {
+		rows, err := store.Range(c.ID, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if row.PriceUSD > 0 {
+				rates = append(rates, rateAt{t: row.Timestamp, usd: row.PriceUSD})
+			}
+		}
+		return rates, nil
+	}
+
+	refUSD, err := store.Range(crossRateReference, start, end)
+	if err != nil {
+		return nil, err
+	}
+	refVs, err := store.QuoteRange(crossRateReference, vs, start, end)
+	if err != nil {
+		return nil, err
+	}
+	vsAt := make(map[int64]float64, len(refVs))
+	for _, q := range refVs {
+		vsAt[q.Timestamp.UnixMilli()] = q.Price
+	}
+	for _, row := range refUSD {
+		if p, ok := vsAt[row.Timestamp.UnixMilli()]; ok && p > 0 {
+			rates = append(rates, rateAt{t: row.Timestamp, usd: row.PriceUSD / p})
+		}
+	}
+	return rates, nil
+}
+
+// rateNear returns the latest rate at or before t, or the earliest one after
+// it when none precedes t. rates must be sorted ascending.
+func rateNear(rates []rateAt, t time.Time) (float64, bool) {
+	if len(rates) == 0 {
+		return 0, false
+	}
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].t.After(t) })
+	if i == 0 {
+		return rates[0].usd, true
+	}
+	return rates[i-1].usd, true
+}
+
+// convertRows fills Price and VsCurrency on rows when vs is set.
+func convertRows(coinID, vs string, rows []PriceData) ([]PriceData, error) {
+	if vs == "" || len(rows) == 0 {
+		return rows, nil
+	}
+	ts := make([]time.Time, len(rows))
+	usd := make([]float64, len(rows))
+	for i, row := range rows {
+		ts[i] = row.Timestamp
+		usd[i] = row.PriceUSD
+	}
+	prices, err := convertSeries(coinID, vs, ts, usd)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PriceData, len(rows))
+	for i, row := range rows {
+		row.VsCurrency = vs
+		row.Price = prices[i]
+		out[i] = row
+	}
+	return out, nil
+}
+
+// writeConversionError maps a conversion failure to a response.
+func writeConversionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNoRate) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Query error", http.StatusInternalServerError)
+}
+
+// vsLabel is the currency named in responses for a parsed vs.
+func vsLabel(vs string) string {
+	if vs == "" {
+		return "usd"
+	}
+	return vs
+}
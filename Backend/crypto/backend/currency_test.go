@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestConvertSeries(t *testing.T) {
+	s := useMemoryStore(t)
+	t0 := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	t1, t2 := t0.Add(10*time.Minute), t0.Add(20*time.Minute)
+	for _, p := range []PriceData{
+		{CoinID: "bitcoin", Timestamp: t0, PriceUSD: 60000},
+		{CoinID: "bitcoin", Timestamp: t1, PriceUSD: 62000},
+		{CoinID: "ethereum", Timestamp: t0, PriceUSD: 3000},
+		{CoinID: "ethereum", Timestamp: t1, PriceUSD: 3100},
+	} {
+		s.Insert(p)
+	}
+	// Bitcoin's EUR quote gives the USD/EUR rate; ethereum has its own
+	// quote for the second tick only.
+	s.InsertQuote(CurrencyQuote{CoinID: "bitcoin", VsCurrency: "eur", Timestamp: t0, Price: 50000})
+	s.InsertQuote(CurrencyQuote{CoinID: "ethereum", VsCurrency: "eur", Timestamp: t1, Price: 2800})
+
+	ts := []time.Time{t0, t1, t2}
+	usd := []float64{3000, 3100, 3200}
+	tests := []struct {
+		coin, vs string
+		want     []float64
+	}{
+		// 1.2 USD per EUR at t0, carried forward past the last EUR quote.
+		{"ethereum", "eur", []float64{2500, 2800, 3200 / 1.2}},
+		// Through bitcoin's own USD price, the last one carried forward.
+		{"ethereum", "btc", []float64{0.05, 0.05, 3200.0 / 62000}},
+		{"ethereum", "", usd},
+	}
+	for _, tt := range tests {
+		got, err := convertSeries(tt.coin, tt.vs, ts, usd)
+		if err != nil {
+			t.Errorf("%s in %q: %v", tt.coin, tt.vs, err)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+				t.Errorf("%s in %q = %v, want %v", tt.coin, tt.vs, got, tt.want)
+				break
+			}
+		}
+	}
+
+	if _, err := convertSeries("ethereum", "gbp", ts, usd); !errors.Is(err, ErrNoRate) {
+		t.Errorf("without a GBP quote: got %v, want ErrNoRate", err)
+	}
+}
+
+func TestRoundPrice(t *testing.T) {
+	tests := []struct {
+		p    float64
+		vs   string
+		want float64
+	}{
+		{64123.456, "", 64123.46},
+		{0.123456, "", 0.12},
+		{64123.456, "eur", 64123.46},
+		{0.0512345678, "btc", 0.0512346},
+		{-0.000123456789, "btc", -0.000123457},
+		{0, "btc", 0},
+	}
+	for _, tt := range tests {
+		if got := roundPrice(tt.p, tt.vs); math.Abs(got-tt.want) > 1e-15 {
+			t.Errorf("roundPrice(%v, %q) = %v, want %v", tt.p, tt.vs, got, tt.want)
+		}
+	}
+}
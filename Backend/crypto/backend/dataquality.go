@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// QualityConfig sets the thresholds a series is checked against.
+type QualityConfig struct {
+	// ExpectedInterval is the ingestion cadence; a stretch longer than
+	// MaxGap without a row is a gap.
+	ExpectedInterval time.Duration
+	MaxGap           time.Duration
+	// MaxJumpPct flags consecutive rows whose price moves more than this, in
+	// percent.
+	MaxJumpPct float64
+}
+
+// defaultQualityConfig matches the ingestion job's 10 minute schedule: two
+// missed ticks make a gap.
+var defaultQualityConfig = QualityConfig{
+	ExpectedInterval: 10 * time.Minute,
+	MaxGap:           20 * time.Minute,
+	MaxJumpPct:       20,
+}
+
+// DataGap is a stretch without rows. Missing estimates the ticks lost.
+type DataGap struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Minutes float64   `json:"minutes"`
+	Missing int       `json:"missing"`
+}
+
+// DataIssue points at a single suspicious row.
+type DataIssue struct {
+	Timestamp time.Time `json:"timestamp"`
+	PriceUSD  float64   `json:"price_usd"`
+	Detail    string    `json:"detail"`
+}
+
+// PriceJump is a move between consecutive rows larger than MaxJumpPct.
+type PriceJump struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	FromPrice float64   `json:"from_price"`
+	ToPrice   float64   `json:"to_price"`
+	ChangePct float64   `json:"change_pct"`
+}
+
+// DataQualityReport is the result of scanning one coin's series.
+type DataQualityReport struct {
+	CoinID           string           `json:"coin_id"`
+	Start            time.Time        `json:"start"`
+	End              time.Time        `json:"end"`
+	ExpectedInterval string           `json:"expected_interval"`
+	Points           int              `json:"points"`
+	ExpectedPoints   int              `json:"expected_points"`
+	CoveragePct      float64          `json:"coverage_pct"`
+	Gaps             []DataGap        `json:"gaps"`
+	Duplicates       []DataIssue      `json:"duplicates"`
+	NonPositive      []DataIssue      `json:"non_positive"`
+	Jumps            []PriceJump      `json:"jumps"`
+	OK               bool             `json:"ok"`
+	Backfill         []BackfillResult `json:"backfill,omitempty"`
+}
+
+// scanDataQuality checks rows (sorted ascending, as Range returns them)
+// covering start..end. Gaps include the stretches before the first row and
+// after the last one, so a worker that stopped an hour ago shows up as a
+// trailing gap. Duplicates are rows that fall in the same second as the row
+// before them, which happens when two workers write the same tick.
+func scanDataQuality(coinID string, rows []PriceData, start, end time.Time, cfg QualityConfig) DataQualityReport {
+	rep := DataQualityReport{
+		CoinID:           coinID,
+		Start:            start,
+		End:              end,
+		ExpectedInterval: cfg.ExpectedInterval.String(),
+		Points:           len(rows),
+		Gaps:             []DataGap{},
+		Duplicates:       []DataIssue{},
+		NonPositive:      []DataIssue{},
+		Jumps:            []PriceJump{},
+	}
+	rep.ExpectedPoints = int(end.Sub(start) / cfg.ExpectedInterval)
+	if rep.ExpectedPoints > 0 {
+		rep.CoveragePct = math.Min(100, float64(len(rows))/float64(rep.ExpectedPoints)*100)
+	}
+
+	addGap := func(from, to time.Time) {
+		d := to.Sub(from)
+		if d > cfg.MaxGap {
+			rep.Gaps = append(rep.Gaps, DataGap{
+				From:    from,
+				To:      to,
+				Minutes: d.Minutes(),
+				Missing: int(d/cfg.ExpectedInterval) - 1,
+			})
+		}
+	}
+
+	if len(rows) == 0 {
+		addGap(start, end)
+	}
+
+	var prev *PriceData
+	for i := range rows {
+		row := &rows[i]
+		if row.PriceUSD <= 0 {
+			rep.NonPositive = append(rep.NonPositive, DataIssue{Timestamp: row.Timestamp, PriceUSD: row.PriceUSD, Detail: "price is not positive"})
+		}
+
+		if prev == nil {
+			addGap(start, row.Timestamp)
+		} else {
+			if row.Timestamp.Truncate(time.Second).Equal(prev.Timestamp.Truncate(time.Second)) {
+				rep.Duplicates = append(rep.Duplicates, DataIssue{Timestamp: row.Timestamp, PriceUSD: row.PriceUSD, Detail: "same second as " + prev.Timestamp.Format(time.RFC3339Nano)})
+			}
+			addGap(prev.Timestamp, row.Timestamp)
+
+			// Jumps are only measured between positive prices; the bad row is
+			// already reported above.
+			if prev.PriceUSD > 0 && row.PriceUSD > 0 {
+				change := (row.PriceUSD - prev.PriceUSD) / prev.PriceUSD * 100
+				if math.Abs(change) > cfg.MaxJumpPct {
+					rep.Jumps = append(rep.Jumps, PriceJump{
+						From: prev.Timestamp, To: row.Timestamp,
+						FromPrice: prev.PriceUSD, ToPrice: row.PriceUSD,
+						ChangePct: change,
+					})
+				}
+			}
+		}
+		prev = row
+	}
+	if prev != nil {
+		addGap(prev.Timestamp, end)
+	}
+
+	rep.OK = len(rep.Gaps) == 0 && len(rep.Duplicates) == 0 && len(rep.NonPositive) == 0 && len(rep.Jumps) == 0
+	return rep
+}
+
+// checkDataQuality scans coinID between start and end.
+func checkDataQuality(coinID string, start, end time.Time, cfg QualityConfig) (DataQualityReport, error) {
+	rows, err := store.Range(coinID, start, end)
+	if err != nil {
+		return DataQualityReport{}, err
+	}
+	return scanDataQuality(coinID, rows, start, end, cfg), nil
+}
+
+// backfillGaps backfills the span covering every gap in rep and rescans.
+// One request covers all gaps because rows that already exist are skipped.
+func backfillGaps(ctx context.Context, src historySource, rep DataQualityReport, cfg QualityConfig) (DataQualityReport, error) {
+	if len(rep.Gaps) == 0 {
+		return rep, nil
+	}
+	c, ok := registry.Resolve(rep.CoinID)
+	if !ok {
+		c = Coin{ID: rep.CoinID}
+	}
+
+	results, err := runBackfill(ctx, src, store, BackfillOptions{
+		Coins:             []Coin{c},
+		Start:             rep.Gaps[0].From,
+		End:               rep.Gaps[len(rep.Gaps)-1].To,
+		RequestsPerMinute: backfillRequestsPerMinute(),
+	})
+	if err != nil {
+		return rep, err
+	}
+
+	after, err := checkDataQuality(rep.CoinID, rep.Start, rep.End, cfg)
+	if err != nil {
+		return rep, err
+	}
+	after.Backfill = results
+	return after, nil
+}
+
+// parseQualityRequest reads start/end (RFC3339, default the last 24 hours),
+// expected_interval, max_gap (Go durations) and max_jump_pct.
+func parseQualityRequest(r *http.Request) (start, end time.Time, cfg QualityConfig, errMsg string) {
+	q := r.URL.Query()
+	cfg = defaultQualityConfig
+
+	end = time.Now().UTC()
+	if v := q.Get("end"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return start, end, cfg, "Invalid end time"
+		}
+		end = t
+	}
+	start = end.Add(-24 * time.Hour)
+	if v := q.Get("start"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return start, end, cfg, "Invalid start time"
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		return start, end, cfg, "start must be before end"
+	}
+
+	if v := q.Get("expected_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return start, end, cfg, "Invalid expected_interval"
+		}
+		cfg.ExpectedInterval = d
+		cfg.MaxGap = 2 * d
+	}
+	if v := q.Get("max_gap"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return start, end, cfg, "Invalid max_gap"
+		}
+		cfg.MaxGap = d
+	}
+	if v := q.Get("max_jump_pct"); v != "" {
+		pct, err := strconv.ParseFloat(v, 64)
+		if err != nil || pct <= 0 {
+			return start, end, cfg, "Invalid max_jump_pct"
+		}
+		cfg.MaxJumpPct = pct
+	}
+	return start, end, cfg, ""
+}
+
+// getDataQuality handles GET /data-quality/{coin_id}.
+func getDataQuality(w http.ResponseWriter, r *http.Request) {
+	coinID := mux.Vars(r)["coin_id"]
+	start, end, cfg, errMsg := parseQualityRequest(r)
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	rep, err := checkDataQuality(coinID, start, end, cfg)
+	if err != nil {
+		log.Printf("Data quality query error for %s: %v", coinID, err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
+
+// backfillDataQuality handles POST /admin/data-quality/{coin_id}/backfill. It
+// takes the same parameters as getDataQuality, backfills the gaps it finds
+// and returns the report after the backfill.
+func backfillDataQuality(w http.ResponseWriter, r *http.Request) {
+	coinID := mux.Vars(r)["coin_id"]
+	start, end, cfg, errMsg := parseQualityRequest(r)
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	rep, err := checkDataQuality(coinID, start, end, cfg)
+	if err != nil {
+		log.Printf("Data quality query error for %s: %v", coinID, err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+
+	sources, err := configuredPriceSources()
+	if err != nil {
+		log.Printf("Price source config error, backfilling from coingecko: %v", err)
+	}
+	rep, err = backfillGaps(r.Context(), configuredHistorySource(sources), rep, cfg)
+	if err != nil {
+		log.Printf("Backfill error for %s: %v", coinID, err)
+		http.Error(w, "Backfill failed", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScanDataQuality(t *testing.T) {
+	at := func(minute int) time.Time {
+		return time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC).Add(time.Duration(minute) * time.Minute)
+	}
+	rows := []PriceData{
+		{Timestamp: at(10), PriceUSD: 100},
+		{Timestamp: at(20), PriceUSD: 101},
+		{Timestamp: at(20).Add(300 * time.Millisecond), PriceUSD: 101},
+		// 12:30 to 13:10 is missing.
+		{Timestamp: at(70), PriceUSD: 130},
+		{Timestamp: at(80), PriceUSD: 0},
+		{Timestamp: at(90), PriceUSD: 131},
+	}
+	rep := scanDataQuality("bitcoin", rows, at(0), at(120), defaultQualityConfig)
+
+	if rep.OK || rep.Points != 6 || rep.ExpectedPoints != 12 || rep.CoveragePct != 50 {
+		t.Errorf("report = %+v", rep)
+	}
+	// The 10 minutes before the first row are within MaxGap; the 30 after
+	// the last are a trailing gap.
+	wantGaps := []DataGap{
+		{From: at(20).Add(300 * time.Millisecond), To: at(70), Missing: 3},
+		{From: at(90), To: at(120), Missing: 2},
+	}
+	if len(rep.Gaps) != len(wantGaps) {
+		t.Fatalf("gaps = %+v", rep.Gaps)
+	}
+	for i, g := range rep.Gaps {
+		if !g.From.Equal(wantGaps[i].From) || !g.To.Equal(wantGaps[i].To) || g.Missing != wantGaps[i].Missing {
+			t.Errorf("gap %d = %+v, want %+v", i, g, wantGaps[i])
+		}
+	}
+	if len(rep.Duplicates) != 1 || !rep.Duplicates[0].Timestamp.Equal(rows[2].Timestamp) {
+		t.Errorf("duplicates = %+v", rep.Duplicates)
+	}
+	if len(rep.NonPositive) != 1 || !rep.NonPositive[0].Timestamp.Equal(at(80)) {
+		t.Errorf("non-positive = %+v", rep.NonPositive)
+	}
+	// The zero price is not measured as a jump either way.
+	if len(rep.Jumps) != 1 || rep.Jumps[0].FromPrice != 101 || rep.Jumps[0].ToPrice != 130 {
+		t.Errorf("jumps = %+v", rep.Jumps)
+	}
+
+	if empty := scanDataQuality("bitcoin", nil, at(0), at(60), defaultQualityConfig); len(empty.Gaps) != 1 || empty.Gaps[0].Missing != 5 {
+		t.Errorf("no rows: gaps = %+v", empty.Gaps)
+	}
+}
+
+func TestBackfillGaps(t *testing.T) {
+	s := useMemoryStore(t)
+	at := func(minute int) time.Time {
+		return time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC).Add(time.Duration(minute) * time.Minute)
+	}
+
+	var recorded []PriceData
+	for minute := 0; minute <= 60; minute += 10 {
+		p := PriceData{CoinID: "bitcoin", Timestamp: at(minute), PriceUSD: float64(100 + minute)}
+		recorded = append(recorded, p)
+		if minute < 20 || minute > 40 {
+			s.Insert(p)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "replay.json")
+	b, _ := json.Marshal(recorded)
+	os.WriteFile(path, b, 0o644)
+	src, err := newReplaySource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep, _ := checkDataQuality("bitcoin", at(0), at(60), defaultQualityConfig)
+	if len(rep.Gaps) != 1 {
+		t.Fatalf("gaps before the backfill = %+v", rep.Gaps)
+	}
+	after, err := backfillGaps(context.Background(), src, rep, defaultQualityConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.OK || after.Points != 7 {
+		t.Errorf("after the backfill = %+v", after)
+	}
+	// The rows at both ends of the gap were already stored.
+	if len(after.Backfill) != 1 || after.Backfill[0].Inserted != 3 || after.Backfill[0].Skipped != 2 {
+		t.Errorf("backfill results = %+v", after.Backfill)
+	}
+	if c, err := s.Candle("bitcoin", "1h", at(0)); err != nil || c.Ticks != 3 {
+		t.Errorf("backfilled rows were not rolled up: %+v, %v", c, err)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+// useMemoryStore points the package's store and coin registry at a fresh
+// in-memory store, with the coins from coins.json, for the length of the
+// test.
+func useMemoryStore(t *testing.T) *memoryStore {
+	t.Helper()
+	t.Setenv("COIN_REGISTRY_FILE", "coins.json")
+	s := newMemoryStore()
+	r, err := loadCoinRegistry(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStore, oldRegistry := store, registry
+	store, registry = s, r
+	t.Cleanup(func() { store, registry = oldStore, oldRegistry })
+	return s
+}
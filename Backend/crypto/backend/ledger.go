@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Transaction types.
+const (
+	TxBuy         = "buy"
+	TxSell        = "sell"
+	TxTransferIn  = "transfer_in"
+	TxTransferOut = "transfer_out"
+)
+
+// Transaction is one ledger entry of a portfolio. PriceUSD is per unit;
+// when an import does not carry it, it is the stored price at or before
+// Time. FeeUSD is added to the cost of buys and taken from the proceeds of
+// sells. Transfers move coins in or out without realizing a gain; coins
+// transferred in are valued at the market price.
+type Transaction struct {
+	ID          string    `json:"id"`
+	PortfolioID string    `json:"portfolio_id"`
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	CoinID      string    `json:"coin_id"`
+	Quantity    float64   `json:"quantity"`
+	PriceUSD    float64   `json:"price_usd"`
+	FeeUSD      float64   `json:"fee_usd"`
+	Source      string    `json:"source"`
+	ExternalID  string    `json:"external_id,omitempty"`
+}
+
+// TransactionStore persists imported ledgers.
+type TransactionStore interface {
+	// Transactions returns a portfolio's ledger sorted by time.
+	Transactions(portfolioID string) ([]Transaction, error)
+	// PutTransaction adds or replaces a transaction by portfolio and ID.
+	PutTransaction(t Transaction) error
+	// DeleteTransaction removes one transaction.
+	DeleteTransaction(portfolioID, txID string) error
+}
+
+// Lot is an open position left after the ledger is applied.
+type Lot struct {
+	CoinID       string    `json:"coin_id"`
+	Quantity     float64   `json:"quantity"`
+	CostBasisUSD float64   `json:"cost_basis_usd"`
+	AcquiredAt   time.Time `json:"acquired_at"`
+}
+
+// RealizedLot is the part of a sale matched against one lot. With the
+// average-cost method every lot carries the pool's average unit cost and
+// lots are taken oldest first, so each line keeps its own acquisition date.
+type RealizedLot struct {
+	CoinID       string    `json:"coin_id"`
+	Quantity     float64   `json:"quantity"`
+	AcquiredAt   time.Time `json:"acquired_at"`
+	SoldAt       time.Time `json:"sold_at"`
+	ProceedsUSD  float64   `json:"proceeds_usd"`
+	CostBasisUSD float64   `json:"cost_basis_usd"`
+	GainUSD      float64   `json:"gain_usd"`
+	Term         string    `json:"term"`
+	TxID         string    `json:"tx_id"`
+}
+
+// GainsTotals sums the realized lots.
+type GainsTotals struct {
+	ProceedsUSD  float64 `json:"proceeds_usd"`
+	CostBasisUSD float64 `json:"cost_basis_usd"`
+	GainUSD      float64 `json:"gain_usd"`
+	ShortTermUSD float64 `json:"short_term_usd"`
+	LongTermUSD  float64 `json:"long_term_usd"`
+}
+
+// GainsReport is the result of applying a ledger with one lot method.
+type GainsReport struct {
+	PortfolioID string        `json:"portfolio_id"`
+	Method      string        `json:"method"`
+	Year        int           `json:"year,omitempty"`
+	Realized    []RealizedLot `json:"realized"`
+	Totals      GainsTotals   `json:"totals"`
+	OpenLots    []Lot         `json:"open_lots"`
+	Warnings    []string      `json:"warnings"`
+}
+
+// longTermAfter is the holding period after which a gain is long term.
+const longTermAfter = 365 * 24 * time.Hour
+
+// lotEpsilon absorbs float rounding when lots are consumed.
+const lotEpsilon = 1e-12
+
+// computeGains applies txs (sorted by time) with method "fifo", "lifo" or
+// "average". Sales of more than is held realize the excess at zero cost and
+// add a warning. Only sales in year are reported when year is non-zero; open
+// lots are as of the end of the ledger.
+func computeGains(txs []Transaction, method string, year int) (GainsReport, error) {
+	rep := GainsReport{Method: method, Year: year, Realized: []RealizedLot{}, OpenLots: []Lot{}, Warnings: []string{}}
+	if method != "fifo" && method != "lifo" && method != "average" {
+		return rep, fmt.Errorf("unknown method %q (use fifo, lifo or average)", method)
+	}
+
+	lots := make(map[string][]Lot)
+	for _, t := range txs {
+		switch t.Type {
+		case TxBuy, TxTransferIn:
+			cost := t.Quantity * t.PriceUSD
+			if t.Type == TxBuy {
+				cost += t.FeeUSD
+			}
+			lots[t.CoinID] = append(lots[t.CoinID], Lot{CoinID: t.CoinID, Quantity: t.Quantity, CostBasisUSD: cost, AcquiredAt: t.Time})
+
+		case TxSell, TxTransferOut:
+			taken, short := takeLots(lots, t.CoinID, t.Quantity, method)
+			if short > lotEpsilon {
+				rep.Warnings = append(rep.Warnings, fmt.Sprintf("%s %s of %g %s exceeds holdings by %g", t.Time.Format(time.RFC3339), t.Type, t.Quantity, t.CoinID, short))
+				if t.Type == TxSell {
+					taken = append(taken, Lot{CoinID: t.CoinID, Quantity: short, AcquiredAt: t.Time})
+				}
+			}
+			if t.Type == TxTransferOut || (year != 0 && t.Time.Year() != year) {
+				continue
+			}
+
+			proceeds := t.Quantity*t.PriceUSD - t.FeeUSD
+			for _, l := range taken {
+				share := l.Quantity / t.Quantity
+				r := RealizedLot{
+					CoinID:       t.CoinID,
+					Quantity:     l.Quantity,
+					AcquiredAt:   l.AcquiredAt,
+					SoldAt:       t.Time,
+					ProceedsUSD:  proceeds * share,
+					CostBasisUSD: l.CostBasisUSD,
+					TxID:         t.ID,
+				}
+				r.GainUSD = r.ProceedsUSD - r.CostBasisUSD
+				r.Term = "short"
+				if t.Time.Sub(l.AcquiredAt) > longTermAfter {
+					r.Term = "long"
+				}
+				rep.Realized = append(rep.Realized, r)
+
+				rep.Totals.ProceedsUSD += r.ProceedsUSD
+				rep.Totals.CostBasisUSD += r.CostBasisUSD
+				rep.Totals.GainUSD += r.GainUSD
+				if r.Term == "long" {
+					rep.Totals.LongTermUSD += r.GainUSD
+				} else {
+					rep.Totals.ShortTermUSD += r.GainUSD
+				}
+			}
+		}
+	}
+
+	coins := make([]string, 0, len(lots))
+	for c := range lots {
+		coins = append(coins, c)
+	}
+	sort.Strings(coins)
+	for _, c := range coins {
+		rep.OpenLots = append(rep.OpenLots, lots[c]...)
+	}
+	return rep, nil
+}
+
+// takeLots removes qty of coin from lots and returns what was taken, one
+// entry per lot touched, and how much was missing. The average method first
+// spreads the pool's cost evenly over its units, then takes the oldest lots
+// first like fifo.
+func takeLots(lots map[string][]Lot, coin string, qty float64, method string) ([]Lot, float64) {
+	pool := lots[coin]
+	if method == "average" && len(pool) > 1 {
+		var units, cost float64
+		for _, l := range pool {
+			units += l.Quantity
+			cost += l.CostBasisUSD
+		}
+		if units > 0 {
+			for i := range pool {
+				pool[i].CostBasisUSD = cost * pool[i].Quantity / units
+			}
+		}
+	}
+
+	var taken []Lot
+	remaining := qty
+	for remaining > lotEpsilon && len(pool) > 0 {
+		i := 0
+		if method == "lifo" {
+			i = len(pool) - 1
+		}
+		l := pool[i]
+		use := remaining
+		if l.Quantity <= use+lotEpsilon {
+			use = l.Quantity
+			pool = append(pool[:i:i], pool[i+1:]...)
+		} else {
+			cost := l.CostBasisUSD * use / l.Quantity
+			pool[i].Quantity -= use
+			pool[i].CostBasisUSD -= cost
+			l.CostBasisUSD = cost
+		}
+		l.Quantity = use
+		taken = append(taken, l)
+		remaining -= use
+	}
+	lots[coin] = pool
+	if remaining < 0 {
+		remaining = 0
+	}
+	return taken, remaining
+}
+
+// fillPrice fills PriceUSD from the stored price at or before the trade,
+// the same lookup as /at. It returns false when no stored price exists.
+func fillPrice(t *Transaction) bool {
+	if t.PriceUSD > 0 {
+		return true
+	}
+	p, err := store.At(t.CoinID, t.Time)
+	if err != nil {
+		return false
+	}
+	t.PriceUSD = p.PriceUSD
+	return true
+}
+
+// importTransactions handles POST /portfolios/{id}/transactions/import. The
+// CSV is the request body or a multipart "file" field; ?format= selects
+// coinbase, binance, kraken or generic and is detected from the header row
+// when omitted. Rows get deterministic ids, so importing the same file again
+// replaces rather than duplicates them.
+func importTransactions(w http.ResponseWriter, r *http.Request, p Portfolio) {
+	r.Body = http.MaxBytesReader(w, r.Body, 5<<20)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Multipart uploads need a \"file\" field", http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	txs, rowErrs, err := parseLedgerCSV(body, r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	imported := 0
+	for _, t := range txs {
+		t.PortfolioID = p.ID
+		if !fillPrice(&t) {
+			rowErrs = append(rowErrs, fmt.Sprintf("%s %s %s: no stored price for %s at that time", t.Time.Format(time.RFC3339), t.Type, t.CoinID, t.CoinID))
+			continue
+		}
+		t.ID = t.deterministicID()
+		if err := store.PutTransaction(t); err != nil {
+			log.Printf("Error saving transaction: %v", err)
+			http.Error(w, "Failed to save transactions", http.StatusInternalServerError)
+			return
+		}
+		imported++
+	}
+	if rowErrs == nil {
+		rowErrs = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported": imported,
+		"errors":   rowErrs,
+	})
+}
+
+// listTransactions handles GET /portfolios/{id}/transactions.
+func listTransactions(w http.ResponseWriter, r *http.Request, p Portfolio) {
+	txs, err := store.Transactions(p.ID)
+	if err != nil {
+		log.Printf("Transaction query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	if txs == nil {
+		txs = []Transaction{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(txs)
+}
+
+// deleteTransaction handles DELETE /portfolios/{id}/transactions/{tx_id}.
+func deleteTransaction(w http.ResponseWriter, r *http.Request, p Portfolio) {
+	if err := store.DeleteTransaction(p.ID, mux.Vars(r)["tx_id"]); err != nil {
+		log.Printf("Error deleting transaction: %v", err)
+		http.Error(w, "Failed to delete transaction", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getGains handles GET /portfolios/{id}/gains?method=fifo&year=2024&format=json.
+// format=csv returns the realized lots as a tax-lot report.
+func getGains(w http.ResponseWriter, r *http.Request, p Portfolio) {
+	q := r.URL.Query()
+	method := q.Get("method")
+	if method == "" {
+		method = "fifo"
+	}
+	year := 0
+	if v := q.Get("year"); v != "" {
+		y, err := strconv.Atoi(v)
+		if err != nil || y < 2009 || y > 9999 {
+			http.Error(w, "Invalid year", http.StatusBadRequest)
+			return
+		}
+		year = y
+	}
+
+	txs, err := store.Transactions(p.ID)
+	if err != nil {
+		log.Printf("Transaction query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	rep, err := computeGains(txs, method, year)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rep.PortfolioID = p.ID
+
+	switch q.Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rep)
+	case "csv":
+		name := fmt.Sprintf("tax_lots_%s", method)
+		if year != 0 {
+			name += fmt.Sprintf("_%d", year)
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+		if err := writeTaxLotCSV(w, rep); err != nil {
+			log.Printf("Error writing tax-lot CSV: %v", err)
+		}
+	default:
+		http.Error(w, "Invalid format (use json or csv)", http.StatusBadRequest)
+	}
+}
+
+// writeTaxLotCSV writes one row per realized lot in the layout of a capital
+// gains schedule, followed by a totals row.
+func writeTaxLotCSV(w io.Writer, rep GainsReport) error {
+	cw := csv.NewWriter(w)
+	money := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+
+	cw.Write([]string{"Description", "Date Acquired", "Date Sold", "Proceeds (USD)", "Cost Basis (USD)", "Gain or Loss (USD)", "Term", "Transaction ID"})
+	for _, l := range rep.Realized {
+		cw.Write([]string{
+			fmt.Sprintf("%s %s", strconv.FormatFloat(l.Quantity, 'f', -1, 64), l.CoinID),
+			l.AcquiredAt.Format("2006-01-02"),
+			l.SoldAt.Format("2006-01-02"),
+			money(l.ProceedsUSD),
+			money(l.CostBasisUSD),
+			money(l.GainUSD),
+			l.Term,
+			l.TxID,
+		})
+	}
+	cw.Write([]string{"Total", "", "", money(rep.Totals.ProceedsUSD), money(rep.Totals.CostBasisUSD), money(rep.Totals.GainUSD), "", ""})
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ledgerFormat describes one exchange export. detect reports whether a
+// header row belongs to the format; parse turns a data row into zero or
+// more transactions (a Coinbase conversion is a sale and a buy).
+type ledgerFormat struct {
+	name   string
+	detect func(h csvHeader) bool
+	parse  func(h csvHeader, rec []string) ([]Transaction, error)
+}
+
+// ledgerFormats are tried in order when no format is given.
+var ledgerFormats = []ledgerFormat{
+	{"coinbase", func(h csvHeader) bool { return h.has("transaction type", "asset", "quantity transacted") }, parseCoinbaseRow},
+	{"binance", func(h csvHeader) bool { return h.has("pair", "side", "executed") }, parseBinanceRow},
+	{"kraken", func(h csvHeader) bool { return h.has("pair", "type", "vol", "cost") }, parseKrakenRow},
+	{"generic", func(h csvHeader) bool { return h.has("time", "type", "coin", "quantity") }, parseGenericRow},
+}
+
+// usdQuotes are the quote currencies treated as US dollars.
+var usdQuotes = []string{"USDT", "USDC", "BUSD", "FDUSD", "DAI", "ZUSD", "USD"}
+
+func isUSD(asset string) bool {
+	asset = strings.ToUpper(strings.TrimSpace(asset))
+	for _, q := range usdQuotes {
+		if asset == q {
+			return true
+		}
+	}
+	return false
+}
+
+// csvHeader maps lower-cased column names to their index.
+type csvHeader map[string]int
+
+func (h csvHeader) has(cols ...string) bool {
+	for _, c := range cols {
+		if _, ok := h[c]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the first of cols present in the row, trimmed.
+func (h csvHeader) get(rec []string, cols ...string) string {
+	for _, c := range cols {
+		if i, ok := h[c]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+	}
+	return ""
+}
+
+// errSkipRow marks rows that are valid but carry nothing for the ledger,
+// such as fiat deposits.
+var errSkipRow = errors.New("skip row")
+
+// parseLedgerCSV reads an exchange export. The header may follow a few
+// preamble lines, as in Coinbase reports. Rows that fail to parse are
+// reported by line number and left out; a file whose header matches no
+// format is an error.
+func parseLedgerCSV(r io.Reader, format string) ([]Transaction, []string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+
+	var f *ledgerFormat
+	var h csvHeader
+	headerAt := -1
+	for i := 0; i < len(records) && i < 10 && f == nil; i++ {
+		h = make(csvHeader)
+		for j, col := range records[i] {
+			h[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(col, "\ufeff")))] = j
+		}
+		for k := range ledgerFormats {
+			if ledgerFormats[k].detect(h) {
+				f = &ledgerFormats[k]
+				headerAt = i
+				break
+			}
+		}
+	}
+	if f == nil {
+		return nil, nil, errors.New("unrecognized CSV header (supported formats: coinbase, binance, kraken, generic)")
+	}
+	if format != "" && format != f.name {
+		return nil, nil, fmt.Errorf("header is a %s export, not %s", f.name, format)
+	}
+
+	var txs []Transaction
+	var rowErrs []string
+	for i := headerAt + 1; i < len(records); i++ {
+		rec := records[i]
+		if len(rec) == 0 || (len(rec) == 1 && strings.TrimSpace(rec[0]) == "") {
+			continue
+		}
+		parsed, err := f.parse(h, rec)
+		if err == errSkipRow {
+			continue
+		}
+		if err != nil {
+			rowErrs = append(rowErrs, fmt.Sprintf("line %d: %v", i+1, err))
+			continue
+		}
+		for _, t := range parsed {
+			t.Source = f.name
+			txs = append(txs, t)
+		}
+	}
+	return txs, rowErrs, nil
+}
+
+// deterministicID derives the transaction id from its content, so the same
+// row imported twice is stored once.
+func (t Transaction) deterministicID() string {
+	key := fmt.Sprintf("%s|%s|%s|%s|%d|%g|%g|%g", t.Source, t.ExternalID, t.Type, t.CoinID, t.Time.UnixNano(), t.Quantity, t.PriceUSD, t.FeeUSD)
+	if t.ExternalID != "" {
+		key = fmt.Sprintf("%s|%s|%s|%s", t.Source, t.ExternalID, t.Type, t.CoinID)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// resolveAsset maps an exchange ticker (BTC) or coin id to a registry coin.
+func resolveAsset(sym string) (string, error) {
+	sym = strings.TrimSpace(sym)
+	if c, ok := registry.Resolve(strings.ToLower(sym)); ok {
+		return c.ID, nil
+	}
+	for _, c := range registry.All() {
+		if strings.EqualFold(c.Symbol, sym) {
+			return c.ID, nil
+		}
+	}
+	return "", fmt.Errorf("unknown asset %q", sym)
+}
+
+var ledgerTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"01/02/2006 15:04:05",
+	"01/02/2006",
+}
+
+// parseLedgerTime accepts the timestamp layouts used by the supported
+// exports, plus Unix seconds. Times without a zone are UTC.
+func parseLedgerTime(s string) (time.Time, error) {
+	for _, layout := range ledgerTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && secs > 0 {
+		return time.Unix(0, int64(secs*1e9)).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// parseAmount parses a number that may carry a currency sign or thousands
+// separators ("$1,234.50"). Empty is zero.
+func parseAmount(s string) (float64, error) {
+	s = strings.NewReplacer("$", "", ",", "", " ", "").Replace(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return v, nil
+}
+
+// splitAmount splits a Binance amount such as "0.5BTC" into 0.5 and "BTC".
+func splitAmount(s string) (float64, string, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' && r != ',' && r != '-' })
+	if i < 0 {
+		v, err := parseAmount(s)
+		return v, "", err
+	}
+	v, err := parseAmount(s[:i])
+	return v, strings.ToUpper(s[i:]), err
+}
+
+// newTrade builds a checked transaction. price and fee are in USD; a zero
+// price is filled from stored prices on import.
+func newTrade(ts, kind, asset string, qty, price, fee float64) (Transaction, error) {
+	t, err := parseLedgerTime(ts)
+	if err != nil {
+		return Transaction{}, err
+	}
+	coinID, err := resolveAsset(asset)
+	if err != nil {
+		return Transaction{}, err
+	}
+	qty = math.Abs(qty)
+	if qty == 0 {
+		return Transaction{}, errors.New("quantity is zero")
+	}
+	if price < 0 || fee < 0 {
+		return Transaction{}, errors.New("price and fee must not be negative")
+	}
+	return Transaction{Time: t, Type: kind, CoinID: coinID, Quantity: qty, PriceUSD: price, FeeUSD: fee}, nil
+}
+
+var coinbaseConvert = regexp.MustCompile(`(?i)converted\s+([\d.,]+)\s+(\S+)\s+to\s+([\d.,]+)\s+(\S+)`)
+
+// parseCoinbaseRow reads a Coinbase transaction report row. Rewards and
+// receives count as transfers in at market value; a Convert row becomes a
+// sale of the source asset and a buy of the target with the same value.
+func parseCoinbaseRow(h csvHeader, rec []string) ([]Transaction, error) {
+	ts := h.get(rec, "timestamp")
+	asset := h.get(rec, "asset")
+	if isUSD(asset) {
+		return nil, errSkipRow
+	}
+	qty, err := parseAmount(h.get(rec, "quantity transacted"))
+	if err != nil {
+		return nil, err
+	}
+	price, err := parseAmount(h.get(rec, "spot price at transaction", "price at transaction"))
+	if err != nil {
+		return nil, err
+	}
+	fee, err := parseAmount(h.get(rec, "fees and/or spread", "fees"))
+	if err != nil {
+		return nil, err
+	}
+	fee = math.Abs(fee)
+	if cur := h.get(rec, "spot price currency", "price currency"); cur != "" && !isUSD(cur) {
+		// The price is filled from the stored USD price on import; the fee
+		// is valued the same way.
+		if fee, err = coinbaseFeeUSD(ts, asset, cur, fee, price); err != nil {
+			return nil, err
+		}
+		price = 0
+	}
+
+	var kind string
+	switch typ := strings.ToLower(h.get(rec, "transaction type")); typ {
+	case "buy", "advanced trade buy":
+		kind = TxBuy
+	case "sell", "advanced trade sell":
+		kind = TxSell
+	case "receive", "deposit", "rewards income", "staking income", "learning reward", "inflation reward", "coinbase earn":
+		kind = TxTransferIn
+	case "send", "withdrawal":
+		kind = TxTransferOut
+	case "convert":
+		return coinbaseConvertRow(h, rec, ts, asset, qty, price, fee)
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %q", typ)
+	}
+
+	t, err := newTrade(ts, kind, asset, qty, price, fee)
+	if err != nil {
+		return nil, err
+	}
+	t.ExternalID = h.get(rec, "id")
+	return []Transaction{t}, nil
+}
+
+// coinbaseFeeUSD values a fee quoted in cur, the currency of the row's
+// spot price: the fee is worth fee/spot units of the asset, which are
+// priced at the asset's stored USD price at the time.
+func coinbaseFeeUSD(ts, asset, cur string, fee, spot float64) (float64, error) {
+	if fee == 0 {
+		return 0, nil
+	}
+	if spot <= 0 {
+		return 0, fmt.Errorf("%s fee without a spot price to convert it", cur)
+	}
+	t, err := parseLedgerTime(ts)
+	if err != nil {
+		return 0, err
+	}
+	coinID, err := resolveAsset(asset)
+	if err != nil {
+		return 0, err
+	}
+	p, err := store.At(coinID, t)
+	if err != nil {
+		return 0, fmt.Errorf("no stored price to value the %s fee", cur)
+	}
+	return fee / spot * p.PriceUSD, nil
+}
+
+func coinbaseConvertRow(h csvHeader, rec []string, ts, asset string, qty, price, fee float64) ([]Transaction, error) {
+	m := coinbaseConvert.FindStringSubmatch(h.get(rec, "notes"))
+	if m == nil {
+		return nil, errors.New("convert row without a \"Converted X A to Y B\" note")
+	}
+	toQty, err := parseAmount(m[3])
+	if err != nil {
+		return nil, err
+	}
+
+	sell, err := newTrade(ts, TxSell, asset, qty, price, fee)
+	if err != nil {
+		return nil, err
+	}
+	buy, err := newTrade(ts, TxBuy, m[4], toQty, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if price > 0 {
+		// The target is bought with the sale's net proceeds.
+		buy.PriceUSD = (sell.Quantity*price - fee) / buy.Quantity
+	}
+	sell.ExternalID = h.get(rec, "id")
+	buy.ExternalID = sell.ExternalID
+	return []Transaction{sell, buy}, nil
+}
+
+// parseBinanceRow reads a Binance spot trade history row (Date(UTC), Pair,
+// Side, Price, Executed, Amount, Fee). Only pairs quoted in dollars or
+// dollar stablecoins are supported. A fee paid in the base asset reduces the
+// quantity bought; one paid in another asset (BNB) is valued at its stored
+// price.
+func parseBinanceRow(h csvHeader, rec []string) ([]Transaction, error) {
+	pair := strings.ToUpper(h.get(rec, "pair"))
+	var base, quote string
+	for _, q := range usdQuotes {
+		if strings.HasSuffix(pair, q) && len(pair) > len(q) {
+			base, quote = strings.TrimSuffix(pair, q), q
+			break
+		}
+	}
+	if base == "" {
+		return nil, fmt.Errorf("pair %q is not quoted in USD", pair)
+	}
+
+	var kind string
+	switch side := strings.ToUpper(h.get(rec, "side")); side {
+	case "BUY":
+		kind = TxBuy
+	case "SELL":
+		kind = TxSell
+	default:
+		return nil, fmt.Errorf("unsupported side %q", side)
+	}
+
+	ts := h.get(rec, "date(utc)", "date")
+	price, err := parseAmount(h.get(rec, "price"))
+	if err != nil {
+		return nil, err
+	}
+	qty, _, err := splitAmount(h.get(rec, "executed"))
+	if err != nil {
+		return nil, err
+	}
+	feeQty, feeAsset, err := splitAmount(h.get(rec, "fee"))
+	if err != nil {
+		return nil, err
+	}
+
+	var fee float64
+	switch {
+	case feeQty == 0 || feeAsset == quote || isUSD(feeAsset):
+		fee = feeQty
+	case feeAsset == base && kind == TxBuy:
+		qty -= feeQty
+	case feeAsset == base:
+		fee = feeQty * price
+	default:
+		t, err := parseLedgerTime(ts)
+		if err != nil {
+			return nil, err
+		}
+		coinID, err := resolveAsset(feeAsset)
+		if err != nil {
+			return nil, fmt.Errorf("fee: %v", err)
+		}
+		p, err := store.At(coinID, t)
+		if err != nil {
+			return nil, fmt.Errorf("no stored price to value the %s fee", feeAsset)
+		}
+		fee = feeQty * p.PriceUSD
+	}
+
+	t, err := newTrade(ts, kind, base, qty, price, fee)
+	if err != nil {
+		return nil, err
+	}
+	return []Transaction{t}, nil
+}
+
+// krakenPair normalizes Kraken's legacy pair names (XXBTZUSD) to the short
+// form used in the registry's provider ids (XBTUSD).
+func krakenPair(pair string) string {
+	pair = strings.ToUpper(pair)
+	if len(pair) == 8 && pair[0] == 'X' && pair[4] == 'Z' {
+		return pair[1:4] + pair[5:]
+	}
+	return pair
+}
+
+// parseKrakenRow reads a Kraken trades export row. The pair is matched
+// against each coin's kraken provider id, so only USD pairs resolve.
+func parseKrakenRow(h csvHeader, rec []string) ([]Transaction, error) {
+	pair := krakenPair(h.get(rec, "pair"))
+	if !strings.HasSuffix(pair, "USD") {
+		return nil, fmt.Errorf("pair %q is not quoted in USD", pair)
+	}
+	var coinID string
+	for _, c := range registry.All() {
+		if c.ProviderID("kraken", c.Symbol+"USD") == pair {
+			coinID = c.ID
+			break
+		}
+	}
+	if coinID == "" {
+		return nil, fmt.Errorf("unknown pair %q", pair)
+	}
+
+	var kind string
+	switch typ := strings.ToLower(h.get(rec, "type")); typ {
+	case "buy":
+		kind = TxBuy
+	case "sell":
+		kind = TxSell
+	default:
+		return nil, fmt.Errorf("unsupported type %q", typ)
+	}
+
+	price, err := parseAmount(h.get(rec, "price"))
+	if err != nil {
+		return nil, err
+	}
+	qty, err := parseAmount(h.get(rec, "vol"))
+	if err != nil {
+		return nil, err
+	}
+	fee, err := parseAmount(h.get(rec, "fee"))
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := newTrade(h.get(rec, "time"), kind, coinID, qty, price, fee)
+	if err != nil {
+		return nil, err
+	}
+	t.ExternalID = h.get(rec, "txid")
+	return []Transaction{t}, nil
+}
+
+// parseGenericRow reads the project's own layout:
+// time,type,coin,quantity[,price_usd][,fee_usd][,id], where type is buy,
+// sell, transfer_in or transfer_out and coin is an id, alias or ticker.
+func parseGenericRow(h csvHeader, rec []string) ([]Transaction, error) {
+	kind := strings.ToLower(h.get(rec, "type"))
+	switch kind {
+	case TxBuy, TxSell, TxTransferIn, TxTransferOut:
+	case "receive", "deposit":
+		kind = TxTransferIn
+	case "send", "withdrawal":
+		kind = TxTransferOut
+	default:
+		return nil, fmt.Errorf("unsupported type %q", kind)
+	}
+
+	qty, err := parseAmount(h.get(rec, "quantity"))
+	if err != nil {
+		return nil, err
+	}
+	price, err := parseAmount(h.get(rec, "price_usd", "price"))
+	if err != nil {
+		return nil, err
+	}
+	fee, err := parseAmount(h.get(rec, "fee_usd", "fee"))
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := newTrade(h.get(rec, "time"), kind, h.get(rec, "coin"), qty, price, fee)
+	if err != nil {
+		return nil, err
+	}
+	t.ExternalID = h.get(rec, "id")
+	return []Transaction{t}, nil
+}
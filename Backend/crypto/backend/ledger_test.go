@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeGains(t *testing.T) {
+	jan23 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	jun24 := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	jul24 := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	txs := []Transaction{
+		{ID: "b1", Time: jan23, Type: TxBuy, CoinID: "bitcoin", Quantity: 1, PriceUSD: 90, FeeUSD: 10},
+		{ID: "b2", Time: jun24, Type: TxBuy, CoinID: "bitcoin", Quantity: 1, PriceUSD: 200},
+		// 450 less the fee: 280 for the first unit, 140 for the half.
+		{ID: "s1", Time: jul24, Type: TxSell, CoinID: "bitcoin", Quantity: 1.5, PriceUSD: 300, FeeUSD: 30},
+	}
+
+	type lot struct {
+		qty, cost float64
+		acquired  time.Time
+		term      string
+	}
+	tests := []struct {
+		method     string
+		realized   []lot
+		gain, long float64
+		open       lot
+	}{
+		{"fifo", []lot{{1, 100, jan23, "long"}, {0.5, 100, jun24, "short"}}, 220, 180, lot{0.5, 100, jun24, ""}},
+		{"lifo", []lot{{1, 200, jun24, "short"}, {0.5, 50, jan23, "long"}}, 170, 90, lot{0.5, 50, jan23, ""}},
+		// 150 a unit across the pool, oldest lot first.
+		{"average", []lot{{1, 150, jan23, "long"}, {0.5, 75, jun24, "short"}}, 195, 130, lot{0.5, 75, jun24, ""}},
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	for _, tt := range tests {
+		rep, err := computeGains(txs, tt.method, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rep.Realized) != len(tt.realized) {
+			t.Errorf("%s: realized %+v", tt.method, rep.Realized)
+			continue
+		}
+		for i, r := range rep.Realized {
+			w := tt.realized[i]
+			if !near(r.Quantity, w.qty) || !near(r.CostBasisUSD, w.cost) || !r.AcquiredAt.Equal(w.acquired) || r.Term != w.term || r.TxID != "s1" {
+				t.Errorf("%s: lot %d = %+v, want %+v", tt.method, i, r, w)
+			}
+		}
+		if !near(rep.Totals.ProceedsUSD, 420) || !near(rep.Totals.GainUSD, tt.gain) ||
+			!near(rep.Totals.LongTermUSD, tt.long) || !near(rep.Totals.ShortTermUSD, tt.gain-tt.long) {
+			t.Errorf("%s: totals %+v", tt.method, rep.Totals)
+		}
+		if len(rep.OpenLots) != 1 || !near(rep.OpenLots[0].Quantity, tt.open.qty) ||
+			!near(rep.OpenLots[0].CostBasisUSD, tt.open.cost) || !rep.OpenLots[0].AcquiredAt.Equal(tt.open.acquired) {
+			t.Errorf("%s: open lots %+v", tt.method, rep.OpenLots)
+		}
+	}
+
+	if _, err := computeGains(txs, "hifo", 0); err == nil {
+		t.Error("unknown method accepted")
+	}
+	if rep, _ := computeGains(txs, "fifo", 2023); len(rep.Realized) != 0 || len(rep.OpenLots) != 1 {
+		t.Errorf("2023 report = %+v", rep)
+	}
+}
+
+func TestComputeGainsTransfersAndShortSales(t *testing.T) {
+	at := func(day int) time.Time { return time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC) }
+	txs := []Transaction{
+		{Time: at(1), Type: TxTransferIn, CoinID: "ethereum", Quantity: 2, PriceUSD: 1000, FeeUSD: 5},
+		// Moving coins out consumes lots without realizing anything.
+		{Time: at(2), Type: TxTransferOut, CoinID: "ethereum", Quantity: 1, PriceUSD: 1100},
+		{Time: at(3), Type: TxSell, CoinID: "ethereum", Quantity: 2, PriceUSD: 1200},
+	}
+	rep, err := computeGains(txs, "fifo", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The transfer fee is not part of the cost; the unit sold beyond the
+	// holdings has none.
+	if len(rep.Realized) != 2 || rep.Realized[0].CostBasisUSD != 1000 || rep.Realized[1].CostBasisUSD != 0 {
+		t.Errorf("realized %+v", rep.Realized)
+	}
+	if rep.Totals.GainUSD != 1400 || len(rep.OpenLots) != 0 {
+		t.Errorf("totals %+v, open %+v", rep.Totals, rep.OpenLots)
+	}
+	if len(rep.Warnings) != 1 || !strings.Contains(rep.Warnings[0], "exceeds holdings by 1") {
+		t.Errorf("warnings %q", rep.Warnings)
+	}
+}
+
+func TestParseLedgerCSV(t *testing.T) {
+	s := useMemoryStore(t)
+	s.Insert(PriceData{CoinID: "ethereum", Timestamp: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), PriceUSD: 3000})
+
+	tests := []struct {
+		name, csv string
+		want      []Transaction
+	}{
+		{"binance", "Date(UTC),Pair,Side,Price,Executed,Amount,Fee\n" +
+			"2024-03-01 10:00:00,BTCUSDT,BUY,60000,0.5BTC,30000USDT,0.001BTC\n" +
+			"2024-03-02 10:00:00,ETHUSDT,SELL,3100,2ETH,6200USDT,0.01ETH\n" +
+			"2024-03-02 11:00:00,SOLBTC,BUY,0.002,10SOL,0.02BTC,0\n",
+			[]Transaction{
+				// A fee in the bought coin comes off the quantity.
+				{Type: TxBuy, CoinID: "bitcoin", Quantity: 0.499, PriceUSD: 60000},
+				{Type: TxSell, CoinID: "ethereum", Quantity: 2, PriceUSD: 3100, FeeUSD: 31},
+			}},
+		{"kraken", `"txid","ordertxid","pair","time","type","ordertype","price","cost","fee","vol"` + "\n" +
+			`"T1","O1","XXBTZUSD","2024-03-01 10:00:00.1234","buy","limit","60000","6000","9.6","0.1"` + "\n",
+			[]Transaction{{Type: TxBuy, CoinID: "bitcoin", Quantity: 0.1, PriceUSD: 60000, FeeUSD: 9.6, ExternalID: "T1"}}},
+		{"generic", "time,type,coin,quantity,price_usd\n" +
+			"2024-03-01T12:00:00Z,deposit,ETH,1,\n",
+			[]Transaction{{Type: TxTransferIn, CoinID: "ethereum", Quantity: 1}}},
+	}
+	for _, tt := range tests {
+		txs, rowErrs, err := parseLedgerCSV(strings.NewReader(tt.csv), "")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(txs) != len(tt.want) {
+			t.Errorf("%s: %+v (row errors %q)", tt.name, txs, rowErrs)
+			continue
+		}
+		for i, tx := range txs {
+			w := tt.want[i]
+			if tx.Source != tt.name || tx.Type != w.Type || tx.CoinID != w.CoinID || math.Abs(tx.Quantity-w.Quantity) > 1e-12 ||
+				tx.PriceUSD != w.PriceUSD || math.Abs(tx.FeeUSD-w.FeeUSD) > 1e-9 || tx.ExternalID != w.ExternalID {
+				t.Errorf("%s: row %d = %+v, want %+v", tt.name, i, tx, w)
+			}
+		}
+	}
+
+	// The price missing from the deposit is the stored one at that time.
+	txs, _, _ := parseLedgerCSV(strings.NewReader(tests[2].csv), "generic")
+	if !fillPrice(&txs[0]) || txs[0].PriceUSD != 3000 {
+		t.Errorf("filled price %v", txs[0].PriceUSD)
+	}
+	if _, _, err := parseLedgerCSV(strings.NewReader(tests[2].csv), "kraken"); err == nil {
+		t.Error("a generic file was accepted as kraken")
+	}
+	if _, _, err := parseLedgerCSV(strings.NewReader("a,b,c\n1,2,3\n"), ""); err == nil {
+		t.Error("unknown header accepted")
+	}
+}
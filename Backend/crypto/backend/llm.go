@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// LLMRequest is one completion: an optional system message and a prompt.
+// Feature names what the call is for, for cost accounting.
+type LLMRequest struct {
+	Feature string
+	System  string
+	Prompt  string
+	// MaxTokens caps the reply; zero means the configured limit.
+	MaxTokens int
+}
+
+// LLMResponse is a completion and the tokens it used.
+type LLMResponse struct {
+	Text             string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// LLMClient is what /ask and the reports use to reach a language model.
+type LLMClient interface {
+	Complete(ctx context.Context, req LLMRequest) (LLMResponse, error)
+}
+
+// ErrLLMBudget is returned once the daily token budget has been spent.
+var ErrLLMBudget = errors.New("daily LLM token budget exhausted")
+
+// llm is the client shared by every handler and job in the process.
+var llm LLMClient
+
+// LLMConfig is how the process talks to its language model.
+type LLMConfig struct {
+	Provider   string // "openai", "local" or "fake"
+	Model      string
+	BaseURL    string
+	APIKey     string
+	Timeout    time.Duration
+	MaxRetries int
+	// MaxTokens caps every reply; DailyTokens caps prompt plus reply
+	// tokens per UTC day, zero meaning unlimited.
+	MaxTokens   int
+	DailyTokens int
+	// CacheTTL is how long replies are reused for identical prompts, zero
+	// turning the cache off. Price overrides the list price used for cost.
+	CacheTTL time.Duration
+	Price    *llmPrice
+}
+
+// configuredLLM reads LLM_PROVIDER and the LLM_* settings around it.
+func configuredLLM() (LLMClient, error) {
+	cfg := LLMConfig{
+		Provider:   "openai",
+		Model:      "gpt-4o-mini",
+		APIKey:     os.Getenv("OPENAI_API_KEY"),
+		Timeout:    30 * time.Second,
+		MaxRetries: 2,
+		MaxTokens:  512,
+		CacheTTL:   6 * time.Hour,
+	}
+	if p := os.Getenv("LLM_PROVIDER"); p != "" {
+		cfg.Provider = p
+	}
+	if cfg.Provider == "local" {
+		cfg.Model = ""
+		cfg.APIKey = os.Getenv("LLM_API_KEY")
+	}
+	if m := os.Getenv("LLM_MODEL"); m != "" {
+		cfg.Model = m
+	}
+	cfg.BaseURL = os.Getenv("LLM_BASE_URL")
+	if v := os.Getenv("LLM_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid LLM_TIMEOUT %q", v)
+		}
+		cfg.Timeout = d
+	}
+	if v := os.Getenv("LLM_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid LLM_CACHE_TTL %q", v)
+		}
+		cfg.CacheTTL = d
+	}
+	if v := os.Getenv("LLM_PRICE_PER_MTOK"); v != "" {
+		p, err := parseLLMPrice(v)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Price = p
+	}
+	for _, setting := range []struct {
+		env string
+		dst *int
+	}{
+		{"LLM_MAX_RETRIES", &cfg.MaxRetries},
+		{"LLM_MAX_TOKENS", &cfg.MaxTokens},
+		{"LLM_DAILY_TOKENS", &cfg.DailyTokens},
+	} {
+		if v := os.Getenv(setting.env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", setting.env, v)
+			}
+			*setting.dst = n
+		}
+	}
+	return newLLMClient(cfg)
+}
+
+// newLLMClient builds the client cfg describes, wrapped in retries and the
+// token budget, behind the response cache and usage records.
+func newLLMClient(cfg LLMConfig) (LLMClient, error) {
+	var client LLMClient
+	switch cfg.Provider {
+	case "openai":
+		client = newOpenAIClient(cfg)
+	case "local":
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("LLM_PROVIDER=local needs LLM_BASE_URL, e.g. http://localhost:11434/v1")
+		}
+		if cfg.Model == "" {
+			return nil, fmt.Errorf("LLM_PROVIDER=local needs LLM_MODEL")
+		}
+		client = newOpenAIClient(cfg)
+	case "fake":
+		client = &fakeLLM{Reply: os.Getenv("LLM_FAKE_REPLY")}
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q", cfg.Provider)
+	}
+	model := cfg.Model
+	if cfg.Provider == "fake" {
+		model = "fake"
+	}
+	return &meteredLLM{
+		next:  &managedLLM{next: client, cfg: cfg, now: time.Now, after: time.After},
+		model: model,
+		ttl:   cfg.CacheTTL,
+		price: cfg.Price,
+		free:  cfg.Provider != "openai",
+		cache: make(map[string]llmCacheEntry),
+		now:   time.Now,
+	}, nil
+}
+
+// openAIClient talks to the OpenAI chat completions API, or to any server
+// that speaks it, such as Ollama or the llama.cpp server.
+type openAIClient struct {
+	client *openai.Client
+	model  string
+	keyed  bool
+}
+
+func newOpenAIClient(cfg LLMConfig) *openAIClient {
+	oc := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		oc.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	}
+	return &openAIClient{
+		client: openai.NewClientWithConfig(oc),
+		model:  cfg.Model,
+		keyed:  cfg.APIKey != "" || cfg.Provider == "local",
+	}
+}
+
+func (c *openAIClient) Complete(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	if !c.keyed {
+		return LLMResponse{}, fmt.Errorf("OPENAI_API_KEY not set in environment")
+	}
+	var messages []openai.ChatCompletionMessage
+	if req.System != "" {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: req.System})
+	}
+	messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: req.Prompt})
+
+	resp, err := c.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:     c.model,
+		Messages:  messages,
+		MaxTokens: req.MaxTokens,
+	})
+	if err != nil {
+		return LLMResponse{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return LLMResponse{}, fmt.Errorf("no choices in completion response")
+	}
+	return LLMResponse{
+		Text:             resp.Choices[0].Message.Content,
+		Model:            resp.Model,
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+	}, nil
+}
+
+// fakeLLM answers without a model. Reply, when set, is returned for every
+// prompt; otherwise the reply is a fixed sentence naming a hash of the
+// prompt, so the same prompt always gets the same answer. Errs fail the
+// first calls, one error each.
+type fakeLLM struct {
+	Reply string
+	Errs  []error
+
+	mu    sync.Mutex
+	Calls []LLMRequest
+}
+
+func (f *fakeLLM) Complete(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	f.mu.Lock()
+	f.Calls = append(f.Calls, req)
+	var err error
+	if len(f.Errs) > 0 {
+		err, f.Errs = f.Errs[0], f.Errs[1:]
+	}
+	f.mu.Unlock()
+	if err != nil {
+		return LLMResponse{}, err
+	}
+
+	text := f.Reply
+	if text == "" {
+		sum := sha256.Sum256([]byte(req.System + "\n" + req.Prompt))
+		text = "Generated without a language model (prompt " + hex.EncodeToString(sum[:4]) + ")."
+	}
+	return LLMResponse{
+		Text:             text,
+		Model:            "fake",
+		PromptTokens:     estimateTokens(req.System + req.Prompt),
+		CompletionTokens: estimateTokens(text),
+	}, nil
+}
+
+// estimateTokens approximates a token count at four characters a token.
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// managedLLM applies the configured model limits around a client: the
+// reply cap, a timeout per attempt, retries with backoff on rate limits
+// and server errors, and the daily token budget.
+type managedLLM struct {
+	next LLMClient
+	cfg  LLMConfig
+
+	// now and after are the clock, replaced in tests.
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+
+	mu    sync.Mutex
+	day   string
+	spent int
+}
+
+func (m *managedLLM) Complete(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	if m.cfg.MaxTokens > 0 && (req.MaxTokens == 0 || req.MaxTokens > m.cfg.MaxTokens) {
+		req.MaxTokens = m.cfg.MaxTokens
+	}
+	// The worst case is set aside before the call, so concurrent calls
+	// can't all pass the check, and trued up to the real usage after it.
+	estimate := estimateTokens(req.System+req.Prompt) + req.MaxTokens
+	day, ok := m.reserve(estimate)
+	if !ok {
+		return LLMResponse{}, ErrLLMBudget
+	}
+	used := 0
+	defer func() { m.settle(day, estimate, used) }()
+
+	var resp LLMResponse
+	var err error
+	for attempt := 0; ; attempt++ {
+		actx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+		resp, err = m.next.Complete(actx, req)
+		cancel()
+		if err == nil || attempt >= m.cfg.MaxRetries || !retryableLLMError(err) || ctx.Err() != nil {
+			break
+		}
+		backoff := time.Duration(1<<attempt) * 500 * time.Millisecond
+		log.Printf("llm: attempt %d failed, retrying in %s: %v", attempt+1, backoff, err)
+		select {
+		case <-m.after(backoff):
+		case <-ctx.Done():
+			return LLMResponse{}, ctx.Err()
+		}
+	}
+	if err != nil {
+		return LLMResponse{}, err
+	}
+	used = resp.PromptTokens + resp.CompletionTokens
+	return resp, nil
+}
+
+// reserve sets tokens aside from today's budget and returns the day they
+// were taken from, or false when they don't fit in what is left.
+func (m *managedLLM) reserve(tokens int) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover()
+	if m.cfg.DailyTokens > 0 && m.spent+tokens > m.cfg.DailyTokens {
+		return m.day, false
+	}
+	m.spent += tokens
+	return m.day, true
+}
+
+// settle replaces a reservation made on day with the tokens the call
+// actually used, none when it failed. A reservation from before midnight
+// went with the old day's budget.
+func (m *managedLLM) settle(day string, reserved, used int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover()
+	if m.day == day {
+		m.spent += used - reserved
+	}
+}
+
+// rollover resets the budget at UTC midnight. m.mu must be held.
+func (m *managedLLM) rollover() {
+	if today := m.now().UTC().Format("2006-01-02"); today != m.day {
+		m.day, m.spent = today, 0
+	}
+}
+
+// retryableLLMError reports whether err is worth another attempt: rate
+// limits, server errors, timeouts and network failures.
+func retryableLLMError(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode == http.StatusTooManyRequests || apiErr.HTTPStatusCode >= 500
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode == http.StatusTooManyRequests || reqErr.HTTPStatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr)
+}
+
+// complete runs prompt for feature through the shared client with the
+// default limits.
+func complete(ctx context.Context, feature, system, prompt string) (string, error) {
+	if llm == nil {
+		return "", fmt.Errorf("no language model configured")
+	}
+	resp, err := llm.Complete(ctx, LLMRequest{Feature: feature, System: system, Prompt: prompt})
+	if err != nil {
+		return "", err
+	}
+	return resp.Text, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// testManagedLLM wraps f in a managedLLM on a clock the test moves by hand.
+// Backoffs are recorded in the returned slice instead of waited out.
+func testManagedLLM(f *fakeLLM, cfg LLMConfig) (*managedLLM, *time.Time, *[]time.Duration) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	var waits []time.Duration
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Minute
+	}
+	m := &managedLLM{next: f, cfg: cfg, now: func() time.Time { return now }}
+	m.after = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		ch := make(chan time.Time, 1)
+		ch <- now
+		return ch
+	}
+	return m, &now, &waits
+}
+
+func TestManagedLLMRetries(t *testing.T) {
+	status := func(code int) error { return &openai.APIError{HTTPStatusCode: code, Message: http.StatusText(code)} }
+
+	tests := []struct {
+		name      string
+		errs      []error
+		retries   int
+		wantCalls int
+		wantErr   bool
+		wantWaits []time.Duration
+	}{
+		{"first try", nil, 2, 1, false, nil},
+		{"rate limited then served", []error{status(429), status(500)}, 2, 3, false, []time.Duration{500 * time.Millisecond, time.Second}},
+		{"retries exhausted", []error{status(503), status(503), status(503)}, 2, 3, true, []time.Duration{500 * time.Millisecond, time.Second}},
+		{"client error", []error{status(400)}, 2, 1, true, nil},
+		{"no retries", []error{status(429)}, 0, 1, true, nil},
+		{"timeout", []error{context.DeadlineExceeded}, 1, 2, false, []time.Duration{500 * time.Millisecond}},
+		{"request error", []error{&openai.RequestError{HTTPStatusCode: 502, Err: errors.New("bad gateway")}}, 1, 2, false, []time.Duration{500 * time.Millisecond}},
+		{"other error", []error{errors.New("invalid model")}, 2, 1, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeLLM{Reply: "ok", Errs: tt.errs}
+			m, _, waits := testManagedLLM(f, LLMConfig{MaxRetries: tt.retries})
+			resp, err := m.Complete(context.Background(), LLMRequest{Prompt: "hello"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && resp.Text != "ok" {
+				t.Errorf("reply = %q", resp.Text)
+			}
+			if len(f.Calls) != tt.wantCalls {
+				t.Errorf("%d calls, want %d", len(f.Calls), tt.wantCalls)
+			}
+			if !reflect.DeepEqual(*waits, tt.wantWaits) {
+				t.Errorf("backoffs = %v, want %v", *waits, tt.wantWaits)
+			}
+		})
+	}
+}
+
+func TestManagedLLMCancelledDuringBackoff(t *testing.T) {
+	f := &fakeLLM{Errs: []error{&openai.APIError{HTTPStatusCode: 429}}}
+	m, _, _ := testManagedLLM(f, LLMConfig{MaxRetries: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	m.after = func(time.Duration) <-chan time.Time {
+		cancel()
+		return nil
+	}
+	if _, err := m.Complete(ctx, LLMRequest{Prompt: "hello"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want the context's error", err)
+	}
+	if len(f.Calls) != 1 {
+		t.Errorf("%d calls after cancelling, want 1", len(f.Calls))
+	}
+	if m.spent != 0 {
+		t.Errorf("a cancelled call kept %d tokens reserved", m.spent)
+	}
+}
+
+func TestManagedLLMMaxTokens(t *testing.T) {
+	f := &fakeLLM{}
+	m, _, _ := testManagedLLM(f, LLMConfig{MaxTokens: 200})
+	for _, n := range []int{0, 100, 500} {
+		if _, err := m.Complete(context.Background(), LLMRequest{Prompt: "hello", MaxTokens: n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []int
+	for _, c := range f.Calls {
+		got = append(got, c.MaxTokens)
+	}
+	if want := []int{200, 100, 200}; !reflect.DeepEqual(got, want) {
+		t.Errorf("max tokens sent = %v, want %v", got, want)
+	}
+}
+
+func TestManagedLLMDailyBudget(t *testing.T) {
+	// Each call reserves 10 prompt tokens plus the 10 token reply cap and
+	// spends 11: the prompt and a one-token reply.
+	f := &fakeLLM{Reply: "ok"}
+	m, now, _ := testManagedLLM(f, LLMConfig{MaxTokens: 10, DailyTokens: 40})
+	req := LLMRequest{Prompt: strings.Repeat("p", 40)}
+
+	for i := 0; i < 2; i++ {
+		if _, err := m.Complete(context.Background(), req); err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+	}
+	if _, err := m.Complete(context.Background(), req); err != ErrLLMBudget {
+		t.Fatalf("third call: got %v, want ErrLLMBudget", err)
+	}
+	if len(f.Calls) != 2 {
+		t.Errorf("%d calls reached the model, want 2", len(f.Calls))
+	}
+
+	*now = time.Date(2025, 1, 1, 23, 59, 59, 0, time.UTC)
+	if _, err := m.Complete(context.Background(), req); err != ErrLLMBudget {
+		t.Fatalf("before midnight: got %v, want ErrLLMBudget", err)
+	}
+	*now = time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	if _, err := m.Complete(context.Background(), req); err != nil {
+		t.Fatalf("after midnight: %v", err)
+	}
+	if m.day != "2025-01-02" || m.spent != 11 {
+		t.Errorf("after rollover day=%s spent=%d, want 2025-01-02 and 11", m.day, m.spent)
+	}
+
+	// A failed call spends nothing.
+	f.Errs = []error{errors.New("invalid model")}
+	m.Complete(context.Background(), req)
+	if m.spent != 11 {
+		t.Errorf("a failed call spent %d tokens", m.spent-11)
+	}
+}
+
+// llmFunc adapts a function to LLMClient.
+type llmFunc func(ctx context.Context, req LLMRequest) (LLMResponse, error)
+
+func (f llmFunc) Complete(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	return f(ctx, req)
+}
+
+func TestManagedLLMReservesBudget(t *testing.T) {
+	// Each call sets 20 tokens aside, so a second call made while the
+	// first is in flight does not fit in 35. Once the first has spent its
+	// 11 there is room again.
+	f := &fakeLLM{Reply: "ok"}
+	m, _, _ := testManagedLLM(f, LLMConfig{MaxTokens: 10, DailyTokens: 35})
+	req := LLMRequest{Prompt: strings.Repeat("p", 40)}
+
+	var inFlight int
+	var concurrent error
+	m.next = llmFunc(func(ctx context.Context, r LLMRequest) (LLMResponse, error) {
+		inFlight = m.spent
+		_, concurrent = m.Complete(ctx, req)
+		return f.Complete(ctx, r)
+	})
+	if _, err := m.Complete(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if inFlight != 20 || concurrent != ErrLLMBudget {
+		t.Errorf("during the call: spent %d, second call got %v; want 20 and ErrLLMBudget", inFlight, concurrent)
+	}
+	if m.spent != 11 {
+		t.Errorf("after the call: spent %d, want 11", m.spent)
+	}
+
+	m.next = f
+	if _, err := m.Complete(context.Background(), req); err != nil || m.spent != 22 {
+		t.Errorf("after settling: got %v with %d spent, want a reply and 22", err, m.spent)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Features an LLM call is accounted to.
+const (
+	FeatureAskIntent     = "ask.intent"
+	FeatureAskCQL        = "ask.cql"
+	FeatureAskAnswer     = "ask.answer"
+	FeatureReportSection = "report.section"
+	FeatureReportChart   = "report.chart"
+)
+
+// llmCacheMaxEntries bounds the response cache.
+const llmCacheMaxEntries = 2000
+
+// LLMUsage is the record kept for one completion. Cached calls were
+// answered from the response cache and cost nothing.
+type LLMUsage struct {
+	ID               string    `json:"id"`
+	Time             time.Time `json:"time"`
+	Feature          string    `json:"feature"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	CostUSD          float64   `json:"cost_usd"`
+	Cached           bool      `json:"cached"`
+}
+
+// LLMUsageStore keeps usage records by UTC day.
+type LLMUsageStore interface {
+	// LLMUsage returns the records for day (YYYY-MM-DD), oldest first.
+	LLMUsage(day string) ([]LLMUsage, error)
+	// PutLLMUsage stores one record.
+	PutLLMUsage(u LLMUsage) error
+}
+
+// llmPrice is a model's list price in USD per million tokens.
+type llmPrice struct {
+	Input  float64
+	Output float64
+}
+
+// llmPrices are OpenAI's list prices. A model is priced by the longest
+// entry its name starts with, so dated snapshots such as
+// gpt-4o-mini-2024-07-18 match; unknown models are free.
+var llmPrices = map[string]llmPrice{
+	"gpt-4o-mini":  {0.15, 0.60},
+	"gpt-4o":       {2.50, 10.00},
+	"gpt-4.1-nano": {0.10, 0.40},
+	"gpt-4.1-mini": {0.40, 1.60},
+	"gpt-4.1":      {2.00, 8.00},
+}
+
+func lookupLLMPrice(model string) llmPrice {
+	var best string
+	for prefix := range llmPrices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	return llmPrices[best]
+}
+
+// parseLLMPrice reads LLM_PRICE_PER_MTOK, "input,output" in USD per
+// million tokens.
+func parseLLMPrice(v string) (*llmPrice, error) {
+	in, out, ok := strings.Cut(v, ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid LLM_PRICE_PER_MTOK %q, want input,output", v)
+	}
+	var p llmPrice
+	var err error
+	if p.Input, err = strconv.ParseFloat(strings.TrimSpace(in), 64); err != nil || p.Input < 0 {
+		return nil, fmt.Errorf("invalid LLM_PRICE_PER_MTOK %q", v)
+	}
+	if p.Output, err = strconv.ParseFloat(strings.TrimSpace(out), 64); err != nil || p.Output < 0 {
+		return nil, fmt.Errorf("invalid LLM_PRICE_PER_MTOK %q", v)
+	}
+	return &p, nil
+}
+
+type llmCacheEntry struct {
+	resp    LLMResponse
+	expires time.Time
+}
+
+// meteredLLM answers repeated prompts from a cache and records every call
+// with its estimated cost.
+type meteredLLM struct {
+	next  LLMClient
+	model string
+	ttl   time.Duration
+	// price overrides the list price; local and fake models cost nothing
+	// unless it is set.
+	price *llmPrice
+	free  bool
+
+	mu    sync.Mutex
+	cache map[string]llmCacheEntry
+	now   func() time.Time
+}
+
+func (m *meteredLLM) Complete(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	key := m.cacheKey(req)
+	if resp, ok := m.cached(key); ok {
+		m.record(req.Feature, LLMResponse{Model: resp.Model}, true)
+		return resp, nil
+	}
+
+	resp, err := m.next.Complete(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	if m.ttl > 0 {
+		m.mu.Lock()
+		if len(m.cache) >= llmCacheMaxEntries {
+			m.evict()
+		}
+		m.cache[key] = llmCacheEntry{resp: resp, expires: m.now().Add(m.ttl)}
+		m.mu.Unlock()
+	}
+	m.record(req.Feature, resp, false)
+	return resp, nil
+}
+
+// cacheKey hashes everything that shapes the reply.
+func (m *meteredLLM) cacheKey(req LLMRequest) string {
+	sum := sha256.Sum256([]byte(m.model + "\x00" + strconv.Itoa(req.MaxTokens) + "\x00" + req.System + "\x00" + req.Prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *meteredLLM) cached(key string) (LLMResponse, bool) {
+	if m.ttl <= 0 {
+		return LLMResponse{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.cache[key]
+	if !ok {
+		return LLMResponse{}, false
+	}
+	if m.now().After(e.expires) {
+		delete(m.cache, key)
+		return LLMResponse{}, false
+	}
+	return e.resp, true
+}
+
+// evict makes room in a full cache: expired entries go first, then the
+// ones closest to expiring. m.mu must be held.
+func (m *meteredLLM) evict() {
+	now := m.now()
+	for k, e := range m.cache {
+		if now.After(e.expires) {
+			delete(m.cache, k)
+		}
+	}
+	if len(m.cache) < llmCacheMaxEntries {
+		return
+	}
+	keys := make([]string, 0, len(m.cache))
+	for k := range m.cache {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return m.cache[keys[i]].expires.Before(m.cache[keys[j]].expires) })
+	for _, k := range keys[:len(keys)/10+1] {
+		delete(m.cache, k)
+	}
+}
+
+// cost estimates what resp cost.
+func (m *meteredLLM) cost(resp LLMResponse) float64 {
+	var p llmPrice
+	switch {
+	case m.price != nil:
+		p = *m.price
+	case m.free:
+		return 0
+	default:
+		model := resp.Model
+		if model == "" {
+			model = m.model
+		}
+		p = lookupLLMPrice(model)
+	}
+	return (float64(resp.PromptTokens)*p.Input + float64(resp.CompletionTokens)*p.Output) / 1e6
+}
+
+// record stores the usage of one call. A failure to record is logged and
+// never fails the call.
+func (m *meteredLLM) record(feature string, resp LLMResponse, cached bool) {
+	if store == nil {
+		return
+	}
+	if feature == "" {
+		feature = "other"
+	}
+	model := resp.Model
+	if model == "" {
+		model = m.model
+	}
+	u := LLMUsage{
+		ID:               randomHex(8),
+		Time:             m.now().UTC(),
+		Feature:          feature,
+		Model:            model,
+		PromptTokens:     resp.PromptTokens,
+		CompletionTokens: resp.CompletionTokens,
+		CostUSD:          m.cost(resp),
+		Cached:           cached,
+	}
+	if err := store.PutLLMUsage(u); err != nil {
+		log.Printf("llm: recording usage for %s: %v", feature, err)
+	}
+}
+
+// llmFeatureSpend is one feature's usage on one day.
+type llmFeatureSpend struct {
+	Feature          string  `json:"feature"`
+	Calls            int     `json:"calls"`
+	CachedCalls      int     `json:"cached_calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// llmDaySpend is the usage on one UTC day.
+type llmDaySpend struct {
+	Day      string            `json:"day"`
+	Calls    int               `json:"calls"`
+	CostUSD  float64           `json:"cost_usd"`
+	Features []llmFeatureSpend `json:"features"`
+}
+
+func summarizeLLMUsage(day string, usage []LLMUsage) llmDaySpend {
+	byFeature := make(map[string]*llmFeatureSpend)
+	out := llmDaySpend{Day: day, Features: []llmFeatureSpend{}}
+	for _, u := range usage {
+		f, ok := byFeature[u.Feature]
+		if !ok {
+			f = &llmFeatureSpend{Feature: u.Feature}
+			byFeature[u.Feature] = f
+		}
+		f.Calls++
+		if u.Cached {
+			f.CachedCalls++
+		}
+		f.PromptTokens += u.PromptTokens
+		f.CompletionTokens += u.CompletionTokens
+		f.CostUSD += u.CostUSD
+		out.Calls++
+		out.CostUSD += u.CostUSD
+	}
+	for _, f := range byFeature {
+		out.Features = append(out.Features, *f)
+	}
+	sort.Slice(out.Features, func(i, j int) bool { return out.Features[i].Feature < out.Features[j].Feature })
+	return out
+}
+
+// getLLMUsage reports AI spend by day and feature. The window is the last
+// ?days (default 7, at most 92) UTC days, or ?from to ?to as YYYY-MM-DD.
+func getLLMUsage(w http.ResponseWriter, r *http.Request) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 92 {
+			http.Error(w, "days must be between 1 and 92", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	from := to.AddDate(0, 0, -(days - 1))
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "Invalid to date, want YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		to = t
+		from = to.AddDate(0, 0, -(days - 1))
+	}
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "Invalid from date, want YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if from.After(to) || to.Sub(from) >= 92*24*time.Hour {
+		http.Error(w, "from must be on or before to, at most 92 days apart", http.StatusBadRequest)
+		return
+	}
+
+	var total float64
+	out := []llmDaySpend{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		usage, err := store.LLMUsage(day)
+		if err != nil {
+			log.Printf("llm usage for %s: %v", day, err)
+			http.Error(w, "Failed to load LLM usage", http.StatusInternalServerError)
+			return
+		}
+		spend := summarizeLLMUsage(day, usage)
+		total += spend.CostUSD
+		out = append(out, spend)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":           from.Format("2006-01-02"),
+		"to":             to.Format("2006-01-02"),
+		"total_cost_usd": total,
+		"days":           out,
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testMeteredLLM puts a cache with ttl in front of f on a clock the test
+// moves by hand, pricing every token at $1 (prompt) or $2 (reply) per
+// million.
+func testMeteredLLM(f *fakeLLM, ttl time.Duration) (*meteredLLM, *time.Time) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	return &meteredLLM{
+		next:  f,
+		model: "test-model",
+		ttl:   ttl,
+		price: &llmPrice{Input: 1, Output: 2},
+		cache: make(map[string]llmCacheEntry),
+		now:   func() time.Time { return now },
+	}, &now
+}
+
+func TestMeteredLLMCache(t *testing.T) {
+	useMemoryStore(t)
+	f := &fakeLLM{}
+	m, now := testMeteredLLM(f, time.Hour)
+	ctx := context.Background()
+	req := LLMRequest{Feature: FeatureAskAnswer, System: "be brief", Prompt: "hello"}
+
+	first, err := m.Complete(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name   string
+		req    LLMRequest
+		wait   time.Duration
+		cached bool
+	}{
+		{"same prompt", req, 0, true},
+		{"other feature", LLMRequest{Feature: FeatureAskIntent, System: req.System, Prompt: req.Prompt}, 0, true},
+		{"other system message", LLMRequest{Feature: req.Feature, Prompt: req.Prompt}, 0, false},
+		{"other token limit", LLMRequest{Feature: req.Feature, System: req.System, Prompt: req.Prompt, MaxTokens: 50}, 0, false},
+		{"just before expiry", req, time.Hour - time.Second, true},
+		{"expired", req, time.Second + time.Nanosecond, false},
+		{"refreshed", req, 0, true},
+	}
+	calls := len(f.Calls)
+	for _, s := range steps {
+		*now = now.Add(s.wait)
+		resp, err := m.Complete(ctx, s.req)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if !s.cached {
+			calls++
+		}
+		if len(f.Calls) != calls {
+			t.Errorf("%s: %d calls reached the model, want %d", s.name, len(f.Calls), calls)
+		}
+		if s.cached && resp != first {
+			t.Errorf("%s: cached reply %+v, want %+v", s.name, resp, first)
+		}
+	}
+
+	usage, err := store.LLMUsage("2025-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != len(steps)+1 {
+		t.Fatalf("%d usage records, want %d", len(usage), len(steps)+1)
+	}
+	want := (float64(first.PromptTokens)*1 + float64(first.CompletionTokens)*2) / 1e6
+	if u := usage[0]; u.Cached || u.Feature != FeatureAskAnswer || u.Model != "fake" || u.CostUSD != want {
+		t.Errorf("first record = %+v, want an uncached call costing %v", u, want)
+	}
+	for i, s := range steps {
+		u := usage[i+1]
+		if u.Cached != s.cached || (s.cached && (u.CostUSD != 0 || u.PromptTokens != 0)) {
+			t.Errorf("%s: recorded %+v", s.name, u)
+		}
+	}
+	if usage[2].Feature != FeatureAskIntent {
+		t.Errorf("a cached call was recorded under %q", usage[2].Feature)
+	}
+}
+
+func TestMeteredLLMCacheOff(t *testing.T) {
+	useMemoryStore(t)
+	f := &fakeLLM{}
+	m, _ := testMeteredLLM(f, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := m.Complete(context.Background(), LLMRequest{Prompt: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(f.Calls) != 2 || len(m.cache) != 0 {
+		t.Errorf("with no TTL: %d calls and %d cache entries, want 2 and 0", len(f.Calls), len(m.cache))
+	}
+}
+
+func TestMeteredLLMErrorsAreNotCached(t *testing.T) {
+	useMemoryStore(t)
+	f := &fakeLLM{Errs: []error{ErrLLMBudget}}
+	m, _ := testMeteredLLM(f, time.Hour)
+	if _, err := m.Complete(context.Background(), LLMRequest{Prompt: "hello"}); err != ErrLLMBudget {
+		t.Fatalf("got %v, want ErrLLMBudget", err)
+	}
+	if _, err := m.Complete(context.Background(), LLMRequest{Prompt: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	usage, _ := store.LLMUsage("2025-01-01")
+	if len(f.Calls) != 2 || len(usage) != 1 {
+		t.Errorf("%d calls and %d usage records, want 2 and 1", len(f.Calls), len(usage))
+	}
+}
+
+func TestMeteredLLMEviction(t *testing.T) {
+	useMemoryStore(t)
+	m, now := testMeteredLLM(&fakeLLM{}, time.Hour)
+	key := func(i int) string { return fmt.Sprintf("k%04d", i) }
+	fill := func(expires func(i int) time.Time) {
+		m.cache = make(map[string]llmCacheEntry)
+		for i := 0; i < llmCacheMaxEntries; i++ {
+			m.cache[key(i)] = llmCacheEntry{expires: expires(i)}
+		}
+	}
+
+	// Expired entries make enough room on their own.
+	fill(func(i int) time.Time {
+		if i%400 == 0 {
+			return now.Add(-time.Second)
+		}
+		return now.Add(time.Duration(i) * time.Second)
+	})
+	if _, err := m.Complete(context.Background(), LLMRequest{Prompt: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.cache) != llmCacheMaxEntries-5+1 {
+		t.Errorf("%d entries after dropping the expired ones, want %d", len(m.cache), llmCacheMaxEntries-4)
+	}
+	for i := 0; i < llmCacheMaxEntries; i++ {
+		if _, ok := m.cache[key(i)]; ok == (i%400 == 0) {
+			t.Fatalf("entry %d kept=%v", i, ok)
+		}
+	}
+
+	// Otherwise the tenth closest to expiring goes.
+	fill(func(i int) time.Time { return now.Add(time.Duration(llmCacheMaxEntries-i) * time.Minute) })
+	if _, err := m.Complete(context.Background(), LLMRequest{Prompt: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	dropped := llmCacheMaxEntries/10 + 1
+	if len(m.cache) != llmCacheMaxEntries-dropped+1 {
+		t.Errorf("%d entries after evicting, want %d", len(m.cache), llmCacheMaxEntries-dropped+1)
+	}
+	for i := 0; i < llmCacheMaxEntries; i++ {
+		if _, ok := m.cache[key(i)]; ok != (i < llmCacheMaxEntries-dropped) {
+			t.Fatalf("entry %d expiring in %d minutes kept=%v", i, llmCacheMaxEntries-i, ok)
+		}
+	}
+}
@@ -1,11 +1,15 @@
+//go:build !ingest
+
 package main
 
 import (
+    "context"
     "encoding/json"
     "fmt"
     "log"
     "net/http"
     "strconv"
+    "strings"
     "time"
     "os"
     "net/smtp"
@@ -15,89 +19,119 @@ import (
     
     "github.com/gorilla/mux"
     "github.com/rs/cors"
-    gocqlastra "github.com/datastax/gocql-astra"
-    "github.com/gocql/gocql"
 
 )
 
-var session *gocql.Session
-
-
-type PriceData struct {
-    CoinID    string    `json:"coin_id"`
-    Timestamp time.Time `json:"timestamp"`
-    PriceUSD  float64   `json:"price_usd"`
-}
 
 type Subscriber struct {
     Email string `json:"email"`
 }
 
 func main() {
-    // Connect to Cassandra
-var err error
-
-    var cluster *gocql.ClusterConfig
-
-
-    cluster, err = gocqlastra.NewClusterFromURL("https://api.astra.datastax.com", os.Getenv("ASTRA_DB_ID") , os.Getenv("ASTRA_DB_APPLICATION_TOKEN"), 10*time.Second)
-    cluster.Keyspace = "iot_data"
-
+    var err error
+    store, err = openStore()
     if err != nil {
-        log.Fatalf("unable to load cluster %s from astra: %v", os.Getenv("ASTRA_DB_APPLICATION_TOKEN"), err)
+        log.Fatalf("unable to open store: %v", err)
     }
 
-    cluster.Timeout = 30 * time.Second
-    start := time.Now()
-    session, err = gocql.NewSession(*cluster)
-    elapsed := time.Now().Sub(start)
-
+    registry, err = loadCoinRegistry(store)
     if err != nil {
-        log.Fatalf("unable to connect session: %v", err)
+        log.Fatalf("unable to load coin registry: %v", err)
     }
 
-    fmt.Println("Making the query now")
-
-    iter := session.Query("SELECT release_version FROM system.local").Iter()
-
-    var version string
-
-    for iter.Scan(&version) {
-        fmt.Println(version)
+    if err := startTickFeed(context.Background()); err != nil {
+        log.Fatalf("unable to start tick stream: %v", err)
     }
 
-    if err = iter.Close(); err != nil {
-        log.Printf("error running query: %v", err)
+    budgets, err := configuredRateBudgets()
+    if err != nil {
+        log.Fatalf("invalid rate limits: %v", err)
     }
+    limiter = newRateLimiter(budgets)
 
-    fmt.Printf("Connection process took %s", elapsed)
-
+    llm, err = configuredLLM()
     if err != nil {
-        log.Fatalf("unable to connect session: %v", err)
+        log.Fatalf("unable to configure LLM: %v", err)
     }
 
-
-fmt.Println("Connected to Cassandra")
+    origins, err := configuredCORSOrigins()
+    if err != nil {
+        log.Fatalf("invalid CORS origins: %v", err)
+    }
+    wsUpgrader.CheckOrigin = originChecker(origins)
 
 // Set up router
 router := mux.NewRouter()
+router.Use(authenticate)
+if limiter != nil {
+    router.Use(limiter.middleware)
+}
+router.Use(resolveCoinAlias)
 router.HandleFunc("/latest/{coin_id}", getLatestPrice).Methods("GET")
 router.HandleFunc("/history/{coin_id}", getHistory).Methods("GET")
 router.HandleFunc("/average/{coin_id}", getAveragePrice).Methods("GET")
 router.HandleFunc("/at/{coin_id}", getPriceAtTime).Methods("GET")
 router.HandleFunc("/range/{coin_id}", getPriceRange).Methods("GET")
 router.HandleFunc("/coins", getAvailableCoins).Methods("GET")
+router.HandleFunc("/candles/{coin_id}", getCandles).Methods("GET")
 router.HandleFunc("/volatility/{coin_id}", getVolatility).Methods("GET")
 router.HandleFunc("/trend/{coin_id}", getTrend).Methods("GET")
 router.HandleFunc("/top-movers", getTopMovers).Methods("GET")
 router.HandleFunc("/predict/{coin_id}", getPredict).Methods("GET")
+router.HandleFunc("/data-quality/{coin_id}", getDataQuality).Methods("GET")
+router.HandleFunc("/stream/sse", streamSSE).Methods("GET")
+router.HandleFunc("/stream/ws", streamWS).Methods("GET")
+router.HandleFunc("/internal/ticks", receiveTicks).Methods("POST")
+router.HandleFunc("/alerts/key", requestAlertKey).Methods("POST")
+router.HandleFunc("/alerts", withAlertOwner(listAlerts)).Methods("GET")
+router.HandleFunc("/alerts", withAlertOwner(createAlert)).Methods("POST")
+router.HandleFunc("/alerts/{id}", withAlertOwner(getAlert)).Methods("GET")
+router.HandleFunc("/alerts/{id}", withAlertOwner(updateAlert)).Methods("PUT")
+router.HandleFunc("/alerts/{id}", withAlertOwner(deleteAlert)).Methods("DELETE")
+router.HandleFunc("/portfolios", requireUser(listPortfolios)).Methods("GET")
+router.HandleFunc("/portfolios", createPortfolio).Methods("POST")
+router.HandleFunc("/portfolios/{id}", withPortfolio(getPortfolio)).Methods("GET")
+router.HandleFunc("/portfolios/{id}", withPortfolio(renamePortfolio)).Methods("PUT")
+router.HandleFunc("/portfolios/{id}", withPortfolio(deletePortfolio)).Methods("DELETE")
+router.HandleFunc("/portfolios/{id}/history", withPortfolio(getPortfolioHistory)).Methods("GET")
+router.HandleFunc("/portfolios/{id}/holdings", withPortfolio(listHoldings)).Methods("GET")
+router.HandleFunc("/portfolios/{id}/holdings", withPortfolio(addHolding)).Methods("POST")
+router.HandleFunc("/portfolios/{id}/holdings/{holding_id}", withPortfolio(updateHolding)).Methods("PUT")
+router.HandleFunc("/portfolios/{id}/holdings/{holding_id}", withPortfolio(deleteHolding)).Methods("DELETE")
+router.HandleFunc("/portfolios/{id}/transactions", withPortfolio(listTransactions)).Methods("GET")
+router.HandleFunc("/portfolios/{id}/transactions/import", withPortfolio(importTransactions)).Methods("POST")
+router.HandleFunc("/portfolios/{id}/transactions/{tx_id}", withPortfolio(deleteTransaction)).Methods("DELETE")
+router.HandleFunc("/portfolios/{id}/gains", withPortfolio(getGains)).Methods("GET")
 router.HandleFunc("/ask", handleAsk).Methods("POST")
 router.HandleFunc("/subscribe", addSubscriber).Methods("POST")
-router.HandleFunc("/generate-report", generateReportHandler).Methods("GET")
+router.HandleFunc("/generate-report", requireAdmin(generateReportHandler)).Methods("GET")
+router.HandleFunc("/reports", listReports).Methods("GET")
+router.HandleFunc("/reports/{date}", getReport).Methods("GET")
+router.HandleFunc("/reports/{date}/insights", getReportInsights).Methods("GET")
 router.HandleFunc("/unsubscribe", removeSubscriber).Methods("POST")
+router.HandleFunc("/subscription/key", requestSubscriptionKey).Methods("POST")
+router.HandleFunc("/subscription", withSubscriptionKey(getSubscription)).Methods("GET")
+router.HandleFunc("/subscription", withSubscriptionKey(updateSubscription)).Methods("PUT")
 router.HandleFunc("/verify", verifyEmail).Methods("GET")
 router.HandleFunc("/ping", pingHandler).Methods("GET", "HEAD")
 router.HandleFunc("/verifyDel", verifyEmailDel).Methods("GET")
+router.HandleFunc("/admin/coins", requireAdmin(listRegistryCoins)).Methods("GET")
+router.HandleFunc("/admin/coins", requireAdmin(putRegistryCoin)).Methods("POST")
+router.HandleFunc("/admin/coins/{coin_id}/enable", requireAdmin(setRegistryCoinEnabled(true))).Methods("POST")
+router.HandleFunc("/admin/coins/{coin_id}/disable", requireAdmin(setRegistryCoinEnabled(false))).Methods("POST")
+router.HandleFunc("/admin/coins/{coin_id}/aliases", requireAdmin(addRegistryCoinAlias)).Methods("POST")
+router.HandleFunc("/admin/data-quality/{coin_id}/backfill", requireAdmin(backfillDataQuality)).Methods("POST")
+router.HandleFunc("/admin/users", requireAdmin(listUsers)).Methods("GET")
+router.HandleFunc("/admin/users", requireAdmin(createUser)).Methods("POST")
+router.HandleFunc("/admin/users/{user_id}", requireAdmin(updateUser)).Methods("PUT")
+router.HandleFunc("/admin/users/{user_id}", requireAdmin(deleteUser)).Methods("DELETE")
+router.HandleFunc("/admin/users/{user_id}/keys", requireAdmin(createUserKey)).Methods("POST")
+router.HandleFunc("/account", requireUser(getAccount)).Methods("GET")
+router.HandleFunc("/rate-limit", getRateLimit).Methods("GET")
+router.HandleFunc("/admin/rate-limits", requireAdmin(getRateLimitStats)).Methods("GET")
+router.HandleFunc("/admin/llm-usage", requireAdmin(getLLMUsage)).Methods("GET")
+router.HandleFunc("/account/keys", requireUser(createAccountKey)).Methods("POST")
+router.HandleFunc("/account/keys/{key_id}", requireUser(deleteAccountKey)).Methods("DELETE")
 
 
 
@@ -110,10 +144,9 @@ router.HandleFunc("/verifyDel", verifyEmailDel).Methods("GET")
 
 
 c := cors.New(cors.Options{
-    AllowedOrigins:   []string{"*"}, 
-    AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-    AllowedHeaders:   []string{"*"},
-    AllowCredentials: true,
+    AllowedOrigins:   origins,
+    AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+    AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key", "X-Admin-Token", "X-Alert-Key", "X-Subscription-Key", "X-Portfolio-Key"},
 })
 
 handler := c.Handler(router)
@@ -123,20 +156,44 @@ log.Fatal(http.ListenAndServe(":8000", handler))
 
 }
 
+// configuredCORSOrigins reads CORS_ALLOWED_ORIGINS, a comma separated list
+// of origins allowed to call the API from a browser. Unset, it defaults to
+// the local frontend dev server, except on Render (which sets RENDER on
+// every service), where it is an error so a deploy can't quietly lock the
+// dashboard out. Credentials travel in headers, not cookies, so no origin
+// is allowed credentials.
+func configuredCORSOrigins() ([]string, error) {
+    v := os.Getenv("CORS_ALLOWED_ORIGINS")
+    if v == "" {
+        if os.Getenv("RENDER") != "" {
+            return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS must be set to the frontend's origin")
+        }
+        log.Printf("CORS_ALLOWED_ORIGINS is unset; allowing only http://localhost:5173")
+        return []string{"http://localhost:5173"}, nil
+    }
+    var origins []string
+    for _, o := range strings.Split(v, ",") {
+        if o = strings.TrimSpace(o); o != "" {
+            origins = append(origins, o)
+        }
+    }
+    if len(origins) == 0 {
+        return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS lists no origins")
+    }
+    return origins, nil
+}
+
 func getLatestPrice(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     coinID := vars["coin_id"]
+    vs, err := parseVs(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
 
-    var data PriceData
-
-    err := session.Query(`
-        SELECT coin_id, timestamp, price_usd
-        FROM crypto_price_by_coin
-        WHERE coin_id = ? LIMIT 1`, coinID).
-        Consistency(gocql.One).
-        Scan(&data.CoinID, &data.Timestamp, &data.PriceUSD)
-
-    if err == gocql.ErrNotFound {
+    data, err := store.Latest(coinID)
+    if err == ErrNotFound {
         http.Error(w, "Price data not found", http.StatusNotFound)
         return
     } else if err != nil {
@@ -145,6 +202,13 @@ func getLatestPrice(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    converted, err := convertRows(coinID, vs, []PriceData{data})
+    if err != nil {
+        writeConversionError(w, err)
+        return
+    }
+    data = converted[0]
+
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(data)
 }
@@ -153,6 +217,11 @@ func getHistory(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     coinID := vars["coin_id"]
     minutesParam := r.URL.Query().Get("minutes")
+    vs, err := parseVs(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
 
     minutes := 60 // default 1 hour
     if minutesParam != "" {
@@ -161,32 +230,33 @@ func getHistory(w http.ResponseWriter, r *http.Request) {
         }
     }
 
-    since := time.Now().Add(-time.Duration(minutes) * time.Minute)
-
-    var results []PriceData
-
-    iter := session.Query(`
-        SELECT coin_id, timestamp, price_usd
-        FROM crypto_price_by_coin
-        WHERE coin_id = ? AND timestamp >= ? ALLOW FILTERING`, coinID, since).
-        Consistency(gocql.One).
-        Iter()
+    until := time.Now()
+    since := until.Add(-time.Duration(minutes) * time.Minute)
 
-    var data PriceData
-    for iter.Scan(&data.CoinID, &data.Timestamp, &data.PriceUSD) {
-        
-        results = append(results, PriceData{
-            CoinID:    data.CoinID,
-            Timestamp: data.Timestamp,
-            PriceUSD:  data.PriceUSD,
-        })
+    // An explicit start and end replace minutes.
+    if startStr, endStr := r.URL.Query().Get("start"), r.URL.Query().Get("end"); startStr != "" || endStr != "" {
+        if since, err = time.Parse(time.RFC3339, startStr); err != nil {
+            http.Error(w, "Invalid start time", http.StatusBadRequest)
+            return
+        }
+        if until, err = time.Parse(time.RFC3339, endStr); err != nil {
+            http.Error(w, "Invalid end time", http.StatusBadRequest)
+            return
+        }
     }
 
-    if err := iter.Close(); err != nil {
+    results, err := store.Range(coinID, since, until)
+    if err != nil {
         http.Error(w, "Query error", http.StatusInternalServerError)
         return
     }
 
+    results, err = convertRows(coinID, vs, results)
+    if err != nil {
+        writeConversionError(w, err)
+        return
+    }
+
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(results)
 }
@@ -211,26 +281,30 @@ func getAveragePrice(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    iter := session.Query(`
-        SELECT price_usd
-        FROM crypto_price_by_coin
-        WHERE coin_id = ? AND timestamp >= ? AND timestamp <= ? ALLOW FILTERING`,
-        coinID, start, end).Consistency(gocql.One).Iter()
-
-    var sum float64
-    var count int
-    var price float64
-
-    for iter.Scan(&price) {
-        sum += price
-        count++
+    vs, err := parseVs(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
     }
 
-    if err := iter.Close(); err != nil {
+    rows, err := store.Range(coinID, start, end)
+    if err != nil {
         http.Error(w, "Query error", http.StatusInternalServerError)
         return
     }
 
+    rows, err = convertRows(coinID, vs, rows)
+    if err != nil {
+        writeConversionError(w, err)
+        return
+    }
+
+    var sum float64
+    count := len(rows)
+    for _, row := range rows {
+        sum += row.Value()
+    }
+
     if count == 0 {
         http.Error(w, "No data found", http.StatusNotFound)
         return
@@ -243,6 +317,7 @@ func getAveragePrice(w http.ResponseWriter, r *http.Request) {
         "data_points":  count,
         "start":        start,
         "end":          end,
+        "vs_currency":  vsLabel(vs),
     }
 
     w.Header().Set("Content-Type", "application/json")
@@ -260,18 +335,8 @@ func getPriceAtTime(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    var data PriceData
-    err = session.Query(`
-        SELECT coin_id, timestamp, price_usd
-        FROM crypto_price_by_coin
-        WHERE coin_id = ? AND timestamp <= ?
-        ORDER BY timestamp DESC
-        LIMIT 1 ALLOW FILTERING`,
-        coinID, ts).
-        Consistency(gocql.One).
-        Scan(&data.CoinID, &data.Timestamp, &data.PriceUSD)
-
-    if err == gocql.ErrNotFound {
+    data, err := store.At(coinID, ts)
+    if err == ErrNotFound {
         http.Error(w, "Price data not found", http.StatusNotFound)
         return
     } else if err != nil {
@@ -303,19 +368,29 @@ func getPriceRange(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    iter := session.Query(`
-        SELECT price_usd
-        FROM crypto_price_by_coin
-        WHERE coin_id = ? AND timestamp >= ? AND timestamp <= ? ALLOW FILTERING`,
-        coinID, start, end).
-        Consistency(gocql.One).
-        Iter()
+    vs, err := parseVs(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    rows, err := store.Range(coinID, start, end)
+    if err != nil {
+        http.Error(w, "Query error", http.StatusInternalServerError)
+        return
+    }
+
+    rows, err = convertRows(coinID, vs, rows)
+    if err != nil {
+        writeConversionError(w, err)
+        return
+    }
 
-    var price float64
     var min, max float64
     first := true
 
-    for iter.Scan(&price) {
+    for _, row := range rows {
+        price := row.Value()
         if first {
             min, max = price, price
             first = false
@@ -329,11 +404,6 @@ func getPriceRange(w http.ResponseWriter, r *http.Request) {
         }
     }
 
-    if err := iter.Close(); err != nil {
-        http.Error(w, "Query error", http.StatusInternalServerError)
-        return
-    }
-
     if first {
         http.Error(w, "No data found", http.StatusNotFound)
         return
@@ -345,6 +415,7 @@ func getPriceRange(w http.ResponseWriter, r *http.Request) {
         "max":      max,
         "start":    start,
         "end":      end,
+        "vs_currency": vsLabel(vs),
     }
 
     w.Header().Set("Content-Type", "application/json")
@@ -352,19 +423,21 @@ func getPriceRange(w http.ResponseWriter, r *http.Request) {
 }
 
 
+// getAvailableCoins lists the enabled registry coins, falling back to the
+// coins present in the store when the registry is empty.
 func getAvailableCoins(w http.ResponseWriter, r *http.Request) {
-    iter := session.Query(`SELECT DISTINCT coin_id FROM crypto_price_by_coin`).Iter()
-
-    var coinID string
     var coins []string
-
-    for iter.Scan(&coinID) {
-        coins = append(coins, coinID)
+    for _, c := range registry.Enabled() {
+        coins = append(coins, c.ID)
     }
 
-    if err := iter.Close(); err != nil {
-        http.Error(w, "Query error", http.StatusInternalServerError)
-        return
+    if len(coins) == 0 {
+        var err error
+        coins, err = store.Coins()
+        if err != nil {
+            http.Error(w, "Query error", http.StatusInternalServerError)
+            return
+        }
     }
 
     w.Header().Set("Content-Type", "application/json")
@@ -391,11 +464,7 @@ func addSubscriber(w http.ResponseWriter, r *http.Request) {
     createdAt := time.Now()
 
     
-    err := session.Query(`
-        INSERT INTO iot_data.staging_subscribers ("token", email, created_at)
-        VALUES (?, ?, ?)`,
-        token, sub.Email, createdAt,
-    ).Exec()
+    err := store.AddPendingSubscriber(token, sub.Email, createdAt)
     if err != nil {
         log.Printf("Error inserting subscriber into staging: %v", err)
         http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
@@ -475,14 +544,7 @@ func verifyEmail(w http.ResponseWriter, r *http.Request) {
     }
 
    
-    var email string
-    var createdAt time.Time
-    err := session.Query(`
-        SELECT email, created_at
-        FROM iot_data.staging_subscribers
-        WHERE "token" = ?`,
-        token,
-    ).Scan(&email, &createdAt)
+    email, createdAt, err := store.PendingSubscriber(token)
 
     if err != nil {
         log.Printf("Invalid or expired token: %v", err)
@@ -497,11 +559,7 @@ func verifyEmail(w http.ResponseWriter, r *http.Request) {
     }
 
     
-    err = session.Query(`
-        INSERT INTO iot_data.email_subscribers (email, subscribed_at)
-        VALUES (?, ?)`,
-        email, time.Now(),
-    ).Exec()
+    err = store.AddSubscriber(email, time.Now())
     if err != nil {
         log.Printf("Error adding verified email: %v", err)
         http.Error(w, "Failed to verify email", http.StatusInternalServerError)
@@ -509,11 +567,7 @@ func verifyEmail(w http.ResponseWriter, r *http.Request) {
     }
 
     
-    err = session.Query(`
-        DELETE FROM iot_data.staging_subscribers
-        WHERE "token" = ?`,
-        token,
-    ).Exec()
+    err = store.DeletePendingSubscriber(token)
     if err != nil {
         log.Printf("Error deleting token from staging: %v", err)
     }
@@ -542,11 +596,7 @@ func removeSubscriber(w http.ResponseWriter, r *http.Request) {
     createdAt := time.Now()
 
     
-    err := session.Query(`
-        INSERT INTO iot_data.staging_subscribers ("token", email, created_at)
-        VALUES (?, ?, ?)`,
-        token, sub.Email, createdAt,
-    ).Exec()
+    err := store.AddPendingSubscriber(token, sub.Email, createdAt)
     if err != nil {
         log.Printf("Error inserting subscriber into staging: %v", err)
         http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
@@ -591,14 +641,7 @@ func verifyEmailDel(w http.ResponseWriter, r *http.Request) {
     }
 
    
-    var email string
-    var createdAt time.Time
-    err := session.Query(`
-        SELECT email, created_at
-        FROM iot_data.staging_subscribers
-        WHERE "token" = ?`,
-        token,
-    ).Scan(&email, &createdAt)
+    email, createdAt, err := store.PendingSubscriber(token)
 
     if err != nil {
         log.Printf("Invalid or expired token: %v", err)
@@ -613,21 +656,14 @@ func verifyEmailDel(w http.ResponseWriter, r *http.Request) {
     }
 
     
-    err = session.Query(`
-        DELETE FROM iot_data.email_subscribers Where email = ?`,
-        email,
-    ).Exec()
+    err = store.RemoveSubscriber(email)
     if err != nil {
         log.Printf("Error adding verified email: %v", err)
         http.Error(w, "Failed to remove email", http.StatusInternalServerError)
         return
     }
     
-    err = session.Query(`
-        DELETE FROM iot_data.staging_subscribers
-        WHERE "token" = ?`,
-        token,
-    ).Exec()
+    err = store.DeletePendingSubscriber(token)
     if err != nil {
         log.Printf("Error deleting token from staging: %v", err)
     }
@@ -2,13 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"math"
 	"net/http"
 	"sort"
 	"strconv"
 	"time"
 
-	"github.com/gocql/gocql"
 	"github.com/gorilla/mux"
 )
 
@@ -16,6 +16,7 @@ import (
 type PredictResponse struct {
 	CoinID          string    `json:"coin_id"`
 	HorizonMinutes  int       `json:"horizon_minutes"`
+	VsCurrency      string    `json:"vs_currency"`
 	PredictedPrice  float64   `json:"predicted_price"`
 	PriceLow        float64   `json:"price_low"`
 	PriceHigh       float64   `json:"price_high"`
@@ -27,23 +28,22 @@ type PredictResponse struct {
 }
 
 // fetchHistoryForML returns historical (timestamp, price) for the coin over the last lookbackMinutes, sorted by time.
-func fetchHistoryForML(coinID string, lookbackMinutes int) ([]struct{ T time.Time; P float64 }, error) {
+// Prices are in vs, or USD when vs is empty.
+func fetchHistoryForML(coinID, vs string, lookbackMinutes int) ([]struct{ T time.Time; P float64 }, error) {
 	since := time.Now().Add(-time.Duration(lookbackMinutes) * time.Minute)
-	iter := session.Query(`
-		SELECT timestamp, price_usd
-		FROM crypto_price_by_coin
-		WHERE coin_id = ? AND timestamp >= ? ALLOW FILTERING`,
-		coinID, since).Consistency(gocql.One).Iter()
-
-	var out []struct{ T time.Time; P float64 }
-	var t time.Time
-	var p float64
-	for iter.Scan(&t, &p) {
-		out = append(out, struct{ T time.Time; P float64 }{T: t, P: p})
+	rows, err := store.Range(coinID, since, time.Now())
+	if err != nil {
+		return nil, err
 	}
-	if err := iter.Close(); err != nil {
+	rows, err = convertRows(coinID, vs, rows)
+	if err != nil {
 		return nil, err
 	}
+
+	var out []struct{ T time.Time; P float64 }
+	for _, row := range rows {
+		out = append(out, struct{ T time.Time; P float64 }{T: row.Timestamp, P: row.Value()})
+	}
 	// Sort by time ascending for regression
 	sort.Slice(out, func(i, j int) bool { return out[i].T.Before(out[j].T) })
 	return out, nil
@@ -112,7 +112,17 @@ func getPredict(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	history, err := fetchHistoryForML(coinID, lookbackMinutes)
+	vs, err := parseVs(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	history, err := fetchHistoryForML(coinID, vs, lookbackMinutes)
+	if errors.Is(err, ErrNoRate) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to fetch history", http.StatusInternalServerError)
 		return
@@ -161,9 +171,10 @@ func getPredict(w http.ResponseWriter, r *http.Request) {
 	resp := PredictResponse{
 		CoinID:         coinID,
 		HorizonMinutes: horizonMinutes,
-		PredictedPrice: roundPrice(predictedPrice),
-		PriceLow:       roundPrice(priceLow),
-		PriceHigh:      roundPrice(priceHigh),
+		VsCurrency:     vsLabel(vs),
+		PredictedPrice: roundPrice(predictedPrice, vs),
+		PriceLow:       roundPrice(priceLow, vs),
+		PriceHigh:      roundPrice(priceHigh, vs),
 		Trend:          trendFromSlope(slope),
 		Slope:          slope,
 		DataPoints:     len(history),
@@ -174,6 +185,13 @@ func getPredict(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-func roundPrice(p float64) float64 {
+// roundPrice rounds to cents. Prices converted to another currency vs keep
+// six significant digits below one instead (e.g. altcoins quoted in btc),
+// where cents would round them to zero.
+func roundPrice(p float64, vs string) float64 {
+	if vs != "" && p != 0 && math.Abs(p) < 1 {
+		scale := math.Pow(10, 5-math.Floor(math.Log10(math.Abs(p))))
+		return math.Round(p*scale) / scale
+	}
 	return math.Round(p*100) / 100
 }
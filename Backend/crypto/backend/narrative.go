@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report narrative modes. NarrativeAI asks the LLM for each section's
+// commentary and falls back to the template text when a call fails;
+// NarrativeTemplate never calls the LLM.
+const (
+	NarrativeAI       = "ai"
+	NarrativeTemplate = "template"
+)
+
+// flatChangePct is the absolute change below which a coin counts as flat.
+const flatChangePct = 0.05
+
+// configuredNarrative reads REPORT_NARRATIVE, defaulting to NarrativeAI.
+func configuredNarrative() string {
+	if m := os.Getenv("REPORT_NARRATIVE"); m == NarrativeTemplate {
+		return m
+	}
+	return NarrativeAI
+}
+
+// parseNarrative validates a narrative mode, "" meaning the configured one.
+func parseNarrative(mode string) (string, error) {
+	switch mode {
+	case "":
+		return configuredNarrative(), nil
+	case NarrativeAI, NarrativeTemplate:
+		return mode, nil
+	}
+	return "", fmt.Errorf("narrative must be %s or %s", NarrativeAI, NarrativeTemplate)
+}
+
+// reportNarrator writes the commentary under each report section. The AI
+// commentary is written in language, with chart times in loc; the template
+// commentary is English.
+type reportNarrator struct {
+	mode     string
+	client   LLMClient
+	language string
+	loc      *time.Location
+}
+
+// section returns the LLM's summary of a table, or fallback when the
+// narrator is in template mode or the call fails.
+func (n reportNarrator) section(ctx context.Context, title string, rows [][]string, fallback string) string {
+	if n.mode != NarrativeAI {
+		return fallback
+	}
+	text, err := generateAnalysis(ctx, n.client, title, rows, n.language)
+	if err != nil || strings.TrimSpace(text) == "" {
+		log.Printf("report: AI analysis for %s unavailable, using template: %v", title, err)
+		return fallback
+	}
+	return text
+}
+
+// chart returns the LLM's commentary on a coin's series, or the template
+// commentary.
+func (n reportNarrator) chart(ctx context.Context, coin string, series []PricePoint) string {
+	loc := n.loc
+	if loc == nil {
+		loc = time.UTC
+	}
+	if n.mode != NarrativeAI {
+		return narrateChart(coin, series, loc)
+	}
+	text, err := generateChartAnalysis(ctx, n.client, coin, series, loc, n.language)
+	if err != nil || strings.TrimSpace(text) == "" {
+		log.Printf("report: AI chart analysis for %s unavailable, using template: %v", coin, err)
+		return narrateChart(coin, series, loc)
+	}
+	return text
+}
+
+// usd formats a price for prose: thousands separators and cents for
+// prices of a dollar or more, four significant digits below that.
+func usd(v float64) string {
+	if math.Abs(v) < 1 && v != 0 {
+		return "$" + strconv.FormatFloat(v, 'g', 4, 64)
+	}
+	s := strconv.FormatFloat(math.Abs(v), 'f', 2, 64)
+	whole, cents, _ := strings.Cut(s, ".")
+	var b strings.Builder
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	sign := ""
+	if v < 0 {
+		sign = "-"
+	}
+	return sign + "$" + b.String() + "." + cents
+}
+
+// usdCompact formats large amounts such as volume and market cap.
+func usdCompact(v float64) string {
+	switch a := math.Abs(v); {
+	case a >= 1e12:
+		return fmt.Sprintf("$%.2f trillion", v/1e12)
+	case a >= 1e9:
+		return fmt.Sprintf("$%.2f billion", v/1e9)
+	case a >= 1e6:
+		return fmt.Sprintf("$%.2f million", v/1e6)
+	}
+	return usd(v)
+}
+
+// signedPct formats a percentage with an explicit sign.
+func signedPct(v float64) string {
+	return fmt.Sprintf("%+.2f%%", v)
+}
+
+// volatilityPct formats Insight.Volatility, the standard deviation of log
+// returns, as a percentage per reading.
+func volatilityPct(v float64) string {
+	return fmt.Sprintf("%.3f%% per reading", v*100)
+}
+
+// joinNames joins names as "a", "a and b" or "a, b and c".
+func joinNames(names []string) string {
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// narrateRangeMetrics summarizes how far each coin moved between its low
+// and high.
+func narrateRangeMetrics(coins []Insight) string {
+	if len(coins) == 0 {
+		return "No price data was recorded for this period."
+	}
+	byRange := append([]Insight(nil), coins...)
+	sort.SliceStable(byRange, func(i, j int) bool { return byRange[i].RangePct > byRange[j].RangePct })
+
+	var sum float64
+	wide := 0
+	for _, c := range coins {
+		sum += c.RangePct
+		if c.RangePct > 10 {
+			wide++
+		}
+	}
+	widest, narrowest := byRange[0], byRange[len(byRange)-1]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Across %d coins the gap between the low and the high averaged %.2f%%. ", len(coins), sum/float64(len(coins)))
+	fmt.Fprintf(&b, "%s had the widest range at %.2f%%, trading between %s and %s", widest.CoinID, widest.RangePct, usd(widest.MinPrice), usd(widest.MaxPrice))
+	if len(coins) > 1 {
+		fmt.Fprintf(&b, ", while %s was the most contained at %.2f%%", narrowest.CoinID, narrowest.RangePct)
+	}
+	b.WriteString(".")
+	switch {
+	case wide == 1:
+		b.WriteString(" It was the only coin to swing more than 10%.")
+	case wide > 1:
+		fmt.Fprintf(&b, " %d coins swung more than 10%%.", wide)
+	}
+	return b.String()
+}
+
+// narrateMarketOverview summarizes the market-cap index and the volume
+// leaders.
+func narrateMarketOverview(insights ReportInsights) string {
+	var b strings.Builder
+	switch idx := insights.MarketCapIndexPct; {
+	case math.Abs(idx) < flatChangePct:
+		b.WriteString("The market-cap-weighted index was essentially flat.")
+	case idx > 0:
+		fmt.Fprintf(&b, "The market-cap-weighted index rose %.2f%%.", idx)
+	default:
+		fmt.Fprintf(&b, "The market-cap-weighted index fell %.2f%%.", -idx)
+	}
+	if len(insights.VolumeLeaders) == 0 {
+		return b.String()
+	}
+
+	lead := insights.VolumeLeaders[0]
+	fmt.Fprintf(&b, " %s led trading with %s in 24h volume", lead.CoinID, usdCompact(lead.Volume24h))
+	if lead.MarketCap > 0 {
+		fmt.Fprintf(&b, " on a market cap of %s", usdCompact(lead.MarketCap))
+	}
+	fmt.Fprintf(&b, " and finished %s.", signedPct(lead.PercentChange))
+
+	if n := len(insights.VolumeLeaders); n > 1 {
+		up := 0
+		for _, v := range insights.VolumeLeaders {
+			if v.PercentChange > 0 {
+				up++
+			}
+		}
+		fmt.Fprintf(&b, " %d of the %d most traded coins ended higher.", up, n)
+	}
+	return b.String()
+}
+
+// narrateMovers summarizes the top gainers (gainers true) or losers.
+func narrateMovers(movers []Insight, gainers bool) string {
+	if len(movers) == 0 {
+		return "No price data was recorded for this period."
+	}
+	// Only coins that actually moved in the named direction count.
+	var moved []Insight
+	for _, m := range movers {
+		if (gainers && m.PercentChange > 0) || (!gainers && m.PercentChange < 0) {
+			moved = append(moved, m)
+		}
+	}
+	if len(moved) == 0 {
+		best := movers[0]
+		if gainers {
+			return fmt.Sprintf("No coin finished higher; the strongest was %s at %s.", best.CoinID, signedPct(best.PercentChange))
+		}
+		return fmt.Sprintf("No coin finished lower; the weakest was %s at %s.", best.CoinID, signedPct(best.PercentChange))
+	}
+
+	lead := moved[0]
+	verb := "led the gainers"
+	if !gainers {
+		verb = "fell the most"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s at %s, moving from %s to %s", lead.CoinID, verb, signedPct(lead.PercentChange), usd(lead.FirstPrice), usd(lead.LastPrice))
+	if lead.Volatility > 0 {
+		fmt.Fprintf(&b, ", with a volatility of %s", volatilityPct(lead.Volatility))
+	}
+	b.WriteString(".")
+
+	if rest := moved[1:]; len(rest) > 0 {
+		var parts []string
+		for _, m := range rest {
+			parts = append(parts, fmt.Sprintf("%s (%s)", m.CoinID, signedPct(m.PercentChange)))
+		}
+		fmt.Fprintf(&b, " %s followed.", joinNames(parts))
+	}
+	return b.String()
+}
+
+// narrateSnapshot summarizes breadth, the median move and volatility
+// across every coin.
+func narrateSnapshot(coins []Insight) string {
+	if len(coins) == 0 {
+		return "No price data was recorded for this period."
+	}
+	var up, down, flat int
+	changes := make([]float64, 0, len(coins))
+	for _, c := range coins {
+		switch {
+		case math.Abs(c.PercentChange) < flatChangePct:
+			flat++
+		case c.PercentChange > 0:
+			up++
+		default:
+			down++
+		}
+		changes = append(changes, c.PercentChange)
+	}
+	sort.Float64s(changes)
+	median := changes[len(changes)/2]
+	if len(changes)%2 == 0 {
+		median = (changes[len(changes)/2-1] + changes[len(changes)/2]) / 2
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Across %d coins tracked, %d advanced, %d declined and %d held flat, for a median change of %s.", len(coins), up, down, flat, signedPct(median))
+
+	byVol := append([]Insight(nil), coins...)
+	sort.SliceStable(byVol, func(i, j int) bool { return byVol[i].Volatility > byVol[j].Volatility })
+	if most := byVol[0]; most.Volatility > 0 {
+		fmt.Fprintf(&b, " %s was the most volatile (%s)", most.CoinID, volatilityPct(most.Volatility))
+		if least := byVol[len(byVol)-1]; len(byVol) > 1 {
+			fmt.Fprintf(&b, " and %s the calmest (%s)", least.CoinID, volatilityPct(least.Volatility))
+		}
+		b.WriteString(".")
+	}
+	return b.String()
+}
+
+// narrateChart describes one coin's series: open to close, the extremes
+// with their times in loc and the largest move between readings.
+func narrateChart(coin string, series []PricePoint, loc *time.Location) string {
+	if len(series) == 0 {
+		return fmt.Sprintf("No price data available for %s.", coin)
+	}
+	first, last := series[0], series[len(series)-1]
+	lo, hi := first, first
+	var bigMove float64
+	for i, p := range series {
+		if p.Price < lo.Price {
+			lo = p
+		}
+		if p.Price > hi.Price {
+			hi = p
+		}
+		if i > 0 && series[i-1].Price > 0 {
+			if move := (p.Price - series[i-1].Price) / series[i-1].Price * 100; math.Abs(move) > math.Abs(bigMove) {
+				bigMove = move
+			}
+		}
+	}
+	change := 0.0
+	if first.Price != 0 {
+		change = (last.Price - first.Price) / first.Price * 100
+	}
+
+	var b strings.Builder
+	switch {
+	case change > 1:
+		fmt.Fprintf(&b, "%s trended higher, ", coin)
+	case change < -1:
+		fmt.Fprintf(&b, "%s trended lower, ", coin)
+	default:
+		fmt.Fprintf(&b, "%s traded sideways, ", coin)
+	}
+	fmt.Fprintf(&b, "opening at %s and closing at %s (%s). ", usd(first.Price), usd(last.Price), signedPct(change))
+	if len(series) > 1 {
+		fmt.Fprintf(&b, "It touched a low of %s at %s and a high of %s at %s", usd(lo.Price), lo.Timestamp.In(loc).Format("Jan 2 15:04 MST"), usd(hi.Price), hi.Timestamp.In(loc).Format("Jan 2 15:04 MST"))
+		if bigMove != 0 {
+			fmt.Fprintf(&b, "; the sharpest move between readings was %s", signedPct(bigMove))
+		}
+		b.WriteString(".")
+	}
+	return b.String()
+}
+
+// narratePerformance summarizes a multi-day period: the best and worst
+// performers, the deepest drawdown, the single best and worst days and how
+// the average return moved from one part of the period to the next.
+func narratePerformance(insights ReportInsights) string {
+	coins := insights.CoinMetrics
+	if len(coins) == 0 {
+		return "No price data was recorded for this period."
+	}
+	best, worst, deepest := coins[0], coins[0], coins[0]
+	bestDay, worstDay := coins[0], coins[0]
+	for _, c := range coins[1:] {
+		if c.PercentChange > best.PercentChange {
+			best = c
+		}
+		if c.PercentChange < worst.PercentChange {
+			worst = c
+		}
+		if c.MaxDrawdownPct > deepest.MaxDrawdownPct {
+			deepest = c
+		}
+		if c.BestDay != "" && (bestDay.BestDay == "" || c.BestDayPct > bestDay.BestDayPct) {
+			bestDay = c
+		}
+		if c.WorstDay != "" && (worstDay.WorstDay == "" || c.WorstDayPct < worstDay.WorstDayPct) {
+			worstDay = c
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Over %s, %s performed best at %s", insights.Period.Describe(), best.CoinID, signedPct(best.PercentChange))
+	if len(coins) > 1 {
+		fmt.Fprintf(&b, " and %s worst at %s", worst.CoinID, signedPct(worst.PercentChange))
+	}
+	b.WriteString(".")
+	if deepest.MaxDrawdownPct > 0 {
+		fmt.Fprintf(&b, " %s suffered the deepest drawdown, falling %.2f%% from its peak.", deepest.CoinID, deepest.MaxDrawdownPct)
+	}
+	if bestDay.BestDay != "" && worstDay.WorstDay != "" {
+		fmt.Fprintf(&b, " The strongest single day was %s for %s (%s) and the weakest %s for %s (%s).",
+			bestDay.BestDay, bestDay.CoinID, signedPct(bestDay.BestDayPct),
+			worstDay.WorstDay, worstDay.CoinID, signedPct(worstDay.WorstDayPct))
+	}
+
+	// Average each part of the period across the coins that have it.
+	var labels []string
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, c := range coins {
+		for _, r := range c.Returns {
+			if counts[r.Label] == 0 {
+				labels = append(labels, r.Label)
+			}
+			sums[r.Label] += r.ChangePct
+			counts[r.Label]++
+		}
+	}
+	if len(labels) > 1 {
+		sort.Strings(labels)
+		hi, lo := labels[0], labels[0]
+		for _, l := range labels {
+			if sums[l]/float64(counts[l]) > sums[hi]/float64(counts[hi]) {
+				hi = l
+			}
+			if sums[l]/float64(counts[l]) < sums[lo]/float64(counts[lo]) {
+				lo = l
+			}
+		}
+		fmt.Fprintf(&b, " On average coins did best over %s (%s) and worst over %s (%s).",
+			strings.ReplaceAll(hi, "--", " to "), signedPct(sums[hi]/float64(counts[hi])),
+			strings.ReplaceAll(lo, "--", " to "), signedPct(sums[lo]/float64(counts[lo])))
+	}
+	return b.String()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// narrativeCoins are three coins from a week: one up, one down and one
+// flat.
+func narrativeCoins() []Insight {
+	return []Insight{
+		{
+			CoinID: "bitcoin", FirstPrice: 60000, LastPrice: 63000, PercentChange: 5,
+			MinPrice: 59000, MaxPrice: 63720, RangePct: 8, Volatility: 0.002,
+			MarketCap: 1.2e12, Volume24h: 3e10, MaxDrawdownPct: 3,
+			BestDay: "2026-10-13", BestDayPct: 4, WorstDay: "2026-10-15", WorstDayPct: -2,
+			Returns: []PeriodReturn{{"2026-10-12", 1}, {"2026-10-13", 4}},
+		},
+		{
+			CoinID: "ethereum", FirstPrice: 2500, LastPrice: 2400, PercentChange: -4,
+			MinPrice: 2350, MaxPrice: 2643.75, RangePct: 12.5, Volatility: 0.004,
+			MarketCap: 3e11, Volume24h: 1.5e10, MaxDrawdownPct: 9.5,
+			BestDay: "2026-10-14", BestDayPct: 1.5, WorstDay: "2026-10-16", WorstDayPct: -6,
+			Returns: []PeriodReturn{{"2026-10-12", -3}, {"2026-10-13", 2}},
+		},
+		{
+			CoinID: "dogecoin", FirstPrice: 0.1234, LastPrice: 0.12342, PercentChange: 0.02,
+			MinPrice: 0.12, MaxPrice: 0.1236, RangePct: 3, Volatility: 0.001,
+		},
+	}
+}
+
+func TestNarrateSections(t *testing.T) {
+	coins := narrativeCoins()
+	btc, eth, doge := coins[0], coins[1], coins[2]
+	week, _ := periodContaining(PeriodWeekly, time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name, got, want string
+	}{
+		{"range metrics", narrateRangeMetrics(coins),
+			"Across 3 coins the gap between the low and the high averaged 7.83%. ethereum had the widest range at 12.50%, trading between $2,350.00 and $2,643.75, while dogecoin was the most contained at 3.00%. It was the only coin to swing more than 10%."},
+		{"range metrics, no data", narrateRangeMetrics(nil), "No price data was recorded for this period."},
+
+		{"market overview", narrateMarketOverview(ReportInsights{MarketCapIndexPct: 3.1, VolumeLeaders: []Insight{btc, eth}}),
+			"The market-cap-weighted index rose 3.10%. bitcoin led trading with $30.00 billion in 24h volume on a market cap of $1.20 trillion and finished +5.00%. 1 of the 2 most traded coins ended higher."},
+		{"market overview, falling", narrateMarketOverview(ReportInsights{MarketCapIndexPct: -2.5, VolumeLeaders: []Insight{doge}}),
+			"The market-cap-weighted index fell 2.50%. dogecoin led trading with $0.00 in 24h volume and finished +0.02%."},
+		{"market overview, flat", narrateMarketOverview(ReportInsights{MarketCapIndexPct: 0.01}),
+			"The market-cap-weighted index was essentially flat."},
+
+		{"gainers", narrateMovers([]Insight{btc, doge, eth}, true),
+			"bitcoin led the gainers at +5.00%, moving from $60,000.00 to $63,000.00, with a volatility of 0.200% per reading. dogecoin (+0.02%) followed."},
+		{"losers", narrateMovers([]Insight{eth, doge, btc}, false),
+			"ethereum fell the most at -4.00%, moving from $2,500.00 to $2,400.00, with a volatility of 0.400% per reading."},
+		{"no losers", narrateMovers([]Insight{btc}, false),
+			"No coin finished lower; the weakest was bitcoin at +5.00%."},
+		{"no gainers", narrateMovers([]Insight{eth}, true),
+			"No coin finished higher; the strongest was ethereum at -4.00%."},
+
+		{"snapshot", narrateSnapshot(coins),
+			"Across 3 coins tracked, 1 advanced, 1 declined and 1 held flat, for a median change of +0.02%. ethereum was the most volatile (0.400% per reading) and dogecoin the calmest (0.100% per reading)."},
+		{"snapshot, even count", narrateSnapshot([]Insight{btc, eth}),
+			"Across 2 coins tracked, 1 advanced, 1 declined and 0 held flat, for a median change of +0.50%. ethereum was the most volatile (0.400% per reading) and bitcoin the calmest (0.200% per reading)."},
+
+		{"performance", narratePerformance(ReportInsights{Period: week, CoinMetrics: coins}),
+			"Over 2026-10-12 to 2026-10-18, bitcoin performed best at +5.00% and ethereum worst at -4.00%. ethereum suffered the deepest drawdown, falling 9.50% from its peak. The strongest single day was 2026-10-13 for bitcoin (+4.00%) and the weakest 2026-10-16 for ethereum (-6.00%). On average coins did best over 2026-10-13 (+3.00%) and worst over 2026-10-12 (-1.00%)."},
+		{"performance, no data", narratePerformance(ReportInsights{Period: week}), "No price data was recorded for this period."},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestNarrateChart(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	at := func(hour int) time.Time { return time.Date(2026, 10, 16, hour, 0, 0, 0, time.UTC) }
+	series := []PricePoint{{Timestamp: at(0), Price: 100}, {Timestamp: at(4), Price: 90}, {Timestamp: at(8), Price: 120}, {Timestamp: at(12), Price: 110}}
+
+	want := "bitcoin trended higher, opening at $100.00 and closing at $110.00 (+10.00%). It touched a low of $90.00 at Oct 16 00:00 EDT and a high of $120.00 at Oct 16 04:00 EDT; the sharpest move between readings was +33.33%."
+	if got := narrateChart("bitcoin", series, ny); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	if got := narrateChart("bitcoin", series, time.UTC); !strings.Contains(got, "low of $90.00 at Oct 16 04:00 UTC") {
+		t.Errorf("in UTC: %q", got)
+	}
+	if got := narrateChart("solana", nil, time.UTC); got != "No price data available for solana." {
+		t.Errorf("no data: %q", got)
+	}
+}
+
+func TestFormatters(t *testing.T) {
+	tests := []struct{ got, want string }{
+		{usd(1234567.891), "$1,234,567.89"},
+		{usd(999.999), "$1,000.00"},
+		{usd(-42), "-$42.00"},
+		{usd(0), "$0.00"},
+		{usd(0.5), "$0.5"},
+		{usdCompact(2.5e12), "$2.50 trillion"},
+		{usdCompact(1.5e9), "$1.50 billion"},
+		{usdCompact(2.5e6), "$2.50 million"},
+		{usdCompact(999), "$999.00"},
+		{signedPct(1.234), "+1.23%"},
+		{signedPct(-0.5), "-0.50%"},
+		{joinNames(nil), ""},
+		{joinNames([]string{"a"}), "a"},
+		{joinNames([]string{"a", "b"}), "a and b"},
+		{joinNames([]string{"a", "b", "c"}), "a, b and c"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestReportNarrator(t *testing.T) {
+	ctx := context.Background()
+	rows := [][]string{{"Coin", "Change"}, {"bitcoin", "+5.00%"}}
+	series := []PricePoint{{Timestamp: time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC), Price: 100}}
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	f := &fakeLLM{Reply: "Bitcoin led the week."}
+	n := reportNarrator{mode: NarrativeAI, client: f, language: "es", loc: ny}
+	if got := n.section(ctx, "Top Gainers", rows, "fallback"); got != "Bitcoin led the week." {
+		t.Errorf("AI section = %q", got)
+	}
+	if got := n.chart(ctx, "bitcoin", series); got != "Bitcoin led the week." {
+		t.Errorf("AI chart = %q", got)
+	}
+	if len(f.Calls) != 2 {
+		t.Fatalf("%d calls, want 2", len(f.Calls))
+	}
+	section, chart := f.Calls[0], f.Calls[1]
+	if section.Feature != FeatureReportSection || section.System != reportAnalystRole || !strings.Contains(section.Prompt, "bitcoin | +5.00%") {
+		t.Errorf("section request = %+v", section)
+	}
+	if chart.Feature != FeatureReportChart || !strings.Contains(chart.Prompt, "Oct 16 04:00 EDT | 100.0000") {
+		t.Errorf("chart request = %+v", chart)
+	}
+	for _, c := range f.Calls {
+		if !strings.Contains(c.Prompt, "Write it in Spanish.") {
+			t.Errorf("%s prompt does not ask for Spanish", c.Feature)
+		}
+	}
+
+	// The template commentary stands in when the model fails or says
+	// nothing, and is all template mode uses.
+	template := narrateChart("bitcoin", series, ny)
+	fallbacks := []struct {
+		name string
+		n    reportNarrator
+	}{
+		{"model error", reportNarrator{mode: NarrativeAI, client: &fakeLLM{Errs: []error{errors.New("down"), errors.New("down")}}, loc: ny}},
+		{"blank reply", reportNarrator{mode: NarrativeAI, client: &fakeLLM{Reply: "  \n"}, loc: ny}},
+		{"no model", reportNarrator{mode: NarrativeAI, loc: ny}},
+		{"template mode", reportNarrator{mode: NarrativeTemplate, client: f, loc: ny}},
+	}
+	for _, tt := range fallbacks {
+		if got := tt.n.section(ctx, "Top Gainers", rows, "fallback"); got != "fallback" {
+			t.Errorf("%s: section = %q", tt.name, got)
+		}
+		if got := tt.n.chart(ctx, "bitcoin", series); got != template {
+			t.Errorf("%s: chart = %q, want %q", tt.name, got, template)
+		}
+	}
+	if len(f.Calls) != 2 {
+		t.Errorf("template mode called the model")
+	}
+	if english := languageInstruction(LanguageEnglish); english != "" {
+		t.Errorf("English reports ask for %q", english)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report periods.
+const (
+	PeriodDaily   = "daily"
+	PeriodWeekly  = "weekly"
+	PeriodMonthly = "monthly"
+	PeriodCustom  = "custom"
+)
+
+// maxCustomPeriod bounds a custom report's span.
+const maxCustomPeriod = 366 * 24 * time.Hour
+
+// ReportPeriod is the span a report covers: whole UTC days from Start
+// (inclusive) to End (exclusive). Weeks run Monday to Sunday.
+type ReportPeriod struct {
+	Kind  string    `json:"kind"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func utcDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// periodContaining returns the daily, weekly or monthly period day falls
+// in.
+func periodContaining(kind string, day time.Time) (ReportPeriod, error) {
+	d := utcDay(day)
+	switch kind {
+	case PeriodDaily:
+		return ReportPeriod{kind, d, d.AddDate(0, 0, 1)}, nil
+	case PeriodWeekly:
+		monday := d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+		return ReportPeriod{kind, monday, monday.AddDate(0, 0, 7)}, nil
+	case PeriodMonthly:
+		first := time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return ReportPeriod{kind, first, first.AddDate(0, 1, 0)}, nil
+	}
+	return ReportPeriod{}, fmt.Errorf("period must be %s, %s, %s or %s", PeriodDaily, PeriodWeekly, PeriodMonthly, PeriodCustom)
+}
+
+// lastCompletePeriod returns the most recent period of kind that has
+// ended by now: yesterday, last week or last month.
+func lastCompletePeriod(kind string, now time.Time) (ReportPeriod, error) {
+	current, err := periodContaining(kind, now)
+	if err != nil {
+		return current, err
+	}
+	return periodContaining(kind, current.Start.AddDate(0, 0, -1))
+}
+
+// customPeriod covers the days from start to end, both inclusive.
+func customPeriod(start, end time.Time) (ReportPeriod, error) {
+	p := ReportPeriod{PeriodCustom, utcDay(start), utcDay(end).AddDate(0, 0, 1)}
+	if !p.Start.Before(p.End) {
+		return p, fmt.Errorf("start must be on or before end")
+	}
+	if p.End.Sub(p.Start) > maxCustomPeriod {
+		return p, fmt.Errorf("a custom period may span at most 366 days")
+	}
+	return p, nil
+}
+
+// Days is the number of days p covers.
+func (p ReportPeriod) Days() int {
+	return int(p.End.Sub(p.Start).Hours() / 24)
+}
+
+// Last is the final day p covers.
+func (p ReportPeriod) Last() time.Time {
+	return p.End.AddDate(0, 0, -1)
+}
+
+// Label names p as an ISO 8601 date: 2026-10-16, 2026-W42, 2026-10 or the
+// interval 2026-10-01--2026-10-15. Labels key the report archive.
+func (p ReportPeriod) Label() string {
+	switch p.Kind {
+	case PeriodWeekly:
+		year, week := p.Start.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case PeriodMonthly:
+		return p.Start.Format("2006-01")
+	case PeriodCustom:
+		return p.Start.Format("2006-01-02") + "--" + p.Last().Format("2006-01-02")
+	}
+	return p.Start.Format("2006-01-02")
+}
+
+// Describe spells out the days p covers.
+func (p ReportPeriod) Describe() string {
+	if p.Days() == 1 {
+		return p.Start.Format("2006-01-02")
+	}
+	return p.Start.Format("2006-01-02") + " to " + p.Last().Format("2006-01-02")
+}
+
+// Title is the report's name, e.g. "Weekly Crypto Market Report".
+func (p ReportPeriod) Title() string {
+	switch p.Kind {
+	case PeriodWeekly:
+		return "Weekly Crypto Market Report"
+	case PeriodMonthly:
+		return "Monthly Crypto Market Report"
+	case PeriodCustom:
+		return "Crypto Market Report"
+	}
+	return "Daily Crypto Market Report"
+}
+
+// parsePeriodLabel is the inverse of Label.
+func parsePeriodLabel(label string) (ReportPeriod, error) {
+	invalid := fmt.Errorf("invalid report date %q, want YYYY-MM-DD, YYYY-Www, YYYY-MM or YYYY-MM-DD--YYYY-MM-DD", label)
+	if from, to, ok := strings.Cut(label, "--"); ok {
+		start, err1 := time.Parse("2006-01-02", from)
+		end, err2 := time.Parse("2006-01-02", to)
+		if err1 != nil || err2 != nil {
+			return ReportPeriod{}, invalid
+		}
+		return customPeriod(start, end)
+	}
+	if year, week, ok := strings.Cut(label, "-W"); ok {
+		y, err1 := strconv.Atoi(year)
+		w, err2 := strconv.Atoi(week)
+		if err1 != nil || err2 != nil || len(year) != 4 || len(week) != 2 {
+			return ReportPeriod{}, invalid
+		}
+		// Week 1 is the week with January 4th in it.
+		jan4 := time.Date(y, 1, 4, 0, 0, 0, 0, time.UTC)
+		p, _ := periodContaining(PeriodWeekly, jan4.AddDate(0, 0, 7*(w-1)))
+		if gy, gw := p.Start.ISOWeek(); gy != y || gw != w {
+			return ReportPeriod{}, invalid
+		}
+		return p, nil
+	}
+	if t, err := time.Parse("2006-01-02", label); err == nil {
+		return periodContaining(PeriodDaily, t)
+	}
+	if t, err := time.Parse("2006-01", label); err == nil {
+		return periodContaining(PeriodMonthly, t)
+	}
+	return ReportPeriod{}, invalid
+}
+
+// periodFromRequest reads ?period (default daily) with ?date, any day in
+// the period, or for custom reports ?start and ?end. Without a date the
+// last complete period is used.
+func periodFromRequest(r *http.Request, now time.Time) (ReportPeriod, error) {
+	q := r.URL.Query()
+	kind := q.Get("period")
+	if kind == "" {
+		kind = PeriodDaily
+	}
+	if kind == PeriodCustom {
+		start, err := time.Parse("2006-01-02", q.Get("start"))
+		if err != nil {
+			return ReportPeriod{}, fmt.Errorf("custom reports need start as YYYY-MM-DD")
+		}
+		end, err := time.Parse("2006-01-02", q.Get("end"))
+		if err != nil {
+			return ReportPeriod{}, fmt.Errorf("custom reports need end as YYYY-MM-DD")
+		}
+		return customPeriod(start, end)
+	}
+	if v := q.Get("date"); v != "" {
+		day, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return ReportPeriod{}, fmt.Errorf("invalid date %q, want YYYY-MM-DD", v)
+		}
+		return periodContaining(kind, day)
+	}
+	return lastCompletePeriod(kind, now)
+}
+
+// PeriodReturn is a coin's change over one part of a report period.
+type PeriodReturn struct {
+	Label     string  `json:"period"`
+	ChangePct float64 `json:"change_pct"`
+}
+
+// returnBuckets splits p into the parts its returns are reported by: days
+// for a week or a custom span of up to a month, weeks otherwise. Single
+// days are not split.
+func returnBuckets(p ReportPeriod) []ReportPeriod {
+	kind := PeriodDaily
+	switch {
+	case p.Days() <= 1:
+		return nil
+	case p.Kind == PeriodMonthly, p.Days() > 31:
+		kind = PeriodWeekly
+	}
+	var out []ReportPeriod
+	for d := p.Start; d.Before(p.End); {
+		b, _ := periodContaining(kind, d)
+		// Weeks at the edges are clipped to the period.
+		if b.Start.Before(p.Start) {
+			b.Start = p.Start
+		}
+		if b.End.After(p.End) {
+			b.End = p.End
+		}
+		out = append(out, b)
+		d = b.End
+	}
+	return out
+}
+
+// periodMetrics fills the multi-day measures of in from series: the
+// largest peak-to-trough fall, and for periods longer than a day the
+// best and worst day and the returns per part of the period. Each day's
+// return is its close against the previous close, or against the
+// period's first price on the first day.
+func periodMetrics(in *Insight, series []PricePoint, p ReportPeriod) {
+	if len(series) == 0 {
+		return
+	}
+	peak := series[0].Price
+	for _, pt := range series {
+		if pt.Price > peak {
+			peak = pt.Price
+		}
+		if peak > 0 {
+			in.MaxDrawdownPct = math.Max(in.MaxDrawdownPct, (peak-pt.Price)/peak*100)
+		}
+	}
+	if p.Days() <= 1 {
+		return
+	}
+
+	// closeBy returns the last price before t, or the first price.
+	closeBy := func(t time.Time) float64 {
+		price := series[0].Price
+		for _, pt := range series {
+			if !pt.Timestamp.Before(t) {
+				break
+			}
+			price = pt.Price
+		}
+		return price
+	}
+	change := func(from, to float64) float64 {
+		if from == 0 {
+			return 0
+		}
+		return (to - from) / from * 100
+	}
+
+	first := true
+	for d := p.Start; d.Before(p.End); d = d.AddDate(0, 0, 1) {
+		if d.After(series[len(series)-1].Timestamp) || !d.AddDate(0, 0, 1).After(series[0].Timestamp) {
+			continue
+		}
+		pct := change(closeBy(d), closeBy(d.AddDate(0, 0, 1)))
+		day := d.Format("2006-01-02")
+		if first || pct > in.BestDayPct {
+			in.BestDay, in.BestDayPct = day, pct
+		}
+		if first || pct < in.WorstDayPct {
+			in.WorstDay, in.WorstDayPct = day, pct
+		}
+		first = false
+	}
+
+	for _, b := range returnBuckets(p) {
+		if b.Start.After(series[len(series)-1].Timestamp) {
+			break
+		}
+		label := b.Start.Format("2006-01-02")
+		if b.Days() > 1 {
+			label = b.Start.Format("2006-01-02") + "--" + b.Last().Format("2006-01-02")
+		}
+		in.Returns = append(in.Returns, PeriodReturn{Label: label, ChangePct: change(closeBy(b.Start), closeBy(b.End))})
+	}
+}
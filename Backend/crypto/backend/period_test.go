@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func day(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParsePeriodLabel(t *testing.T) {
+	tests := []struct {
+		label, kind, start, end string
+	}{
+		{"2026-10-16", PeriodDaily, "2026-10-16", "2026-10-17"},
+		{"2026-W42", PeriodWeekly, "2026-10-12", "2026-10-19"},
+		// Week 1 holds January 4th, so it can start in December.
+		{"2026-W01", PeriodWeekly, "2025-12-29", "2026-01-05"},
+		{"2020-W53", PeriodWeekly, "2020-12-28", "2021-01-04"},
+		{"2026-10", PeriodMonthly, "2026-10-01", "2026-11-01"},
+		{"2024-02", PeriodMonthly, "2024-02-01", "2024-03-01"},
+		{"2026-10-01--2026-10-15", PeriodCustom, "2026-10-01", "2026-10-16"},
+		{"2026-10-01--2026-10-01", PeriodCustom, "2026-10-01", "2026-10-02"},
+	}
+	for _, tt := range tests {
+		p, err := parsePeriodLabel(tt.label)
+		if err != nil {
+			t.Errorf("%s: %v", tt.label, err)
+			continue
+		}
+		want := ReportPeriod{tt.kind, day(tt.start), day(tt.end)}
+		if p != want {
+			t.Errorf("%s = %+v, want %+v", tt.label, p, want)
+		}
+		if p.Label() != tt.label {
+			t.Errorf("%s labels itself %s", tt.label, p.Label())
+		}
+	}
+
+	for _, label := range []string{
+		"", "yesterday", "2026-13", "2026-02-30", "2026-W1", "2026-W00", "2021-W53",
+		"26-W10", "2026-10-15--2026-10-01", "2026-10-01--", "2025-01-01--2026-06-01",
+	} {
+		if p, err := parsePeriodLabel(label); err == nil {
+			t.Errorf("%q parsed as %+v", label, p)
+		}
+	}
+}
+
+func TestPeriodMetrics(t *testing.T) {
+	at := func(date string, hour int) time.Time { return day(date).Add(time.Duration(hour) * time.Hour) }
+	// No reading on Thursday the 15th or Saturday the 17th.
+	series := []PricePoint{
+		{Timestamp: at("2026-10-12", 0), Price: 100},
+		{Timestamp: at("2026-10-12", 12), Price: 110},
+		{Timestamp: at("2026-10-13", 12), Price: 99},
+		{Timestamp: at("2026-10-14", 12), Price: 121},
+		{Timestamp: at("2026-10-16", 12), Price: 110},
+		{Timestamp: at("2026-10-18", 12), Price: 132},
+	}
+	week, _ := periodContaining(PeriodWeekly, day("2026-10-14"))
+
+	var in Insight
+	periodMetrics(&in, series, week)
+	if math.Abs(in.MaxDrawdownPct-10) > 1e-9 {
+		t.Errorf("drawdown = %v, want 10 (110 to 99)", in.MaxDrawdownPct)
+	}
+	if in.BestDay != "2026-10-14" || math.Abs(in.BestDayPct-200.0/9) > 1e-9 {
+		t.Errorf("best day = %s %v, want 2026-10-14 +22.22", in.BestDay, in.BestDayPct)
+	}
+	if in.WorstDay != "2026-10-13" || math.Abs(in.WorstDayPct+10) > 1e-9 {
+		t.Errorf("worst day = %s %v, want 2026-10-13 -10", in.WorstDay, in.WorstDayPct)
+	}
+	// A day without readings carries the previous close.
+	want := []float64{10, -10, 200.0 / 9, 0, -100.0 / 11, 0, 20}
+	if len(in.Returns) != len(want) {
+		t.Fatalf("returns = %+v, want one per day", in.Returns)
+	}
+	for i, r := range in.Returns {
+		if label := week.Start.AddDate(0, 0, i).Format("2006-01-02"); r.Label != label || math.Abs(r.ChangePct-want[i]) > 1e-9 {
+			t.Errorf("return %d = %+v, want %s %v", i, r, label, want[i])
+		}
+	}
+
+	// A single day only gets the drawdown.
+	var daily Insight
+	periodMetrics(&daily, series[:3], ReportPeriod{PeriodDaily, day("2026-10-12"), day("2026-10-13")})
+	if daily.BestDay != "" || daily.WorstDay != "" || daily.Returns != nil || math.Abs(daily.MaxDrawdownPct-10) > 1e-9 {
+		t.Errorf("daily = %+v", daily)
+	}
+
+	var empty Insight
+	periodMetrics(&empty, nil, week)
+	if !reflect.DeepEqual(empty, Insight{}) {
+		t.Errorf("no series filled %+v", empty)
+	}
+}
+
+func TestPeriodMetricsMonthlyReturns(t *testing.T) {
+	// October 2026 starts on a Thursday; its weeks are clipped to the
+	// month and those after the last reading are left out.
+	series := []PricePoint{
+		{Timestamp: day("2026-10-02"), Price: 100},
+		{Timestamp: day("2026-10-20"), Price: 150},
+	}
+	month, _ := periodContaining(PeriodMonthly, day("2026-10-09"))
+
+	var in Insight
+	periodMetrics(&in, series, month)
+	want := []PeriodReturn{
+		{"2026-10-01--2026-10-04", 0},
+		{"2026-10-05--2026-10-11", 0},
+		{"2026-10-12--2026-10-18", 0},
+		{"2026-10-19--2026-10-25", 50},
+	}
+	if !reflect.DeepEqual(in.Returns, want) {
+		t.Errorf("returns = %+v, want %+v", in.Returns, want)
+	}
+	if in.BestDay != "2026-10-20" || in.BestDayPct != 50 || in.MaxDrawdownPct != 0 {
+		t.Errorf("metrics = %+v", in)
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Portfolio is a named set of holdings. UserID is the owning account, and
+// only that account's API keys can reach it. Portfolios created anonymously,
+// before accounts were required, are the legacy kind: KeyHash is the hash of
+// the access key returned when the portfolio was created, and every
+// portfolio route requires the key in X-Portfolio-Key.
+type Portfolio struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id,omitempty"`
+	Name      string    `json:"name"`
+	KeyHash   string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Holding is a position bought at AcquiredAt. CostBasisUSD is the total paid
+// for Quantity, fees included.
+type Holding struct {
+	ID           string    `json:"id"`
+	PortfolioID  string    `json:"portfolio_id"`
+	CoinID       string    `json:"coin_id"`
+	Quantity     float64   `json:"quantity"`
+	CostBasisUSD float64   `json:"cost_basis_usd"`
+	AcquiredAt   time.Time `json:"acquired_at"`
+}
+
+// PortfolioStore persists portfolios and their holdings.
+type PortfolioStore interface {
+	// Portfolio returns one portfolio, or ErrNotFound.
+	Portfolio(id string) (Portfolio, error)
+	// Portfolios returns the portfolios of an account, oldest first.
+	Portfolios(userID string) ([]Portfolio, error)
+	// PutPortfolio adds or replaces a portfolio by ID.
+	PutPortfolio(p Portfolio) error
+	// DeletePortfolio removes a portfolio and its holdings.
+	DeletePortfolio(id string) error
+	// Holdings returns the holdings of a portfolio, oldest first.
+	Holdings(portfolioID string) ([]Holding, error)
+	// PutHolding adds or replaces a holding by portfolio and ID.
+	PutHolding(h Holding) error
+	// DeleteHolding removes one holding.
+	DeleteHolding(portfolioID, holdingID string) error
+}
+
+// HoldingValue is a holding priced at a point in time.
+type HoldingValue struct {
+	Holding
+	PriceUSD         float64   `json:"price_usd"`
+	PricedAt         time.Time `json:"priced_at"`
+	ValueUSD         float64   `json:"value_usd"`
+	UnrealizedPnLUSD float64   `json:"unrealized_pnl_usd"`
+	UnrealizedPnLPct float64   `json:"unrealized_pnl_pct"`
+	AllocationPct    float64   `json:"allocation_pct"`
+	MissingPrice     bool      `json:"missing_price,omitempty"`
+}
+
+// CoinAllocation sums the holdings of one coin.
+type CoinAllocation struct {
+	CoinID        string  `json:"coin_id"`
+	Quantity      float64 `json:"quantity"`
+	ValueUSD      float64 `json:"value_usd"`
+	CostBasisUSD  float64 `json:"cost_basis_usd"`
+	AllocationPct float64 `json:"allocation_pct"`
+}
+
+// PortfolioValuation is a portfolio priced at At.
+type PortfolioValuation struct {
+	Portfolio
+	At               time.Time        `json:"at"`
+	ValueUSD         float64          `json:"value_usd"`
+	CostBasisUSD     float64          `json:"cost_basis_usd"`
+	UnrealizedPnLUSD float64          `json:"unrealized_pnl_usd"`
+	UnrealizedPnLPct float64          `json:"unrealized_pnl_pct"`
+	Holdings         []HoldingValue   `json:"holdings"`
+	Allocation       []CoinAllocation `json:"allocation"`
+}
+
+// PortfolioPoint is one step of a value curve. Only holdings acquired by T
+// are counted.
+type PortfolioPoint struct {
+	T            time.Time `json:"t"`
+	ValueUSD     float64   `json:"value_usd"`
+	CostBasisUSD float64   `json:"cost_basis_usd"`
+	PnLUSD       float64   `json:"pnl_usd"`
+}
+
+// pnlPct is pnl as a percentage of cost, or 0 without a cost.
+func pnlPct(pnl, cost float64) float64 {
+	if cost <= 0 {
+		return 0
+	}
+	return pnl / cost * 100
+}
+
+// valuePortfolio prices holdings at the latest stored price, or at the
+// price at or before at when at is non-zero. Holdings without a price are
+// reported with MissingPrice and count as zero.
+func valuePortfolio(p Portfolio, holdings []Holding, at time.Time) (PortfolioValuation, error) {
+	v := PortfolioValuation{Portfolio: p, At: at, Holdings: []HoldingValue{}, Allocation: []CoinAllocation{}}
+	if at.IsZero() {
+		v.At = time.Now().UTC()
+	}
+
+	prices := make(map[string]PriceData)
+	byCoin := make(map[string]*CoinAllocation)
+	for _, h := range holdings {
+		if !at.IsZero() && h.AcquiredAt.After(at) {
+			continue
+		}
+		price, ok := prices[h.CoinID]
+		if !ok {
+			var err error
+			if at.IsZero() {
+				price, err = store.Latest(h.CoinID)
+			} else {
+				price, err = store.At(h.CoinID, at)
+			}
+			if err != nil && err != ErrNotFound {
+				return v, err
+			}
+			prices[h.CoinID] = price
+		}
+
+		hv := HoldingValue{Holding: h, PriceUSD: price.PriceUSD, PricedAt: price.Timestamp, MissingPrice: price.PriceUSD == 0}
+		hv.ValueUSD = h.Quantity * price.PriceUSD
+		hv.UnrealizedPnLUSD = hv.ValueUSD - h.CostBasisUSD
+		hv.UnrealizedPnLPct = pnlPct(hv.UnrealizedPnLUSD, h.CostBasisUSD)
+		v.Holdings = append(v.Holdings, hv)
+
+		v.ValueUSD += hv.ValueUSD
+		v.CostBasisUSD += h.CostBasisUSD
+
+		a := byCoin[h.CoinID]
+		if a == nil {
+			a = &CoinAllocation{CoinID: h.CoinID}
+			byCoin[h.CoinID] = a
+		}
+		a.Quantity += h.Quantity
+		a.ValueUSD += hv.ValueUSD
+		a.CostBasisUSD += h.CostBasisUSD
+	}
+
+	v.UnrealizedPnLUSD = v.ValueUSD - v.CostBasisUSD
+	v.UnrealizedPnLPct = pnlPct(v.UnrealizedPnLUSD, v.CostBasisUSD)
+	for i := range v.Holdings {
+		if v.ValueUSD > 0 {
+			v.Holdings[i].AllocationPct = v.Holdings[i].ValueUSD / v.ValueUSD * 100
+		}
+	}
+	for _, a := range byCoin {
+		if v.ValueUSD > 0 {
+			a.AllocationPct = a.ValueUSD / v.ValueUSD * 100
+		}
+		v.Allocation = append(v.Allocation, *a)
+	}
+	sort.Slice(v.Allocation, func(i, j int) bool { return v.Allocation[i].ValueUSD > v.Allocation[j].ValueUSD })
+	return v, nil
+}
+
+// portfolioCurve builds the value of holdings between start and end from
+// each coin's rangeSeries, so interval picks candle closes the same way as
+// /volatility and /trend. Every coin's last known price is carried forward
+// to the timestamps of the others.
+func portfolioCurve(holdings []Holding, interval string, start, end time.Time) ([]PortfolioPoint, error) {
+	type series struct {
+		ts     []time.Time
+		prices []float64
+	}
+	byCoin := make(map[string]series)
+	stamps := make(map[int64]time.Time)
+	for _, h := range holdings {
+		if _, ok := byCoin[h.CoinID]; ok {
+			continue
+		}
+		// Reach back one bucket so the first point has a price to carry.
+		from := start
+		if d, ok := candleIntervals[interval]; ok {
+			from = start.Add(-d)
+		}
+		ts, prices, err := rangeSeries(h.CoinID, interval, from, end)
+		if err != nil {
+			return nil, err
+		}
+		byCoin[h.CoinID] = series{ts, prices}
+		for _, t := range ts {
+			if !t.Before(start) {
+				stamps[t.UnixNano()] = t
+			}
+		}
+	}
+
+	times := make([]time.Time, 0, len(stamps))
+	for _, t := range stamps {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	out := make([]PortfolioPoint, 0, len(times))
+	for _, t := range times {
+		pt := PortfolioPoint{T: t}
+		for _, h := range holdings {
+			if h.AcquiredAt.After(t) {
+				continue
+			}
+			s := byCoin[h.CoinID]
+			i := sort.Search(len(s.ts), func(i int) bool { return s.ts[i].After(t) })
+			if i > 0 {
+				pt.ValueUSD += h.Quantity * s.prices[i-1]
+			}
+			pt.CostBasisUSD += h.CostBasisUSD
+		}
+		pt.PnLUSD = pt.ValueUSD - pt.CostBasisUSD
+		out = append(out, pt)
+	}
+	return out, nil
+}
+
+// validate checks a holding from the API and resolves its coin.
+func (h *Holding) validate() error {
+	c, ok := registry.Resolve(strings.TrimSpace(h.CoinID))
+	if !ok {
+		return fmt.Errorf("unknown coin %q", h.CoinID)
+	}
+	h.CoinID = c.ID
+	if h.Quantity <= 0 || math.IsInf(h.Quantity, 0) || math.IsNaN(h.Quantity) {
+		return fmt.Errorf("quantity must be positive")
+	}
+	if h.CostBasisUSD < 0 || math.IsInf(h.CostBasisUSD, 0) || math.IsNaN(h.CostBasisUSD) {
+		return fmt.Errorf("cost_basis_usd must not be negative")
+	}
+	if h.AcquiredAt.IsZero() {
+		h.AcquiredAt = time.Now().UTC()
+	}
+	if h.AcquiredAt.After(time.Now().Add(time.Minute)) {
+		return fmt.Errorf("acquired_at is in the future")
+	}
+	return nil
+}
+
+// withPortfolio loads {id} and checks the caller may use it: the owning
+// account for account portfolios, X-Portfolio-Key for legacy ones.
+func withPortfolio(next func(w http.ResponseWriter, r *http.Request, p Portfolio)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := store.Portfolio(mux.Vars(r)["id"])
+		if err == ErrNotFound {
+			http.Error(w, "Portfolio not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Portfolio query error: %v", err)
+			http.Error(w, "Query error", http.StatusInternalServerError)
+			return
+		}
+		allowed := false
+		if p.UserID != "" {
+			u, ok := callerAccount(r)
+			allowed = ok && u.ID == p.UserID
+		} else if key := r.Header.Get("X-Portfolio-Key"); key != "" {
+			allowed = subtle.ConstantTimeCompare([]byte(hashKey(key)), []byte(p.KeyHash)) == 1
+		}
+		if !allowed {
+			// Same answer as a missing portfolio, so ids cannot be probed.
+			http.Error(w, "Portfolio not found", http.StatusNotFound)
+			return
+		}
+		next(w, r, p)
+	}
+}
+
+// createPortfolio handles POST /portfolios with {"name": ...}, creating a
+// portfolio owned by the caller's account. Legacy key-owned portfolios can
+// still be used but no longer created, since nothing would limit how many
+// an anonymous caller makes.
+func createPortfolio(w http.ResponseWriter, r *http.Request) {
+	u, ok := callerAccount(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="crypto-dashboard"`)
+		http.Error(w, "An account's API key is required to create a portfolio", http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	p := Portfolio{ID: uuid.New().String(), UserID: u.ID, Name: name, CreatedAt: time.Now().UTC()}
+	if err := store.PutPortfolio(p); err != nil {
+		log.Printf("Error saving portfolio: %v", err)
+		http.Error(w, "Failed to save portfolio", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"portfolio": p})
+}
+
+// listPortfolios handles GET /portfolios, the caller's account portfolios.
+func listPortfolios(w http.ResponseWriter, r *http.Request) {
+	u, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+	list, err := store.Portfolios(u.ID)
+	if err != nil {
+		log.Printf("Portfolio query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []Portfolio{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// getPortfolio handles GET /portfolios/{id}, the portfolio valued now. With
+// ?at= (RFC3339) it is valued at that time instead, counting only holdings
+// acquired by then.
+func getPortfolio(w http.ResponseWriter, r *http.Request, p Portfolio) {
+	var at time.Time
+	if v := r.URL.Query().Get("at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid at time", http.StatusBadRequest)
+			return
+		}
+		at = t
+	}
+
+	holdings, err := store.Holdings(p.ID)
+	if err != nil {
+		log.Printf("Holdings query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	v, err := valuePortfolio(p, holdings, at)
+	if err != nil {
+		log.Printf("Portfolio valuation error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// renamePortfolio handles PUT /portfolios/{id} with {"name": ...}.
+func renamePortfolio(w http.ResponseWriter, r *http.Request, p Portfolio) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Name) == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	p.Name = strings.TrimSpace(body.Name)
+	if err := store.PutPortfolio(p); err != nil {
+		log.Printf("Error saving portfolio: %v", err)
+		http.Error(w, "Failed to save portfolio", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// deletePortfolio handles DELETE /portfolios/{id}.
+func deletePortfolio(w http.ResponseWriter, r *http.Request, p Portfolio) {
+	if err := store.DeletePortfolio(p.ID); err != nil {
+		log.Printf("Error deleting portfolio: %v", err)
+		http.Error(w, "Failed to delete portfolio", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listHoldings handles GET /portfolios/{id}/holdings.
+func listHoldings(w http.ResponseWriter, r *http.Request, p Portfolio) {
+	holdings, err := store.Holdings(p.ID)
+	if err != nil {
+		log.Printf("Holdings query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	if holdings == nil {
+		holdings = []Holding{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(holdings)
+}
+
+// addHolding handles POST /portfolios/{id}/holdings.
+func addHolding(w http.ResponseWriter, r *http.Request, p Portfolio) {
+	var h Holding
+	if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := h.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.ID = uuid.New().String()
+	h.PortfolioID = p.ID
+
+	if err := store.PutHolding(h); err != nil {
+		log.Printf("Error saving holding: %v", err)
+		http.Error(w, "Failed to save holding", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h)
+}
+
+// findHolding returns the {holding_id} holding of p.
+func findHolding(w http.ResponseWriter, r *http.Request, p Portfolio) (Holding, bool) {
+	holdings, err := store.Holdings(p.ID)
+	if err != nil {
+		log.Printf("Holdings query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return Holding{}, false
+	}
+	id := mux.Vars(r)["holding_id"]
+	for _, h := range holdings {
+		if h.ID == id {
+			return h, true
+		}
+	}
+	http.Error(w, "Holding not found", http.StatusNotFound)
+	return Holding{}, false
+}
+
+// updateHolding handles PUT /portfolios/{id}/holdings/{holding_id}. Fields
+// left out of the body keep their current values.
+func updateHolding(w http.ResponseWriter, r *http.Request, p Portfolio) {
+	old, ok := findHolding(w, r, p)
+	if !ok {
+		return
+	}
+	h := old
+	if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	h.ID, h.PortfolioID = old.ID, old.PortfolioID
+	if err := h.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := store.PutHolding(h); err != nil {
+		log.Printf("Error saving holding: %v", err)
+		http.Error(w, "Failed to save holding", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
+
+// deleteHolding handles DELETE /portfolios/{id}/holdings/{holding_id}.
+func deleteHolding(w http.ResponseWriter, r *http.Request, p Portfolio) {
+	h, ok := findHolding(w, r, p)
+	if !ok {
+		return
+	}
+	if err := store.DeleteHolding(p.ID, h.ID); err != nil {
+		log.Printf("Error deleting holding: %v", err)
+		http.Error(w, "Failed to delete holding", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getPortfolioHistory handles GET /portfolios/{id}/history?start=&end=&interval=.
+// start and end are RFC3339 and default to the last 7 days; interval is a
+// candle size and defaults to 1h.
+func getPortfolioHistory(w http.ResponseWriter, r *http.Request, p Portfolio) {
+	q := r.URL.Query()
+	end := time.Now().UTC()
+	if v := q.Get("end"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid end time", http.StatusBadRequest)
+			return
+		}
+		end = t
+	}
+	start := end.Add(-7 * 24 * time.Hour)
+	if v := q.Get("start"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid start time", http.StatusBadRequest)
+			return
+		}
+		start = t
+	}
+	interval := q.Get("interval")
+	if interval == "" {
+		interval = "1h"
+	}
+	if _, ok := candleIntervals[interval]; !ok && interval != "raw" {
+		http.Error(w, "Invalid interval (use 1m, 5m, 1h, 1d or raw)", http.StatusBadRequest)
+		return
+	}
+	label := interval
+	if interval == "raw" {
+		interval = ""
+	}
+
+	holdings, err := store.Holdings(p.ID)
+	if err != nil {
+		log.Printf("Holdings query error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	points, err := portfolioCurve(holdings, interval, start, end)
+	if err != nil {
+		log.Printf("Portfolio history error: %v", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"portfolio_id": p.ID,
+		"start":        start,
+		"end":          end,
+		"interval":     label,
+		"points":       points,
+	})
+}
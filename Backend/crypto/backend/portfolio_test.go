@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCreatePortfolioNeedsAccount(t *testing.T) {
+	s := useMemoryStore(t)
+	u := User{ID: "u1", Email: "a@example.com", Role: RoleUser}
+	s.PutUser(u)
+	_, key, err := issueAPIKey(u.ID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/portfolios", strings.NewReader(`{"name": "Long term"}`))
+		if apiKey != "" {
+			r.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		w := httptest.NewRecorder()
+		authenticate(http.HandlerFunc(createPortfolio)).ServeHTTP(w, r)
+		return w
+	}
+
+	if w := create(""); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: %d %s", w.Code, w.Body)
+	}
+	t.Setenv("ADMIN_TOKEN", "adm")
+	r := httptest.NewRequest(http.MethodPost, "/portfolios", strings.NewReader(`{"name": "Long term"}`))
+	r.Header.Set("X-Admin-Token", "adm")
+	w := httptest.NewRecorder()
+	authenticate(http.HandlerFunc(createPortfolio)).ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("admin token: %d %s", w.Code, w.Body)
+	}
+	if list, _ := s.Portfolios(""); len(list) != 0 {
+		t.Fatalf("%d portfolios without an owner", len(list))
+	}
+
+	w = create(key)
+	var resp struct {
+		Portfolio Portfolio `json:"portfolio"`
+		Key       string    `json:"key"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("with an account: %d %v", w.Code, err)
+	}
+	if resp.Portfolio.UserID != u.ID || resp.Key != "" {
+		t.Errorf("created %+v with key %q", resp.Portfolio, resp.Key)
+	}
+}
+
+func TestLegacyPortfolioKey(t *testing.T) {
+	s := useMemoryStore(t)
+	legacy := Portfolio{ID: "p1", Name: "Old", KeyHash: hashKey("secret"), CreatedAt: time.Now()}
+	s.PutPortfolio(legacy)
+
+	for _, tt := range []struct {
+		key  string
+		want int
+	}{
+		{"secret", http.StatusOK},
+		{"wrong", http.StatusNotFound},
+		{"", http.StatusNotFound},
+	} {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodPut, "/portfolios/p1", strings.NewReader(`{"name": "Renamed"}`)), map[string]string{"id": "p1"})
+		if tt.key != "" {
+			r.Header.Set("X-Portfolio-Key", tt.key)
+		}
+		w := httptest.NewRecorder()
+		withPortfolio(renamePortfolio)(w, r)
+		if w.Code != tt.want {
+			t.Errorf("key %q: %d %s, want %d", tt.key, w.Code, w.Body, tt.want)
+		}
+	}
+}
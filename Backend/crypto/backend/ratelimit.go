@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimit is a token bucket: Burst requests at once, refilled at Rate per
+// second.
+type rateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// String formats l the way RATE_LIMITS takes it, preferring the unit whose
+// count equals the burst ("10/m:10") and otherwise the smallest unit that
+// gives a whole count.
+func (l rateLimit) String() string {
+	units := []struct {
+		name string
+		secs float64
+	}{{"s", 1}, {"m", 60}, {"h", 3600}, {"d", 86400}}
+	whole := func(n float64) bool { return n >= 1 && math.Abs(n-math.Round(n)) < 1e-9 }
+	for _, u := range units {
+		if n := l.Rate * u.secs; whole(n) && math.Round(n) == l.Burst {
+			return fmt.Sprintf("%g/%s", l.Burst, u.name)
+		}
+	}
+	for _, u := range units {
+		if n := l.Rate * u.secs; whole(n) {
+			return fmt.Sprintf("%g/%s:%g", math.Round(n), u.name, l.Burst)
+		}
+	}
+	return fmt.Sprintf("%g/d:%g", l.Rate*86400, l.Burst)
+}
+
+// rateBudget is a named limit shared by a set of routes. Anonymous callers
+// get PerIP, callers with an API key get PerKey.
+type rateBudget struct {
+	Name   string
+	Routes []string
+	PerIP  rateLimit
+	PerKey rateLimit
+}
+
+func perMinute(n float64) rateLimit { return rateLimit{Rate: n / 60, Burst: n} }
+func perHour(n float64) rateLimit   { return rateLimit{Rate: n / 3600, Burst: n} }
+
+// defaultRateBudgets keep the routes that call OpenAI or send email much
+// tighter than plain reads. Routes not listed use "default".
+var defaultRateBudgets = []rateBudget{
+	{Name: "default", PerIP: perMinute(120), PerKey: perMinute(600)},
+	{Name: "ask", Routes: []string{"/ask"}, PerIP: perMinute(10), PerKey: perMinute(30)},
+	{Name: "report", Routes: []string{"/generate-report"}, PerIP: perHour(2), PerKey: perHour(6)},
+	{Name: "subscribe", Routes: []string{"/subscribe", "/unsubscribe", "/alerts/key", "/subscription/key"}, PerIP: perHour(5), PerKey: perHour(20)},
+}
+
+// rateLimitExempt routes are never limited: health checks and the
+// ingestion worker's token-protected push.
+var rateLimitExempt = map[string]bool{
+	"/ping":           true,
+	"/internal/ticks": true,
+}
+
+// configuredRateBudgets applies RATE_LIMITS to the defaults. It is a comma
+// separated list of budget=count/unit[:burst], where unit is s, m, h or d and
+// burst defaults to count; "budget.key=" sets the limit for API keys instead
+// of IPs, e.g. "ask=5/m,ask.key=60/m,subscribe=3/h:1". RATE_LIMITS=off
+// disables limiting. On Render every request arrives from its proxy, so
+// limiting there requires TRUST_PROXY_HEADERS=true; without it every
+// anonymous client would share one bucket.
+func configuredRateBudgets() ([]rateBudget, error) {
+	budgets := append([]rateBudget(nil), defaultRateBudgets...)
+	v := strings.TrimSpace(os.Getenv("RATE_LIMITS"))
+	if v == "off" {
+		return nil, nil
+	}
+	if os.Getenv("RENDER") != "" && os.Getenv("TRUST_PROXY_HEADERS") != "true" {
+		return nil, fmt.Errorf("TRUST_PROXY_HEADERS must be true behind Render's proxy")
+	}
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid RATE_LIMITS entry %q", item)
+		}
+		name, forKey := strings.CutSuffix(strings.TrimSpace(name), ".key")
+		lim, err := parseRateLimit(spec)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMITS %s: %v", name, err)
+		}
+		found := false
+		for i := range budgets {
+			if budgets[i].Name == name {
+				if forKey {
+					budgets[i].PerKey = lim
+				} else {
+					budgets[i].PerIP = lim
+				}
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("RATE_LIMITS: unknown budget %q", name)
+		}
+	}
+	return budgets, nil
+}
+
+// parseRateLimit reads count/unit[:burst].
+func parseRateLimit(spec string) (rateLimit, error) {
+	spec, burstStr, hasBurst := strings.Cut(strings.TrimSpace(spec), ":")
+	countStr, unit, ok := strings.Cut(spec, "/")
+	if !ok {
+		return rateLimit{}, fmt.Errorf("invalid limit %q", spec)
+	}
+	count, err := strconv.ParseFloat(countStr, 64)
+	if err != nil || count <= 0 {
+		return rateLimit{}, fmt.Errorf("invalid count %q", countStr)
+	}
+	per := map[string]float64{"s": 1, "m": 60, "h": 3600, "d": 86400}[unit]
+	if per == 0 {
+		return rateLimit{}, fmt.Errorf("invalid unit %q (use s, m, h or d)", unit)
+	}
+	lim := rateLimit{Rate: count / per, Burst: count}
+	if hasBurst {
+		b, err := strconv.ParseFloat(burstStr, 64)
+		if err != nil || b < 1 {
+			return rateLimit{}, fmt.Errorf("invalid burst %q", burstStr)
+		}
+		lim.Burst = b
+	}
+	return lim, nil
+}
+
+type bucketKey struct {
+	budget string
+	client string
+}
+
+type tokenBucket struct {
+	limit   rateLimit
+	tokens  float64
+	last    time.Time
+	allowed int64
+	limited int64
+}
+
+// refill adds the tokens earned since the last request.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.limit.Burst, b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+type rateCounter struct {
+	Allowed int64 `json:"allowed"`
+	Limited int64 `json:"limited"`
+}
+
+// rateLimiter holds a bucket per budget and client. Idle buckets that have
+// refilled completely are dropped, so memory follows the active clients;
+// the per-budget totals cover the life of the process.
+type rateLimiter struct {
+	mu        sync.Mutex
+	budgets   map[string]rateBudget
+	routes    map[string]string
+	buckets   map[bucketKey]*tokenBucket
+	totals    map[string]*rateCounter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// limiter is the API's rate limiter; nil when limiting is disabled.
+var limiter *rateLimiter
+
+func newRateLimiter(budgets []rateBudget) *rateLimiter {
+	if len(budgets) == 0 {
+		return nil
+	}
+	l := &rateLimiter{
+		budgets: make(map[string]rateBudget),
+		routes:  make(map[string]string),
+		buckets: make(map[bucketKey]*tokenBucket),
+		totals:  make(map[string]*rateCounter),
+		now:     time.Now,
+	}
+	for _, b := range budgets {
+		l.budgets[b.Name] = b
+		l.totals[b.Name] = &rateCounter{}
+		for _, route := range b.Routes {
+			l.routes[route] = b.Name
+		}
+	}
+	return l
+}
+
+// clientIP returns the caller's address. Behind a proxy (TRUST_PROXY_HEADERS
+// =true) it is the last X-Forwarded-For entry, the one the proxy appended;
+// earlier entries come from the client and cannot be trusted.
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			parts := strings.Split(xff, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// client identifies the caller and picks the limit that applies to it.
+func (l *rateLimiter) client(r *http.Request, b rateBudget) (string, rateLimit) {
+	if p, ok := currentPrincipal(r); ok {
+		return "key:" + p.KeyID, b.PerKey
+	}
+	return "ip:" + clientIP(r), b.PerIP
+}
+
+// budgetFor maps a request to its budget by route template.
+func (l *rateLimiter) budgetFor(r *http.Request) (rateBudget, bool) {
+	tmpl := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if t, err := route.GetPathTemplate(); err == nil {
+			tmpl = t
+		}
+	}
+	if rateLimitExempt[tmpl] {
+		return rateBudget{}, false
+	}
+	name, ok := l.routes[tmpl]
+	if !ok {
+		name = "default"
+	}
+	b, ok := l.budgets[name]
+	return b, ok
+}
+
+// bucket returns the caller's bucket, refilled to now. l.mu must be held.
+func (l *rateLimiter) bucket(key bucketKey, lim rateLimit, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok || b.limit != lim {
+		b = &tokenBucket{limit: lim, tokens: lim.Burst, last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	return b
+}
+
+// take spends one token. When none is left it returns how long until one is.
+func (l *rateLimiter) take(budget, client string, lim rateLimit) (bool, float64, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b := l.bucket(bucketKey{budget, client}, lim, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		b.allowed++
+		l.totals[budget].Allowed++
+		return true, b.tokens, 0
+	}
+	b.limited++
+	l.totals[budget].Limited++
+	wait := time.Duration((1 - b.tokens) / lim.Rate * float64(time.Second))
+	return false, b.tokens, wait
+}
+
+// sweep drops buckets that have been idle long enough to be full again,
+// at most once a minute. l.mu must be held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if now.Sub(b.last).Seconds()*b.limit.Rate+b.tokens >= b.limit.Burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// middleware enforces the budgets. Every limited response carries
+// X-RateLimit-Budget, X-RateLimit-Limit and X-RateLimit-Remaining; a caller
+// over budget gets 429 with Retry-After in seconds. It runs after
+// authenticate so API keys are limited per key rather than per address.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := l.budgetFor(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, lim := l.client(r, b)
+		allowed, remaining, wait := l.take(b.Name, client, lim)
+
+		w.Header().Set("X-RateLimit-Budget", b.Name)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(lim.Burst)))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(remaining)))
+		if !allowed {
+			secs := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			http.Error(w, fmt.Sprintf("Rate limit exceeded for %s; retry in %d seconds", b.Name, secs), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateUsage is one bucket's state.
+type RateUsage struct {
+	Budget    string  `json:"budget"`
+	Client    string  `json:"client,omitempty"`
+	Limit     string  `json:"limit"`
+	Remaining float64 `json:"remaining"`
+	Allowed   int64   `json:"allowed"`
+	Limited   int64   `json:"limited"`
+}
+
+// usage reports the caller's bucket in every budget without spending tokens.
+func (l *rateLimiter) usage(r *http.Request) []RateUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	out := []RateUsage{}
+	for _, name := range l.budgetNames() {
+		b := l.budgets[name]
+		client, lim := l.client(r, b)
+		u := RateUsage{Budget: name, Limit: lim.String(), Remaining: lim.Burst}
+		if bk, ok := l.buckets[bucketKey{name, client}]; ok && bk.limit == lim {
+			bk.refill(now)
+			u.Remaining, u.Allowed, u.Limited = math.Floor(bk.tokens), bk.allowed, bk.limited
+		}
+		out = append(out, u)
+	}
+	return out
+}
+
+func (l *rateLimiter) budgetNames() []string {
+	names := make([]string, 0, len(l.budgets))
+	for name := range l.budgets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getRateLimit handles GET /rate-limit, the caller's remaining budget.
+func getRateLimit(w http.ResponseWriter, r *http.Request) {
+	if limiter == nil {
+		http.Error(w, "Rate limiting is disabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(limiter.usage(r))
+}
+
+// getRateLimitStats handles GET /admin/rate-limits: the configured budgets,
+// totals per budget since startup and the active clients, most limited
+// first (at most 100).
+func getRateLimitStats(w http.ResponseWriter, r *http.Request) {
+	if limiter == nil {
+		http.Error(w, "Rate limiting is disabled", http.StatusNotFound)
+		return
+	}
+	l := limiter
+	l.mu.Lock()
+	type budgetInfo struct {
+		Name   string   `json:"name"`
+		Routes []string `json:"routes"`
+		PerIP  string   `json:"per_ip"`
+		PerKey string   `json:"per_key"`
+		rateCounter
+	}
+	budgets := []budgetInfo{}
+	for _, name := range l.budgetNames() {
+		b := l.budgets[name]
+		routes := b.Routes
+		if routes == nil {
+			routes = []string{}
+		}
+		budgets = append(budgets, budgetInfo{name, routes, b.PerIP.String(), b.PerKey.String(), *l.totals[name]})
+	}
+	now := l.now()
+	clients := []RateUsage{}
+	for k, b := range l.buckets {
+		b.refill(now)
+		clients = append(clients, RateUsage{Budget: k.budget, Client: k.client, Limit: b.limit.String(), Remaining: math.Floor(b.tokens), Allowed: b.allowed, Limited: b.limited})
+	}
+	l.mu.Unlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Limited != clients[j].Limited {
+			return clients[i].Limited > clients[j].Limited
+		}
+		return clients[i].Allowed > clients[j].Allowed
+	})
+	if len(clients) > 100 {
+		clients = clients[:100]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"budgets": budgets,
+		"clients": clients,
+	})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// testLimiter returns a limiter on a clock the test moves by hand.
+func testLimiter(budgets []rateBudget) (*rateLimiter, *time.Time) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter(budgets)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestRateLimitBurstAndRefill(t *testing.T) {
+	lim := rateLimit{Rate: 1, Burst: 3}
+	l, now := testLimiter([]rateBudget{{Name: "default", PerIP: lim, PerKey: lim}})
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := l.take("default", "ip:a", lim); !ok {
+			t.Fatalf("request %d within the burst was limited", i+1)
+		}
+	}
+	ok, remaining, wait := l.take("default", "ip:a", lim)
+	if ok || remaining != 0 || wait != time.Second {
+		t.Fatalf("after the burst: got ok=%v remaining=%v wait=%v, want limited with 1s to wait", ok, remaining, wait)
+	}
+
+	*now = now.Add(500 * time.Millisecond)
+	if ok, _, wait := l.take("default", "ip:a", lim); ok || wait != 500*time.Millisecond {
+		t.Fatalf("half a token later: got ok=%v wait=%v, want limited with 500ms to wait", ok, wait)
+	}
+	*now = now.Add(500 * time.Millisecond)
+	if ok, _, _ := l.take("default", "ip:a", lim); !ok {
+		t.Fatal("a refilled token was not spent")
+	}
+
+	// A long idle period refills to the burst, not beyond it.
+	*now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := l.take("default", "ip:a", lim); !ok {
+			t.Fatalf("request %d after refilling was limited", i+1)
+		}
+	}
+	if ok, _, _ := l.take("default", "ip:a", lim); ok {
+		t.Fatal("the bucket refilled past its burst")
+	}
+}
+
+func TestRateLimitSweep(t *testing.T) {
+	lim := rateLimit{Rate: 0.1, Burst: 10}
+	l, now := testLimiter([]rateBudget{{Name: "default", PerIP: lim, PerKey: lim}})
+
+	l.take("default", "ip:idle", lim)
+	*now = now.Add(55 * time.Second)
+	for i := 0; i < 10; i++ {
+		l.take("default", "ip:busy", lim)
+	}
+	*now = now.Add(10 * time.Second)
+	l.take("default", "ip:other", lim)
+
+	if _, ok := l.buckets[bucketKey{"default", "ip:idle"}]; ok {
+		t.Error("a bucket idle long enough to be full was kept")
+	}
+	if _, ok := l.buckets[bucketKey{"default", "ip:busy"}]; !ok {
+		t.Error("a bucket still refilling was dropped")
+	}
+
+	// Sweeps run at most once a minute: "other" is full again 10s later
+	// but stays until the next sweep.
+	*now = now.Add(30 * time.Second)
+	l.take("default", "ip:late", lim)
+	if _, ok := l.buckets[bucketKey{"default", "ip:other"}]; !ok {
+		t.Error("a sweep ran less than a minute after the last one")
+	}
+	*now = now.Add(30 * time.Second)
+	l.take("default", "ip:late", lim)
+	if _, ok := l.buckets[bucketKey{"default", "ip:other"}]; ok {
+		t.Error("the next sweep kept a full bucket")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	l, now := testLimiter([]rateBudget{
+		{Name: "default", PerIP: perMinute(2), PerKey: perMinute(4)},
+		{Name: "ask", Routes: []string{"/ask"}, PerIP: perMinute(1), PerKey: perMinute(3)},
+	})
+	router := mux.NewRouter()
+	router.Use(l.middleware)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router.HandleFunc("/ask", ok)
+	router.HandleFunc("/latest/{coin_id}", ok)
+	router.HandleFunc("/ping", ok)
+
+	do := func(path, ip, keyID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = ip + ":4000"
+		if keyID != "" {
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal{KeyID: keyID}))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("/ask", "10.0.0.1", ""); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Budget") != "ask" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("first /ask: got %d budget=%q remaining=%q", w.Code, w.Header().Get("X-RateLimit-Budget"), w.Header().Get("X-RateLimit-Remaining"))
+	}
+	w := do("/ask", "10.0.0.1", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("second /ask: got %d Retry-After=%q, want 429 after 60", w.Code, w.Header().Get("Retry-After"))
+	}
+	*now = now.Add(20 * time.Second)
+	if w := do("/ask", "10.0.0.1", ""); w.Header().Get("Retry-After") != "40" {
+		t.Errorf("Retry-After 20s later = %q, want 40", w.Header().Get("Retry-After"))
+	}
+
+	// Budgets, addresses and keys each have their own bucket.
+	tests := []struct {
+		name, path, ip, key string
+		want                int
+	}{
+		{"other budget", "/latest/bitcoin", "10.0.0.1", "", http.StatusOK},
+		{"route template shared", "/latest/ethereum", "10.0.0.1", "", http.StatusOK},
+		{"default exhausted", "/latest/solana", "10.0.0.1", "", http.StatusTooManyRequests},
+		{"other address", "/ask", "10.0.0.2", "", http.StatusOK},
+		{"key on a limited address", "/ask", "10.0.0.1", "k1", http.StatusOK},
+		{"key burst", "/ask", "10.0.0.1", "k1", http.StatusOK},
+		{"key burst spent", "/ask", "10.0.0.9", "k1", http.StatusOK},
+		{"key over budget", "/ask", "10.0.0.9", "k1", http.StatusTooManyRequests},
+		{"other key", "/ask", "10.0.0.9", "k2", http.StatusOK},
+		{"exempt", "/ping", "10.0.0.1", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := do(tt.path, tt.ip, tt.key); w.Code != tt.want {
+			t.Errorf("%s: %s from %s key %q = %d, want %d", tt.name, tt.path, tt.ip, tt.key, w.Code, tt.want)
+		}
+	}
+	if w := do("/ping", "10.0.0.1", ""); w.Header().Get("X-RateLimit-Budget") != "" {
+		t.Error("an exempt route carried rate limit headers")
+	}
+	if got := l.totals["ask"].Limited; got != 3 {
+		t.Errorf("ask limited total = %d, want 3", got)
+	}
+}
+
+func TestRateLimitString(t *testing.T) {
+	tests := []struct {
+		lim  rateLimit
+		want string
+	}{
+		{perMinute(120), "120/m"},
+		{perHour(5), "5/h"},
+		{rateLimit{Rate: 1, Burst: 1}, "1/s"},
+		{rateLimit{Rate: 5.0 / 60, Burst: 1}, "5/m:1"},
+		{rateLimit{Rate: 2.0 / 86400, Burst: 5}, "2/d:5"},
+		{rateLimit{Rate: 90.0 / 3600, Burst: 90}, "90/h"},
+	}
+	for _, tt := range tests {
+		if got := tt.lim.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.lim, got, tt.want)
+		}
+		back, err := parseRateLimit(tt.want)
+		if err != nil {
+			t.Errorf("parseRateLimit(%q): %v", tt.want, err)
+			continue
+		}
+		if back.String() != tt.want || back.Burst != tt.lim.Burst {
+			t.Errorf("parseRateLimit(%q) = %+v, which does not round-trip", tt.want, back)
+		}
+	}
+}
+
+func TestConfiguredRateBudgets(t *testing.T) {
+	t.Setenv("RATE_LIMITS", " ask=5/m, ask.key=60/m ,subscribe=3/h:1")
+	budgets, err := configuredRateBudgets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]rateBudget)
+	for _, b := range budgets {
+		got[b.Name] = b
+	}
+	if got["ask"].PerIP != perMinute(5) || got["ask"].PerKey != perMinute(60) {
+		t.Errorf("ask = %+v", got["ask"])
+	}
+	if got["subscribe"].PerIP != (rateLimit{Rate: 3.0 / 3600, Burst: 1}) || got["subscribe"].PerKey != perHour(20) {
+		t.Errorf("subscribe = %+v", got["subscribe"])
+	}
+	if got["default"].PerIP != perMinute(120) {
+		t.Errorf("default changed: %+v", got["default"])
+	}
+	if defaultRateBudgets[1].PerIP != perMinute(10) {
+		t.Error("RATE_LIMITS modified the defaults")
+	}
+
+	t.Setenv("RENDER", "true")
+	if _, err := configuredRateBudgets(); err == nil || !strings.Contains(err.Error(), "TRUST_PROXY_HEADERS") {
+		t.Errorf("on Render without TRUST_PROXY_HEADERS: got error %v", err)
+	}
+	t.Setenv("TRUST_PROXY_HEADERS", "true")
+	if _, err := configuredRateBudgets(); err != nil {
+		t.Errorf("on Render with TRUST_PROXY_HEADERS: %v", err)
+	}
+
+	t.Setenv("RATE_LIMITS", "off")
+	t.Setenv("TRUST_PROXY_HEADERS", "")
+	if budgets, err := configuredRateBudgets(); err != nil || budgets != nil || newRateLimiter(budgets) != nil {
+		t.Errorf("off: got %v, %v; want limiting disabled", budgets, err)
+	}
+	t.Setenv("RENDER", "")
+
+	errs := []struct{ spec, want string }{
+		{"ask", "invalid RATE_LIMITS entry"},
+		{"search=1/m", "unknown budget"},
+		{"ask=10", "invalid limit"},
+		{"ask=0/m", "invalid count"},
+		{"ask=x/m", "invalid count"},
+		{"ask=1/w", "invalid unit"},
+		{"ask=1/m:0", "invalid burst"},
+		{"ask.key=1/m:y", "invalid burst"},
+	}
+	for _, tt := range errs {
+		t.Setenv("RATE_LIMITS", tt.spec)
+		if _, err := configuredRateBudgets(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("RATE_LIMITS=%q: got error %v, want %q", tt.spec, err, tt.want)
+		}
+	}
+}
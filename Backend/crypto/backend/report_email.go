@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// emailMaxCoins bounds the coin table of a report email; the PDF has the
+// rest.
+const emailMaxCoins = 20
+
+// reportEmail is the body of a report email: a plain-text and an HTML
+// rendering of the same highlights, and the charts the HTML shows inline.
+type reportEmail struct {
+	Text   string
+	HTML   string
+	Images []inlineImage
+}
+
+// inlineImage is a PNG referenced from the HTML as cid:ContentID.
+type inlineImage struct {
+	ContentID string
+	Filename  string
+	Data      []byte
+}
+
+// archivedBuild is what an archived report can show without a run: its
+// insights and PDF but no charts.
+func archivedBuild(report ArchivedReport) reportBuild {
+	b := reportBuild{PDF: report.PDF}
+	if err := json.Unmarshal(report.Insights, &b.Insights); err != nil {
+		b.Insights = ReportInsights{}
+	}
+	return b
+}
+
+type emailRow struct {
+	Coin   string
+	Price  string
+	Change string
+	Extra  string
+	Up     bool
+}
+
+type emailTable struct {
+	Title string
+	Rows  []emailRow
+}
+
+type emailChart struct {
+	Coin string
+	Src  template.URL
+}
+
+type emailView struct {
+	Lang       string
+	Title      string
+	Covering   string
+	Paragraphs []string
+	SignOff    string
+	Index      string
+	Movers     []emailTable
+	Leaders    []emailRow
+	Coins      []emailRow
+	MoreCoins  string
+	Charts     []emailChart
+	T          func(string) string
+}
+
+// renderReportEmail renders the highlights of b for a subscriber with
+// opts. greeting is the message's opening paragraphs and sign-off,
+// separated by blank lines; the highlights go between them.
+func renderReportEmail(b reportBuild, opts reportOptions, greeting string) (reportEmail, error) {
+	in := b.Insights
+	v := emailView{
+		Lang:       opts.Language,
+		Title:      opts.text(in.Period.Title()),
+		Paragraphs: strings.Split(greeting, "\n\n"),
+		T:          opts.text,
+	}
+	if n := len(v.Paragraphs); n > 1 {
+		v.Paragraphs, v.SignOff = v.Paragraphs[:n-1], v.Paragraphs[n-1]
+	}
+	v.Covering = opts.covering(in.Period)
+
+	row := func(c Insight, extra string) emailRow {
+		return emailRow{Coin: c.CoinID, Price: usd(c.LastPrice), Change: signedPct(c.PercentChange), Extra: extra, Up: c.PercentChange >= 0}
+	}
+	if opts.includes(SectionMarket) && len(in.VolumeLeaders) > 0 {
+		v.Index = fmt.Sprintf(opts.text("Market-cap-weighted index change: %.2f%%"), in.MarketCapIndexPct)
+		for _, c := range in.VolumeLeaders {
+			v.Leaders = append(v.Leaders, row(c, usdCompact(c.Volume24h)))
+		}
+	}
+	if opts.includes(SectionGainers) && len(in.TopGainers) > 0 {
+		t := emailTable{Title: opts.text("Top Gainers")}
+		for _, c := range in.TopGainers {
+			t.Rows = append(t.Rows, row(c, ""))
+		}
+		v.Movers = append(v.Movers, t)
+	}
+	if opts.includes(SectionLosers) && len(in.TopLosers) > 0 {
+		t := emailTable{Title: opts.text("Top Losers")}
+		for _, c := range in.TopLosers {
+			t.Rows = append(t.Rows, row(c, ""))
+		}
+		v.Movers = append(v.Movers, t)
+	}
+	if opts.includes(SectionSnapshot) || opts.includes(SectionRanges) {
+		for i, c := range in.CoinMetrics {
+			if i == emailMaxCoins {
+				v.MoreCoins = fmt.Sprintf(opts.text("...and %d more in the attached report."), len(in.CoinMetrics)-emailMaxCoins)
+				break
+			}
+			v.Coins = append(v.Coins, row(c, fmt.Sprintf("%.2f%%", c.RangePct)))
+		}
+	}
+
+	var out reportEmail
+	for _, p := range b.Charts {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		coin := strings.TrimSuffix(filepath.Base(p), "_chart.png")
+		img := inlineImage{ContentID: "chart-" + coin + "@crypto-dashboard", Filename: filepath.Base(p), Data: data}
+		out.Images = append(out.Images, img)
+		v.Charts = append(v.Charts, emailChart{Coin: coin, Src: template.URL("cid:" + img.ContentID)})
+	}
+
+	var html bytes.Buffer
+	if err := reportEmailTemplate.Execute(&html, v); err != nil {
+		return out, err
+	}
+	out.HTML = html.String()
+	out.Text = reportEmailText(v)
+	return out, nil
+}
+
+// reportEmailText is the plain-text alternative to the HTML.
+func reportEmailText(v emailView) string {
+	var b strings.Builder
+	b.WriteString(strings.Join(v.Paragraphs, "\n\n"))
+	fmt.Fprintf(&b, "\n\n%s\n%s\n", v.Title, v.Covering)
+	if v.Index != "" {
+		fmt.Fprintf(&b, "\n%s\n", v.Index)
+	}
+	table := func(title string, rows []emailRow) {
+		if len(rows) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s\n", title)
+		for _, r := range rows {
+			line := fmt.Sprintf("  %-16s %9s  %12s", r.Coin, r.Change, r.Price)
+			if r.Extra != "" {
+				line += "  " + r.Extra
+			}
+			b.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+	}
+	for _, t := range v.Movers {
+		table(t.Title, t.Rows)
+	}
+	table(v.T("Market Overview"), v.Leaders)
+	table(v.T("Coins"), v.Coins)
+	if v.MoreCoins != "" {
+		fmt.Fprintf(&b, "  %s\n", v.MoreCoins)
+	}
+	fmt.Fprintf(&b, "\n%s\n", v.T("The full report is attached as a PDF."))
+	if v.SignOff != "" {
+		fmt.Fprintf(&b, "\n%s\n", v.SignOff)
+	}
+	return b.String()
+}
+
+// reportEmailTemplate lays the highlights out in a single 600px column
+// that narrows on phones. Styles are inline because most mail clients drop
+// <style> rules other than media queries.
+var reportEmailTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+@media (max-width: 620px) {
+  .container { width: 100% !important; }
+  .half { display: block !important; width: 100% !important; }
+}
+</style>
+</head>
+<body style="margin:0;padding:0;background:#f2f4f8;font-family:Helvetica,Arial,sans-serif;color:#1f2933;">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" style="background:#f2f4f8;">
+<tr><td align="center" style="padding:16px 8px;">
+<table role="presentation" class="container" width="600" cellpadding="0" cellspacing="0" style="width:600px;max-width:100%;background:#ffffff;border-radius:6px;">
+<tr><td style="padding:24px;background:#284682;border-radius:6px 6px 0 0;color:#ffffff;">
+<h1 style="margin:0;font-size:22px;">{{.Title}}</h1>
+<p style="margin:6px 0 0;font-size:13px;">{{.Covering}}</p>
+</td></tr>
+<tr><td style="padding:20px 24px 4px;font-size:14px;line-height:1.5;">
+{{range .Paragraphs}}<p style="margin:0 0 12px;white-space:pre-line;">{{.}}</p>
+{{end}}</td></tr>
+{{if .Index}}<tr><td style="padding:4px 24px;font-size:15px;font-weight:bold;">{{.Index}}</td></tr>
+{{end}}{{if .Movers}}<tr><td style="padding:12px 18px;">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0"><tr>
+{{range .Movers}}<td class="half" width="50%" valign="top" style="padding:6px;">{{template "movers" .}}</td>
+{{end}}</tr></table>
+</td></tr>
+{{end}}{{if .Leaders}}<tr><td style="padding:12px 24px;">
+<h2 style="margin:0 0 8px;font-size:16px;color:#284682;">{{call .T "Market Overview"}}</h2>
+<table width="100%" cellpadding="6" cellspacing="0" style="border-collapse:collapse;font-size:13px;">
+<tr style="background:#e6e6e6;"><th align="left">{{call .T "Coin"}}</th><th align="right">{{call .T "24h Volume (USD)"}}</th><th align="right">{{call .T "Change %"}}</th></tr>
+{{range .Leaders}}<tr style="border-bottom:1px solid #eeeeee;"><td>{{.Coin}}</td><td align="right">{{.Extra}}</td><td align="right" style="color:{{if .Up}}#1a7f37{{else}}#c0392b{{end}};">{{.Change}}</td></tr>
+{{end}}</table>
+</td></tr>
+{{end}}{{range .Charts}}<tr><td style="padding:12px 24px;">
+<img src="{{.Src}}" alt="{{.Coin}}" width="552" style="display:block;width:100%;max-width:552px;height:auto;border:0;">
+</td></tr>
+{{end}}{{if .Coins}}<tr><td style="padding:12px 24px;">
+<h2 style="margin:0 0 8px;font-size:16px;color:#284682;">{{call .T "Coins"}}</h2>
+<table width="100%" cellpadding="6" cellspacing="0" style="border-collapse:collapse;font-size:13px;">
+<tr style="background:#e6e6e6;"><th align="left">{{call .T "Coin"}}</th><th align="right">{{call .T "Last Price"}}</th><th align="right">{{call .T "Change %"}}</th><th align="right">{{call .T "Range %"}}</th></tr>
+{{range .Coins}}<tr style="border-bottom:1px solid #eeeeee;"><td>{{.Coin}}</td><td align="right">{{.Price}}</td><td align="right" style="color:{{if .Up}}#1a7f37{{else}}#c0392b{{end}};">{{.Change}}</td><td align="right">{{.Extra}}</td></tr>
+{{end}}</table>
+{{if .MoreCoins}}<p style="margin:8px 0 0;font-size:12px;color:#52606d;">{{.MoreCoins}}</p>{{end}}
+</td></tr>
+{{end}}<tr><td style="padding:16px 24px 0;font-size:12px;color:#52606d;">{{call .T "The full report is attached as a PDF."}}</td></tr>
+{{if .SignOff}}<tr><td style="padding:16px 24px 0;font-size:14px;line-height:1.5;white-space:pre-line;">{{.SignOff}}</td></tr>
+{{end}}<tr><td style="padding:0 0 24px;"></td></tr>
+</table>
+</td></tr>
+</table>
+</body>
+</html>
+{{define "movers"}}<h2 style="margin:0 0 8px;font-size:16px;color:#284682;">{{.Title}}</h2>
+<table width="100%" cellpadding="6" cellspacing="0" style="border-collapse:collapse;font-size:13px;">
+{{range .Rows}}<tr style="border-bottom:1px solid #eeeeee;"><td>{{.Coin}}</td><td align="right">{{.Price}}</td><td align="right" style="color:{{if .Up}}#1a7f37{{else}}#c0392b{{end}};">{{.Change}}</td></tr>
+{{end}}</table>{{end}}`))
+
+// sendReportEmail sends email with the PDF attached.
+func sendReportEmail(to, subject string, email reportEmail, attachment []byte, filename string) error {
+	msg, err := reportMessage(to, subject, email, attachment, filename)
+	if err != nil {
+		return err
+	}
+	return deliverMail(to, msg)
+}
+
+// reportMessage builds the MIME message for sendReportEmail. It is
+// multipart/mixed: a multipart/related part holding the
+// multipart/alternative text and HTML bodies and the inline charts, then
+// the attachment.
+func reportMessage(to, subject string, email reportEmail, attachment []byte, filename string) ([]byte, error) {
+	var msg bytes.Buffer
+	mixed := multipart.NewWriter(&msg)
+
+	fmt.Fprintf(&msg, "From: %s\r\n", os.Getenv("SMTP_EMAIL"))
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mixed.Boundary())
+
+	err := nestedPart(mixed, "multipart/related; type=\"multipart/alternative\"", func(related *multipart.Writer) error {
+		err := nestedPart(related, "multipart/alternative", func(alt *multipart.Writer) error {
+			if err := quotedPrintablePart(alt, "text/plain; charset=utf-8", email.Text); err != nil {
+				return err
+			}
+			return quotedPrintablePart(alt, "text/html; charset=utf-8", email.HTML)
+		})
+		if err != nil {
+			return err
+		}
+		for _, img := range email.Images {
+			part, err := related.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {fmt.Sprintf("image/png; name=%q", img.Filename)},
+				"Content-Transfer-Encoding": {"base64"},
+				"Content-Id":                {"<" + img.ContentID + ">"},
+				"Content-Disposition":       {fmt.Sprintf("inline; filename=%q", img.Filename)},
+			})
+			if err != nil {
+				return err
+			}
+			if err := writeBase64(part, img.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	part, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {fmt.Sprintf("application/pdf; name=%q", filename)},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", filename)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBase64(part, attachment); err != nil {
+		return nil, err
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// nestedPart adds a multipart part of contentType to parent, filled in by
+// fill.
+func nestedPart(parent *multipart.Writer, contentType string, fill func(w *multipart.Writer) error) error {
+	var body bytes.Buffer
+	child := multipart.NewWriter(&body)
+	if err := fill(child); err != nil {
+		return err
+	}
+	if err := child.Close(); err != nil {
+		return err
+	}
+	part, err := parent.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {contentType + "; boundary=" + child.Boundary()},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(body.Bytes())
+	return err
+}
+
+func quotedPrintablePart(w *multipart.Writer, contentType, body string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := io.WriteString(qp, strings.ReplaceAll(body, "\n", "\r\n")); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64 writes data base64-encoded in 76-character lines.
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(w, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := io.WriteString(w, encoded+"\r\n")
+	return err
+}
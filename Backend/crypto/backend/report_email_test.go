@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// mimePart is a decoded part of a message and the parts nested in it.
+type mimePart struct {
+	mediaType string
+	params    map[string]string
+	header    map[string][]string
+	body      []byte
+	parts     []mimePart
+}
+
+// readParts reads every part of a multipart body, recursing into nested
+// multiparts and decoding base64 bodies. Quoted-printable bodies are
+// decoded by multipart.Reader itself.
+func readParts(t *testing.T, body io.Reader, boundary string) []mimePart {
+	t.Helper()
+	var out []mimePart
+	mr := multipart.NewReader(body, boundary)
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		mt, params, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part := mimePart{mediaType: mt, params: params, header: p.Header}
+		var r io.Reader = p
+		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+			r = base64.NewDecoder(base64.StdEncoding, p)
+		}
+		if strings.HasPrefix(mt, "multipart/") {
+			part.parts = readParts(t, r, params["boundary"])
+		} else if part.body, err = io.ReadAll(r); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, part)
+	}
+}
+
+func TestReportMessage(t *testing.T) {
+	t.Setenv("SMTP_EMAIL", "reports@example.com")
+	chart := bytes.Repeat([]byte{0x89, 'P', 'N', 'G', 0, 0xff}, 100)
+	pdf := append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte{0, 1, 2, 0xfe}, 200)...)
+	email := reportEmail{
+		Text: "Généré le 2026-10-16 (UTC)\n" + strings.Repeat("A long line of text that must be wrapped. ", 5),
+		HTML: `<p>Généré le 2026-10-16</p><img src="cid:chart-bitcoin@crypto-dashboard">`,
+		Images: []inlineImage{
+			{ContentID: "chart-bitcoin@crypto-dashboard", Filename: "bitcoin_chart.png", Data: chart},
+		},
+	}
+
+	raw, err := reportMessage("a@example.com", "Rapport quotidien — crypto", email, pdf, "crypto_report_2026-10-16.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Rapport quotidien — crypto" {
+		t.Errorf("subject = %q (%v), encoded as %q", subject, err, msg.Header.Get("Subject"))
+	}
+	if msg.Header.Get("From") != "reports@example.com" || msg.Header.Get("To") != "a@example.com" || msg.Header.Get("MIME-Version") != "1.0" {
+		t.Errorf("headers = %v", msg.Header)
+	}
+	for i, line := range strings.Split(string(raw), "\r\n") {
+		if len(line) > 998 {
+			t.Fatalf("line %d is %d characters long", i+1, len(line))
+		}
+	}
+
+	mt, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/mixed" {
+		t.Fatalf("top-level type %q (%v)", mt, err)
+	}
+	top := readParts(t, msg.Body, params["boundary"])
+
+	// multipart/mixed
+	//   multipart/related
+	//     multipart/alternative
+	//       text/plain
+	//       text/html
+	//     image/png (inline)
+	//   application/pdf (attachment)
+	if len(top) != 2 || top[0].mediaType != "multipart/related" || top[1].mediaType != "application/pdf" {
+		t.Fatalf("top-level parts = %v", partTypes(top))
+	}
+	related := top[0]
+	if related.params["type"] != "multipart/alternative" || len(related.parts) != 2 {
+		t.Fatalf("related = %v %v", related.params, partTypes(related.parts))
+	}
+	alt, img := related.parts[0], related.parts[1]
+	if alt.mediaType != "multipart/alternative" || len(alt.parts) != 2 ||
+		alt.parts[0].mediaType != "text/plain" || alt.parts[1].mediaType != "text/html" {
+		t.Fatalf("alternative = %v", partTypes(alt.parts))
+	}
+	// Quoted-printable text ends its lines with CRLF.
+	if got := strings.ReplaceAll(string(alt.parts[0].body), "\r\n", "\n"); got != email.Text {
+		t.Errorf("text body = %q", got)
+	}
+	if got := string(alt.parts[1].body); got != email.HTML {
+		t.Errorf("HTML body = %q", got)
+	}
+	for _, p := range alt.parts {
+		if p.params["charset"] != "utf-8" {
+			t.Errorf("%s charset = %q", p.mediaType, p.params["charset"])
+		}
+	}
+
+	if img.mediaType != "image/png" || img.header["Content-Id"][0] != "<chart-bitcoin@crypto-dashboard>" ||
+		!strings.HasPrefix(img.header["Content-Disposition"][0], "inline") || !bytes.Equal(img.body, chart) {
+		t.Errorf("image part = %s %v, %d bytes", img.mediaType, img.header, len(img.body))
+	}
+
+	att := top[1]
+	disposition, dparams, _ := mime.ParseMediaType(att.header["Content-Disposition"][0])
+	if disposition != "attachment" || dparams["filename"] != "crypto_report_2026-10-16.pdf" || !bytes.Equal(att.body, pdf) {
+		t.Errorf("attachment = %s %v, %d bytes", disposition, dparams, len(att.body))
+	}
+}
+
+func partTypes(parts []mimePart) []string {
+	var out []string
+	for _, p := range parts {
+		out = append(out, p.mediaType)
+	}
+	return out
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report sections a subscriber can choose. The cover page is always
+// included.
+const (
+	SectionRanges      = "ranges"
+	SectionMarket      = "market"
+	SectionGainers     = "gainers"
+	SectionLosers      = "losers"
+	SectionPerformance = "performance"
+	SectionCharts      = "charts"
+	SectionSnapshot    = "snapshot"
+)
+
+// reportSections lists the sections in the order they appear.
+var reportSections = []string{
+	SectionRanges,
+	SectionMarket,
+	SectionGainers,
+	SectionLosers,
+	SectionPerformance,
+	SectionCharts,
+	SectionSnapshot,
+}
+
+func validSection(s string) bool {
+	for _, v := range reportSections {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// LanguageEnglish is the language reports are written in by default.
+const LanguageEnglish = "en"
+
+// reportLanguage translates a report's fixed text: headings, table
+// columns and the email wording. Name is the language's English name, which
+// the AI narrative is asked to write in. Text maps the English text to the
+// translation; anything missing stays English.
+type reportLanguage struct {
+	Name string
+	Text map[string]string
+}
+
+var reportLanguages = map[string]reportLanguage{
+	LanguageEnglish: {Name: "English"},
+	"es": {Name: "Spanish", Text: map[string]string{
+		"Daily Crypto Market Report":   "Informe diario del mercado cripto",
+		"Weekly Crypto Market Report":  "Informe semanal del mercado cripto",
+		"Monthly Crypto Market Report": "Informe mensual del mercado cripto",
+		"Crypto Market Report":         "Informe del mercado cripto",
+		"Covering %s to %s (%s)":       "Del %s al %s (%s)",
+		"Generated on %s (%s)":         "Generado el %s (%s)",
+		"Page %d/{nb}":                 "Página %d/{nb}",
+		"Daily Range Metrics":          "Rangos del día",
+		"Range Metrics":                "Rangos del periodo",
+		"Coin":                         "Moneda",
+		"Min":                          "Mín.",
+		"Max":                          "Máx.",
+		"Median":                       "Mediana",
+		"Range %":                      "Rango %",
+		"Market Overview":              "Resumen del mercado",
+		"Market-cap-weighted index change: %.2f%%": "Variación del índice ponderado por capitalización: %.2f%%",
+		"24h Volume (USD)":                         "Volumen 24h (USD)",
+		"Market Cap (USD)":                         "Capitalización (USD)",
+		"Change %":                                 "Variación %",
+		"Top Gainers":                              "Mayores subidas",
+		"Top Losers":                               "Mayores caídas",
+		"Percent Change":                           "Variación %",
+		"Avg Price":                                "Precio medio",
+		"Volatility":                               "Volatilidad",
+		"Period Performance":                       "Rendimiento del periodo",
+		"Max Drawdown":                             "Caída máxima",
+		"Best Day":                                 "Mejor día",
+		"Worst Day":                                "Peor día",
+		"Price chart: %s":                          "Gráfico de precios: %s",
+		"Snapshot Metrics (Top Coins)":             "Métricas generales (principales monedas)",
+		"Avg":                                      "Media",
+		"StdDev":                                   "Desv. típica",
+		"Daily Crypto Report":                      "Informe cripto diario",
+		"Weekly Crypto Report":                     "Informe cripto semanal",
+		"Monthly Crypto Report":                    "Informe cripto mensual",
+		"Crypto Report %s":                         "Informe cripto %s",
+		"Dear Subscriber,\n\nAttached is your %s for %s, prepared by the Crypto Dashboard team.\n\nBest regards,\nCrypto Dashboard Team": "Estimado suscriptor:\n\nAdjuntamos su %s de %s, preparado por el equipo de Crypto Dashboard.\n\nSaludos cordiales,\nEquipo de Crypto Dashboard",
+		"Coins":                                  "Monedas",
+		"Last Price":                             "Último precio",
+		"The full report is attached as a PDF.":  "El informe completo va adjunto en PDF.",
+		"...and %d more in the attached report.": "...y %d más en el informe adjunto.",
+	}},
+	"fr": {Name: "French", Text: map[string]string{
+		"Daily Crypto Market Report":   "Rapport quotidien du marché crypto",
+		"Weekly Crypto Market Report":  "Rapport hebdomadaire du marché crypto",
+		"Monthly Crypto Market Report": "Rapport mensuel du marché crypto",
+		"Crypto Market Report":         "Rapport du marché crypto",
+		"Covering %s to %s (%s)":       "Du %s au %s (%s)",
+		"Generated on %s (%s)":         "Généré le %s (%s)",
+		"Page %d/{nb}":                 "Page %d/{nb}",
+		"Daily Range Metrics":          "Amplitudes du jour",
+		"Range Metrics":                "Amplitudes de la période",
+		"Coin":                         "Crypto",
+		"Min":                          "Min",
+		"Max":                          "Max",
+		"Median":                       "Médiane",
+		"Range %":                      "Amplitude %",
+		"Market Overview":              "Vue d'ensemble du marché",
+		"Market-cap-weighted index change: %.2f%%": "Variation de l'indice pondéré par capitalisation : %.2f%%",
+		"24h Volume (USD)":                         "Volume 24h (USD)",
+		"Market Cap (USD)":                         "Capitalisation (USD)",
+		"Change %":                                 "Variation %",
+		"Top Gainers":                              "Plus fortes hausses",
+		"Top Losers":                               "Plus fortes baisses",
+		"Percent Change":                           "Variation %",
+		"Avg Price":                                "Prix moyen",
+		"Volatility":                               "Volatilité",
+		"Period Performance":                       "Performance de la période",
+		"Max Drawdown":                             "Baisse maximale",
+		"Best Day":                                 "Meilleur jour",
+		"Worst Day":                                "Pire jour",
+		"Price chart: %s":                          "Graphique des prix : %s",
+		"Snapshot Metrics (Top Coins)":             "Indicateurs (principales cryptos)",
+		"Avg":                                      "Moyenne",
+		"StdDev":                                   "Écart type",
+		"Daily Crypto Report":                      "Rapport crypto quotidien",
+		"Weekly Crypto Report":                     "Rapport crypto hebdomadaire",
+		"Monthly Crypto Report":                    "Rapport crypto mensuel",
+		"Crypto Report %s":                         "Rapport crypto %s",
+		"Dear Subscriber,\n\nAttached is your %s for %s, prepared by the Crypto Dashboard team.\n\nBest regards,\nCrypto Dashboard Team": "Cher abonné,\n\nVous trouverez ci-joint votre %s pour %s, préparé par l'équipe Crypto Dashboard.\n\nCordialement,\nL'équipe Crypto Dashboard",
+		"Coins":                                  "Cryptos",
+		"Last Price":                             "Dernier prix",
+		"The full report is attached as a PDF.":  "Le rapport complet est joint au format PDF.",
+		"...and %d more in the attached report.": "...et %d de plus dans le rapport joint.",
+	}},
+	"de": {Name: "German", Text: map[string]string{
+		"Daily Crypto Market Report":   "Täglicher Krypto-Marktbericht",
+		"Weekly Crypto Market Report":  "Wöchentlicher Krypto-Marktbericht",
+		"Monthly Crypto Market Report": "Monatlicher Krypto-Marktbericht",
+		"Crypto Market Report":         "Krypto-Marktbericht",
+		"Covering %s to %s (%s)":       "%s bis %s (%s)",
+		"Generated on %s (%s)":         "Erstellt am %s (%s)",
+		"Page %d/{nb}":                 "Seite %d/{nb}",
+		"Daily Range Metrics":          "Tagesspannen",
+		"Range Metrics":                "Spannen im Zeitraum",
+		"Coin":                         "Coin",
+		"Min":                          "Min",
+		"Max":                          "Max",
+		"Median":                       "Median",
+		"Range %":                      "Spanne %",
+		"Market Overview":              "Marktüberblick",
+		"Market-cap-weighted index change: %.2f%%": "Veränderung des marktkapitalisierungsgewichteten Index: %.2f%%",
+		"24h Volume (USD)":                         "24h-Volumen (USD)",
+		"Market Cap (USD)":                         "Marktkap. (USD)",
+		"Change %":                                 "Änderung %",
+		"Top Gainers":                              "Größte Gewinner",
+		"Top Losers":                               "Größte Verlierer",
+		"Percent Change":                           "Änderung %",
+		"Avg Price":                                "Ø Preis",
+		"Volatility":                               "Volatilität",
+		"Period Performance":                       "Entwicklung im Zeitraum",
+		"Max Drawdown":                             "Max. Rückgang",
+		"Best Day":                                 "Bester Tag",
+		"Worst Day":                                "Schlechtester Tag",
+		"Price chart: %s":                          "Preisdiagramm: %s",
+		"Snapshot Metrics (Top Coins)":             "Kennzahlen (Top-Coins)",
+		"Avg":                                      "Mittel",
+		"StdDev":                                   "Std.-Abw.",
+		"Daily Crypto Report":                      "Täglicher Krypto-Bericht",
+		"Weekly Crypto Report":                     "Wöchentlicher Krypto-Bericht",
+		"Monthly Crypto Report":                    "Monatlicher Krypto-Bericht",
+		"Crypto Report %s":                         "Krypto-Bericht %s",
+		"Dear Subscriber,\n\nAttached is your %s for %s, prepared by the Crypto Dashboard team.\n\nBest regards,\nCrypto Dashboard Team": "Sehr geehrte Abonnentin, sehr geehrter Abonnent,\n\nanbei Ihr %s für %s, erstellt vom Crypto Dashboard Team.\n\nMit freundlichen Grüßen\nIhr Crypto Dashboard Team",
+		"Coins":                                  "Coins",
+		"Last Price":                             "Letzter Preis",
+		"The full report is attached as a PDF.":  "Der vollständige Bericht liegt als PDF bei.",
+		"...and %d more in the attached report.": "...und %d weitere im beigefügten Bericht.",
+	}},
+}
+
+// languageCodes returns the supported languages, sorted.
+func languageCodes() []string {
+	codes := make([]string, 0, len(reportLanguages))
+	for code := range reportLanguages {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// reportOptions personalize one build of a report. Coins restricts it to a
+// watch-list (nil for every coin), Location is the zone chart times are
+// shown in, Sections are the sections to include and Language the language
+// of its fixed text and AI narrative.
+type reportOptions struct {
+	Coins    []string
+	Location *time.Location
+	Sections []string
+	Language string
+}
+
+// defaultReportOptions is the report the archive keeps: every coin and
+// section, in English, with times in UTC.
+func defaultReportOptions() reportOptions {
+	return reportOptions{
+		Location: time.UTC,
+		Sections: reportSections,
+		Language: LanguageEnglish,
+	}
+}
+
+func (o reportOptions) includes(section string) bool {
+	for _, s := range o.Sections {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+// key identifies the report o builds; subscribers whose options have the
+// same key get the same PDF.
+func (o reportOptions) key() string {
+	sections := append([]string(nil), o.Sections...)
+	sort.Strings(sections)
+	return strings.Join(o.Coins, ",") + "|" + o.Location.String() + "|" + strings.Join(sections, ",") + "|" + o.Language
+}
+
+// isDefault reports whether o builds the archived report.
+func (o reportOptions) isDefault() bool {
+	return o.key() == defaultReportOptions().key()
+}
+
+// covering is the line under a report's title naming the span p covers,
+// in o's zone. Spans that start and end at midnight there are given as
+// days; otherwise the boundaries are given with their local times.
+func (o reportOptions) covering(p ReportPeriod) string {
+	loc := o.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	start, end := p.Start.In(loc), p.End.In(loc)
+	midnight := func(t time.Time) bool { return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 }
+	switch {
+	case !midnight(start) || !midnight(end):
+		return fmt.Sprintf(o.text("Covering %s to %s (%s)"), start.Format("2006-01-02 15:04"), end.Format("2006-01-02 15:04"), loc)
+	case p.Days() > 1:
+		return fmt.Sprintf(o.text("Covering %s to %s (%s)"), start.Format("2006-01-02"), end.AddDate(0, 0, -1).Format("2006-01-02"), loc)
+	}
+	return fmt.Sprintf(o.text("Generated on %s (%s)"), start.Format("2006-01-02"), loc)
+}
+
+// text translates a report's fixed English text into o's language.
+func (o reportOptions) text(english string) string {
+	if t, ok := reportLanguages[o.Language].Text[english]; ok {
+		return t
+	}
+	return english
+}
+
+// restrictData keeps the series of coins.
+func restrictData(data MarketData, coins []string) MarketData {
+	out := make(MarketData, len(coins))
+	for _, c := range coins {
+		if series, ok := data[c]; ok {
+			out[c] = series
+		}
+	}
+	return out
+}
+
+// restrictInsights narrows insights to coins, reusing the per-coin metrics
+// and recomputing the movers, volume leaders and index over them.
+func restrictInsights(insights ReportInsights, coins []string) ReportInsights {
+	keep := make(map[string]bool, len(coins))
+	for _, c := range coins {
+		keep[c] = true
+	}
+	var metrics []Insight
+	for _, in := range insights.CoinMetrics {
+		if keep[in.CoinID] {
+			metrics = append(metrics, in)
+		}
+	}
+	return summarizeInsights(metrics, insights.Period)
+}
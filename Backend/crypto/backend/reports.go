@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ArchivedReport is a generated report as kept in the archive. Date is the
+// label of the period it covers (see ReportPeriod.Label), which runs from
+// Start to End.
+type ArchivedReport struct {
+	Date        string    `json:"date"`
+	Period      string    `json:"period"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Narrative   string    `json:"narrative"`
+	SizeBytes   int       `json:"size_bytes"`
+	PDF         []byte    `json:"-"`
+	Insights    []byte    `json:"-"` // ReportInsights as JSON
+}
+
+// ReportStore keeps one report per period.
+type ReportStore interface {
+	// Reports lists the archive by Start, newest first, without PDF or
+	// Insights.
+	Reports() ([]ArchivedReport, error)
+	// Report returns the archived report for a period label.
+	Report(date string) (ArchivedReport, error)
+	// PutReport stores r, replacing any report for the same period.
+	PutReport(r ArchivedReport) error
+}
+
+// archiveReport stores a freshly built report and returns it as archived.
+// A failure to store is logged; the report is still returned so it can be
+// sent.
+func archiveReport(insights ReportInsights, pdf []byte, narrative string) ArchivedReport {
+	r := ArchivedReport{
+		Date:        insights.Period.Label(),
+		Period:      insights.Period.Kind,
+		Start:       insights.Period.Start,
+		End:         insights.Period.End,
+		GeneratedAt: time.Now().UTC(),
+		Narrative:   narrative,
+		SizeBytes:   len(pdf),
+		PDF:         pdf,
+	}
+	raw, err := json.Marshal(insights)
+	if err != nil {
+		log.Printf("report %s: encoding insights: %v", r.Date, err)
+		return r
+	}
+	r.Insights = raw
+	if err := store.PutReport(r); err != nil {
+		log.Printf("report %s: archiving: %v", r.Date, err)
+	}
+	return r
+}
+
+// reportDate reads the {date} route variable, a period label.
+func reportDate(r *http.Request) (string, error) {
+	p, err := parsePeriodLabel(mux.Vars(r)["date"])
+	if err != nil {
+		return "", err
+	}
+	return p.Label(), nil
+}
+
+// loadReport writes the error response and returns false when the report
+// for the request's date can't be loaded.
+func loadReport(w http.ResponseWriter, r *http.Request) (ArchivedReport, bool) {
+	date, err := reportDate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return ArchivedReport{}, false
+	}
+	report, err := store.Report(date)
+	if err == ErrNotFound {
+		http.Error(w, "No report for "+date, http.StatusNotFound)
+		return report, false
+	} else if err != nil {
+		log.Printf("report %s: %v", date, err)
+		http.Error(w, "Failed to load report", http.StatusInternalServerError)
+		return report, false
+	}
+	return report, true
+}
+
+// listReports lists the archived reports, newest first, optionally only
+// those of one ?period kind.
+func listReports(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("period")
+	switch kind {
+	case "", PeriodDaily, PeriodWeekly, PeriodMonthly, PeriodCustom:
+	default:
+		http.Error(w, "Invalid period", http.StatusBadRequest)
+		return
+	}
+	reports, err := store.Reports()
+	if err != nil {
+		log.Printf("list reports: %v", err)
+		http.Error(w, "Failed to list reports", http.StatusInternalServerError)
+		return
+	}
+	out := []ArchivedReport{}
+	for _, report := range reports {
+		if kind == "" || report.Period == kind {
+			out = append(out, report)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// getReport downloads an archived report's PDF.
+func getReport(w http.ResponseWriter, r *http.Request) {
+	report, ok := loadReport(w, r)
+	if !ok {
+		return
+	}
+	writeReportPDF(w, report)
+}
+
+// getReportInsights returns the numbers an archived report was built from.
+func getReportInsights(w http.ResponseWriter, r *http.Request) {
+	report, ok := loadReport(w, r)
+	if !ok {
+		return
+	}
+	if len(report.Insights) == 0 {
+		http.Error(w, "No insights were archived for "+report.Date, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(report.Insights)
+}
+
+// writeReportPDF sends report as a PDF download.
+func writeReportPDF(w http.ResponseWriter, report ArchivedReport) {
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="crypto_report_%s.pdf"`, report.Date))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(report.PDF)))
+	w.Header().Set("Last-Modified", report.GeneratedAt.UTC().Format(http.TimeFormat))
+	if _, err := w.Write(report.PDF); err != nil {
+		log.Printf("Error writing PDF response: %v", err)
+	}
+}
+
+// sortReports orders reports by the start of their period, newest first,
+// and longer periods before shorter ones that start the same day.
+func sortReports(reports []ArchivedReport) {
+	sort.Slice(reports, func(i, j int) bool {
+		a, b := reports[i], reports[j]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.After(b.Start)
+		}
+		if !a.End.Equal(b.End) {
+			return a.End.After(b.End)
+		}
+		return a.Date > b.Date
+	})
+}
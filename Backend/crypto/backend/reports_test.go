@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestReportArchive(t *testing.T) {
+	useMemoryStore(t)
+	for _, kind := range []string{PeriodDaily, PeriodWeekly, PeriodMonthly} {
+		p, err := periodContaining(kind, day("2025-01-06"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		archiveReport(ReportInsights{Date: p.End, Period: p}, []byte("%PDF-"+kind), kind+" narrative")
+	}
+	jan5, _ := periodContaining(PeriodDaily, day("2025-01-05"))
+	// Rebuilding a period replaces its report.
+	archiveReport(ReportInsights{Period: jan5}, []byte("%PDF-old"), "")
+	archiveReport(ReportInsights{Period: jan5}, []byte("%PDF-new"), "")
+
+	serve := func(h http.HandlerFunc, target string, vars map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if vars != nil {
+			r = mux.SetURLVars(r, vars)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// Newest start first; the week starting the same day as a daily report
+		// goes before it.
+		{"", []string{"2025-W02", "2025-01-06", "2025-01-05", "2025-01"}},
+		{"?period=daily", []string{"2025-01-06", "2025-01-05"}},
+		{"?period=weekly", []string{"2025-W02"}},
+		{"?period=custom", []string{}},
+	}
+	for _, tt := range tests {
+		w := serve(listReports, "/reports"+tt.query, nil)
+		var list []ArchivedReport
+		json.NewDecoder(w.Body).Decode(&list)
+		var dates []string
+		for _, r := range list {
+			dates = append(dates, r.Date)
+		}
+		if len(dates) != len(tt.want) {
+			t.Errorf("/reports%s = %v, want %v", tt.query, dates, tt.want)
+			continue
+		}
+		for i := range dates {
+			if dates[i] != tt.want[i] {
+				t.Errorf("/reports%s = %v, want %v", tt.query, dates, tt.want)
+				break
+			}
+		}
+	}
+	if w := serve(listReports, "/reports?period=hourly", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown period: %d", w.Code)
+	}
+
+	w := serve(getReport, "/reports/2025-W02", map[string]string{"date": "2025-W02"})
+	if w.Code != http.StatusOK || w.Body.String() != "%PDF-weekly" || w.Header().Get("Content-Type") != "application/pdf" ||
+		w.Header().Get("Content-Disposition") != `attachment; filename="crypto_report_2025-W02.pdf"` {
+		t.Errorf("weekly PDF: %d %v %q", w.Code, w.Header(), w.Body)
+	}
+	if w := serve(getReport, "/reports/2025-01-05", map[string]string{"date": "2025-01-05"}); w.Body.String() != "%PDF-new" {
+		t.Errorf("replaced report: %q", w.Body)
+	}
+
+	w = serve(getReportInsights, "/reports/2025-01/insights", map[string]string{"date": "2025-01"})
+	var insights ReportInsights
+	if err := json.NewDecoder(w.Body).Decode(&insights); err != nil || insights.Period.Kind != PeriodMonthly || !insights.Period.Start.Equal(day("2025-01-01")) {
+		t.Errorf("insights: %d %+v %v", w.Code, insights, err)
+	}
+
+	for date, code := range map[string]int{"2025-01-07": http.StatusNotFound, "2025-13": http.StatusBadRequest, "latest": http.StatusBadRequest} {
+		if w := serve(getReport, "/reports/"+date, map[string]string{"date": date}); w.Code != code {
+			t.Errorf("/reports/%s: %d, want %d", date, w.Code, code)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quote is a single provider's USD price for a coin. Volume is the 24h
+// traded volume in USD; Volume, MarketCap and Change24hPct are 0 when the
+// provider does not report them.
+type Quote struct {
+	CoinID       string
+	Source       string
+	PriceUSD     float64
+	Volume       float64
+	MarketCap    float64
+	Change24hPct float64
+}
+
+// PriceSource fetches current USD prices for a set of registry coins,
+// translating each to its provider-specific id. Coins the provider does not
+// list are left out of the result rather than reported as an error.
+type PriceSource interface {
+	Name() string
+	Fetch(ctx context.Context, coins []Coin) ([]Quote, error)
+}
+
+// sourceHTTPClient is shared by every HTTP price source.
+var sourceHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// configuredPriceSources builds the sources named in PRICE_SOURCES, a comma
+// separated list (default "coingecko"). The "replay" source reads the file
+// named by PRICE_REPLAY_FILE.
+func configuredPriceSources() ([]PriceSource, error) {
+	names := os.Getenv("PRICE_SOURCES")
+	if names == "" {
+		names = "coingecko"
+	}
+
+	var sources []PriceSource
+	for _, name := range strings.Split(names, ",") {
+		src, err := newPriceSource(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+func newPriceSource(name string) (PriceSource, error) {
+	switch name {
+	case "coingecko":
+		return coinGeckoSource{}, nil
+	case "binance":
+		return binanceSource{}, nil
+	case "kraken":
+		return krakenSource{}, nil
+	case "coinbase":
+		return coinbaseSource{}, nil
+	case "replay":
+		path := os.Getenv("PRICE_REPLAY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("replay source requires PRICE_REPLAY_FILE")
+		}
+		return newReplaySource(path)
+	default:
+		return nil, fmt.Errorf("unknown price source %q", name)
+	}
+}
+
+// httpStatusError is returned by getJSON for a non-200 response. RetryAfter
+// is the provider's Retry-After header in seconds, or 0 when absent.
+type httpStatusError struct {
+	Status     string
+	Code       int
+	RetryAfter time.Duration
+}
+
+func (e *httpStatusError) Error() string {
+	return "non-OK HTTP status: " + e.Status
+}
+
+// getJSON issues a GET and decodes a 200 response into out.
+func getJSON(ctx context.Context, rawURL string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := sourceHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		statusErr := &httpStatusError{Status: resp.Status, Code: resp.StatusCode}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			statusErr.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return statusErr
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// CoinGeckoResponse is the body of CoinGecko's simple/price endpoint.
+type CoinGeckoResponse map[string]struct {
+	USD          float64 `json:"usd"`
+	USDMarketCap float64 `json:"usd_market_cap"`
+	USD24hVol    float64 `json:"usd_24h_vol"`
+	USD24hChange float64 `json:"usd_24h_change"`
+}
+
+type coinGeckoSource struct{}
+
+func (coinGeckoSource) Name() string { return "coingecko" }
+
+func (coinGeckoSource) Fetch(ctx context.Context, coins []Coin) ([]Quote, error) {
+	byGeckoID := make(map[string]string, len(coins))
+	ids := make([]string, 0, len(coins))
+	for _, c := range coins {
+		geckoID := c.ProviderID("coingecko", c.ID)
+		byGeckoID[geckoID] = c.ID
+		ids = append(ids, geckoID)
+	}
+	u := "https://api.coingecko.com/api/v3/simple/price?ids=" + url.QueryEscape(strings.Join(ids, ",")) + "&vs_currencies=usd&include_market_cap=true&include_24hr_vol=true&include_24hr_change=true"
+
+	var prices CoinGeckoResponse
+	if err := getJSON(ctx, u, &prices); err != nil {
+		return nil, err
+	}
+
+	var quotes []Quote
+	for geckoID, data := range prices {
+		coinID, ok := byGeckoID[geckoID]
+		if !ok {
+			continue
+		}
+		quotes = append(quotes, Quote{
+			CoinID:       coinID,
+			Source:       "coingecko",
+			PriceUSD:     data.USD,
+			Volume:       data.USD24hVol,
+			MarketCap:    data.USDMarketCap,
+			Change24hPct: data.USD24hChange,
+		})
+	}
+	return quotes, nil
+}
+
+// binanceSource reads USDT pairs, treating USDT as USD. Pairs are requested
+// one at a time because a single unknown symbol fails a batched request.
+type binanceSource struct{}
+
+func (binanceSource) Name() string { return "binance" }
+
+func (binanceSource) Fetch(ctx context.Context, coins []Coin) ([]Quote, error) {
+	var quotes []Quote
+	var lastErr error
+	for _, c := range coins {
+		pair := c.ProviderID("binance", c.Symbol+"USDT")
+
+		var ticker struct {
+			LastPrice          string `json:"lastPrice"`
+			QuoteVolume        string `json:"quoteVolume"`
+			PriceChangePercent string `json:"priceChangePercent"`
+		}
+		if err := getJSON(ctx, "https://api.binance.com/api/v3/ticker/24hr?symbol="+url.QueryEscape(pair), &ticker); err != nil {
+			lastErr = err
+			continue
+		}
+		price, err := strconv.ParseFloat(ticker.LastPrice, 64)
+		if err != nil {
+			continue
+		}
+		volume, _ := strconv.ParseFloat(ticker.QuoteVolume, 64)
+		change, _ := strconv.ParseFloat(ticker.PriceChangePercent, 64)
+		quotes = append(quotes, Quote{CoinID: c.ID, Source: "binance", PriceUSD: price, Volume: volume, Change24hPct: change})
+	}
+	if len(quotes) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return quotes, nil
+}
+
+// krakenSource queries one pair at a time because Kraken renames pairs in
+// its response (XBTUSD comes back as XXBTZUSD).
+type krakenSource struct{}
+
+func (krakenSource) Name() string { return "kraken" }
+
+func (krakenSource) Fetch(ctx context.Context, coins []Coin) ([]Quote, error) {
+	var quotes []Quote
+	var lastErr error
+	for _, c := range coins {
+		pair := c.ProviderID("kraken", c.Symbol+"USD")
+
+		var body struct {
+			Error  []string `json:"error"`
+			Result map[string]struct {
+				Last   []string `json:"c"` // [price, lot volume]
+				Volume []string `json:"v"` // [today, last 24h] in base units
+			} `json:"result"`
+		}
+		if err := getJSON(ctx, "https://api.kraken.com/0/public/Ticker?pair="+url.QueryEscape(pair), &body); err != nil {
+			lastErr = err
+			continue
+		}
+		if len(body.Error) > 0 {
+			lastErr = fmt.Errorf("kraken %s: %s", pair, strings.Join(body.Error, "; "))
+			continue
+		}
+		for _, ticker := range body.Result {
+			if len(ticker.Last) == 0 {
+				continue
+			}
+			price, err := strconv.ParseFloat(ticker.Last[0], 64)
+			if err != nil {
+				continue
+			}
+			var volume float64
+			if len(ticker.Volume) > 1 {
+				base, _ := strconv.ParseFloat(ticker.Volume[1], 64)
+				volume = base * price
+			}
+			quotes = append(quotes, Quote{CoinID: c.ID, Source: "kraken", PriceUSD: price, Volume: volume})
+		}
+	}
+	if len(quotes) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return quotes, nil
+}
+
+// coinbaseSource uses the public spot price endpoint, one request per coin.
+type coinbaseSource struct{}
+
+func (coinbaseSource) Name() string { return "coinbase" }
+
+func (coinbaseSource) Fetch(ctx context.Context, coins []Coin) ([]Quote, error) {
+	var quotes []Quote
+	var lastErr error
+	for _, c := range coins {
+		pair := c.ProviderID("coinbase", c.Symbol+"-USD")
+
+		var body struct {
+			Data struct {
+				Amount string `json:"amount"`
+			} `json:"data"`
+		}
+		if err := getJSON(ctx, "https://api.coinbase.com/v2/prices/"+url.PathEscape(pair)+"/spot", &body); err != nil {
+			lastErr = err
+			continue
+		}
+		if price, err := strconv.ParseFloat(body.Data.Amount, 64); err == nil {
+			quotes = append(quotes, Quote{CoinID: c.ID, Source: "coinbase", PriceUSD: price})
+		}
+	}
+	if len(quotes) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return quotes, nil
+}
+
+// replaySource plays back recorded prices for offline use. The file holds a
+// JSON array of PriceData rows (the same shape as MEMORY_STORE_SEED); rows
+// sharing a timestamp form one tick, and each Fetch returns the next tick,
+// wrapping around at the end.
+type replaySource struct {
+	mu    sync.Mutex
+	ticks [][]PriceData
+	next  int
+}
+
+func newReplaySource(path string) (*replaySource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []PriceData
+	if err := json.NewDecoder(f).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("replay file %s is empty", path)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Timestamp.Before(rows[j].Timestamp) })
+	var ticks [][]PriceData
+	for i, row := range rows {
+		if i == 0 || !row.Timestamp.Equal(rows[i-1].Timestamp) {
+			ticks = append(ticks, nil)
+		}
+		ticks[len(ticks)-1] = append(ticks[len(ticks)-1], row)
+	}
+	return &replaySource{ticks: ticks}, nil
+}
+
+func (s *replaySource) Name() string { return "replay" }
+
+func (s *replaySource) Fetch(ctx context.Context, coins []Coin) ([]Quote, error) {
+	s.mu.Lock()
+	tick := s.ticks[s.next]
+	s.next = (s.next + 1) % len(s.ticks)
+	s.mu.Unlock()
+
+	wanted := make(map[string]bool, len(coins))
+	for _, c := range coins {
+		wanted[c.ID] = true
+	}
+
+	var quotes []Quote
+	for _, row := range tick {
+		if wanted[row.CoinID] {
+			quotes = append(quotes, Quote{CoinID: row.CoinID, Source: "replay", PriceUSD: row.PriceUSD})
+		}
+	}
+	return quotes, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeProvider answers requests from canned bodies keyed by URL; any other
+// URL gets a 400, like an unknown pair.
+type fakeProvider map[string]string
+
+func (f fakeProvider) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, ok := f[r.URL.String()]
+	code := http.StatusOK
+	if !ok {
+		code, body = http.StatusBadRequest, `{}`
+	}
+	return &http.Response{
+		StatusCode: code,
+		Status:     http.StatusText(code),
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+func useFakeProvider(t *testing.T, f fakeProvider) {
+	old := sourceHTTPClient
+	sourceHTTPClient = &http.Client{Transport: f}
+	t.Cleanup(func() { sourceHTTPClient = old })
+}
+
+func testCoins(t *testing.T, ids ...string) []Coin {
+	var coins []Coin
+	for _, id := range ids {
+		c, ok := registry.Resolve(id)
+		if !ok {
+			t.Fatalf("%s is not in coins.json", id)
+		}
+		coins = append(coins, c)
+	}
+	return coins
+}
+
+func sortedQuotes(quotes []Quote) []Quote {
+	sort.Slice(quotes, func(i, j int) bool { return quotes[i].CoinID < quotes[j].CoinID })
+	return quotes
+}
+
+func TestPriceSources(t *testing.T) {
+	useMemoryStore(t)
+	useFakeProvider(t, fakeProvider{
+		// avalanche is listed as avalanche-2; the unrequested coin is ignored.
+		"https://api.coingecko.com/api/v3/simple/price?ids=bitcoin%2Cavalanche-2&vs_currencies=usd&include_market_cap=true&include_24hr_vol=true&include_24hr_change=true": `{
+			"bitcoin": {"usd": 60000, "usd_market_cap": 1.2e12, "usd_24h_vol": 3e10, "usd_24h_change": 1.5},
+			"avalanche-2": {"usd": 30},
+			"dogecoin": {"usd": 0.1}}`,
+		"https://api.binance.com/api/v3/ticker/24hr?symbol=BTCUSDT": `{"lastPrice": "60010.5", "quoteVolume": "2e9", "priceChangePercent": "-0.5"}`,
+		// XBTUSD comes back under Kraken's own name.
+		"https://api.kraken.com/0/public/Ticker?pair=XBTUSD":  `{"error": [], "result": {"XXBTZUSD": {"c": ["60020", "0.1"], "v": ["10", "100"]}}}`,
+		"https://api.coinbase.com/v2/prices/BTC-USD/spot":     `{"data": {"amount": "60030"}}`,
+		"https://api.coinbase.com/v2/prices/AVAX-USD/spot":    `{"data": {"amount": "30.1"}}`,
+		"https://api.kraken.com/0/public/Ticker?pair=AVAXUSD": `{"error": ["EQuery:Unknown asset pair"]}`,
+	})
+	coins := testCoins(t, "bitcoin", "avalanche")
+
+	tests := []struct {
+		source PriceSource
+		want   []Quote
+	}{
+		{coinGeckoSource{}, []Quote{
+			{CoinID: "avalanche", Source: "coingecko", PriceUSD: 30},
+			{CoinID: "bitcoin", Source: "coingecko", PriceUSD: 60000, Volume: 3e10, MarketCap: 1.2e12, Change24hPct: 1.5},
+		}},
+		// AVAXUSDT is not served: that coin is left out.
+		{binanceSource{}, []Quote{{CoinID: "bitcoin", Source: "binance", PriceUSD: 60010.5, Volume: 2e9, Change24hPct: -0.5}}},
+		{krakenSource{}, []Quote{{CoinID: "bitcoin", Source: "kraken", PriceUSD: 60020, Volume: 6002000}}},
+		{coinbaseSource{}, []Quote{
+			{CoinID: "avalanche", Source: "coinbase", PriceUSD: 30.1},
+			{CoinID: "bitcoin", Source: "coinbase", PriceUSD: 60030},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := tt.source.Fetch(context.Background(), coins)
+		if err != nil {
+			t.Errorf("%s: %v", tt.source.Name(), err)
+			continue
+		}
+		if got = sortedQuotes(got); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.source.Name(), got, tt.want)
+		}
+	}
+
+	// A source that serves none of the coins reports why.
+	if _, err := (krakenSource{}).Fetch(context.Background(), testCoins(t, "avalanche")); err == nil || !strings.Contains(err.Error(), "Unknown asset pair") {
+		t.Errorf("kraken without a quote: %v", err)
+	}
+	if _, err := (binanceSource{}).Fetch(context.Background(), testCoins(t, "avalanche")); err == nil {
+		t.Error("binance without a quote: no error")
+	}
+}
+
+func TestReplaySource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.json")
+	os.WriteFile(path, []byte(`[
+		{"coin_id": "bitcoin", "timestamp": "2025-01-01T12:10:00Z", "price_usd": 2},
+		{"coin_id": "bitcoin", "timestamp": "2025-01-01T12:00:00Z", "price_usd": 1},
+		{"coin_id": "ethereum", "timestamp": "2025-01-01T12:00:00Z", "price_usd": 10}
+	]`), 0o644)
+	src, err := newReplaySource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := []Coin{{ID: "bitcoin"}}
+	var got []float64
+	for i := 0; i < 3; i++ {
+		quotes, _ := src.Fetch(context.Background(), coins)
+		for _, q := range quotes {
+			got = append(got, q.PriceUSD)
+		}
+	}
+	if want := []float64{1, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v: ticks in time order, wrapping around", got, want)
+	}
+}
+
+func TestConfiguredPriceSources(t *testing.T) {
+	t.Setenv("PRICE_SOURCES", "coingecko, kraken")
+	sources, err := configuredPriceSources()
+	if err != nil || len(sources) != 2 || sources[0].Name() != "coingecko" || sources[1].Name() != "kraken" {
+		t.Errorf("got %v, %v", sources, err)
+	}
+	for _, names := range []string{"coingecko,bitstamp", "replay"} {
+		t.Setenv("PRICE_SOURCES", names)
+		t.Setenv("PRICE_REPLAY_FILE", "")
+		if _, err := configuredPriceSources(); err == nil {
+			t.Errorf("PRICE_SOURCES=%s: no error", names)
+		}
+	}
+}
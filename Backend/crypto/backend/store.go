@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// PriceData is a single stored price row. SourcesAgreed and RejectedSources
+// describe the consensus that produced PriceUSD, and the market fields are
+// the 24h figures reported alongside it; rows written before those columns
+// existed leave them empty. VsCurrency and Price are only set on responses
+// converted to another quote currency and are never stored.
+type PriceData struct {
+	CoinID          string    `json:"coin_id"`
+	Timestamp       time.Time `json:"timestamp"`
+	PriceUSD        float64   `json:"price_usd"`
+	SourcesAgreed   int       `json:"sources_agreed,omitempty"`
+	RejectedSources []string  `json:"rejected_sources,omitempty"`
+	MarketCapUSD    float64   `json:"market_cap_usd,omitempty"`
+	Volume24hUSD    float64   `json:"volume_24h_usd,omitempty"`
+	Change24hPct    float64   `json:"change_24h_pct,omitempty"`
+	VsCurrency      string    `json:"vs_currency,omitempty"`
+	Price           float64   `json:"price,omitempty"`
+}
+
+// Value returns the converted Price when the row has a VsCurrency and
+// PriceUSD otherwise.
+func (p PriceData) Value() float64 {
+	if p.VsCurrency != "" {
+		return p.Price
+	}
+	return p.PriceUSD
+}
+
+// ErrNotFound is returned by a store when the requested row does not exist.
+var ErrNotFound = errors.New("not found")
+
+// PriceStore is the storage used by the API, the ingestion job and the report
+// pipeline. Range results are sorted by timestamp ascending.
+type PriceStore interface {
+	// Latest returns the most recent price for coinID.
+	Latest(coinID string) (PriceData, error)
+	// At returns the most recent price for coinID at or before ts.
+	At(coinID string, ts time.Time) (PriceData, error)
+	// Range returns every price for coinID with start <= timestamp <= end.
+	Range(coinID string, start, end time.Time) ([]PriceData, error)
+	// Coins returns the distinct coin ids that have at least one price.
+	Coins() ([]string, error)
+	// Insert stores a single price.
+	Insert(p PriceData) error
+
+	// AddPendingSubscriber stores a verification token for email.
+	AddPendingSubscriber(token, email string, createdAt time.Time) error
+	// PendingSubscriber looks up the email and creation time for token.
+	PendingSubscriber(token string) (email string, createdAt time.Time, err error)
+	// DeletePendingSubscriber removes a verification token.
+	DeletePendingSubscriber(token string) error
+	// AddSubscriber records email as a verified subscriber.
+	AddSubscriber(email string, subscribedAt time.Time) error
+	// RemoveSubscriber deletes email from the verified subscribers along
+	// with its preferences.
+	RemoveSubscriber(email string) error
+	// Subscribers returns every verified subscriber email.
+	Subscribers() ([]string, error)
+}
+
+// Store is implemented by every storage backend.
+type Store interface {
+	PriceStore
+	CoinStore
+	CandleStore
+	QuoteStore
+	AlertStore
+	PortfolioStore
+	TransactionStore
+	UserStore
+	LLMUsageStore
+	ReportStore
+	SubscriptionStore
+}
+
+// store is the backend shared by every handler and job in the process.
+var store Store
+
+// openStore selects the storage backend from STORE_BACKEND: "cassandra"
+// (the default) connects to Astra, "memory" keeps everything in process and
+// optionally seeds prices from the JSON file named by MEMORY_STORE_SEED.
+func openStore() (Store, error) {
+	switch backend := os.Getenv("STORE_BACKEND"); backend {
+	case "", "cassandra":
+		session, err := connectAstra()
+		if err != nil {
+			return nil, err
+		}
+		return newCassandraStore(session), nil
+	case "memory":
+		s := newMemoryStore()
+		if path := os.Getenv("MEMORY_STORE_SEED"); path != "" {
+			if err := s.LoadFile(path); err != nil {
+				return nil, fmt.Errorf("seed memory store: %w", err)
+			}
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown STORE_BACKEND %q", backend)
+	}
+}
@@ -0,0 +1,692 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	gocqlastra "github.com/datastax/gocql-astra"
+	"github.com/gocql/gocql"
+)
+
+// connectAstra opens a session to the iot_data keyspace using the
+// ASTRA_DB_ID and ASTRA_DB_APPLICATION_TOKEN environment variables.
+func connectAstra() (*gocql.Session, error) {
+	cluster, err := gocqlastra.NewClusterFromURL("https://api.astra.datastax.com", os.Getenv("ASTRA_DB_ID"), os.Getenv("ASTRA_DB_APPLICATION_TOKEN"), 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load cluster from astra: %w", err)
+	}
+	cluster.Keyspace = "iot_data"
+	cluster.Timeout = 30 * time.Second
+
+	start := time.Now()
+	session, err := gocql.NewSession(*cluster)
+	elapsed := time.Since(start)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect session: %w", err)
+	}
+
+	fmt.Println("Making the query now")
+
+	iter := session.Query("SELECT release_version FROM system.local").Iter()
+	var version string
+	for iter.Scan(&version) {
+		fmt.Println(version)
+	}
+	if err := iter.Close(); err != nil {
+		log.Printf("error running query: %v", err)
+	}
+
+	fmt.Printf("Connection process took %s\n", elapsed)
+	fmt.Println("Connected to Cassandra")
+	return session, nil
+}
+
+// cassandraStore is the PriceStore backed by the Astra keyspace.
+type cassandraStore struct {
+	session *gocql.Session
+}
+
+func newCassandraStore(session *gocql.Session) *cassandraStore {
+	return &cassandraStore{session: session}
+}
+
+// priceColumns is the column list read into a PriceData by priceDest.
+const priceColumns = "coin_id, timestamp, price_usd, sources_agreed, rejected_sources, market_cap_usd, volume_24h_usd, change_24h_pct"
+
+// priceDest returns the scan destinations matching priceColumns.
+func priceDest(p *PriceData) []interface{} {
+	return []interface{}{&p.CoinID, &p.Timestamp, &p.PriceUSD, &p.SourcesAgreed, &p.RejectedSources, &p.MarketCapUSD, &p.Volume24hUSD, &p.Change24hPct}
+}
+
+func (s *cassandraStore) Latest(coinID string) (PriceData, error) {
+	var data PriceData
+	err := s.session.Query(`
+		SELECT `+priceColumns+`
+		FROM crypto_price_by_coin
+		WHERE coin_id = ? LIMIT 1`, coinID).
+		Consistency(gocql.One).
+		Scan(priceDest(&data)...)
+	if err == gocql.ErrNotFound {
+		return data, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *cassandraStore) At(coinID string, ts time.Time) (PriceData, error) {
+	var data PriceData
+	err := s.session.Query(`
+		SELECT `+priceColumns+`
+		FROM crypto_price_by_coin
+		WHERE coin_id = ? AND timestamp <= ?
+		ORDER BY timestamp DESC
+		LIMIT 1 ALLOW FILTERING`,
+		coinID, ts).
+		Consistency(gocql.One).
+		Scan(priceDest(&data)...)
+	if err == gocql.ErrNotFound {
+		return data, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *cassandraStore) Range(coinID string, start, end time.Time) ([]PriceData, error) {
+	iter := s.session.Query(`
+		SELECT `+priceColumns+`
+		FROM crypto_price_by_coin
+		WHERE coin_id = ? AND timestamp >= ? AND timestamp <= ?
+		ORDER BY timestamp ASC ALLOW FILTERING`,
+		coinID, start, end).Consistency(gocql.One).Iter()
+
+	var out []PriceData
+	for {
+		var data PriceData
+		if !iter.Scan(priceDest(&data)...) {
+			break
+		}
+		out = append(out, data)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *cassandraStore) Coins() ([]string, error) {
+	iter := s.session.Query(`SELECT DISTINCT coin_id FROM crypto_price_by_coin`).Iter()
+	var coins []string
+	var coinID string
+	for iter.Scan(&coinID) {
+		coins = append(coins, coinID)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return coins, nil
+}
+
+func (s *cassandraStore) Insert(p PriceData) error {
+	return s.session.Query(`
+		INSERT INTO crypto_price_by_coin (`+priceColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.CoinID, p.Timestamp, p.PriceUSD, p.SourcesAgreed, p.RejectedSources,
+		p.MarketCapUSD, p.Volume24hUSD, p.Change24hPct).Exec()
+}
+
+func (s *cassandraStore) AddPendingSubscriber(token, email string, createdAt time.Time) error {
+	return s.session.Query(`
+		INSERT INTO iot_data.staging_subscribers ("token", email, created_at)
+		VALUES (?, ?, ?)`,
+		token, email, createdAt).Exec()
+}
+
+func (s *cassandraStore) PendingSubscriber(token string) (string, time.Time, error) {
+	var email string
+	var createdAt time.Time
+	err := s.session.Query(`
+		SELECT email, created_at
+		FROM iot_data.staging_subscribers
+		WHERE "token" = ?`,
+		token).Scan(&email, &createdAt)
+	if err == gocql.ErrNotFound {
+		return "", time.Time{}, ErrNotFound
+	}
+	return email, createdAt, err
+}
+
+func (s *cassandraStore) DeletePendingSubscriber(token string) error {
+	return s.session.Query(`
+		DELETE FROM iot_data.staging_subscribers
+		WHERE "token" = ?`,
+		token).Exec()
+}
+
+func (s *cassandraStore) AddSubscriber(email string, subscribedAt time.Time) error {
+	return s.session.Query(`
+		INSERT INTO iot_data.email_subscribers (email, subscribed_at)
+		VALUES (?, ?)`,
+		email, subscribedAt).Exec()
+}
+
+func (s *cassandraStore) RemoveSubscriber(email string) error {
+	if err := s.session.Query(`
+		DELETE FROM iot_data.email_subscribers WHERE email = ?`,
+		email).Exec(); err != nil {
+		return err
+	}
+	return s.session.Query(`
+		DELETE FROM iot_data.subscriber_prefs WHERE email = ?`,
+		email).Exec()
+}
+
+func (s *cassandraStore) Subscribers() ([]string, error) {
+	iter := s.session.Query(`SELECT email FROM email_subscribers`).Iter()
+	var emails []string
+	var email string
+	for iter.Scan(&email) {
+		emails = append(emails, email)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
+
+// QueryCQL runs a statement that selects coin_id, timestamp, price_usd.
+func (s *cassandraStore) QueryCQL(cql string, args ...interface{}) ([]PriceData, error) {
+	iter := s.session.Query(cql, args...).Iter()
+	var out []PriceData
+	var data PriceData
+	for iter.Scan(&data.CoinID, &data.Timestamp, &data.PriceUSD) {
+		out = append(out, data)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *cassandraStore) RegistryCoins() ([]Coin, error) {
+	iter := s.session.Query(`
+		SELECT coin_id, symbol, name, disabled, aliases, provider_ids
+		FROM coin_registry`).Iter()
+	var coins []Coin
+	for {
+		var c Coin
+		if !iter.Scan(&c.ID, &c.Symbol, &c.Name, &c.Disabled, &c.Aliases, &c.ProviderIDs) {
+			break
+		}
+		coins = append(coins, c)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return coins, nil
+}
+
+func (s *cassandraStore) PutCoin(c Coin) error {
+	return s.session.Query(`
+		INSERT INTO coin_registry (coin_id, symbol, name, disabled, aliases, provider_ids)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		c.ID, c.Symbol, c.Name, c.Disabled, c.Aliases, c.ProviderIDs).Exec()
+}
+
+const candleColumns = "coin_id, interval, bucket_start, open, high, low, close, ticks, first_tick, last_tick"
+
+func candleDest(c *Candle) []interface{} {
+	return []interface{}{&c.CoinID, &c.Interval, &c.Start, &c.Open, &c.High, &c.Low, &c.Close, &c.Ticks, &c.FirstTick, &c.LastTick}
+}
+
+func (s *cassandraStore) Candle(coinID, interval string, start time.Time) (Candle, error) {
+	var c Candle
+	err := s.session.Query(`
+		SELECT `+candleColumns+`
+		FROM crypto_candles
+		WHERE coin_id = ? AND interval = ? AND bucket_start = ?`,
+		coinID, interval, start).
+		Consistency(gocql.One).
+		Scan(candleDest(&c)...)
+	if err == gocql.ErrNotFound {
+		return c, ErrNotFound
+	}
+	return c, err
+}
+
+func (s *cassandraStore) PutCandle(c Candle) error {
+	return s.session.Query(`
+		INSERT INTO crypto_candles (`+candleColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.CoinID, c.Interval, c.Start, c.Open, c.High, c.Low, c.Close, c.Ticks, c.FirstTick, c.LastTick).Exec()
+}
+
+func (s *cassandraStore) Candles(coinID, interval string, start, end time.Time) ([]Candle, error) {
+	iter := s.session.Query(`
+		SELECT `+candleColumns+`
+		FROM crypto_candles
+		WHERE coin_id = ? AND interval = ? AND bucket_start >= ? AND bucket_start <= ?
+		ORDER BY bucket_start ASC`,
+		coinID, interval, start, end).Consistency(gocql.One).Iter()
+
+	var out []Candle
+	for {
+		var c Candle
+		if !iter.Scan(candleDest(&c)...) {
+			break
+		}
+		out = append(out, c)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *cassandraStore) InsertQuote(q CurrencyQuote) error {
+	return s.session.Query(`
+		INSERT INTO crypto_quote_by_coin (coin_id, vs_currency, timestamp, price)
+		VALUES (?, ?, ?, ?)`,
+		q.CoinID, q.VsCurrency, q.Timestamp, q.Price).Exec()
+}
+
+func (s *cassandraStore) QuoteRange(coinID, vs string, start, end time.Time) ([]CurrencyQuote, error) {
+	iter := s.session.Query(`
+		SELECT coin_id, vs_currency, timestamp, price
+		FROM crypto_quote_by_coin
+		WHERE coin_id = ? AND vs_currency = ? AND timestamp >= ? AND timestamp <= ?
+		ORDER BY timestamp ASC`,
+		coinID, vs, start, end).Consistency(gocql.One).Iter()
+
+	var out []CurrencyQuote
+	var q CurrencyQuote
+	for iter.Scan(&q.CoinID, &q.VsCurrency, &q.Timestamp, &q.Price) {
+		out = append(out, q)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+const alertColumns = "id, user_id, email, coin_id, kind, direction, threshold, window_minutes, channel, webhook_url, webhook_secret, cooldown_minutes, enabled, created_at, last_fired_at"
+
+func alertDest(a *AlertRule) []interface{} {
+	return []interface{}{&a.ID, &a.UserID, &a.Email, &a.CoinID, &a.Kind, &a.Direction, &a.Threshold, &a.WindowMinutes, &a.Channel,
+		&a.WebhookURL, &a.WebhookSecret, &a.CooldownMinutes, &a.Enabled, &a.CreatedAt, &a.LastFiredAt}
+}
+
+func (s *cassandraStore) Alerts() ([]AlertRule, error) {
+	iter := s.session.Query(`SELECT ` + alertColumns + ` FROM alert_rules`).Iter()
+	var out []AlertRule
+	for {
+		var a AlertRule
+		if !iter.Scan(alertDest(&a)...) {
+			break
+		}
+		out = append(out, a)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *cassandraStore) Alert(id string) (AlertRule, error) {
+	var a AlertRule
+	err := s.session.Query(`SELECT `+alertColumns+` FROM alert_rules WHERE id = ?`, id).Scan(alertDest(&a)...)
+	if err == gocql.ErrNotFound {
+		return a, ErrNotFound
+	}
+	return a, err
+}
+
+func (s *cassandraStore) PutAlert(a AlertRule) error {
+	return s.session.Query(`
+		INSERT INTO alert_rules (`+alertColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, a.UserID, a.Email, a.CoinID, a.Kind, a.Direction, a.Threshold, a.WindowMinutes, a.Channel,
+		a.WebhookURL, a.WebhookSecret, a.CooldownMinutes, a.Enabled, a.CreatedAt, a.LastFiredAt).Exec()
+}
+
+func (s *cassandraStore) DeleteAlert(id string) error {
+	return s.session.Query(`DELETE FROM alert_rules WHERE id = ?`, id).Exec()
+}
+
+func (s *cassandraStore) PutAlertKey(keyHash, email string, createdAt time.Time) error {
+	return s.session.Query(`
+		INSERT INTO alert_keys (key_hash, email, created_at)
+		VALUES (?, ?, ?)`,
+		keyHash, email, createdAt).Exec()
+}
+
+func (s *cassandraStore) AlertKeyEmail(keyHash string) (string, error) {
+	var email string
+	err := s.session.Query(`SELECT email FROM alert_keys WHERE key_hash = ?`, keyHash).Scan(&email)
+	if err == gocql.ErrNotFound {
+		return "", ErrNotFound
+	}
+	return email, err
+}
+
+const portfolioColumns = "id, user_id, name, key_hash, created_at"
+
+func portfolioDest(p *Portfolio) []interface{} {
+	return []interface{}{&p.ID, &p.UserID, &p.Name, &p.KeyHash, &p.CreatedAt}
+}
+
+func (s *cassandraStore) Portfolio(id string) (Portfolio, error) {
+	var p Portfolio
+	err := s.session.Query(`SELECT `+portfolioColumns+` FROM portfolios WHERE id = ?`, id).Scan(portfolioDest(&p)...)
+	if err == gocql.ErrNotFound {
+		return p, ErrNotFound
+	}
+	return p, err
+}
+
+// Portfolios scans the portfolio table, as APIKeys does the key table;
+// accounts have few portfolios, so no index on user_id is kept.
+func (s *cassandraStore) Portfolios(userID string) ([]Portfolio, error) {
+	iter := s.session.Query(`SELECT ` + portfolioColumns + ` FROM portfolios`).Iter()
+	var out []Portfolio
+	for {
+		var p Portfolio
+		if !iter.Scan(portfolioDest(&p)...) {
+			break
+		}
+		if p.UserID == userID {
+			out = append(out, p)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *cassandraStore) PutPortfolio(p Portfolio) error {
+	return s.session.Query(`
+		INSERT INTO portfolios (`+portfolioColumns+`)
+		VALUES (?, ?, ?, ?, ?)`,
+		p.ID, p.UserID, p.Name, p.KeyHash, p.CreatedAt).Exec()
+}
+
+func (s *cassandraStore) DeletePortfolio(id string) error {
+	if err := s.session.Query(`DELETE FROM portfolio_holdings WHERE portfolio_id = ?`, id).Exec(); err != nil {
+		return err
+	}
+	if err := s.session.Query(`DELETE FROM portfolio_transactions WHERE portfolio_id = ?`, id).Exec(); err != nil {
+		return err
+	}
+	return s.session.Query(`DELETE FROM portfolios WHERE id = ?`, id).Exec()
+}
+
+func (s *cassandraStore) Holdings(portfolioID string) ([]Holding, error) {
+	iter := s.session.Query(`
+		SELECT portfolio_id, holding_id, coin_id, quantity, cost_basis_usd, acquired_at
+		FROM portfolio_holdings
+		WHERE portfolio_id = ?`,
+		portfolioID).Iter()
+
+	var out []Holding
+	var h Holding
+	for iter.Scan(&h.PortfolioID, &h.ID, &h.CoinID, &h.Quantity, &h.CostBasisUSD, &h.AcquiredAt) {
+		out = append(out, h)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].AcquiredAt.Before(out[j].AcquiredAt) })
+	return out, nil
+}
+
+func (s *cassandraStore) PutHolding(h Holding) error {
+	return s.session.Query(`
+		INSERT INTO portfolio_holdings (portfolio_id, holding_id, coin_id, quantity, cost_basis_usd, acquired_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		h.PortfolioID, h.ID, h.CoinID, h.Quantity, h.CostBasisUSD, h.AcquiredAt).Exec()
+}
+
+func (s *cassandraStore) DeleteHolding(portfolioID, holdingID string) error {
+	return s.session.Query(`
+		DELETE FROM portfolio_holdings
+		WHERE portfolio_id = ? AND holding_id = ?`,
+		portfolioID, holdingID).Exec()
+}
+
+func (s *cassandraStore) Transactions(portfolioID string) ([]Transaction, error) {
+	iter := s.session.Query(`
+		SELECT portfolio_id, tx_id, tx_time, tx_type, coin_id, quantity, price_usd, fee_usd, source, external_id
+		FROM portfolio_transactions
+		WHERE portfolio_id = ?`,
+		portfolioID).Iter()
+
+	var out []Transaction
+	var t Transaction
+	for iter.Scan(&t.PortfolioID, &t.ID, &t.Time, &t.Type, &t.CoinID, &t.Quantity, &t.PriceUSD, &t.FeeUSD, &t.Source, &t.ExternalID) {
+		out = append(out, t)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out, nil
+}
+
+func (s *cassandraStore) PutTransaction(t Transaction) error {
+	return s.session.Query(`
+		INSERT INTO portfolio_transactions (portfolio_id, tx_id, tx_time, tx_type, coin_id, quantity, price_usd, fee_usd, source, external_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.PortfolioID, t.ID, t.Time, t.Type, t.CoinID, t.Quantity, t.PriceUSD, t.FeeUSD, t.Source, t.ExternalID).Exec()
+}
+
+func (s *cassandraStore) DeleteTransaction(portfolioID, txID string) error {
+	return s.session.Query(`
+		DELETE FROM portfolio_transactions
+		WHERE portfolio_id = ? AND tx_id = ?`,
+		portfolioID, txID).Exec()
+}
+
+func (s *cassandraStore) Users() ([]User, error) {
+	iter := s.session.Query(`SELECT id, email, role, disabled, created_at FROM users`).Iter()
+	var out []User
+	var u User
+	for iter.Scan(&u.ID, &u.Email, &u.Role, &u.Disabled, &u.CreatedAt) {
+		out = append(out, u)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *cassandraStore) User(id string) (User, error) {
+	var u User
+	err := s.session.Query(`
+		SELECT id, email, role, disabled, created_at
+		FROM users
+		WHERE id = ?`,
+		id).Scan(&u.ID, &u.Email, &u.Role, &u.Disabled, &u.CreatedAt)
+	if err == gocql.ErrNotFound {
+		return u, ErrNotFound
+	}
+	return u, err
+}
+
+func (s *cassandraStore) PutUser(u User) error {
+	return s.session.Query(`
+		INSERT INTO users (id, email, role, disabled, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		u.ID, u.Email, u.Role, u.Disabled, u.CreatedAt).Exec()
+}
+
+func (s *cassandraStore) DeleteUser(id string) error {
+	keys, err := s.APIKeys(id)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := s.DeleteAPIKey(k.KeyHash); err != nil {
+			return err
+		}
+	}
+	return s.session.Query(`DELETE FROM users WHERE id = ?`, id).Exec()
+}
+
+const apiKeyColumns = "key_hash, key_id, user_id, name, created_at, last_used_at"
+
+func apiKeyDest(k *APIKey) []interface{} {
+	return []interface{}{&k.KeyHash, &k.ID, &k.UserID, &k.Name, &k.CreatedAt, &k.LastUsedAt}
+}
+
+// APIKeys scans the key table; accounts have few keys and the table stays
+// small, so no index on user_id is kept.
+func (s *cassandraStore) APIKeys(userID string) ([]APIKey, error) {
+	iter := s.session.Query(`SELECT ` + apiKeyColumns + ` FROM api_keys`).Iter()
+	var out []APIKey
+	for {
+		var k APIKey
+		if !iter.Scan(apiKeyDest(&k)...) {
+			break
+		}
+		if k.UserID == userID {
+			out = append(out, k)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *cassandraStore) APIKey(keyHash string) (APIKey, error) {
+	var k APIKey
+	err := s.session.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, keyHash).Scan(apiKeyDest(&k)...)
+	if err == gocql.ErrNotFound {
+		return k, ErrNotFound
+	}
+	return k, err
+}
+
+func (s *cassandraStore) PutAPIKey(k APIKey) error {
+	return s.session.Query(`
+		INSERT INTO api_keys (`+apiKeyColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		k.KeyHash, k.ID, k.UserID, k.Name, k.CreatedAt, k.LastUsedAt).Exec()
+}
+
+func (s *cassandraStore) DeleteAPIKey(keyHash string) error {
+	return s.session.Query(`DELETE FROM api_keys WHERE key_hash = ?`, keyHash).Exec()
+}
+
+const llmUsageColumns = "day, call_time, id, feature, model, prompt_tokens, completion_tokens, cost_usd, cached"
+
+func (s *cassandraStore) LLMUsage(day string) ([]LLMUsage, error) {
+	iter := s.session.Query(`SELECT `+llmUsageColumns+` FROM llm_usage WHERE day = ?`, day).Iter()
+	var out []LLMUsage
+	for {
+		var u LLMUsage
+		var d string
+		if !iter.Scan(&d, &u.Time, &u.ID, &u.Feature, &u.Model, &u.PromptTokens, &u.CompletionTokens, &u.CostUSD, &u.Cached) {
+			break
+		}
+		out = append(out, u)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *cassandraStore) PutLLMUsage(u LLMUsage) error {
+	return s.session.Query(`
+		INSERT INTO llm_usage (`+llmUsageColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.Time.UTC().Format("2006-01-02"), u.Time, u.ID, u.Feature, u.Model, u.PromptTokens, u.CompletionTokens, u.CostUSD, u.Cached).Exec()
+}
+
+// Reports scans the archive's small columns; there is one row a period.
+func (s *cassandraStore) Reports() ([]ArchivedReport, error) {
+	iter := s.session.Query(`SELECT report_date, period, period_start, period_end, generated_at, narrative, pdf_size FROM reports`).Iter()
+	var out []ArchivedReport
+	var r ArchivedReport
+	for iter.Scan(&r.Date, &r.Period, &r.Start, &r.End, &r.GeneratedAt, &r.Narrative, &r.SizeBytes) {
+		out = append(out, r)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	sortReports(out)
+	return out, nil
+}
+
+func (s *cassandraStore) Report(date string) (ArchivedReport, error) {
+	var r ArchivedReport
+	var insights string
+	err := s.session.Query(`
+		SELECT report_date, period, period_start, period_end, generated_at, narrative, pdf_size, pdf, insights
+		FROM reports
+		WHERE report_date = ?`,
+		date).Scan(&r.Date, &r.Period, &r.Start, &r.End, &r.GeneratedAt, &r.Narrative, &r.SizeBytes, &r.PDF, &insights)
+	if err == gocql.ErrNotFound {
+		return r, ErrNotFound
+	}
+	r.Insights = []byte(insights)
+	return r, err
+}
+
+func (s *cassandraStore) PutReport(r ArchivedReport) error {
+	return s.session.Query(`
+		INSERT INTO reports (report_date, period, period_start, period_end, generated_at, narrative, pdf_size, pdf, insights)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Date, r.Period, r.Start, r.End, r.GeneratedAt, r.Narrative, r.SizeBytes, r.PDF, string(r.Insights)).Exec()
+}
+
+func (s *cassandraStore) SubscriberPrefs(email string) (SubscriberPrefs, error) {
+	p := SubscriberPrefs{Email: email}
+	err := s.session.Query(`
+		SELECT digests, coins, timezone, sections, language, updated_at
+		FROM iot_data.subscriber_prefs
+		WHERE email = ?`,
+		email).Scan(&p.Digests, &p.Coins, &p.Timezone, &p.Sections, &p.Language, &p.UpdatedAt)
+	if err == gocql.ErrNotFound {
+		return p, ErrNotFound
+	}
+	// Sets come back sorted; empty ones are null.
+	if p.Digests == nil {
+		p.Digests = []string{}
+	}
+	if p.Coins == nil {
+		p.Coins = []string{}
+	}
+	if p.Sections == nil {
+		p.Sections = []string{}
+	}
+	return p, err
+}
+
+func (s *cassandraStore) PutSubscriberPrefs(p SubscriberPrefs) error {
+	return s.session.Query(`
+		INSERT INTO iot_data.subscriber_prefs (email, digests, coins, timezone, sections, language, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		p.Email, p.Digests, p.Coins, p.Timezone, p.Sections, p.Language, p.UpdatedAt).Exec()
+}
+
+func (s *cassandraStore) PutSubscriptionKey(keyHash, email string, createdAt time.Time) error {
+	return s.session.Query(`
+		INSERT INTO iot_data.subscription_keys (key_hash, email, created_at)
+		VALUES (?, ?, ?)`,
+		keyHash, email, createdAt).Exec()
+}
+
+func (s *cassandraStore) SubscriptionKeyEmail(keyHash string) (string, error) {
+	var email string
+	err := s.session.Query(`SELECT email FROM iot_data.subscription_keys WHERE key_hash = ?`, keyHash).Scan(&email)
+	if err == gocql.ErrNotFound {
+		return "", ErrNotFound
+	}
+	return email, err
+}
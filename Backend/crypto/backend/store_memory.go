@@ -0,0 +1,615 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type pendingSubscriber struct {
+	email     string
+	createdAt time.Time
+}
+
+// memoryStore is a PriceStore that keeps everything in process. It is meant
+// for local development and tests; nothing survives a restart.
+type memoryStore struct {
+	mu          sync.RWMutex
+	prices      map[string][]PriceData // sorted by timestamp ascending
+	pending     map[string]pendingSubscriber
+	subscribers map[string]time.Time
+	coins       map[string]Coin
+	candles     map[candleKey]Candle
+	quotes      map[quoteKey][]CurrencyQuote // sorted by timestamp ascending
+	alerts      map[string]AlertRule
+	alertKeys   map[string]string // key hash -> email
+	portfolios  map[string]Portfolio
+	holdings    map[string][]Holding     // by portfolio id, oldest first
+	ledger      map[string][]Transaction // by portfolio id, oldest first
+	users       map[string]User
+	apiKeys     map[string]APIKey         // by key hash
+	llmUsage    map[string][]LLMUsage     // by UTC day, oldest first
+	reports     map[string]ArchivedReport // by period label
+	prefs       map[string]SubscriberPrefs
+	subKeys     map[string]string // key hash -> email
+}
+
+type quoteKey struct {
+	coinID string
+	vs     string
+}
+
+type candleKey struct {
+	coinID   string
+	interval string
+	start    int64 // UnixNano of the bucket start
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		prices:      make(map[string][]PriceData),
+		pending:     make(map[string]pendingSubscriber),
+		subscribers: make(map[string]time.Time),
+		coins:       make(map[string]Coin),
+		candles:     make(map[candleKey]Candle),
+		quotes:      make(map[quoteKey][]CurrencyQuote),
+		alerts:      make(map[string]AlertRule),
+		alertKeys:   make(map[string]string),
+		portfolios:  make(map[string]Portfolio),
+		holdings:    make(map[string][]Holding),
+		ledger:      make(map[string][]Transaction),
+		users:       make(map[string]User),
+		apiKeys:     make(map[string]APIKey),
+		llmUsage:    make(map[string][]LLMUsage),
+		reports:     make(map[string]ArchivedReport),
+		prefs:       make(map[string]SubscriberPrefs),
+		subKeys:     make(map[string]string),
+	}
+}
+
+// LoadFile inserts every price from a JSON array of PriceData.
+func (s *memoryStore) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var rows []PriceData
+	if err := json.NewDecoder(f).Decode(&rows); err != nil {
+		return err
+	}
+	for _, p := range rows {
+		if err := s.Insert(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Latest(coinID string) (PriceData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	series := s.prices[coinID]
+	if len(series) == 0 {
+		return PriceData{}, ErrNotFound
+	}
+	return series[len(series)-1], nil
+}
+
+func (s *memoryStore) At(coinID string, ts time.Time) (PriceData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	series := s.prices[coinID]
+	// First index strictly after ts; the row before it is the answer.
+	i := sort.Search(len(series), func(i int) bool { return series[i].Timestamp.After(ts) })
+	if i == 0 {
+		return PriceData{}, ErrNotFound
+	}
+	return series[i-1], nil
+}
+
+func (s *memoryStore) Range(coinID string, start, end time.Time) ([]PriceData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	series := s.prices[coinID]
+	lo := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(start) })
+	hi := sort.Search(len(series), func(i int) bool { return series[i].Timestamp.After(end) })
+	if lo >= hi {
+		return nil, nil
+	}
+	return append([]PriceData(nil), series[lo:hi]...), nil
+}
+
+func (s *memoryStore) Coins() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	coins := make([]string, 0, len(s.prices))
+	for coin := range s.prices {
+		coins = append(coins, coin)
+	}
+	sort.Strings(coins)
+	return coins, nil
+}
+
+// Insert behaves like a Cassandra upsert: a row with the same coin and
+// timestamp replaces the existing one.
+func (s *memoryStore) Insert(p PriceData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	series := s.prices[p.CoinID]
+	i := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(p.Timestamp) })
+	if i < len(series) && series[i].Timestamp.Equal(p.Timestamp) {
+		series[i] = p
+		return nil
+	}
+	series = append(series, PriceData{})
+	copy(series[i+1:], series[i:])
+	series[i] = p
+	s.prices[p.CoinID] = series
+	return nil
+}
+
+func (s *memoryStore) AddPendingSubscriber(token, email string, createdAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[token] = pendingSubscriber{email: email, createdAt: createdAt}
+	return nil
+}
+
+func (s *memoryStore) PendingSubscriber(token string) (string, time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.pending[token]
+	if !ok {
+		return "", time.Time{}, ErrNotFound
+	}
+	return p.email, p.createdAt, nil
+}
+
+func (s *memoryStore) DeletePendingSubscriber(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, token)
+	return nil
+}
+
+func (s *memoryStore) AddSubscriber(email string, subscribedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[email] = subscribedAt
+	return nil
+}
+
+func (s *memoryStore) RemoveSubscriber(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, email)
+	delete(s.prefs, email)
+	return nil
+}
+
+func (s *memoryStore) Subscribers() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	emails := make([]string, 0, len(s.subscribers))
+	for email := range s.subscribers {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	return emails, nil
+}
+
+func (s *memoryStore) RegistryCoins() ([]Coin, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	coins := make([]Coin, 0, len(s.coins))
+	for _, c := range s.coins {
+		coins = append(coins, c)
+	}
+	return coins, nil
+}
+
+func (s *memoryStore) PutCoin(c Coin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.coins[c.ID] = c
+	return nil
+}
+
+func (s *memoryStore) Candle(coinID, interval string, start time.Time) (Candle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.candles[candleKey{coinID, interval, start.UnixNano()}]
+	if !ok {
+		return Candle{}, ErrNotFound
+	}
+	return c, nil
+}
+
+func (s *memoryStore) PutCandle(c Candle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.candles[candleKey{c.CoinID, c.Interval, c.Start.UnixNano()}] = c
+	return nil
+}
+
+func (s *memoryStore) Candles(coinID, interval string, start, end time.Time) ([]Candle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []Candle
+	for k, c := range s.candles {
+		if k.coinID == coinID && k.interval == interval && !c.Start.Before(start) && !c.Start.After(end) {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out, nil
+}
+
+func (s *memoryStore) InsertQuote(q CurrencyQuote) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := quoteKey{q.CoinID, q.VsCurrency}
+	series := s.quotes[k]
+	i := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(q.Timestamp) })
+	if i < len(series) && series[i].Timestamp.Equal(q.Timestamp) {
+		series[i] = q
+		return nil
+	}
+	series = append(series, CurrencyQuote{})
+	copy(series[i+1:], series[i:])
+	series[i] = q
+	s.quotes[k] = series
+	return nil
+}
+
+func (s *memoryStore) QuoteRange(coinID, vs string, start, end time.Time) ([]CurrencyQuote, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	series := s.quotes[quoteKey{coinID, vs}]
+	lo := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(start) })
+	hi := sort.Search(len(series), func(i int) bool { return series[i].Timestamp.After(end) })
+	if lo >= hi {
+		return nil, nil
+	}
+	return append([]CurrencyQuote(nil), series[lo:hi]...), nil
+}
+
+func (s *memoryStore) Alerts() ([]AlertRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]AlertRule, 0, len(s.alerts))
+	for _, a := range s.alerts {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *memoryStore) Alert(id string) (AlertRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.alerts[id]
+	if !ok {
+		return AlertRule{}, ErrNotFound
+	}
+	return a, nil
+}
+
+func (s *memoryStore) PutAlert(a AlertRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alerts[a.ID] = a
+	return nil
+}
+
+func (s *memoryStore) DeleteAlert(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.alerts, id)
+	return nil
+}
+
+func (s *memoryStore) PutAlertKey(keyHash, email string, createdAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alertKeys[keyHash] = email
+	return nil
+}
+
+func (s *memoryStore) AlertKeyEmail(keyHash string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	email, ok := s.alertKeys[keyHash]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return email, nil
+}
+
+func (s *memoryStore) Portfolio(id string) (Portfolio, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.portfolios[id]
+	if !ok {
+		return Portfolio{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (s *memoryStore) Portfolios(userID string) ([]Portfolio, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Portfolio
+	for _, p := range s.portfolios {
+		if p.UserID == userID {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *memoryStore) PutPortfolio(p Portfolio) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.portfolios[p.ID] = p
+	return nil
+}
+
+func (s *memoryStore) DeletePortfolio(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.portfolios, id)
+	delete(s.holdings, id)
+	delete(s.ledger, id)
+	return nil
+}
+
+func (s *memoryStore) Holdings(portfolioID string) ([]Holding, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Holding(nil), s.holdings[portfolioID]...), nil
+}
+
+func (s *memoryStore) PutHolding(h Holding) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.holdings[h.PortfolioID]
+	for i := range list {
+		if list[i].ID == h.ID {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	list = append(list, h)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].AcquiredAt.Before(list[j].AcquiredAt) })
+	s.holdings[h.PortfolioID] = list
+	return nil
+}
+
+func (s *memoryStore) DeleteHolding(portfolioID, holdingID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.holdings[portfolioID]
+	for i := range list {
+		if list[i].ID == holdingID {
+			s.holdings[portfolioID] = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Transactions(portfolioID string) ([]Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Transaction(nil), s.ledger[portfolioID]...), nil
+}
+
+func (s *memoryStore) PutTransaction(t Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.ledger[t.PortfolioID]
+	for i := range list {
+		if list[i].ID == t.ID {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	list = append(list, t)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	s.ledger[t.PortfolioID] = list
+	return nil
+}
+
+func (s *memoryStore) DeleteTransaction(portfolioID, txID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.ledger[portfolioID]
+	for i := range list {
+		if list[i].ID == txID {
+			s.ledger[portfolioID] = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Users() ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		out = append(out, u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *memoryStore) User(id string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (s *memoryStore) PutUser(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.ID] = u
+	return nil
+}
+
+func (s *memoryStore) DeleteUser(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, id)
+	for hash, k := range s.apiKeys {
+		if k.UserID == id {
+			delete(s.apiKeys, hash)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) APIKeys(userID string) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []APIKey
+	for _, k := range s.apiKeys {
+		if k.UserID == userID {
+			out = append(out, k)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *memoryStore) APIKey(keyHash string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.apiKeys[keyHash]
+	if !ok {
+		return APIKey{}, ErrNotFound
+	}
+	return k, nil
+}
+
+func (s *memoryStore) PutAPIKey(k APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiKeys[k.KeyHash] = k
+	return nil
+}
+
+func (s *memoryStore) DeleteAPIKey(keyHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.apiKeys, keyHash)
+	return nil
+}
+
+func (s *memoryStore) LLMUsage(day string) ([]LLMUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]LLMUsage(nil), s.llmUsage[day]...), nil
+}
+
+func (s *memoryStore) PutLLMUsage(u LLMUsage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	day := u.Time.UTC().Format("2006-01-02")
+	s.llmUsage[day] = append(s.llmUsage[day], u)
+	return nil
+}
+
+func (s *memoryStore) Reports() ([]ArchivedReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []ArchivedReport
+	for _, r := range s.reports {
+		r.PDF, r.Insights = nil, nil
+		out = append(out, r)
+	}
+	sortReports(out)
+	return out, nil
+}
+
+func (s *memoryStore) Report(date string) (ArchivedReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.reports[date]
+	if !ok {
+		return ArchivedReport{}, ErrNotFound
+	}
+	return r, nil
+}
+
+func (s *memoryStore) PutReport(r ArchivedReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reports[r.Date] = r
+	return nil
+}
+
+func (s *memoryStore) SubscriberPrefs(email string) (SubscriberPrefs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.prefs[email]
+	if !ok {
+		return SubscriberPrefs{}, ErrNotFound
+	}
+	p.Digests = append([]string{}, p.Digests...)
+	p.Coins = append([]string{}, p.Coins...)
+	p.Sections = append([]string{}, p.Sections...)
+	return p, nil
+}
+
+func (s *memoryStore) PutSubscriberPrefs(p SubscriberPrefs) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.Digests = append([]string{}, p.Digests...)
+	p.Coins = append([]string{}, p.Coins...)
+	p.Sections = append([]string{}, p.Sections...)
+	s.prefs[p.Email] = p
+	return nil
+}
+
+func (s *memoryStore) PutSubscriptionKey(keyHash, email string, createdAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subKeys[keyHash] = email
+	return nil
+}
+
+func (s *memoryStore) SubscriptionKeyEmail(keyHash string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	email, ok := s.subKeys[keyHash]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return email, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryStorePrices(t *testing.T) {
+	s := newMemoryStore()
+	at := func(minute int) time.Time { return time.Date(2025, 1, 1, 12, minute, 0, 0, time.UTC) }
+	// Out of order, with one row replaced.
+	for _, p := range []PriceData{
+		{CoinID: "bitcoin", Timestamp: at(20), PriceUSD: 3},
+		{CoinID: "bitcoin", Timestamp: at(0), PriceUSD: 1},
+		{CoinID: "bitcoin", Timestamp: at(10), PriceUSD: 0},
+		{CoinID: "bitcoin", Timestamp: at(10), PriceUSD: 2},
+		{CoinID: "ethereum", Timestamp: at(5), PriceUSD: 10},
+	} {
+		if err := s.Insert(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if p, err := s.Latest("bitcoin"); err != nil || p.PriceUSD != 3 {
+		t.Errorf("Latest = %+v, %v", p, err)
+	}
+	if _, err := s.Latest("solana"); err != ErrNotFound {
+		t.Errorf("Latest of an unknown coin: %v", err)
+	}
+	for _, tt := range []struct {
+		ts   time.Time
+		want float64
+		err  error
+	}{
+		{at(0), 1, nil},
+		{at(15), 2, nil},
+		{at(30), 3, nil},
+		{at(0).Add(-time.Second), 0, ErrNotFound},
+	} {
+		p, err := s.At("bitcoin", tt.ts)
+		if err != tt.err || p.PriceUSD != tt.want {
+			t.Errorf("At(%s) = %v, %v; want %v, %v", tt.ts.Format("15:04:05"), p.PriceUSD, err, tt.want, tt.err)
+		}
+	}
+
+	rows, _ := s.Range("bitcoin", at(0), at(10))
+	var prices []float64
+	for _, p := range rows {
+		prices = append(prices, p.PriceUSD)
+	}
+	if !reflect.DeepEqual(prices, []float64{1, 2}) {
+		t.Errorf("Range, both ends inclusive = %v, want [1 2]", prices)
+	}
+	if rows, _ := s.Range("bitcoin", at(11), at(19)); rows != nil {
+		t.Errorf("Range between rows = %v", rows)
+	}
+
+	if coins, _ := s.Coins(); !reflect.DeepEqual(coins, []string{"bitcoin", "ethereum"}) {
+		t.Errorf("Coins = %v", coins)
+	}
+}
+
+func TestMemoryStoreSubscribers(t *testing.T) {
+	s := newMemoryStore()
+	now := time.Now()
+	s.AddPendingSubscriber("tok", "a@example.com", now)
+	if email, created, err := s.PendingSubscriber("tok"); err != nil || email != "a@example.com" || !created.Equal(now) {
+		t.Errorf("PendingSubscriber = %q %v %v", email, created, err)
+	}
+	s.DeletePendingSubscriber("tok")
+	if _, _, err := s.PendingSubscriber("tok"); err != ErrNotFound {
+		t.Errorf("deleted token: %v", err)
+	}
+
+	s.AddSubscriber("b@example.com", now)
+	s.AddSubscriber("a@example.com", now)
+	if emails, _ := s.Subscribers(); !reflect.DeepEqual(emails, []string{"a@example.com", "b@example.com"}) {
+		t.Errorf("Subscribers = %v", emails)
+	}
+	s.RemoveSubscriber("a@example.com")
+	if emails, _ := s.Subscribers(); !reflect.DeepEqual(emails, []string{"b@example.com"}) {
+		t.Errorf("after removing one: %v", emails)
+	}
+}
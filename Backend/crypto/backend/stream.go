@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// tickHub fans new price rows out to streaming clients. Each subscriber has
+// a buffered channel; a client too slow to drain it loses ticks rather than
+// holding up the others. last holds the newest timestamp published per coin,
+// so a row that reaches the hub both pushed and polled goes out once.
+type tickHub struct {
+	mu   sync.Mutex
+	subs map[*tickSubscriber]struct{}
+	last map[string]time.Time
+}
+
+// tickSubscriber is one client. A stream opened without a coin filter
+// watches every coin except the ones it unsubscribes from; otherwise it
+// watches just the coins in its set, which may become empty.
+type tickSubscriber struct {
+	ch       chan PriceData
+	mu       sync.RWMutex
+	all      bool
+	coins    map[string]bool
+	excluded map[string]bool
+}
+
+// hub is the API process's tick hub, fed by the push endpoint or the poller.
+var hub = newTickHub()
+
+func newTickHub() *tickHub {
+	return &tickHub{subs: make(map[*tickSubscriber]struct{}), last: make(map[string]time.Time)}
+}
+
+func (h *tickHub) subscribe(coins []string) *tickSubscriber {
+	s := &tickSubscriber{ch: make(chan PriceData, 64)}
+	s.setCoins(coins)
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *tickHub) unsubscribe(s *tickSubscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+// publish delivers p to every subscriber watching its coin. A row no newer
+// than the last one published for its coin has already gone out and is
+// dropped.
+func (h *tickHub) publish(p PriceData) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if last, ok := h.last[p.CoinID]; ok && !p.Timestamp.After(last) {
+		return
+	}
+	h.last[p.CoinID] = p.Timestamp
+	for s := range h.subs {
+		if !s.wants(p.CoinID) {
+			continue
+		}
+		select {
+		case s.ch <- p:
+		default:
+		}
+	}
+}
+
+// setCoins sets the coins s watches; none means every coin.
+func (s *tickSubscriber) setCoins(coins []string) {
+	set := make(map[string]bool, len(coins))
+	for _, c := range coins {
+		set[c] = true
+	}
+	s.mu.Lock()
+	s.all = len(coins) == 0
+	s.coins = set
+	s.excluded = make(map[string]bool)
+	s.mu.Unlock()
+}
+
+// addCoins starts (add) or stops watching coins.
+func (s *tickSubscriber) addCoins(coins []string, add bool) {
+	s.mu.Lock()
+	for _, c := range coins {
+		switch {
+		case s.all && add:
+			delete(s.excluded, c)
+		case s.all:
+			s.excluded[c] = true
+		case add:
+			s.coins[c] = true
+		default:
+			delete(s.coins, c)
+		}
+	}
+	s.mu.Unlock()
+}
+
+func (s *tickSubscriber) wants(coinID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.all {
+		return !s.excluded[coinID]
+	}
+	return s.coins[coinID]
+}
+
+// resolveCoins maps ids and aliases to registry ids, dropping blanks.
+func resolveCoins(list []string) []string {
+	var out []string
+	for _, id := range list {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if registry != nil {
+			if c, ok := registry.Resolve(id); ok {
+				id = c.ID
+			}
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+// streamCoins reads the coins= query parameter, a comma separated list of
+// ids or aliases. None means every coin.
+func streamCoins(r *http.Request) []string {
+	return resolveCoins(strings.Split(r.URL.Query().Get("coins"), ","))
+}
+
+// latestSnapshot returns the current price of each coin so a new client
+// has something to draw before the next tick.
+func latestSnapshot(coins []string) []PriceData {
+	if len(coins) == 0 && registry != nil {
+		for _, c := range registry.Enabled() {
+			coins = append(coins, c.ID)
+		}
+	}
+	var out []PriceData
+	for _, id := range coins {
+		if p, err := store.Latest(id); err == nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// streamSSE handles GET /stream/sse?coins=bitcoin,eth. Each tick is sent as
+// a "tick" event carrying a PriceData object; a comment every 25 seconds
+// keeps proxies from closing the connection.
+func streamSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	coins := streamCoins(r)
+	sub := hub.subscribe(coins)
+	defer hub.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	send := func(p PriceData) error {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: tick\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	for _, p := range latestSnapshot(coins) {
+		if send(p) != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(25 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case p := <-sub.ch:
+			if send(p) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// wsUpgrader accepts the same browser origins as CORS; main sets
+// CheckOrigin from CORS_ALLOWED_ORIGINS. Until then only requests without
+// an Origin, i.e. not from a browser, are accepted.
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: originChecker(nil),
+}
+
+// originChecker allows requests without an Origin header and requests from
+// origins. Like the CORS middleware, "*" allows every origin and an origin
+// may contain one "*" standing for any characters.
+func originChecker(origins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := strings.ToLower(r.Header.Get("Origin"))
+		if origin == "" {
+			return true
+		}
+		for _, o := range origins {
+			o = strings.ToLower(o)
+			if prefix, suffix, wild := strings.Cut(o, "*"); wild {
+				if len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+					return true
+				}
+			} else if origin == o {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// wsMessage is the envelope for WebSocket traffic. The server sends
+// {"type":"tick","data":{...}}; clients may send
+// {"action":"subscribe"|"unsubscribe","coins":["bitcoin"]} to change the
+// coins they watch.
+type wsMessage struct {
+	Type   string     `json:"type,omitempty"`
+	Data   *PriceData `json:"data,omitempty"`
+	Action string     `json:"action,omitempty"`
+	Coins  []string   `json:"coins,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// streamWS handles GET /stream/ws?coins=bitcoin,eth.
+func streamWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	coins := streamCoins(r)
+	sub := hub.subscribe(coins)
+	defer hub.unsubscribe(sub)
+
+	// The reader applies subscription changes and notices disconnects;
+	// gorilla/websocket allows one concurrent reader and one writer, and
+	// every write below happens on this goroutine.
+	const pongWait = 60 * time.Second
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error { return conn.SetReadDeadline(time.Now().Add(pongWait)) })
+
+	replies := make(chan wsMessage, 4)
+	done := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(done)
+		reply := func(msg wsMessage) bool {
+			select {
+			case replies <- msg:
+				return true
+			case <-quit:
+				return false
+			}
+		}
+		for {
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			ids := resolveCoins(msg.Coins)
+			switch msg.Action {
+			case "subscribe":
+				sub.addCoins(ids, true)
+				for _, p := range latestSnapshot(ids) {
+					p := p
+					if !reply(wsMessage{Type: "tick", Data: &p}) {
+						return
+					}
+				}
+			case "unsubscribe":
+				sub.addCoins(ids, false)
+			default:
+				if !reply(wsMessage{Type: "error", Error: "unknown action " + msg.Action}) {
+					return
+				}
+			}
+		}
+	}()
+
+	write := func(msg wsMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(msg)
+	}
+
+	for _, p := range latestSnapshot(coins) {
+		p := p
+		if write(wsMessage{Type: "tick", Data: &p}) != nil {
+			return
+		}
+	}
+
+	ping := time.NewTicker(pongWait * 9 / 10)
+	defer ping.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case p := <-sub.ch:
+			if write(wsMessage{Type: "tick", Data: &p}) != nil {
+				return
+			}
+		case msg := <-replies:
+			if write(msg) != nil {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if conn.WriteMessage(websocket.PingMessage, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+// receiveTicks handles POST /internal/ticks, where the ingestion job pushes
+// each tick's rows. It requires TICK_PUBLISH_TOKEN in X-Tick-Token and is
+// disabled when the token is unset.
+func receiveTicks(w http.ResponseWriter, r *http.Request) {
+	token := os.Getenv("TICK_PUBLISH_TOKEN")
+	if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Tick-Token")), []byte(token)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var rows []PriceData
+	if err := json.NewDecoder(r.Body).Decode(&rows); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	for _, p := range rows {
+		hub.publish(p)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pollTicks feeds the hub from the store when the ingestion job does not
+// push: every interval it reads each enabled coin's latest row and publishes
+// the ones newer than last time. The first pass only records the baseline.
+func pollTicks(ctx context.Context, interval time.Duration) {
+	seen := make(map[string]time.Time)
+	first := true
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		for _, c := range registry.Enabled() {
+			p, err := store.Latest(c.ID)
+			if err != nil {
+				continue
+			}
+			if last, ok := seen[c.ID]; !first && (!ok || p.Timestamp.After(last)) {
+				hub.publish(p)
+			}
+			seen[c.ID] = p.Timestamp
+		}
+		first = false
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// startTickFeed starts the poller unless STREAM_SOURCE is "push", in which
+// case the hub is fed only through /internal/ticks. /internal/ticks also
+// works alongside the poller; the hub publishes each row once whichever
+// arrives first. STREAM_POLL_INTERVAL (default 5s) sets the polling period.
+func startTickFeed(ctx context.Context) error {
+	switch src := os.Getenv("STREAM_SOURCE"); src {
+	case "push":
+		if os.Getenv("TICK_PUBLISH_TOKEN") == "" {
+			return fmt.Errorf("STREAM_SOURCE=push requires TICK_PUBLISH_TOKEN")
+		}
+		return nil
+	case "", "poll":
+		interval := 5 * time.Second
+		if v := os.Getenv("STREAM_POLL_INTERVAL"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid STREAM_POLL_INTERVAL %q", v)
+			}
+			interval = d
+		}
+		go pollTicks(ctx, interval)
+		return nil
+	default:
+		return fmt.Errorf("unknown STREAM_SOURCE %q", src)
+	}
+}
+
+// publishTicks pushes rows to the API's /internal/ticks when
+// TICK_PUBLISH_URL is set (e.g. http://localhost:8000/internal/ticks). The
+// ingestion job calls it after every tick; a failed push is logged and the
+// API's poller, if enabled, still picks the rows up. Rows both pushed and
+// polled are streamed once.
+func publishTicks(ctx context.Context, rows []PriceData) {
+	target := os.Getenv("TICK_PUBLISH_URL")
+	if target == "" || len(rows) == 0 {
+		return
+	}
+	body, err := json.Marshal(rows)
+	if err != nil {
+		log.Printf("Error encoding ticks: %v", err)
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error publishing ticks: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tick-Token", os.Getenv("TICK_PUBLISH_TOKEN"))
+
+	resp, err := sourceHTTPClient.Do(req)
+	if err != nil {
+		log.Printf("Error publishing ticks: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		log.Printf("Error publishing ticks: %s", resp.Status)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// drain returns "coin@minute" for every tick waiting on s.
+func drain(s *tickSubscriber) []string {
+	var got []string
+	for {
+		select {
+		case p := <-s.ch:
+			got = append(got, p.CoinID+"@"+p.Timestamp.Format("15:04"))
+		default:
+			return got
+		}
+	}
+}
+
+func TestTickHubPublishesEachRowOnce(t *testing.T) {
+	h := newTickHub()
+	sub := h.subscribe(nil)
+	at := func(minute int) time.Time { return time.Date(2025, 1, 1, 12, minute, 0, 0, time.UTC) }
+
+	// The same rows pushed by the worker and found by the poller.
+	for _, p := range []PriceData{
+		{CoinID: "bitcoin", Timestamp: at(0)},
+		{CoinID: "ethereum", Timestamp: at(0)},
+		{CoinID: "bitcoin", Timestamp: at(0)},
+		{CoinID: "ethereum", Timestamp: at(0)},
+		{CoinID: "bitcoin", Timestamp: at(10)},
+		{CoinID: "bitcoin", Timestamp: at(5)},
+		{CoinID: "bitcoin", Timestamp: at(10)},
+	} {
+		h.publish(p)
+	}
+	want := []string{"bitcoin@12:00", "ethereum@12:00", "bitcoin@12:10"}
+	if got := drain(sub); !reflect.DeepEqual(got, want) {
+		t.Errorf("streamed %v, want %v", got, want)
+	}
+}
+
+func TestTickSubscriberCoins(t *testing.T) {
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		coins   []string
+		changes func(s *tickSubscriber)
+		want    []string
+	}{
+		{"every coin", nil, func(*tickSubscriber) {}, []string{"bitcoin", "ethereum", "solana"}},
+		{"filtered", []string{"bitcoin"}, func(*tickSubscriber) {}, []string{"bitcoin"}},
+		{"subscribe", []string{"bitcoin"}, func(s *tickSubscriber) { s.addCoins([]string{"solana"}, true) }, []string{"bitcoin", "solana"}},
+		{"unsubscribe from the last coin", []string{"bitcoin"}, func(s *tickSubscriber) { s.addCoins([]string{"bitcoin"}, false) }, nil},
+		{"unsubscribe then subscribe", []string{"bitcoin"}, func(s *tickSubscriber) {
+			s.addCoins([]string{"bitcoin"}, false)
+			s.addCoins([]string{"ethereum"}, true)
+		}, []string{"ethereum"}},
+		{"every coin but one", nil, func(s *tickSubscriber) { s.addCoins([]string{"ethereum"}, false) }, []string{"bitcoin", "solana"}},
+		{"every coin, resubscribed", nil, func(s *tickSubscriber) {
+			s.addCoins([]string{"ethereum"}, false)
+			s.addCoins([]string{"ethereum"}, true)
+		}, []string{"bitcoin", "ethereum", "solana"}},
+	}
+	for _, tt := range tests {
+		h := newTickHub()
+		sub := h.subscribe(tt.coins)
+		tt.changes(sub)
+		for _, coin := range []string{"bitcoin", "ethereum", "solana"} {
+			h.publish(PriceData{CoinID: coin, Timestamp: at})
+		}
+		var got []string
+		for _, tick := range drain(sub) {
+			got = append(got, tick[:len(tick)-len("@12:00")])
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: streamed %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
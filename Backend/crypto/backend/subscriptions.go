@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxWatchList bounds the coins a subscriber's reports can be restricted
+// to.
+const maxWatchList = 25
+
+// SubscriberPrefs are a subscriber's report settings. Digests are the
+// periodic reports they receive: daily, weekly and/or monthly. The rest
+// personalize those reports: Coins restricts the tables and charts to a
+// watch-list (empty for every coin), Timezone is the IANA zone times are
+// shown in, Sections picks the report sections (empty for all of them)
+// and Language is the report's language.
+type SubscriberPrefs struct {
+	Email     string    `json:"email"`
+	Digests   []string  `json:"digests"`
+	Coins     []string  `json:"coins"`
+	Timezone  string    `json:"timezone"`
+	Sections  []string  `json:"sections"`
+	Language  string    `json:"language"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// SubscriptionStore keeps subscriber preferences and the keys that manage
+// them. RemoveSubscriber also deletes the preferences.
+type SubscriptionStore interface {
+	// SubscriberPrefs returns the preferences saved for email.
+	SubscriberPrefs(email string) (SubscriberPrefs, error)
+	// PutSubscriberPrefs stores p, replacing any saved preferences.
+	PutSubscriberPrefs(p SubscriberPrefs) error
+	// PutSubscriptionKey records that keyHash belongs to email.
+	PutSubscriptionKey(keyHash, email string, createdAt time.Time) error
+	// SubscriptionKeyEmail returns the email owning keyHash, or ErrNotFound.
+	SubscriptionKeyEmail(keyHash string) (string, error)
+}
+
+// defaultPrefs are the preferences of a subscriber who never saved any.
+func defaultPrefs(email string) SubscriberPrefs {
+	return SubscriberPrefs{
+		Email:    email,
+		Digests:  []string{PeriodDaily},
+		Coins:    []string{},
+		Timezone: "UTC",
+		Sections: []string{},
+		Language: LanguageEnglish,
+	}
+}
+
+// loadPrefs returns email's saved preferences or the defaults.
+func loadPrefs(email string) (SubscriberPrefs, error) {
+	p, err := store.SubscriberPrefs(email)
+	if err == ErrNotFound {
+		return defaultPrefs(email), nil
+	}
+	return p, err
+}
+
+// validate checks p and normalizes it: coin aliases become canonical ids
+// and the lists are de-duplicated and sorted.
+func (p *SubscriberPrefs) validate() error {
+	for _, d := range p.Digests {
+		switch d {
+		case PeriodDaily, PeriodWeekly, PeriodMonthly:
+		default:
+			return fmt.Errorf("digests may only contain %s, %s and %s", PeriodDaily, PeriodWeekly, PeriodMonthly)
+		}
+	}
+	p.Digests = uniqueSorted(p.Digests)
+
+	if len(p.Coins) > maxWatchList {
+		return fmt.Errorf("the watch-list may hold at most %d coins", maxWatchList)
+	}
+	for i, id := range p.Coins {
+		c, ok := registry.Resolve(strings.ToLower(strings.TrimSpace(id)))
+		if !ok {
+			return fmt.Errorf("unknown coin %q", id)
+		}
+		p.Coins[i] = c.ID
+	}
+	p.Coins = uniqueSorted(p.Coins)
+
+	if p.Timezone == "" {
+		p.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", p.Timezone)
+	}
+
+	for _, s := range p.Sections {
+		if !validSection(s) {
+			return fmt.Errorf("sections may only contain %s", strings.Join(reportSections, ", "))
+		}
+	}
+	p.Sections = uniqueSorted(p.Sections)
+
+	if p.Language == "" {
+		p.Language = LanguageEnglish
+	}
+	if _, ok := reportLanguages[p.Language]; !ok {
+		return fmt.Errorf("language must be one of %s", strings.Join(languageCodes(), ", "))
+	}
+	return nil
+}
+
+// reportOptions returns how p's reports are personalized.
+func (p SubscriberPrefs) reportOptions() reportOptions {
+	opts := defaultReportOptions()
+	opts.Coins = p.Coins
+	if loc, err := time.LoadLocation(p.Timezone); err == nil {
+		opts.Location = loc
+	}
+	if len(p.Sections) > 0 {
+		opts.Sections = p.Sections
+	}
+	if p.Language != "" {
+		opts.Language = p.Language
+	}
+	return opts
+}
+
+func uniqueSorted(list []string) []string {
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, v := range list {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// wants reports whether p receives the digest of kind. Custom reports go
+// to every subscriber.
+func (p SubscriberPrefs) wants(kind string) bool {
+	if kind == PeriodCustom {
+		return true
+	}
+	for _, d := range p.Digests {
+		if d == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// digestSubscribers returns the preferences of the subscribers that
+// receive reports of kind. A subscriber whose preferences can't be loaded
+// is skipped.
+func digestSubscribers(kind string) ([]SubscriberPrefs, error) {
+	emails, err := store.Subscribers()
+	if err != nil {
+		return nil, err
+	}
+	var out []SubscriberPrefs
+	for _, email := range emails {
+		p, err := loadPrefs(email)
+		if err != nil {
+			log.Printf("subscription %s: %v", email, err)
+			continue
+		}
+		if p.wants(kind) {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// requestSubscriptionKey handles POST /subscription/key with {"email": ...}.
+// A verified subscriber is emailed a new key for /subscription; the
+// response is the same either way.
+func requestSubscriptionKey(w http.ResponseWriter, r *http.Request) {
+	mailSubscriberKey(w, r, "subscription key", "X-Subscription-Key", "view and change your report preferences", store.PutSubscriptionKey)
+}
+
+// withSubscriptionKey resolves the subscriber email for the
+// X-Subscription-Key header. A key stops working once its email
+// unsubscribes.
+func withSubscriptionKey(next func(w http.ResponseWriter, r *http.Request, email string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, err := subscriptionKeyOwner(r)
+		if err == ErrNotFound {
+			w.Header().Set("WWW-Authenticate", `X-Subscription-Key realm="subscription"`)
+			http.Error(w, "A valid X-Subscription-Key is required", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Subscription key lookup error: %v", err)
+			http.Error(w, "Failed to check subscription key", http.StatusInternalServerError)
+			return
+		}
+		next(w, r, email)
+	}
+}
+
+// subscriptionKeyOwner returns the verified subscriber the
+// X-Subscription-Key header belongs to, or ErrNotFound.
+func subscriptionKeyOwner(r *http.Request) (string, error) {
+	key := r.Header.Get("X-Subscription-Key")
+	if key == "" {
+		return "", ErrNotFound
+	}
+	email, err := store.SubscriptionKeyEmail(hashKey(key))
+	if err != nil {
+		return "", err
+	}
+	emails, err := store.Subscribers()
+	if err != nil {
+		return "", err
+	}
+	for _, e := range emails {
+		if e == email {
+			return email, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// getSubscription handles GET /subscription.
+func getSubscription(w http.ResponseWriter, r *http.Request, email string) {
+	p, err := loadPrefs(email)
+	if err != nil {
+		log.Printf("subscription %s: %v", email, err)
+		http.Error(w, "Failed to load subscription", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// updateSubscription handles PUT /subscription. Fields left out of the
+// body keep their current values. An empty digests list stops the periodic
+// reports without unsubscribing.
+func updateSubscription(w http.ResponseWriter, r *http.Request, email string) {
+	p, err := loadPrefs(email)
+	if err != nil {
+		log.Printf("subscription %s: %v", email, err)
+		http.Error(w, "Failed to load subscription", http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := p.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.Email = email
+	p.UpdatedAt = time.Now().UTC()
+	if err := store.PutSubscriberPrefs(p); err != nil {
+		log.Printf("subscription %s: %v", email, err)
+		http.Error(w, "Failed to save subscription", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
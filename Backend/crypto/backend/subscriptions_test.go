@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionKey(t *testing.T) {
+	s := useMemoryStore(t)
+	s.AddSubscriber("a@example.com", time.Now())
+	const key = "k1"
+	s.PutSubscriptionKey(hashKey(key), "a@example.com", time.Now())
+	s.PutSubscriptionKey(hashKey("pending"), "b@example.com", time.Now())
+
+	serve := func(method, body, apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/subscription", strings.NewReader(body))
+		if apiKey != "" {
+			r.Header.Set("X-Subscription-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		h := withSubscriptionKey(getSubscription)
+		if method == http.MethodPut {
+			h = withSubscriptionKey(updateSubscription)
+		}
+		h(w, r)
+		return w
+	}
+
+	var p SubscriberPrefs
+	w := serve(http.MethodGet, "", key)
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil || !reflect.DeepEqual(p, defaultPrefs("a@example.com")) {
+		t.Errorf("defaults: %d %+v %v", w.Code, p, err)
+	}
+
+	// The email in the body is ignored; the key decides whose preferences
+	// change.
+	w = serve(http.MethodPut, `{"email": "b@example.com", "digests": ["weekly", "daily", "weekly"], "coins": ["ETH", "btc", "bitcoin"]}`, key)
+	p = SubscriberPrefs{}
+	json.NewDecoder(w.Body).Decode(&p)
+	if w.Code != http.StatusOK || p.Email != "a@example.com" || !reflect.DeepEqual(p.Digests, []string{"daily", "weekly"}) ||
+		!reflect.DeepEqual(p.Coins, []string{"bitcoin", "ethereum"}) {
+		t.Errorf("update: %d %+v", w.Code, p)
+	}
+	if weekly, _ := digestSubscribers(PeriodWeekly); len(weekly) != 1 || weekly[0].Email != "a@example.com" {
+		t.Errorf("weekly subscribers %+v", weekly)
+	}
+	if monthly, _ := digestSubscribers(PeriodMonthly); len(monthly) != 0 {
+		t.Errorf("monthly subscribers %+v", monthly)
+	}
+
+	for _, body := range []string{
+		`{"digests": ["hourly"]}`,
+		`{"coins": ["shibacoin"]}`,
+		`{"timezone": "Mars/Olympus_Mons"}`,
+		`{"language": "xx"}`,
+		`{"sections": ["horoscope"]}`,
+	} {
+		if w := serve(http.MethodPut, body, key); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d", body, w.Code)
+		}
+	}
+
+	// A key for an address that never confirmed, no key, or an unknown one.
+	for _, k := range []string{"pending", "", "k2"} {
+		if w := serve(http.MethodGet, "", k); w.Code != http.StatusUnauthorized {
+			t.Errorf("key %q: %d", k, w.Code)
+		}
+	}
+
+	s.RemoveSubscriber("a@example.com")
+	if w := serve(http.MethodGet, "", key); w.Code != http.StatusUnauthorized {
+		t.Errorf("after unsubscribing: %d", w.Code)
+	}
+	// Subscribing again starts from the defaults.
+	s.AddSubscriber("a@example.com", time.Now())
+	p = SubscriberPrefs{}
+	json.NewDecoder(serve(http.MethodGet, "", key).Body).Decode(&p)
+	if !reflect.DeepEqual(p, defaultPrefs("a@example.com")) {
+		t.Errorf("after subscribing again: %+v", p)
+	}
+}